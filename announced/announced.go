@@ -4,6 +4,8 @@ import (
 	"fmt"
 	"io"
 	"net"
+	"sync/atomic"
+	"time"
 
 	log "github.com/Sirupsen/logrus"
 )
@@ -45,6 +47,10 @@ type Query struct {
 // Requester is responsible for sending out queries and receiving the responses.
 // The requester does not process the Responses in any way.
 type Requester struct {
+	// queryRound is accessed atomically and needs to be the first field to be
+	// aligned on 32 bit platforms.
+	queryRound  uint64
+	name        string
 	unicastConn net.PacketConn
 	queryChan   chan Query
 	ReceiveChan chan Response
@@ -73,8 +79,15 @@ func getIPFromInterface(ifaceName string) (*net.IP, error) {
 }
 
 // NewRequester creates a new Requester using the interface named by interfaceName
-// and listening on the port specified for responses.
+// and listening on the port specified for responses. The interface name is used
+// as name of the Requester.
 func NewRequester(ifaceName string, port int) (r *Requester, err error) {
+	return NewNamedRequester(ifaceName, ifaceName, port)
+}
+
+// NewNamedRequester creates a new Requester like NewRequester. All received
+// Responses are tagged with the given name.
+func NewNamedRequester(name, ifaceName string, port int) (r *Requester, err error) {
 	lIP := &net.IPv6zero
 	if ifaceName != "" {
 		lIP, err = getIPFromInterface(ifaceName)
@@ -86,6 +99,7 @@ func NewRequester(ifaceName string, port int) (r *Requester, err error) {
 		return
 	}
 	r = &Requester{
+		name:        name,
 		queryChan:   make(chan Query),
 		ReceiveChan: make(chan Response, 100),
 	}
@@ -144,6 +158,9 @@ func (r *Requester) readLoop() {
 		r.ReceiveChan <- Response{
 			ClientAddr: raddr,
 			Payload:    payload,
+			Receiver:   r.name,
+			Received:   time.Now(),
+			QueryRound: atomic.LoadUint64(&r.queryRound),
 		}
 	}
 }
@@ -165,6 +182,7 @@ func (r *Requester) QueryUnicast(addr *net.UDPAddr, queryString string) {
 // Query multicasts the specified query to the default announced multicast group
 // on the default port.
 func (r *Requester) Query(queryString string) {
+	atomic.AddUint64(&r.queryRound, 1)
 	r.queryChan <- Query{QueryString: queryString}
 }
 
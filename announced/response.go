@@ -5,6 +5,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"net"
+	"time"
 )
 
 // Response represents the raw response received from announced.
@@ -15,6 +16,16 @@ type Response struct {
 	ClientAddr net.Addr
 	Payload    []byte
 	Errored    bool
+	// Receiver is the name of the receiver which received this response.
+	Receiver string
+	// Received is the time the response was read from the socket.
+	Received time.Time
+	// QueryRound is the number of multicast queries the receiver has sent
+	// before this response was received.
+	QueryRound uint64
+	// Domain is the name of the mesh domain the receiver listens to, empty if
+	// it is not configured.
+	Domain string
 }
 
 type JsonAddr struct {
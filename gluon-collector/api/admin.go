@@ -0,0 +1,73 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/ffdo/node-informant/gluon-collector/data"
+	"github.com/ffdo/node-informant/gluon-collector/httpserver"
+)
+
+// AdminApi exposes the endpoints to back up and restore the data store and to
+// edit the overrides. All of them require authentication.
+type AdminApi struct {
+	Store data.Nodeinfostore
+	// Overrides is nil if no overrides file is configured.
+	Overrides *data.Overrides
+}
+
+func (a *AdminApi) Routes() []httpserver.Route {
+	routes := []httpserver.Route{
+		httpserver.Route{"Backup", "GET", "/admin/backup", httpserver.RequireAuth(a.GetBackup)},
+		httpserver.Route{"Export", "GET", "/admin/export", httpserver.RequireAuth(a.GetExport)},
+		httpserver.Route{"Import", "POST", "/admin/import", httpserver.RequireAuth(a.PostImport)},
+	}
+	if a.Overrides != nil {
+		routes = append(routes, a.overrideRoutes()...)
+	}
+	return routes
+}
+
+// GetBackup streams a snapshot of the database in the native format of the
+// store.
+func (a *AdminApi) GetBackup(w http.ResponseWriter, r *http.Request) {
+	backupStore, ok := a.Store.(data.BackupStore)
+	if !ok {
+		respondMissing(w, fmt.Errorf("The data store doesn't support backups, use /admin/export"))
+		return
+	}
+	filename := fmt.Sprintf("collector-%s.db", time.Now().Format("20060102-150405"))
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, filename))
+	written, err := backupStore.Backup(w)
+	if err != nil {
+		// The status code has already been sent, so we can only log the error
+		log.WithFields(log.Fields{
+			"error":   err,
+			"written": written,
+		}).Error("Error writing backup")
+	}
+}
+
+// GetExport responds with a json archive of all data of the store.
+func (a *AdminApi) GetExport(w http.ResponseWriter, r *http.Request) {
+	archive, err := data.ExportArchive(a.Store)
+	if err != nil {
+		httpserver.Respond(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Disposition", `attachment; filename="collector-archive.json"`)
+	respondOK(w, archive)
+}
+
+// PostImport imports the json archive in the request body into the store.
+func (a *AdminApi) PostImport(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+	if err := data.ReadArchive(r.Body, a.Store); err != nil {
+		httpserver.RespondBadRequest(w, err)
+		return
+	}
+	respondOK(w, "Archive imported")
+}
@@ -1,8 +1,10 @@
 package api
 
 import (
-	"encoding/json"
+	"fmt"
 	"net/http"
+	"strconv"
+	"time"
 
 	"github.com/ffdo/node-informant/gluon-collector/data"
 	"github.com/ffdo/node-informant/gluon-collector/httpserver"
@@ -15,41 +17,115 @@ type HttpApi struct {
 
 func (h *HttpApi) Routes() []httpserver.Route {
 	var apiRoutes = []httpserver.Route{
+		httpserver.Route{"Nodes", "GET", "/nodes", h.GetNodesRest},
 		httpserver.Route{"NodeInfo", "GET", "/nodeinfos/{nodeid}", h.GetNodeInfoRest},
 		httpserver.Route{"Nodeinfos", "GET", "/nodeinfos", h.GetNodeinfosRest},
+		httpserver.Route{"NodeinfoHistory", "GET", "/nodeinfos/{nodeid}/history", h.GetNodeinfoHistoryRest},
+		httpserver.Route{"FirmwareUpgrades", "GET", "/firmware/upgrades", h.GetFirmwareUpgradesRest},
 		httpserver.Route{"NodeStatistics", "GET", "/statistics/{nodeid}", h.GetNodeStatisticsRest},
 		httpserver.Route{"NodesNeighbours", "GET", "/neighbours/{nodeid}", h.GetNodeNeighboursRest},
+		httpserver.Route{"NodeLinks", "GET", "/neighbours/{nodeid}/links", h.GetNodeLinksRest},
+		httpserver.Route{"NodeStatisticsHistory", "GET", "/statistics/{nodeid}/history", h.GetNodeStatisticsHistoryRest},
 		httpserver.Route{"AllNeighbours", "GET", "/neighbours", h.GetAllNeighboursRest},
 		httpserver.Route{"AllStatistics", "GET", "/statistics", h.GetAllStatistics},
 		httpserver.Route{"AllNodeStatus", "GET", "/nodestatus", h.GetAllNodeStatus},
 		httpserver.Route{"NodeStatus", "GET", "/nodestatus/{nodeid}", h.GetNodeStatus},
+		httpserver.Route{"Events", "GET", "/events", h.GetEventsRest},
+		httpserver.Route{"LookupMac", "GET", "/lookup/mac/{mac}", h.LookupMacRest},
+		httpserver.Route{"LookupAddress", "GET", "/lookup/address/{address}", h.LookupAddressRest},
+		httpserver.Route{"LookupHostname", "GET", "/lookup/hostname/{hostname}", h.LookupHostnameRest},
+		httpserver.Route{"LookupSite", "GET", "/lookup/site/{site}", h.LookupSiteRest},
+		httpserver.Route{"Domains", "GET", "/domains", h.GetDomainsRest},
+		httpserver.Route{"DomainNodeinfos", "GET", "/domains/{domain}/nodeinfos", h.GetDomainNodeinfosRest},
+		httpserver.Route{"DomainStatistics", "GET", "/domains/{domain}/statistics", h.GetDomainStatisticsRest},
+		httpserver.Route{"DomainNeighbours", "GET", "/domains/{domain}/neighbours", h.GetDomainNeighboursRest},
+		httpserver.Route{"DomainNodeStatus", "GET", "/domains/{domain}/nodestatus", h.GetDomainNodeStatusRest},
 	}
 	return apiRoutes
 }
 
-func respond(w http.ResponseWriter, data interface{}, status int) {
-	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
-	w.WriteHeader(status)
-	json.NewEncoder(w).Encode(data)
-}
-
 func respondOK(w http.ResponseWriter, data interface{}) {
-	respond(w, data, http.StatusOK)
+	httpserver.RespondOK(w, data)
 }
 
 func respondMissing(w http.ResponseWriter, data error) {
-	respond(w, data, http.StatusNotFound)
+	httpserver.RespondMissing(w, data)
+}
+
+// wantsRaw checks whether the client requested the json documents as they have
+// been received from the node via the raw query parameter.
+func wantsRaw(r *http.Request) bool {
+	raw := r.URL.Query().Get("raw")
+	return raw == "1" || raw == "true"
+}
+
+// respondRawData responds with the stored raw json document of the given type.
+func (h *HttpApi) respondRawData(w http.ResponseWriter, nodeId, responseType string) {
+	raw, err := h.Store.GetRawData(nodeId, responseType)
+	if err == nil {
+		httpserver.RespondRaw(w, raw)
+	} else {
+		respondMissing(w, err)
+	}
 }
 
+// nodeStatus is the NodeStatusInfo extended by the availability of the node,
+// which is calculated on every request.
+type nodeStatus struct {
+	data.NodeStatusInfo
+	Availability map[string]float64 `json:",omitempty"`
+}
+
+func (h *HttpApi) withAvailability(status data.NodeStatusInfo, now time.Time) nodeStatus {
+	result := nodeStatus{NodeStatusInfo: status}
+	if eventLog, ok := h.Store.(data.EventLog); ok {
+		result.Availability = data.GetAvailability(eventLog, status.NodeId, now)
+	}
+	return result
+}
+
+// GetAllNodeStatus reads the events of all nodes at once to calculate their
+// availability.
 func (h *HttpApi) GetAllNodeStatus(w http.ResponseWriter, r *http.Request) {
-	respondOK(w, h.Store.GetNodeStatusInfos())
+	statusInfos := h.Store.GetNodeStatusInfos()
+	var availabilities map[string]map[string]float64
+	if eventLog, ok := h.Store.(data.EventLog); ok {
+		availabilities = data.GetAllAvailabilities(eventLog, time.Now())
+	}
+	result := make([]nodeStatus, 0, len(statusInfos))
+	for _, status := range statusInfos {
+		result = append(result, nodeStatus{NodeStatusInfo: status, Availability: availabilities[status.NodeId]})
+	}
+	respondOK(w, result)
 }
 
 func (h *HttpApi) GetNodeStatus(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	status, err := h.Store.GetNodeStatusInfo(vars["nodeid"])
 	if err == nil {
-		respondOK(w, status)
+		respondOK(w, h.withAvailability(status, time.Now()))
+	} else {
+		respondMissing(w, err)
+	}
+}
+
+// GetEventsRest returns the status transitions of all nodes or of the node
+// given by the query parameter nodeid since the query parameter since, which
+// defaults to the last 24 hours.
+func (h *HttpApi) GetEventsRest(w http.ResponseWriter, r *http.Request) {
+	eventLog, ok := h.Store.(data.EventLog)
+	if !ok {
+		respondMissing(w, fmt.Errorf("The data store keeps no event log"))
+		return
+	}
+	since, err := parseTimeParam(r, "since", time.Now().Add(-24*time.Hour))
+	if err != nil {
+		httpserver.RespondBadRequest(w, err)
+		return
+	}
+	events, err := eventLog.GetEvents(r.URL.Query().Get("nodeid"), since)
+	if err == nil {
+		respondOK(w, events)
 	} else {
 		respondMissing(w, err)
 	}
@@ -65,6 +141,10 @@ func (h *HttpApi) GetAllNeighboursRest(w http.ResponseWriter, r *http.Request) {
 
 func (h *HttpApi) GetNodeStatisticsRest(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
+	if wantsRaw(r) {
+		h.respondRawData(w, vars["nodeid"], "statistics")
+		return
+	}
 	stats, err := h.Store.GetStatistics(vars["nodeid"])
 	if err == nil {
 		respondOK(w, stats)
@@ -75,6 +155,10 @@ func (h *HttpApi) GetNodeStatisticsRest(w http.ResponseWriter, r *http.Request)
 
 func (n *HttpApi) GetNodeNeighboursRest(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
+	if wantsRaw(r) {
+		n.respondRawData(w, vars["nodeid"], "neighbours")
+		return
+	}
 	neighbours, err := n.Store.GetNodeNeighbours(vars["nodeid"])
 	if err == nil {
 		respondOK(w, neighbours)
@@ -83,12 +167,28 @@ func (n *HttpApi) GetNodeNeighboursRest(w http.ResponseWriter, r *http.Request)
 	}
 }
 
+// GetNodeLinksRest returns the links to the mesh neighbours of the node for all
+// routing protocols in a protocol independent format.
+func (n *HttpApi) GetNodeLinksRest(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	neighbours, err := n.Store.GetNodeNeighbours(vars["nodeid"])
+	if err == nil {
+		respondOK(w, neighbours.Links())
+	} else {
+		respondMissing(w, err)
+	}
+}
+
 func (n *HttpApi) GetNodeinfosRest(w http.ResponseWriter, r *http.Request) {
 	respondOK(w, n.Store.GetNodeInfos())
 }
 
 func (n *HttpApi) GetNodeInfoRest(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
+	if wantsRaw(r) {
+		n.respondRawData(w, vars["nodeid"], "nodeinfo")
+		return
+	}
 	nodeinfo, err := n.Store.GetNodeInfo(vars["nodeid"])
 	if err == nil {
 		respondOK(w, nodeinfo)
@@ -96,3 +196,113 @@ func (n *HttpApi) GetNodeInfoRest(w http.ResponseWriter, r *http.Request) {
 		respondMissing(w, err)
 	}
 }
+
+// parseTimeParam parses a query parameter given either as RFC3339 time or as
+// unix timestamp in seconds.
+func parseTimeParam(r *http.Request, name string, defaultValue time.Time) (time.Time, error) {
+	value := r.URL.Query().Get(name)
+	if value == "" {
+		return defaultValue, nil
+	}
+	if seconds, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return time.Unix(seconds, 0), nil
+	}
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return t, fmt.Errorf("Invalid time %s for parameter %s", value, name)
+	}
+	return t, nil
+}
+
+// parseDurationParam parses a query parameter given either as duration like 5m
+// or as number of seconds.
+func parseDurationParam(r *http.Request, name string) (time.Duration, error) {
+	value := r.URL.Query().Get(name)
+	if value == "" {
+		return 0, nil
+	}
+	if seconds, err := strconv.ParseUint(value, 10, 64); err == nil {
+		return time.Duration(seconds) * time.Second, nil
+	}
+	duration, err := time.ParseDuration(value)
+	if err != nil || duration < 0 {
+		return 0, fmt.Errorf("Invalid duration %s for parameter %s", value, name)
+	}
+	return duration, nil
+}
+
+// GetNodeStatisticsHistoryRest returns the statistics history of the node
+// between the query parameters from and to (defaulting to the last 24 hours),
+// averaged over the optional step.
+func (h *HttpApi) GetNodeStatisticsHistoryRest(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	historyStore, ok := h.Store.(data.StatisticsHistoryStore)
+	if !ok {
+		respondMissing(w, fmt.Errorf("The data store keeps no statistics history"))
+		return
+	}
+	to, err := parseTimeParam(r, "to", time.Now())
+	if err != nil {
+		httpserver.RespondBadRequest(w, err)
+		return
+	}
+	from, err := parseTimeParam(r, "from", to.Add(-24*time.Hour))
+	if err != nil {
+		httpserver.RespondBadRequest(w, err)
+		return
+	}
+	step, err := parseDurationParam(r, "step")
+	if err != nil {
+		httpserver.RespondBadRequest(w, err)
+		return
+	}
+	samples, err := historyStore.GetStatisticsHistory(vars["nodeid"], from, to, step)
+	if err == nil {
+		respondOK(w, samples)
+	} else {
+		respondMissing(w, err)
+	}
+}
+
+// GetNodeinfoHistoryRest returns all recorded changes of the nodeinfo of the
+// node, optionally only since the query parameter since.
+func (n *HttpApi) GetNodeinfoHistoryRest(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	history, ok := n.Store.(data.NodeinfoHistoryStore)
+	if !ok {
+		respondMissing(w, fmt.Errorf("The data store keeps no nodeinfo history"))
+		return
+	}
+	since, err := parseTimeParam(r, "since", time.Unix(0, 0))
+	if err != nil {
+		httpserver.RespondBadRequest(w, err)
+		return
+	}
+	changes, err := history.GetNodeinfoChanges(vars["nodeid"], since)
+	if err == nil {
+		respondOK(w, changes)
+	} else {
+		respondMissing(w, err)
+	}
+}
+
+// GetFirmwareUpgradesRest returns the firmware changes of all nodes since the
+// query parameter since, which defaults to the last 7 days.
+func (n *HttpApi) GetFirmwareUpgradesRest(w http.ResponseWriter, r *http.Request) {
+	history, ok := n.Store.(data.NodeinfoHistoryStore)
+	if !ok {
+		respondMissing(w, fmt.Errorf("The data store keeps no nodeinfo history"))
+		return
+	}
+	since, err := parseTimeParam(r, "since", time.Now().Add(-7*24*time.Hour))
+	if err != nil {
+		httpserver.RespondBadRequest(w, err)
+		return
+	}
+	changes, err := history.GetNodeinfoChanges("", since)
+	if err == nil {
+		respondOK(w, data.FilterChanges(changes, data.FirmwareReleasePath))
+	} else {
+		respondMissing(w, err)
+	}
+}
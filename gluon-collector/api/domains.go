@@ -0,0 +1,123 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/ffdo/node-informant/gluon-collector/data"
+	"github.com/gorilla/mux"
+)
+
+// domainSummary is a mesh domain with the number of its nodes.
+type domainSummary struct {
+	Domain string `json:"domain"`
+	Nodes  int    `json:"nodes"`
+	Online int    `json:"online"`
+}
+
+type byDomain []domainSummary
+
+func (d byDomain) Len() int           { return len(d) }
+func (d byDomain) Swap(i, j int)      { d[i], d[j] = d[j], d[i] }
+func (d byDomain) Less(i, j int) bool { return d[i].Domain < d[j].Domain }
+
+// GetDomainsRest returns all known mesh domains ordered by name.
+func (h *HttpApi) GetDomainsRest(w http.ResponseWriter, r *http.Request) {
+	summaries := make(map[string]*domainSummary)
+	for _, status := range h.Store.GetNodeStatusInfos() {
+		if status.Domain == "" {
+			continue
+		}
+		summary, exists := summaries[status.Domain]
+		if !exists {
+			summary = &domainSummary{Domain: status.Domain}
+			summaries[status.Domain] = summary
+		}
+		summary.Nodes++
+		if status.Online {
+			summary.Online++
+		}
+	}
+	result := make([]domainSummary, 0, len(summaries))
+	for _, summary := range summaries {
+		result = append(result, *summary)
+	}
+	sort.Sort(byDomain(result))
+	respondOK(w, result)
+}
+
+// domainNodes returns the nodes of the domain requested by the path or responds
+// with an error if the domain has no nodes.
+func (h *HttpApi) domainNodes(w http.ResponseWriter, r *http.Request) (map[string]bool, bool) {
+	domain := mux.Vars(r)["domain"]
+	nodes := data.DomainNodes(h.Store, domain)
+	if len(nodes) == 0 {
+		respondMissing(w, fmt.Errorf("Unknown domain %s", domain))
+		return nil, false
+	}
+	return nodes, true
+}
+
+func (h *HttpApi) GetDomainNodeinfosRest(w http.ResponseWriter, r *http.Request) {
+	nodes, ok := h.domainNodes(w, r)
+	if !ok {
+		return
+	}
+	result := make([]data.NodeInfo, 0, len(nodes))
+	for _, nodeinfo := range h.Store.GetNodeInfos() {
+		if nodes[nodeinfo.NodeId] {
+			result = append(result, nodeinfo)
+		}
+	}
+	respondOK(w, result)
+}
+
+func (h *HttpApi) GetDomainStatisticsRest(w http.ResponseWriter, r *http.Request) {
+	nodes, ok := h.domainNodes(w, r)
+	if !ok {
+		return
+	}
+	result := make([]data.StatisticsStruct, 0, len(nodes))
+	for _, statistics := range h.Store.GetAllStatistics() {
+		if nodes[statistics.NodeId] {
+			result = append(result, statistics)
+		}
+	}
+	respondOK(w, result)
+}
+
+func (h *HttpApi) GetDomainNeighboursRest(w http.ResponseWriter, r *http.Request) {
+	nodes, ok := h.domainNodes(w, r)
+	if !ok {
+		return
+	}
+	result := make([]data.NeighbourStruct, 0, len(nodes))
+	for _, neighbours := range h.Store.GetAllNeighbours() {
+		if nodes[neighbours.NodeId] {
+			result = append(result, neighbours)
+		}
+	}
+	respondOK(w, result)
+}
+
+// GetDomainNodeStatusRest reads the events of all nodes at once like
+// GetAllNodeStatus to calculate the availability of the nodes of the domain.
+func (h *HttpApi) GetDomainNodeStatusRest(w http.ResponseWriter, r *http.Request) {
+	nodes, ok := h.domainNodes(w, r)
+	if !ok {
+		return
+	}
+	var availabilities map[string]map[string]float64
+	if eventLog, ok := h.Store.(data.EventLog); ok {
+		availabilities = data.GetAllAvailabilities(eventLog, time.Now())
+	}
+	result := make([]nodeStatus, 0, len(nodes))
+	for _, status := range h.Store.GetNodeStatusInfos() {
+		if nodes[status.NodeId] {
+			result = append(result, nodeStatus{NodeStatusInfo: status, Availability: availabilities[status.NodeId]})
+		}
+	}
+	respondOK(w, result)
+}
@@ -0,0 +1,51 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/ffdo/node-informant/gluon-collector/data"
+	"github.com/gorilla/mux"
+)
+
+// lookupResult is the node found by a lookup. Nodes only known by their mesh
+// neighbours have no nodeinfo yet.
+type lookupResult struct {
+	NodeId   string         `json:"node_id"`
+	Nodeinfo *data.NodeInfo `json:"nodeinfo,omitempty"`
+}
+
+func (h *HttpApi) respondLookup(w http.ResponseWriter, nodeId string, err error) {
+	if err != nil {
+		respondMissing(w, err)
+		return
+	}
+	result := lookupResult{NodeId: nodeId}
+	if nodeinfo, err := h.Store.GetNodeInfo(nodeId); err == nil {
+		result.Nodeinfo = &nodeinfo
+	}
+	respondOK(w, result)
+}
+
+// LookupMacRest finds the node by its primary mac or the mac of one of its mesh
+// interfaces.
+func (h *HttpApi) LookupMacRest(w http.ResponseWriter, r *http.Request) {
+	nodeId, err := h.Store.LookupMac(mux.Vars(r)["mac"])
+	h.respondLookup(w, nodeId, err)
+}
+
+// LookupAddressRest finds the node announcing the ip address.
+func (h *HttpApi) LookupAddressRest(w http.ResponseWriter, r *http.Request) {
+	nodeId, err := h.Store.LookupAddress(mux.Vars(r)["address"])
+	h.respondLookup(w, nodeId, err)
+}
+
+// LookupHostnameRest finds the node by its hostname.
+func (h *HttpApi) LookupHostnameRest(w http.ResponseWriter, r *http.Request) {
+	nodeId, err := h.Store.LookupHostname(mux.Vars(r)["hostname"])
+	h.respondLookup(w, nodeId, err)
+}
+
+// LookupSiteRest returns the ids of all nodes of the site.
+func (h *HttpApi) LookupSiteRest(w http.ResponseWriter, r *http.Request) {
+	respondOK(w, h.Store.LookupSite(mux.Vars(r)["site"]))
+}
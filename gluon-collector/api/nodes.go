@@ -0,0 +1,429 @@
+package api
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/ffdo/node-informant/gluon-collector/data"
+	"github.com/ffdo/node-informant/gluon-collector/httpserver"
+)
+
+// nodeEntry combines all we know about a node. The most commonly used fields
+// are available at the top level, so they can be filtered, sorted and selected
+// easily.
+type nodeEntry struct {
+	NodeId            string                 `json:"node_id"`
+	Hostname          string                 `json:"hostname"`
+	SiteCode          string                 `json:"site_code"`
+	Domain            string                 `json:"domain"`
+	Model             string                 `json:"model"`
+	Firmware          string                 `json:"firmware"`
+	AutoupdaterBranch string                 `json:"autoupdater_branch"`
+	Online            bool                   `json:"online"`
+	Gateway           bool                   `json:"gateway"`
+	Clients           int                    `json:"clients"`
+	Firstseen         string                 `json:"firstseen"`
+	Lastseen          string                 `json:"lastseen"`
+	Location          *data.LocationStruct   `json:"location,omitempty"`
+	Nodeinfo          data.NodeInfo          `json:"nodeinfo"`
+	Statistics        *data.StatisticsStruct `json:"statistics,omitempty"`
+	Status            *data.NodeStatusInfo   `json:"status,omitempty"`
+}
+
+func newNodeEntry(nodeinfo data.NodeInfo, statistics *data.StatisticsStruct, status *data.NodeStatusInfo) nodeEntry {
+	entry := nodeEntry{
+		NodeId:     nodeinfo.NodeId,
+		Hostname:   nodeinfo.Hostname,
+		SiteCode:   nodeinfo.System.SiteCode,
+		Domain:     nodeinfo.Domain(),
+		Model:      nodeinfo.Hardware.Model,
+		Location:   nodeinfo.Location,
+		Nodeinfo:   nodeinfo,
+		Statistics: statistics,
+		Status:     status,
+	}
+	if firmware := nodeinfo.Software.Firmware; firmware != nil {
+		entry.Firmware = firmware.Release
+	}
+	if autoupdater := nodeinfo.Software.Autoupdater; autoupdater != nil {
+		entry.AutoupdaterBranch = autoupdater.Branch
+	}
+	if status != nil {
+		entry.Online = status.Online
+		entry.Gateway = status.Gateway
+		entry.Firstseen = status.Firstseen
+		entry.Lastseen = status.Lastseen
+		if status.Domain != "" {
+			entry.Domain = status.Domain
+		}
+	}
+	// Offline nodes have no clients, like in nodes.json
+	if statistics != nil && entry.Online {
+		entry.Clients = statistics.Clients.Total
+	}
+	return entry
+}
+
+// nodeEntries joins the nodeinfos, statistics and status infos of all nodes
+// with a nodeinfo.
+func (h *HttpApi) nodeEntries() []nodeEntry {
+	allStatistics := make(map[string]*data.StatisticsStruct)
+	for _, statistics := range h.Store.GetAllStatistics() {
+		stats := statistics
+		allStatistics[statistics.NodeId] = &stats
+	}
+	statusInfos := make(map[string]*data.NodeStatusInfo)
+	for _, status := range h.Store.GetNodeStatusInfos() {
+		info := status
+		statusInfos[status.NodeId] = &info
+	}
+	nodeinfos := h.Store.GetNodeInfos()
+	entries := make([]nodeEntry, 0, len(nodeinfos))
+	for _, nodeinfo := range nodeinfos {
+		entries = append(entries, newNodeEntry(nodeinfo, allStatistics[nodeinfo.NodeId], statusInfos[nodeinfo.NodeId]))
+	}
+	return entries
+}
+
+// nodeFilter is a single condition a node needs to fulfill to be listed.
+type nodeFilter func(entry nodeEntry) bool
+
+func parseBoolParam(value, name string) (bool, error) {
+	parsed, err := strconv.ParseBool(value)
+	if err != nil {
+		return false, fmt.Errorf("Invalid boolean %s for parameter %s", value, name)
+	}
+	return parsed, nil
+}
+
+// parseBoundingBox parses a bounding box given as
+// min longitude,min latitude,max longitude,max latitude.
+func parseBoundingBox(value string) (nodeFilter, error) {
+	parts := strings.Split(value, ",")
+	if len(parts) != 4 {
+		return nil, fmt.Errorf("Invalid bounding box %s, expected minLon,minLat,maxLon,maxLat", value)
+	}
+	bounds := make([]float64, 4)
+	for i, part := range parts {
+		bound, err := strconv.ParseFloat(strings.TrimSpace(part), 64)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid bounding box %s, expected minLon,minLat,maxLon,maxLat", value)
+		}
+		bounds[i] = bound
+	}
+	return func(entry nodeEntry) bool {
+		location := entry.Location
+		return location != nil &&
+			location.Longtitude >= bounds[0] && location.Latitude >= bounds[1] &&
+			location.Longtitude <= bounds[2] && location.Latitude <= bounds[3]
+	}, nil
+}
+
+// equalFilter compares a field of the nodes ignoring the case.
+func equalFilter(value string, field func(entry nodeEntry) string) nodeFilter {
+	return func(entry nodeEntry) bool {
+		return strings.EqualFold(field(entry), value)
+	}
+}
+
+// parseNodeFilters creates the filters for all filter parameters given.
+func parseNodeFilters(r *http.Request) ([]nodeFilter, error) {
+	query := r.URL.Query()
+	filters := make([]nodeFilter, 0, 4)
+	for _, name := range []string{"online", "gateway"} {
+		value := query.Get(name)
+		if value == "" {
+			continue
+		}
+		expected, err := parseBoolParam(value, name)
+		if err != nil {
+			return nil, err
+		}
+		if name == "online" {
+			filters = append(filters, func(entry nodeEntry) bool { return entry.Online == expected })
+		} else {
+			filters = append(filters, func(entry nodeEntry) bool { return entry.Gateway == expected })
+		}
+	}
+	fields := map[string]func(entry nodeEntry) string{
+		"site":     func(entry nodeEntry) string { return entry.SiteCode },
+		"domain":   func(entry nodeEntry) string { return entry.Domain },
+		"model":    func(entry nodeEntry) string { return entry.Model },
+		"firmware": func(entry nodeEntry) string { return entry.Firmware },
+		"branch":   func(entry nodeEntry) string { return entry.AutoupdaterBranch },
+	}
+	for name, field := range fields {
+		if value := query.Get(name); value != "" {
+			filters = append(filters, equalFilter(value, field))
+		}
+	}
+	if search := strings.ToLower(query.Get("q")); search != "" {
+		filters = append(filters, func(entry nodeEntry) bool {
+			return strings.Contains(strings.ToLower(entry.Hostname), search)
+		})
+	}
+	if value := query.Get("bbox"); value != "" {
+		filter, err := parseBoundingBox(value)
+		if err != nil {
+			return nil, err
+		}
+		filters = append(filters, filter)
+	}
+	return filters, nil
+}
+
+// sortKeys are the fields the nodes can be sorted by.
+var sortKeys = map[string]func(entry nodeEntry) interface{}{
+	"node_id":   func(entry nodeEntry) interface{} { return entry.NodeId },
+	"hostname":  func(entry nodeEntry) interface{} { return strings.ToLower(entry.Hostname) },
+	"site_code": func(entry nodeEntry) interface{} { return entry.SiteCode },
+	"domain":    func(entry nodeEntry) interface{} { return entry.Domain },
+	"model":     func(entry nodeEntry) interface{} { return entry.Model },
+	"firmware":  func(entry nodeEntry) interface{} { return entry.Firmware },
+	"clients":   func(entry nodeEntry) interface{} { return float64(entry.Clients) },
+	"firstseen": func(entry nodeEntry) interface{} { return entry.Firstseen },
+	"lastseen":  func(entry nodeEntry) interface{} { return entry.Lastseen },
+}
+
+// nodeOrder orders the nodes by a sort key and by node id for equal keys, so
+// the order is stable across requests.
+type nodeOrder struct {
+	key        func(entry nodeEntry) interface{}
+	descending bool
+}
+
+func parseNodeOrder(value string) (nodeOrder, error) {
+	order := nodeOrder{}
+	if strings.HasPrefix(value, "-") {
+		order.descending = true
+		value = value[1:]
+	}
+	if value == "" {
+		value = "node_id"
+	}
+	key, exists := sortKeys[value]
+	if !exists {
+		return order, fmt.Errorf("Can't sort by %s", value)
+	}
+	order.key = key
+	return order, nil
+}
+
+// compareKeys compares two sort keys, which are either both strings or both
+// numbers.
+func compareKeys(a, b interface{}) int {
+	switch a := a.(type) {
+	case string:
+		return strings.Compare(a, b.(string))
+	case float64:
+		if a < b.(float64) {
+			return -1
+		} else if a > b.(float64) {
+			return 1
+		}
+	}
+	return 0
+}
+
+// compare returns a negative number if the node with the key and the node id
+// comes before the other node.
+func (o nodeOrder) compare(key interface{}, nodeId string, other nodeEntry) int {
+	result := compareKeys(key, o.key(other))
+	if o.descending {
+		result = -result
+	}
+	if result == 0 {
+		result = strings.Compare(nodeId, other.NodeId)
+	}
+	return result
+}
+
+// nodesInOrder sorts the nodes by a nodeOrder.
+type nodesInOrder struct {
+	entries []nodeEntry
+	order   nodeOrder
+}
+
+func (n nodesInOrder) Len() int      { return len(n.entries) }
+func (n nodesInOrder) Swap(i, j int) { n.entries[i], n.entries[j] = n.entries[j], n.entries[i] }
+func (n nodesInOrder) Less(i, j int) bool {
+	return n.order.compare(n.order.key(n.entries[i]), n.entries[i].NodeId, n.entries[j]) < 0
+}
+
+func (o nodeOrder) sort(entries []nodeEntry) {
+	sort.Sort(nodesInOrder{entries: entries, order: o})
+}
+
+// nodeCursor points behind the last node of a page. It is handed out base64
+// encoded and only valid for the same sort order.
+type nodeCursor struct {
+	Key    interface{} `json:"k"`
+	NodeId string      `json:"n"`
+}
+
+func encodeCursor(order nodeOrder, last nodeEntry) string {
+	encoded, _ := json.Marshal(nodeCursor{Key: order.key(last), NodeId: last.NodeId})
+	return base64.RawURLEncoding.EncodeToString(encoded)
+}
+
+// skipToCursor returns the nodes behind the cursor.
+func skipToCursor(entries []nodeEntry, order nodeOrder, value string) ([]nodeEntry, error) {
+	cursor := nodeCursor{}
+	decoded, err := base64.RawURLEncoding.DecodeString(value)
+	if err == nil {
+		err = json.Unmarshal(decoded, &cursor)
+	}
+	// Numbers are decoded as float64, so the key has the type of the sort key
+	if err != nil || len(entries) > 0 && fmt.Sprintf("%T", cursor.Key) != fmt.Sprintf("%T", order.key(entries[0])) {
+		return nil, fmt.Errorf("Invalid cursor %s", value)
+	}
+	start := sort.Search(len(entries), func(i int) bool {
+		return order.compare(cursor.Key, cursor.NodeId, entries[i]) < 0
+	})
+	return entries[start:], nil
+}
+
+func parseIntParam(r *http.Request, name string) (int, error) {
+	value := r.URL.Query().Get(name)
+	if value == "" {
+		return 0, nil
+	}
+	parsed, err := strconv.Atoi(value)
+	if err != nil || parsed < 0 {
+		return 0, fmt.Errorf("Invalid number %s for parameter %s", value, name)
+	}
+	return parsed, nil
+}
+
+// selectFields reduces the json document of the node to the given fields,
+// which are paths of json field names joined by dots. The node id is always
+// kept.
+func selectFields(entry nodeEntry, fields []string) (map[string]interface{}, error) {
+	encoded, err := json.Marshal(entry)
+	if err != nil {
+		return nil, err
+	}
+	document := make(map[string]interface{})
+	if err := json.Unmarshal(encoded, &document); err != nil {
+		return nil, err
+	}
+	selected := map[string]interface{}{"node_id": entry.NodeId}
+	for _, field := range fields {
+		keys := strings.Split(field, ".")
+		var value interface{} = document
+		for _, key := range keys {
+			object, ok := value.(map[string]interface{})
+			if !ok {
+				value = nil
+				break
+			}
+			value = object[key]
+		}
+		if value == nil {
+			continue
+		}
+		target := selected
+		for _, key := range keys[:len(keys)-1] {
+			child, ok := target[key].(map[string]interface{})
+			if !ok {
+				child = make(map[string]interface{})
+				target[key] = child
+			}
+			target = child
+		}
+		target[keys[len(keys)-1]] = value
+	}
+	return selected, nil
+}
+
+// nodeList is a page of the nodes matching the filters.
+type nodeList struct {
+	// Total is the number of nodes matching the filters.
+	Total      int         `json:"total"`
+	Offset     int         `json:"offset"`
+	NextCursor string      `json:"next_cursor,omitempty"`
+	Nodes      interface{} `json:"nodes"`
+}
+
+// GetNodesRest lists the nodes matching the filter parameters in the order
+// given by sort. The result is paginated either by limit and offset or by
+// limit and the next_cursor of the previous page, and reduced to the fields
+// given as comma separated list.
+func (h *HttpApi) GetNodesRest(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	filters, err := parseNodeFilters(r)
+	if err != nil {
+		httpserver.RespondBadRequest(w, err)
+		return
+	}
+	order, err := parseNodeOrder(query.Get("sort"))
+	if err != nil {
+		httpserver.RespondBadRequest(w, err)
+		return
+	}
+	limit, err := parseIntParam(r, "limit")
+	if err != nil {
+		httpserver.RespondBadRequest(w, err)
+		return
+	}
+	offset, err := parseIntParam(r, "offset")
+	if err != nil {
+		httpserver.RespondBadRequest(w, err)
+		return
+	}
+
+	entries := make([]nodeEntry, 0)
+	for _, entry := range h.nodeEntries() {
+		matches := true
+		for _, filter := range filters {
+			if !filter(entry) {
+				matches = false
+				break
+			}
+		}
+		if matches {
+			entries = append(entries, entry)
+		}
+	}
+	order.sort(entries)
+	result := nodeList{Total: len(entries)}
+
+	page := entries
+	if cursor := query.Get("cursor"); cursor != "" {
+		if page, err = skipToCursor(entries, order, cursor); err != nil {
+			httpserver.RespondBadRequest(w, err)
+			return
+		}
+		offset = len(entries) - len(page)
+	} else if offset < len(page) {
+		page = page[offset:]
+	} else {
+		page = page[:0]
+	}
+	result.Offset = offset
+	if limit > 0 && limit < len(page) {
+		page = page[:limit]
+		result.NextCursor = encodeCursor(order, page[len(page)-1])
+	}
+
+	if fields := query.Get("fields"); fields != "" {
+		selected := make([]map[string]interface{}, 0, len(page))
+		for _, entry := range page {
+			document, err := selectFields(entry, strings.Split(fields, ","))
+			if err != nil {
+				httpserver.Respond(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			selected = append(selected, document)
+		}
+		result.Nodes = selected
+	} else {
+		result.Nodes = page
+	}
+	respondOK(w, result)
+}
@@ -0,0 +1,111 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/ffdo/node-informant/gluon-collector/data"
+	"github.com/stretchr/testify/assert"
+)
+
+func testNodesApi() *HttpApi {
+	store := data.NewSimpleInMemoryStore()
+	nodes := []struct {
+		nodeId, hostname, siteCode string
+		online                     bool
+		clients                    int
+		latitude                   float64
+	}{
+		{"a", "Dortmund-1", "ffdo", true, 5, 51.5},
+		{"b", "dortmund-2", "ffdo", true, 12, 51.4},
+		{"c", "Muenchen-1", "ffmuc", true, 12, 48.1},
+		{"d", "Dortmund-3", "ffdo", false, 3, 51.6},
+	}
+	for _, node := range nodes {
+		nodeinfo := data.NodeInfo{NodeId: node.nodeId, Hostname: node.hostname}
+		nodeinfo.System.SiteCode = node.siteCode
+		nodeinfo.Location = &data.LocationStruct{Latitude: node.latitude, Longtitude: 7.4}
+		store.PutNodeInfo(nodeinfo)
+		statistics := &data.StatisticsStruct{NodeId: node.nodeId}
+		statistics.Clients.Total = node.clients
+		store.PutStatistics(*statistics)
+		store.PutNodeStatusInfo(node.nodeId, data.NodeStatusInfo{NodeId: node.nodeId, Online: node.online})
+	}
+	return &HttpApi{Store: store}
+}
+
+func getNodes(api *HttpApi, query string) (*httptest.ResponseRecorder, map[string]interface{}) {
+	recorder := httptest.NewRecorder()
+	request, _ := http.NewRequest("GET", "/nodes?"+query, nil)
+	api.GetNodesRest(recorder, request)
+	result := make(map[string]interface{})
+	json.Unmarshal(recorder.Body.Bytes(), &result)
+	return recorder, result
+}
+
+func nodeIds(result map[string]interface{}) []string {
+	ids := make([]string, 0)
+	nodes, _ := result["nodes"].([]interface{})
+	for _, node := range nodes {
+		ids = append(ids, node.(map[string]interface{})["node_id"].(string))
+	}
+	return ids
+}
+
+func TestFilteringAndSortingNodes(t *testing.T) {
+	assert := assert.New(t)
+	api := testNodesApi()
+
+	_, result := getNodes(api, "")
+	assert.Equal([]string{"a", "b", "c", "d"}, nodeIds(result))
+	assert.Equal(float64(4), result["total"])
+
+	_, result = getNodes(api, "online=true&site=FFDO")
+	assert.Equal([]string{"a", "b"}, nodeIds(result))
+	_, result = getNodes(api, "q=dortmund&sort=-hostname")
+	assert.Equal([]string{"d", "b", "a"}, nodeIds(result))
+	_, result = getNodes(api, "sort=-clients")
+	assert.Equal([]string{"b", "c", "a", "d"}, nodeIds(result), "Equal keys are sorted by node id, offline nodes have no clients")
+	_, result = getNodes(api, "bbox=7,51,8,52")
+	assert.Equal([]string{"a", "b", "d"}, nodeIds(result))
+
+	_, result = getNodes(api, "fields=hostname,statistics.clients.total&q=muenchen")
+	nodes := result["nodes"].([]interface{})
+	assert.Equal(1, len(nodes))
+	node := nodes[0].(map[string]interface{})
+	assert.Equal(3, len(node))
+	assert.Equal("Muenchen-1", node["hostname"])
+	assert.Equal(float64(12), node["statistics"].(map[string]interface{})["clients"].(map[string]interface{})["total"])
+
+	for _, query := range []string{"online=maybe", "sort=unknown", "bbox=1,2,3", "limit=-1", "cursor=invalid"} {
+		recorder, _ := getNodes(api, query)
+		assert.Equal(http.StatusBadRequest, recorder.Code, query)
+	}
+}
+
+func TestPaginatingNodes(t *testing.T) {
+	assert := assert.New(t)
+	api := testNodesApi()
+
+	_, result := getNodes(api, "limit=2&offset=1")
+	assert.Equal([]string{"b", "c"}, nodeIds(result))
+	assert.Equal(float64(1), result["offset"])
+	_, result = getNodes(api, "offset=10")
+	assert.Equal([]string{}, nodeIds(result))
+
+	seen := make([]string, 0)
+	cursor := ""
+	for pages := 0; pages < 4; pages++ {
+		_, result = getNodes(api, "sort=-clients&limit=3&cursor="+cursor)
+		seen = append(seen, nodeIds(result)...)
+		next, hasNext := result["next_cursor"].(string)
+		if !hasNext {
+			break
+		}
+		cursor = next
+	}
+	assert.Equal([]string{"b", "c", "a", "d"}, seen)
+	assert.Equal(float64(3), result["offset"])
+}
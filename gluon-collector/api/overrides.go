@@ -0,0 +1,65 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/ffdo/node-informant/gluon-collector/data"
+	"github.com/ffdo/node-informant/gluon-collector/httpserver"
+	"github.com/gorilla/mux"
+)
+
+// overrideRoutes are only available if an overrides file is configured. They
+// require authentication, since they reveal the hidden nodes.
+func (a *AdminApi) overrideRoutes() []httpserver.Route {
+	return []httpserver.Route{
+		httpserver.Route{"Overrides", "GET", "/admin/overrides", httpserver.RequireAuth(a.GetOverridesRest)},
+		httpserver.Route{"Override", "GET", "/admin/overrides/{nodeid}", httpserver.RequireAuth(a.GetOverrideRest)},
+		httpserver.Route{"PutOverride", "PUT", "/admin/overrides/{nodeid}", httpserver.RequireAuth(a.PutOverrideRest)},
+		httpserver.Route{"DeleteOverride", "DELETE", "/admin/overrides/{nodeid}", httpserver.RequireAuth(a.DeleteOverrideRest)},
+	}
+}
+
+func (a *AdminApi) GetOverridesRest(w http.ResponseWriter, r *http.Request) {
+	respondOK(w, a.Overrides.All())
+}
+
+func (a *AdminApi) GetOverrideRest(w http.ResponseWriter, r *http.Request) {
+	nodeId := mux.Vars(r)["nodeid"]
+	override, exists := a.Overrides.Get(nodeId)
+	if exists {
+		respondOK(w, override)
+	} else {
+		respondMissing(w, fmt.Errorf("No override for node %s", nodeId))
+	}
+}
+
+// PutOverrideRest replaces the override of the node by the json object in the
+// request body and writes the overrides file.
+func (a *AdminApi) PutOverrideRest(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+	override := data.Override{}
+	if err := json.NewDecoder(r.Body).Decode(&override); err != nil {
+		httpserver.RespondBadRequest(w, fmt.Errorf("Invalid override: %v", err))
+		return
+	}
+	if err := a.Overrides.Put(mux.Vars(r)["nodeid"], override); err != nil {
+		httpserver.Respond(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	respondOK(w, override)
+}
+
+func (a *AdminApi) DeleteOverrideRest(w http.ResponseWriter, r *http.Request) {
+	nodeId := mux.Vars(r)["nodeid"]
+	if _, exists := a.Overrides.Get(nodeId); !exists {
+		respondMissing(w, fmt.Errorf("No override for node %s", nodeId))
+		return
+	}
+	if err := a.Overrides.Delete(nodeId); err != nil {
+		httpserver.Respond(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	respondOK(w, "Override deleted")
+}
@@ -1,33 +1,214 @@
 package assemble
 
 import (
+	"fmt"
 	"io"
 
 	log "github.com/Sirupsen/logrus"
+	cfg "github.com/olebedev/config"
 
 	"github.com/ffdo/node-informant/announced"
-	"github.com/ffdo/node-informant/gluon-collector/collectors"
+	conf "github.com/ffdo/node-informant/gluon-collector/config"
 	"github.com/ffdo/node-informant/gluon-collector/data"
+	"github.com/ffdo/node-informant/gluon-collector/httpserver"
 	"github.com/ffdo/node-informant/gluon-collector/pipeline"
-	"github.com/ffdo/node-informant/gluon-collector/prometheus"
+
+	// All packages providing pipeline stages register them on import
+	_ "github.com/ffdo/node-informant/gluon-collector/collectors"
+	_ "github.com/ffdo/node-informant/gluon-collector/dedupe"
+	_ "github.com/ffdo/node-informant/gluon-collector/denylist"
+	_ "github.com/ffdo/node-informant/gluon-collector/identity"
+	_ "github.com/ffdo/node-informant/gluon-collector/prometheus"
 )
 
-func getProcessPipes(store data.Nodeinfostore) []pipeline.ProcessPipe {
-	pipes := make([]pipeline.ProcessPipe, 0, 10)
+var (
+	// DefaultReceiveStages are used if the receive pipeline is not configured.
+	DefaultReceiveStages = []string{"deflate"}
+
+	// DefaultParseStage is used if the parse stage is not configured.
+	DefaultParseStage = "json"
+
+	// DefaultProcessStages are used if the process pipeline is not configured.
+	// The prometheus pipes need to be added before the collectors, since they
+	// compare the received data with the stored data. Denied nodes are dropped
+	// before they can affect any other stage.
+	DefaultProcessStages = []string{"denylist", "dedupe", "identity",
+		"clientcount", "trafficcount", "nodemetrics", "wifimetrics", "neighbourmetrics",
+		"gateway", "nodeinfo", "statistics", "history", "neighbours", "raw", "status"}
+)
 
-	pipes = append(pipes, prometheus.GetPrometheusProcessPipes(store)...)
-	pipes = append(pipes, &collectors.GatewayCollector{Store: store},
-		&collectors.NodeinfoCollector{Store: store}, &collectors.StatisticsCollector{Store: store},
-		&collectors.NeighbourInfoCollector{Store: store}, &collectors.StatusInfoCollector{Store: store})
-	return pipes
+// Stage is a single named stage of a pipeline with its options.
+type Stage struct {
+	Name    string
+	Options *cfg.Config
 }
 
-func BuildPipelines(store data.Nodeinfostore, receiver announced.AnnouncedPacketReceiver, pipeEnd func(response data.ParsedResponse)) ([]io.Closer, error) {
+// PipelineConfig describes of which stages the receive and process pipelines
+// consist and in which order they are connected.
+type PipelineConfig struct {
+	Receive []Stage
+	Parse   Stage
+	Process []Stage
+}
+
+// stageOptions returns the options of a stage which has not been configured
+// inline. For these stages the top level config section with the name of the
+// stage is used, i.e. the identity stage uses the identity section.
+func stageOptions(name string) *cfg.Config {
+	if conf.Global == nil {
+		return pipeline.EmptyOptions()
+	}
+	options, err := conf.Global.Get(name)
+	if err != nil {
+		return pipeline.EmptyOptions()
+	}
+	return options
+}
+
+func stagesFromNames(names []string) []Stage {
+	stages := make([]Stage, 0, len(names))
+	for _, name := range names {
+		stages = append(stages, Stage{Name: name, Options: stageOptions(name)})
+	}
+	return stages
+}
+
+// parseStage converts a configured stage which is either simply the name of
+// the stage or a map with the name and the options of the stage.
+func parseStage(value interface{}) (Stage, error) {
+	switch v := value.(type) {
+	case string:
+		return Stage{Name: v, Options: stageOptions(v)}, nil
+	case map[string]interface{}:
+		name, ok := v["name"].(string)
+		if !ok || name == "" {
+			return Stage{}, fmt.Errorf("Pipeline stage without name: %v", v)
+		}
+		return Stage{Name: name, Options: &cfg.Config{Root: v}}, nil
+	}
+	return Stage{}, fmt.Errorf("Invalid pipeline stage %v", value)
+}
+
+func stagesFromConfig(path string, defaults []string) ([]Stage, error) {
+	if conf.Global == nil {
+		return stagesFromNames(defaults), nil
+	}
+	list, err := conf.Global.List(path)
+	if err != nil {
+		return stagesFromNames(defaults), nil
+	}
+	stages := make([]Stage, 0, len(list))
+	for _, value := range list {
+		stage, err := parseStage(value)
+		if err != nil {
+			return nil, err
+		}
+		stages = append(stages, stage)
+	}
+	return stages, nil
+}
+
+// DefaultPipelineConfig returns the pipeline configuration used if nothing is
+// configured.
+func DefaultPipelineConfig() PipelineConfig {
+	return PipelineConfig{
+		Receive: stagesFromNames(DefaultReceiveStages),
+		Parse:   Stage{Name: DefaultParseStage, Options: stageOptions(DefaultParseStage)},
+		Process: stagesFromNames(DefaultProcessStages),
+	}
+}
+
+// PipelineConfigFromGlobal reads the pipeline configuration from the pipeline
+// section of the global config. Every part which is not configured falls back
+// to the defaults.
+func PipelineConfigFromGlobal() (PipelineConfig, error) {
+	pipelineConfig := DefaultPipelineConfig()
+	var err error
+	if pipelineConfig.Receive, err = stagesFromConfig("pipeline.receive", DefaultReceiveStages); err != nil {
+		return pipelineConfig, err
+	}
+	if pipelineConfig.Process, err = stagesFromConfig("pipeline.process", DefaultProcessStages); err != nil {
+		return pipelineConfig, err
+	}
+	if conf.Global != nil {
+		if value, err := conf.Global.Get("pipeline.parse"); err == nil {
+			if pipelineConfig.Parse, err = parseStage(value.Root); err != nil {
+				return pipelineConfig, err
+			}
+		}
+	}
+	return pipelineConfig, nil
+}
+
+func stageNames(stages []Stage) []string {
+	names := make([]string, 0, len(stages))
+	for _, stage := range stages {
+		names = append(names, stage.Name)
+	}
+	return names
+}
+
+// collectExtras sorts the created pipes into the ones which need to be closed
+// and the ones exposing an http API themselves.
+func collectExtras(pipe interface{}, closeables []io.Closer, serveables []httpserver.HttpServeable) ([]io.Closer, []httpserver.HttpServeable) {
+	if closeable, ok := pipe.(io.Closer); ok {
+		closeables = append(closeables, closeable)
+	}
+	if serveable, ok := pipe.(httpserver.HttpServeable); ok {
+		serveables = append(serveables, serveable)
+	}
+	return closeables, serveables
+}
+
+// BuildPipelines connects the receiver to the receive and process pipelines
+// configured in the global config.
+// Beside the closeables it returns all pipes which want to expose routes via
+// the http server.
+func BuildPipelines(store data.Nodeinfostore, receiver announced.AnnouncedPacketReceiver, pipeEnd func(response data.ParsedResponse)) ([]io.Closer, []httpserver.HttpServeable, error) {
+	pipelineConfig, err := PipelineConfigFromGlobal()
+	if err != nil {
+		return nil, nil, err
+	}
+	return BuildConfiguredPipelines(pipelineConfig, store, receiver, pipeEnd)
+}
+
+// BuildConfiguredPipelines works like BuildPipelines, but uses the given
+// pipeline configuration. This is useful to build minimal pipelines.
+func BuildConfiguredPipelines(pipelineConfig PipelineConfig, store data.Nodeinfostore, receiver announced.AnnouncedPacketReceiver, pipeEnd func(response data.ParsedResponse)) ([]io.Closer, []httpserver.HttpServeable, error) {
 
 	closeables := make([]io.Closer, 0, 2)
+	serveables := make([]httpserver.HttpServeable, 0, 2)
+
+	receivePipes := make([]pipeline.ReceivePipe, 0, len(pipelineConfig.Receive))
+	for _, stage := range pipelineConfig.Receive {
+		pipe, err := pipeline.NewReceivePipe(stage.Name, stage.Options)
+		if err != nil {
+			return closeables, serveables, err
+		}
+		closeables, serveables = collectExtras(pipe, closeables, serveables)
+		receivePipes = append(receivePipes, pipe)
+	}
+	parsePipe, err := pipeline.NewParsePipe(pipelineConfig.Parse.Name, pipelineConfig.Parse.Options)
+	if err != nil {
+		return closeables, serveables, err
+	}
+	processPipes := make([]pipeline.ProcessPipe, 0, len(pipelineConfig.Process))
+	for _, stage := range pipelineConfig.Process {
+		pipe, err := pipeline.NewProcessPipe(stage.Name, store, stage.Options)
+		if err != nil {
+			return closeables, serveables, err
+		}
+		closeables, serveables = collectExtras(pipe, closeables, serveables)
+		processPipes = append(processPipes, pipe)
+	}
+	log.WithFields(log.Fields{
+		"receive": stageNames(pipelineConfig.Receive),
+		"parse":   pipelineConfig.Parse.Name,
+		"process": stageNames(pipelineConfig.Process),
+	}).Debug("Assembling pipelines")
 
-	receivePipeline := pipeline.NewReceivePipeline(&pipeline.JsonParsePipe{}, &pipeline.DeflatePipe{})
-	processPipe := pipeline.NewProcessPipeline(getProcessPipes(store)...)
+	receivePipeline := pipeline.NewReceivePipeline(parsePipe, receivePipes...)
+	processPipe := pipeline.NewProcessPipeline(processPipes...)
 	closeables = append(closeables, receivePipeline, processPipe)
 	log.Printf("Adding process pipe end")
 	go func() {
@@ -46,5 +227,5 @@ func BuildPipelines(store data.Nodeinfostore, receiver announced.AnnouncedPacket
 			processPipe.Enqueue(response)
 		})
 	}()
-	return closeables, nil
+	return closeables, serveables, nil
 }
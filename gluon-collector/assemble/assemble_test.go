@@ -0,0 +1,104 @@
+package assemble
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/ffdo/node-informant/announced"
+	"github.com/ffdo/node-informant/gluon-collector/config"
+	"github.com/ffdo/node-informant/gluon-collector/data"
+	cfg "github.com/olebedev/config"
+	"github.com/stretchr/testify/assert"
+)
+
+var pipelineConfig = `
+dedupe:
+  window: 30
+pipeline:
+  receive: []
+  process:
+  - validate
+  - dedupe
+  - name: identity
+    window: 10
+  - nodeinfo
+`
+
+type plainReceiver struct {
+	responses []announced.Response
+}
+
+func (p *plainReceiver) Query(queryString string) {}
+
+func (p *plainReceiver) QueryUnicast(addr *net.UDPAddr, queryString string) {}
+
+func (p *plainReceiver) Receive(rFunc func(announced.Response)) {
+	for _, response := range p.responses {
+		rFunc(response)
+	}
+}
+
+func (p *plainReceiver) Close() error {
+	return nil
+}
+
+func TestParsingPipelineConfig(t *testing.T) {
+	assert := assert.New(t)
+	var err error
+	config.Global, err = cfg.ParseYaml(pipelineConfig)
+	assert.Nil(err)
+
+	pipelineConfig, err := PipelineConfigFromGlobal()
+	assert.Nil(err)
+	assert.Equal(0, len(pipelineConfig.Receive))
+	assert.Equal(DefaultParseStage, pipelineConfig.Parse.Name)
+	assert.Equal([]string{"validate", "dedupe", "identity", "nodeinfo"}, stageNames(pipelineConfig.Process))
+	assert.Equal(30, pipelineConfig.Process[1].Options.UInt("window", 60), "Options are taken from the top level section")
+	assert.Equal(10, pipelineConfig.Process[2].Options.UInt("window", 3600), "Inline options are used")
+
+	config.Global, err = cfg.ParseYaml(`
+pipeline:
+  process:
+  - window: 10
+`)
+	assert.Nil(err)
+	_, err = PipelineConfigFromGlobal()
+	assert.NotNil(err, "A stage without name is invalid")
+}
+
+func TestBuildingMinimalPipeline(t *testing.T) {
+	assert := assert.New(t)
+	config.Global = &cfg.Config{}
+	store := data.NewSimpleInMemoryStore()
+	receiver := &plainReceiver{responses: []announced.Response{
+		announced.Response{Payload: []byte(`{"nodeinfo": {"node_id": "a", "hostname": "Node A"}}`)},
+		announced.Response{Payload: []byte(`not json at all`)},
+	}}
+
+	minimalConfig := PipelineConfig{
+		Parse:   Stage{Name: "json"},
+		Process: []Stage{Stage{Name: "validate"}, Stage{Name: "nodeinfo"}},
+	}
+	received := make(chan data.ParsedResponse, 2)
+	closeables, _, err := BuildConfiguredPipelines(minimalConfig, store, receiver, func(response data.ParsedResponse) {
+		received <- response
+	})
+	assert.Nil(err)
+
+	select {
+	case response := <-received:
+		assert.Equal("a", response.NodeId())
+	case <-time.After(time.Second):
+		assert.Fail("Response didn't pass the pipeline")
+	}
+	nodeinfo, err := store.GetNodeInfo("a")
+	assert.Nil(err)
+	assert.Equal("Node A", nodeinfo.Hostname)
+	for _, closeable := range closeables {
+		closeable.Close()
+	}
+
+	_, _, err = BuildConfiguredPipelines(PipelineConfig{Parse: Stage{Name: "unknown"}}, store, receiver, nil)
+	assert.NotNil(err)
+}
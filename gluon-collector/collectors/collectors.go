@@ -6,11 +6,12 @@ import (
 	log "github.com/Sirupsen/logrus"
 
 	"github.com/ffdo/node-informant/gluon-collector/data"
-	"github.com/ffdo/node-informant/gluon-collector/prometheus"
 )
 
 // GatewayCollector inspects all received statistics and stores the mac addresses
-// of gateways to the data store.
+// of gateways to the data store. If a node reports another gateway than in its
+// stored statistics a GatewayChanged event is published, so this collector needs
+// to run before the statistics are stored.
 type GatewayCollector struct {
 	Store data.Nodeinfostore
 }
@@ -25,6 +26,16 @@ func (g *GatewayCollector) Process(in chan data.ParsedResponse) chan data.Parsed
 				if gateway != "" {
 					g.Store.PutGateway(gateway)
 				}
+				stored, err := g.Store.GetStatistics(statistics.NodeId)
+				if err == nil && stored.Gateway != gateway {
+					g.Store.Events().Publish(data.Event{
+						Type:   data.GatewayChanged,
+						NodeId: statistics.NodeId,
+						Time:   response.Meta().Received,
+						From:   stored.Gateway,
+						To:     gateway,
+					})
+				}
 			}
 			out <- response
 		}
@@ -34,18 +45,53 @@ func (g *GatewayCollector) Process(in chan data.ParsedResponse) chan data.Parsed
 }
 
 // NodeinfoCollector inspects all ParsedResponses containing general information
-// about a node and stores this to the data store.
+// about a node and stores this to the data store. Changes to the stored nodeinfo
+// are published as NodeinfoChanged event and recorded if the store keeps a
+// nodeinfo history.
 type NodeinfoCollector struct {
 	Store data.Nodeinfostore
 }
 
+func (n *NodeinfoCollector) recordChanges(nodeinfo data.NodeInfo, received time.Time) {
+	stored, err := n.Store.GetNodeInfo(nodeinfo.NodeId)
+	if err != nil {
+		// Nothing to compare with for new nodes
+		return
+	}
+	if received.IsZero() {
+		received = time.Now()
+	}
+	changes, err := data.DiffNodeinfo(stored, nodeinfo, received)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"error":  err,
+			"nodeid": nodeinfo.NodeId,
+		}).Error("Can't compare nodeinfos")
+		return
+	}
+	if len(changes) == 0 {
+		return
+	}
+	if history, ok := n.Store.(data.NodeinfoHistoryStore); ok {
+		history.PutNodeinfoChanges(changes)
+	}
+	n.Store.Events().Publish(data.Event{
+		Type:    data.NodeinfoChanged,
+		NodeId:  nodeinfo.NodeId,
+		Time:    received,
+		Changes: changes,
+	})
+}
+
 func (n *NodeinfoCollector) Process(in chan data.ParsedResponse) chan data.ParsedResponse {
 	out := make(chan data.ParsedResponse)
 	go func() {
 		for response := range in {
 			if response.Type() == "nodeinfo" {
 				nodeinfo := response.ParsedData().(data.NodeInfo)
+				n.recordChanges(nodeinfo, response.Meta().Received)
 				n.Store.PutNodeInfo(nodeinfo)
+				n.Store.PutResponseMeta(nodeinfo.NodeId, response.Type(), response.Meta())
 			}
 			out <- response
 		}
@@ -67,6 +113,7 @@ func (s *StatisticsCollector) Process(in chan data.ParsedResponse) chan data.Par
 			if response.Type() == "statistics" {
 				statistics := response.ParsedData().(*data.StatisticsStruct)
 				s.Store.PutStatistics(*statistics)
+				s.Store.PutResponseMeta(statistics.NodeId, response.Type(), response.Meta())
 			}
 			out <- response
 		}
@@ -87,6 +134,7 @@ func (n *NeighbourInfoCollector) Process(in chan data.ParsedResponse) chan data.
 			if response.Type() == "neighbours" {
 				neighbours := response.ParsedData().(*data.NeighbourStruct)
 				n.Store.PutNodeNeighbours(*neighbours)
+				n.Store.PutResponseMeta(neighbours.NodeId, response.Type(), response.Meta())
 			}
 			out <- response
 		}
@@ -95,41 +143,140 @@ func (n *NeighbourInfoCollector) Process(in chan data.ParsedResponse) chan data.
 	return out
 }
 
+// RawCollector stores the json documents of all responses as they have been
+// received, so fields not modelled by our structs are available too.
+type RawCollector struct {
+	Store data.Nodeinfostore
+}
+
+func (r *RawCollector) Process(in chan data.ParsedResponse) chan data.ParsedResponse {
+	out := make(chan data.ParsedResponse)
+	go func() {
+		for response := range in {
+			if raw := response.RawData(); raw != nil && response.NodeId() != "" {
+				r.Store.PutRawData(response.NodeId(), response.Type(), raw)
+			}
+			out <- response
+		}
+	}()
+	return out
+}
+
+// HistoryCollector appends a sample of every received statistics to the
+// statistics history of the node. It does nothing if the store can't keep a
+// history.
+type HistoryCollector struct {
+	Store data.StatisticsHistoryStore
+}
+
+func (h *HistoryCollector) Process(in chan data.ParsedResponse) chan data.ParsedResponse {
+	out := make(chan data.ParsedResponse)
+	go func() {
+		for response := range in {
+			if response.Type() == "statistics" && h.Store != nil {
+				statistics := response.ParsedData().(*data.StatisticsStruct)
+				received := response.Meta().Received
+				if received.IsZero() {
+					received = time.Now()
+				}
+				h.Store.PutStatisticsSample(statistics.NodeId, data.NewStatisticsSample(received, *statistics))
+			}
+			out <- response
+		}
+	}()
+	return out
+}
+
 const TimeFormat string = time.RFC3339
 
 // StatusInfoCollector creates some meta data like Firstseen and Lastseen for every
 // node. Everytime we receive a packet from a node, we assume that is online and also
-// update the Lastseen value. If we have never seen a packet from this node before we
-// also set the Firstseen value.
-// TODO determine Gateway status.
+// update the Lastseen value to the time the packet was received. If we have never
+// seen a packet from this node before we also set the Firstseen value.
+// If the store keeps an event log, new, returned and online events are recorded.
+// NodeNew and NodeOnline events are published for new and returning nodes.
+// The Gateway flag is maintained by the gateways.Tracker, the IdentityConflict
+// flag is taken from the response meta data set by the identity stage.
 type StatusInfoCollector struct {
 	Store data.Nodeinfostore
 }
 
+// domain determines the mesh domain of the node. The domain of the receiver
+// takes precedence over the domain announced by the node.
+func (s *StatusInfoCollector) domain(response data.ParsedResponse, stored string) string {
+	if domain := response.Meta().Domain; domain != "" {
+		return domain
+	}
+	if response.Type() == "nodeinfo" {
+		if domain := response.ParsedData().(data.NodeInfo).Domain(); domain != "" {
+			return domain
+		}
+	}
+	if stored != "" {
+		return stored
+	}
+	if nodeinfo, err := s.Store.GetNodeInfo(response.NodeId()); err == nil {
+		return nodeinfo.Domain()
+	}
+	return ""
+}
+
+// putEvent records the status transition if the store keeps an event log.
+func (s *StatusInfoCollector) putEvent(nodeId string, received time.Time, eventType string) {
+	if eventLog, ok := s.Store.(data.EventLog); ok {
+		eventLog.PutEvent(data.NodeEvent{Time: received, NodeId: nodeId, Type: eventType})
+	}
+}
+
+// firstSeenEvent determines whether a node we have no status for is new or
+// has been expired before.
+func (s *StatusInfoCollector) firstSeenEvent(nodeId string, received time.Time) string {
+	if eventLog, ok := s.Store.(data.EventLog); ok {
+		last, err := eventLog.GetLastEvent(nodeId, received)
+		if err == nil && last.Type == data.EventExpired {
+			return data.EventReturned
+		}
+	}
+	return data.EventNew
+}
+
 func (s *StatusInfoCollector) Process(in chan data.ParsedResponse) chan data.ParsedResponse {
 	out := make(chan data.ParsedResponse)
 	go func() {
 		for response := range in {
 			nodeId := response.NodeId()
+			received := response.Meta().Received
+			if received.IsZero() {
+				received = time.Now()
+			}
 			statusInfo, err := s.Store.GetNodeStatusInfo(nodeId)
 			if err == nil {
 				if !statusInfo.Online {
-					prometheus.OnlineNodes.Inc()
 					log.WithFields(log.Fields{
 						"nodeid": nodeId,
 					}).Info("Node is considered online again, after receiving any packet at all")
+					s.putEvent(nodeId, received, data.EventOnline)
+					s.Store.Events().Publish(data.Event{Type: data.NodeOnline, NodeId: nodeId, Time: received})
+					// The status engine decides whether the node is degraded
+					statusInfo.State = data.StateOnline
 				}
 				statusInfo.Online = true
-				statusInfo.Lastseen = time.Now().Format(TimeFormat)
+				statusInfo.Lastseen = received.Format(TimeFormat)
+				statusInfo.Domain = s.domain(response, statusInfo.Domain)
 			} else {
 				statusInfo = data.NodeStatusInfo{
 					Online:    true,
-					Firstseen: time.Now().Format(TimeFormat),
-					Lastseen:  time.Now().Format(TimeFormat),
+					State:     data.StateOnline,
+					Firstseen: received.Format(TimeFormat),
+					Lastseen:  received.Format(TimeFormat),
 					Gateway:   false,
 					NodeId:    nodeId,
+					Domain:    s.domain(response, ""),
 				}
+				s.putEvent(nodeId, received, s.firstSeenEvent(nodeId, received))
+				s.Store.Events().Publish(data.Event{Type: data.NodeNew, NodeId: nodeId, Time: received})
 			}
+			statusInfo.IdentityConflict = response.Meta().IdentityConflict
 			s.Store.PutNodeStatusInfo(nodeId, statusInfo)
 			out <- response
 		}
@@ -0,0 +1,36 @@
+package collectors
+
+import (
+	log "github.com/Sirupsen/logrus"
+	"github.com/ffdo/node-informant/gluon-collector/data"
+	"github.com/ffdo/node-informant/gluon-collector/pipeline"
+	cfg "github.com/olebedev/config"
+)
+
+func init() {
+	pipeline.RegisterProcessPipe("gateway", func(store data.Nodeinfostore, options *cfg.Config) (pipeline.ProcessPipe, error) {
+		return &GatewayCollector{Store: store}, nil
+	})
+	pipeline.RegisterProcessPipe("nodeinfo", func(store data.Nodeinfostore, options *cfg.Config) (pipeline.ProcessPipe, error) {
+		return &NodeinfoCollector{Store: store}, nil
+	})
+	pipeline.RegisterProcessPipe("statistics", func(store data.Nodeinfostore, options *cfg.Config) (pipeline.ProcessPipe, error) {
+		return &StatisticsCollector{Store: store}, nil
+	})
+	pipeline.RegisterProcessPipe("neighbours", func(store data.Nodeinfostore, options *cfg.Config) (pipeline.ProcessPipe, error) {
+		return &NeighbourInfoCollector{Store: store}, nil
+	})
+	pipeline.RegisterProcessPipe("raw", func(store data.Nodeinfostore, options *cfg.Config) (pipeline.ProcessPipe, error) {
+		return &RawCollector{Store: store}, nil
+	})
+	pipeline.RegisterProcessPipe("history", func(store data.Nodeinfostore, options *cfg.Config) (pipeline.ProcessPipe, error) {
+		historyStore, ok := store.(data.StatisticsHistoryStore)
+		if !ok {
+			log.Warn("The data store keeps no statistics history, the history stage does nothing")
+		}
+		return &HistoryCollector{Store: historyStore}, nil
+	})
+	pipeline.RegisterProcessPipe("status", func(store data.Nodeinfostore, options *cfg.Config) (pipeline.ProcessPipe, error) {
+		return &StatusInfoCollector{Store: store}, nil
+	})
+}
@@ -34,6 +34,16 @@ func UString(key, def string) string {
 	}
 }
 
+// UBool tries to retrieve a boolean value specified by the key. For this the same
+// rules as with UInt apply.
+func UBool(key string, def bool) bool {
+	if Global != nil {
+		return Global.UBool(key, def)
+	} else {
+		return def
+	}
+}
+
 // ParseConfig parses a configuration file located at path. Configuration files
 // need to end in .yaml or .json, depending whether they are in yaml or json format
 func ParseConfig(path string) error {
@@ -0,0 +1,199 @@
+package data
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+)
+
+// ArchiveVersion is the version of the archive format written by ExportArchive.
+const ArchiveVersion int = 1
+
+// Archive contains everything a store knows in a store independent format, so
+// the data can be moved between different store types or hosts. The parts only
+// some stores keep, like the history, are left out if the store doesn't keep
+// them.
+type Archive struct {
+	Version     int                `json:"version"`
+	Created     time.Time          `json:"created"`
+	Nodeinfos   []NodeInfo         `json:"nodeinfos"`
+	Statistics  []StatisticsStruct `json:"statistics"`
+	StatusInfos []NodeStatusInfo   `json:"status"`
+	Neighbours  []NeighbourStruct  `json:"neighbours"`
+	Gateways    []string           `json:"gateways"`
+	// Raw contains the raw json documents by node id and response type.
+	Raw map[string]map[string]json.RawMessage `json:"raw,omitempty"`
+	// ResponseMeta contains the response envelopes by node id and response type.
+	ResponseMeta map[string]map[string]ResponseMeta `json:"response_meta,omitempty"`
+	// History contains the statistics samples by tier and node id.
+	History         map[string]map[string][]StatisticsSample `json:"history,omitempty"`
+	Events          []NodeEvent                              `json:"events,omitempty"`
+	NodeinfoChanges []NodeinfoChange                         `json:"nodeinfo_changes,omitempty"`
+}
+
+// archivedNodeIds collects the ids of all nodes the store has any data for.
+func archivedNodeIds(archive *Archive) []string {
+	seen := make(map[string]bool)
+	nodeIds := make([]string, 0, len(archive.StatusInfos))
+	add := func(nodeId string) {
+		if nodeId != "" && !seen[nodeId] {
+			seen[nodeId] = true
+			nodeIds = append(nodeIds, nodeId)
+		}
+	}
+	for _, nodeinfo := range archive.Nodeinfos {
+		add(nodeinfo.NodeId)
+	}
+	for _, statistics := range archive.Statistics {
+		add(statistics.NodeId)
+	}
+	for _, status := range archive.StatusInfos {
+		add(status.NodeId)
+	}
+	for _, neighbours := range archive.Neighbours {
+		add(neighbours.NodeId)
+	}
+	return nodeIds
+}
+
+// ExportArchive collects all data of the store into an archive.
+func ExportArchive(store Nodeinfostore) (*Archive, error) {
+	archive := &Archive{
+		Version:      ArchiveVersion,
+		Created:      time.Now(),
+		Nodeinfos:    store.GetNodeInfos(),
+		Statistics:   store.GetAllStatistics(),
+		StatusInfos:  store.GetNodeStatusInfos(),
+		Neighbours:   store.GetAllNeighbours(),
+		Gateways:     store.GetGateways(),
+		Raw:          make(map[string]map[string]json.RawMessage),
+		ResponseMeta: make(map[string]map[string]ResponseMeta),
+	}
+	nodeIds := archivedNodeIds(archive)
+	for _, nodeId := range nodeIds {
+		for _, responseType := range RawResponseTypes {
+			if raw, err := store.GetRawData(nodeId, responseType); err == nil {
+				if archive.Raw[nodeId] == nil {
+					archive.Raw[nodeId] = make(map[string]json.RawMessage)
+				}
+				archive.Raw[nodeId][responseType] = raw
+			}
+			if meta, err := store.GetResponseMeta(nodeId, responseType); err == nil {
+				if archive.ResponseMeta[nodeId] == nil {
+					archive.ResponseMeta[nodeId] = make(map[string]ResponseMeta)
+				}
+				archive.ResponseMeta[nodeId][responseType] = meta
+			}
+		}
+	}
+	if history, ok := store.(StatisticsHistoryStore); ok {
+		archive.History = make(map[string]map[string][]StatisticsSample)
+		for _, tier := range HistoryTiers {
+			archive.History[tier.Name] = make(map[string][]StatisticsSample)
+			for _, nodeId := range nodeIds {
+				if samples, err := history.GetHistoryTier(nodeId, tier.Name); err == nil {
+					archive.History[tier.Name][nodeId] = samples
+				}
+			}
+		}
+	}
+	if eventLog, ok := store.(EventLog); ok {
+		events, err := eventLog.GetEvents("", time.Unix(0, 0))
+		if err != nil {
+			return nil, err
+		}
+		archive.Events = events
+	}
+	if nodeinfoHistory, ok := store.(NodeinfoHistoryStore); ok {
+		changes, err := nodeinfoHistory.GetNodeinfoChanges("", time.Unix(0, 0))
+		if err != nil {
+			return nil, err
+		}
+		archive.NodeinfoChanges = changes
+	}
+	return archive, nil
+}
+
+// ImportArchive puts all data of the archive into the store. Existing data of
+// the same nodes is overwritten. Parts of the archive the store can't keep are
+// skipped.
+func ImportArchive(store Nodeinfostore, archive *Archive) error {
+	if archive.Version != ArchiveVersion {
+		return fmt.Errorf("Unsupported archive version %d", archive.Version)
+	}
+	if bulk, ok := store.(BulkStore); ok {
+		bulk.PutNodeInfos(archive.Nodeinfos)
+		bulk.PutAllStatistics(archive.Statistics)
+		bulk.PutNodeStatusInfos(archive.StatusInfos)
+		bulk.PutAllNeighbours(archive.Neighbours)
+	} else {
+		for _, nodeinfo := range archive.Nodeinfos {
+			store.PutNodeInfo(nodeinfo)
+		}
+		for _, statistics := range archive.Statistics {
+			store.PutStatistics(statistics)
+		}
+		for _, status := range archive.StatusInfos {
+			if status.NodeId != "" {
+				store.PutNodeStatusInfo(status.NodeId, status)
+			}
+		}
+		for _, neighbours := range archive.Neighbours {
+			store.PutNodeNeighbours(neighbours)
+		}
+	}
+	for _, gateway := range archive.Gateways {
+		store.PutGateway(gateway)
+	}
+	for nodeId, documents := range archive.Raw {
+		for responseType, raw := range documents {
+			store.PutRawData(nodeId, responseType, raw)
+		}
+	}
+	for nodeId, envelopes := range archive.ResponseMeta {
+		for responseType, meta := range envelopes {
+			store.PutResponseMeta(nodeId, responseType, meta)
+		}
+	}
+	if history, ok := store.(StatisticsHistoryStore); ok {
+		for tier, nodes := range archive.History {
+			for nodeId, samples := range nodes {
+				history.PutHistoryTier(nodeId, tier, samples)
+			}
+		}
+	}
+	if eventLog, ok := store.(EventLog); ok {
+		for _, event := range archive.Events {
+			eventLog.PutEvent(event)
+		}
+	}
+	if nodeinfoHistory, ok := store.(NodeinfoHistoryStore); ok && len(archive.NodeinfoChanges) > 0 {
+		nodeinfoHistory.PutNodeinfoChanges(archive.NodeinfoChanges)
+	}
+	return nil
+}
+
+// WriteArchive exports the store and writes the archive as json.
+func WriteArchive(w io.Writer, store Nodeinfostore) error {
+	archive, err := ExportArchive(store)
+	if err != nil {
+		return err
+	}
+	return json.NewEncoder(w).Encode(archive)
+}
+
+// ReadArchive reads an archive in json and imports it into the store.
+func ReadArchive(r io.Reader, store Nodeinfostore) error {
+	archive := &Archive{}
+	if err := json.NewDecoder(r).Decode(archive); err != nil {
+		return err
+	}
+	return ImportArchive(store, archive)
+}
+
+// BackupStore can be implemented by stores which are able to write a consistent
+// snapshot in their own format while they are in use.
+type BackupStore interface {
+	Backup(w io.Writer) (int64, error)
+}
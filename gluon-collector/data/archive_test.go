@@ -0,0 +1,104 @@
+package data
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func fillStore(store Nodeinfostore) {
+	store.PutNodeInfo(NodeInfo{NodeId: "a", Hostname: "Node A"})
+	store.PutStatistics(StatisticsStruct{NodeId: "a", Uptime: 42})
+	store.PutNodeStatusInfo("a", NodeStatusInfo{NodeId: "a", Online: true, Lastseen: "2016-03-01T12:00:00Z"})
+	store.PutNodeNeighbours(NeighbourStruct{NodeId: "a"})
+	store.PutGateway("de:ad:be:ef:00:01")
+	store.PutRawData("a", "nodeinfo", json.RawMessage(`{"node_id":"a","custom":1}`))
+	store.PutResponseMeta("a", "nodeinfo", ResponseMeta{Receiver: "bat0"})
+}
+
+func TestMovingDataBetweenStores(t *testing.T) {
+	assert := assert.New(t)
+	dbPath := "./archive.db"
+	defer os.RemoveAll(dbPath)
+	boltStore, err := NewBoltStore(dbPath)
+	assert.Nil(err)
+	defer boltStore.Close()
+	fillStore(boltStore)
+	now := time.Now()
+	boltStore.PutStatisticsSample("a", StatisticsSample{Time: now, Count: 1, Clients: 3})
+	boltStore.PutEvent(NodeEvent{Time: now, NodeId: "a", Type: EventNew})
+	boltStore.PutNodeinfoChanges([]NodeinfoChange{NodeinfoChange{Time: now, NodeId: "a", Path: "hostname"}})
+
+	buffer := &bytes.Buffer{}
+	assert.Nil(WriteArchive(buffer, boltStore))
+	exported := buffer.Bytes()
+
+	memoryStore := NewSimpleInMemoryStore()
+	assert.Nil(ReadArchive(bytes.NewReader(exported), memoryStore))
+	nodeinfo, err := memoryStore.GetNodeInfo("a")
+	assert.Nil(err)
+	assert.Equal("Node A", nodeinfo.Hostname)
+	statistics, err := memoryStore.GetStatistics("a")
+	assert.Nil(err)
+	assert.Equal(42.0, statistics.Uptime)
+	status, err := memoryStore.GetNodeStatusInfo("a")
+	assert.Nil(err)
+	assert.True(status.Online)
+	assert.True(memoryStore.IsGateway("de:ad:be:ef:00:01"))
+	raw, err := memoryStore.GetRawData("a", "nodeinfo")
+	assert.Nil(err)
+	assert.Equal(`{"node_id":"a","custom":1}`, string(raw))
+	meta, err := memoryStore.GetResponseMeta("a", "nodeinfo")
+	assert.Nil(err)
+	assert.Equal("bat0", meta.Receiver)
+
+	otherPath := "./restored.db"
+	defer os.RemoveAll(otherPath)
+	restored, err := NewBoltStore(otherPath)
+	assert.Nil(err)
+	defer restored.Close()
+	assert.Nil(ReadArchive(bytes.NewReader(exported), restored))
+	assert.Nil(ReadArchive(bytes.NewReader(exported), restored))
+	samples, err := restored.GetHistoryTier("a", "5m")
+	assert.Nil(err)
+	assert.Equal(1, len(samples))
+	events, err := restored.GetEvents("a", now.Add(-time.Hour))
+	assert.Nil(err)
+	assert.Equal(1, len(events), "Importing twice doesn't duplicate events")
+	changes, err := restored.GetNodeinfoChanges("a", now.Add(-time.Hour))
+	assert.Nil(err)
+	assert.Equal(1, len(changes))
+	assert.Equal([]string{"de:ad:be:ef:00:01"}, restored.GetGateways())
+
+	assert.NotNil(ImportArchive(memoryStore, &Archive{Version: ArchiveVersion + 1}))
+}
+
+func TestWritingBackup(t *testing.T) {
+	assert := assert.New(t)
+	dbPath := "./backup.db"
+	defer os.RemoveAll(dbPath)
+	store, err := NewBoltStore(dbPath)
+	assert.Nil(err)
+	defer store.Close()
+	fillStore(store)
+
+	backupPath := "./backup-copy.db"
+	defer os.RemoveAll(backupPath)
+	backupFile, err := os.Create(backupPath)
+	assert.Nil(err)
+	written, err := store.Backup(backupFile)
+	backupFile.Close()
+	assert.Nil(err)
+	assert.True(written > 0)
+
+	copied, err := NewBoltStore(backupPath)
+	assert.Nil(err)
+	defer copied.Close()
+	nodeinfo, err := copied.GetNodeInfo("a")
+	assert.Nil(err)
+	assert.Equal("Node A", nodeinfo.Hostname)
+}
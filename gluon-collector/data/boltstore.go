@@ -3,6 +3,8 @@ package data
 import (
 	"encoding/json"
 	"fmt"
+	"io"
+	"sync"
 	"time"
 
 	log "github.com/Sirupsen/logrus"
@@ -14,12 +16,16 @@ import (
 // BoltStore implements the Nodeinfostore interface. BoltStore uses the embedded
 // bolt database to persist data to disc.
 type BoltStore struct {
-	db                  *bolt.DB
-	bucket              *bolt.Bucket
-	onlineStatusJob     *scheduler.ScheduledJob
-	expireNodesJob      *scheduler.ScheduledJob
-	gwOfflineHandler    []func(string)
-	expiredNodesHandler []func(string)
+	db       *bolt.DB
+	bucket   *bolt.Bucket
+	pruneJob *scheduler.ScheduledJob
+	flushJob *scheduler.ScheduledJob
+	writes   *writeBuffer
+	index    *nodeIndex
+	events   *EventBus
+	// statusLock is held while the status infos are written, so they can be
+	// updated in a single step.
+	statusLock sync.Mutex
 }
 
 type JsonBool struct {
@@ -32,20 +38,30 @@ const (
 	StatusInfoBucket string = "statusinfo"
 	NeighboursBucket string = "neighbours"
 	GatewayBucket    string = "gateways"
+	RawBucket        string = "raw"
+	MetaBucket       string = "responsemeta"
 )
 
 var AllBucketNames = []string{NodeinfoBucket, StatisticsBucket,
-	StatusInfoBucket, NeighboursBucket, GatewayBucket}
+	StatusInfoBucket, NeighboursBucket, GatewayBucket, RawBucket, MetaBucket,
+	HistoryBucket, EventBucket, ChangesBucket, MetadataBucket}
 
 // NewBoltStore creates a new BoltStore where the database file is located at
 // the given path. If the file does not exist it will be created. If there is
 // already a bolt database at the given path this BoltStore will contain its data.
+// All pending migrations are run on the database before it is used.
 func NewBoltStore(path string) (*BoltStore, error) {
 	db, err := bolt.Open(path, 0600, nil)
 	if err != nil {
 		return nil, err
 	}
-	store := &BoltStore{db: db}
+	flushInterval := time.Duration(conf.UInt("store.flushInterval", 1000)) * time.Millisecond
+	store := &BoltStore{
+		db:     db,
+		writes: newWriteBuffer(flushInterval, conf.UInt("store.maxPendingWrites", 10000)),
+		index:  newNodeIndex(),
+		events: NewEventBus(DefaultEventBufferSize),
+	}
 	err = db.Update(func(tx *bolt.Tx) error {
 		for _, bucketName := range AllBucketNames {
 			_, err := tx.CreateBucketIfNotExists([]byte(bucketName))
@@ -58,175 +74,70 @@ func NewBoltStore(path string) (*BoltStore, error) {
 	if err != nil {
 		return nil, err
 	}
-	store.gwOfflineHandler = make([]func(string), 0, 10)
-	store.expiredNodesHandler = make([]func(string), 0, 10)
-	store.onlineStatusJob = scheduler.NewJob(time.Minute*1, store.calculateOnlineStatus, false)
-	store.expireNodesJob = scheduler.NewJob(time.Hour*24, store.expireUnreachableNodes, false)
+	if _, err := migrate(db, false); err != nil {
+		db.Close()
+		return nil, err
+	}
+	// The index is kept in memory only and rebuilt on every start
+	for _, nodeinfo := range store.GetNodeInfos() {
+		store.index.putNodeInfo(nodeinfo)
+	}
+	for _, neighbours := range store.GetAllNeighbours() {
+		store.index.putNeighbours(neighbours)
+	}
+	store.pruneJob = scheduler.NewJob(time.Hour*1, func() {
+		store.pruneHistory()
+		store.pruneEvents()
+		store.pruneChanges()
+	}, false)
+	if flushInterval > 0 {
+		store.flushJob = scheduler.NewJob(flushInterval, store.Flush, false)
+	}
 	return store, nil
 }
 
-// Close closes the underlying bolt database.
+// Close delivers the pending events, writes all buffered data and closes the
+// underlying bolt database.
 func (b *BoltStore) Close() error {
-	b.onlineStatusJob.Stop()
+	b.pruneJob.Stop()
+	if b.flushJob != nil {
+		b.flushJob.Stop()
+	}
+	b.events.Close()
+	b.Flush()
 	b.db.Close()
 	return nil
 }
 
-// executeHandlersOnNodeIdList is a helper method to simply invoke a list of handler
-// methods on a list if node ids.
-func executeHandlersOnNodeIdList(nodeIds []string, handlers []func(string)) {
-	for _, nodeid := range nodeIds {
-		for _, handler := range handlers {
-			handler(string(nodeid))
-		}
+// DeleteNode removes the node from all buckets. The events of the node are
+// kept, so the node can be recognized if it returns. The deletions go through
+// the write buffer, so they are ordered with the puts of the node.
+func (bs *BoltStore) DeleteNode(nodeId string) {
+	if nodeinfo, err := bs.GetNodeInfo(nodeId); err == nil && nodeinfo.Network.Mac != "" {
+		bs.deleteBytes(GatewayBucket, nodeinfo.Network.Mac)
 	}
-}
-
-// expireUnreachableNodes is invoked once per day to finally delete nodes which
-// have been offline for too long. The default interval is one year.
-// All information in all buckets regarding the expired nodes is removed.
-func (bs *BoltStore) expireUnreachableNodes() {
-	now := time.Now()
-	expireDuration := time.Duration(conf.UInt("store.expireNodesAfterDays", 365)*24) * time.Hour
-	expireSeconds := expireDuration.Seconds()
-	expiredNodeIds := make([]string, 0, 50)
-	err := bs.db.Update(func(tx *bolt.Tx) error {
-		b := tx.Bucket([]byte(StatusInfoBucket))
-		nodeinfoBucket := tx.Bucket([]byte(NodeinfoBucket))
-		statsBucket := tx.Bucket([]byte(StatisticsBucket))
-		neighbourBucket := tx.Bucket([]byte(NeighboursBucket))
-		gatewayBucket := tx.Bucket([]byte(GatewayBucket))
-		c := b.Cursor()
-		for k, v := c.First(); k != nil; k, v = c.Next() {
-			status := NodeStatusInfo{}
-			err := json.Unmarshal(v, &status)
-			if err != nil {
-				log.WithFields(log.Fields{
-					"error":      err,
-					"nodeId":     k,
-					"jsonString": string(v),
-				}).Error("Can't unmarshall json from node status info")
-				continue
-			}
-			var lastseen time.Time
-			lastseen, err = time.Parse(TimeFormat, status.Lastseen)
-			if err != nil {
-				// In case we have imported data from ffmap-backend still in our database
-				lastseen, err = time.Parse(LegacyTimeFormat, status.Lastseen)
-				if err != nil {
-					log.WithFields(log.Fields{
-						"error":      err,
-						"timeString": status.Lastseen,
-						"nodeId":     status.NodeId,
-					}).Error("Can't parse lastseen time")
-				}
-			}
-			if (now.Unix() - lastseen.Unix()) > int64(expireSeconds) {
-				log.WithFields(log.Fields{
-					"nodeid":   string(k),
-					"lastseen": status.Lastseen,
-				}).Info("Deleting node as it is considered gone forever after")
-				b.Delete(k)
-				nodeinfoBucket.Delete(k)
-				statsBucket.Delete(k)
-				neighbourBucket.Delete(k)
-				gatewayBucket.Delete(k)
-				expiredNodeIds = append(expiredNodeIds, string(k))
-			}
-		}
-		return nil
-	})
-	executeHandlersOnNodeIdList(expiredNodeIds, bs.expiredNodesHandler)
-	if err != nil {
-		log.WithFields(log.Fields{
-			"error": err,
-		}).Error("Error in database transaction while updating online status")
+	bs.index.deleteNode(nodeId)
+	bs.statusLock.Lock()
+	for _, bucket := range []string{StatusInfoBucket, NodeinfoBucket,
+		StatisticsBucket, NeighboursBucket, GatewayBucket} {
+		bs.deleteBytes(bucket, nodeId)
 	}
-}
-
-// calculateOnlineStatus is invoked once a minute to calculate the current
-// online status of a node. A node is considered to be offline if it has missed
-// to report data back on multiple requests. Eventually the node is tried via
-// unicast, which could bring her back.
-func (bs *BoltStore) calculateOnlineStatus() {
-	now := time.Now()
-	updateInterval := conf.UInt("announced.interval.statistics", 300)
-	factor := conf.UInt("announced.interval.expire", 3)
-	offlineInterval := updateInterval * factor
-	offlineNodeIds := make([]string, 0, 50)
-	err := bs.db.Update(func(tx *bolt.Tx) error {
-		b := tx.Bucket([]byte(StatusInfoBucket))
-		c := b.Cursor()
-		for k, v := c.First(); k != nil; k, v = c.Next() {
-			status := NodeStatusInfo{}
-			err := json.Unmarshal(v, &status)
-			if err != nil {
-				log.WithFields(log.Fields{
-					"error":      err,
-					"nodeId":     k,
-					"jsonString": string(v),
-				}).Error("Can't unmarshall json from node status info")
-				continue
-			}
-			var lastseen time.Time
-			lastseen, err = time.Parse(TimeFormat, status.Lastseen)
-			if err != nil {
-				// In case we have imported data from ffmap-backend still in our database
-				lastseen, err = time.Parse(LegacyTimeFormat, status.Lastseen)
-				if err != nil {
-					log.WithFields(log.Fields{
-						"error":      err,
-						"timeString": status.Lastseen,
-						"nodeId":     status.NodeId,
-					}).Error("Can't parse lastseen time")
-				}
-			}
-			if (now.Unix()-lastseen.Unix()) > int64(offlineInterval) && status.Online {
-				log.WithFields(log.Fields{
-					"nodeid":   string(k),
-					"lastseen": status.Lastseen,
-				}).Info("Node is considered offline after being unreachable for too long")
-				status.Online = false
-				offlineNodeIds = append(offlineNodeIds, string(k))
-				if status.NodeId == "" {
-					status.NodeId = string(k)
-				}
-				data, err := json.Marshal(status)
-				if err == nil {
-					b.Put(k, data)
-				} else {
-					log.WithFields(log.Fields{
-						"error":      err,
-						"nodeStatus": status,
-						"nodeid":     string(k),
-					}).Error("Can't marshall node status info")
-				}
-			}
-		}
-		return nil
-	})
-	executeHandlersOnNodeIdList(offlineNodeIds, bs.gwOfflineHandler)
-	if err != nil {
-		log.WithFields(log.Fields{
-			"error": err,
-		}).Error("Error in database transaction while updating online status")
+	bs.statusLock.Unlock()
+	for _, responseType := range RawResponseTypes {
+		bs.deleteBytes(RawBucket, string(recordKey(nodeId, responseType)))
+		bs.deleteBytes(MetaBucket, string(recordKey(nodeId, responseType)))
 	}
+	bs.updateHistory(func(history pendingHistory) {
+		history.cleared[nodeId] = true
+		delete(history.changes, nodeId)
+		delete(history.samples, nodeId)
+	})
 }
 
 // put is a helper method to arbritrary data as marshalled as a json String
 // in the specified bucket.
 func (b *BoltStore) put(key, bucket string, data interface{}) {
 	bytes, err := json.Marshal(data)
-	if err == nil && bytes != nil {
-		err = b.db.Update(func(tx *bolt.Tx) error {
-			b := tx.Bucket([]byte(bucket))
-			if b == nil {
-				return fmt.Errorf("Bucket %s was null", bucket)
-			}
-			err = b.Put([]byte(key), bytes)
-			return err
-		})
-	}
 	if err != nil {
 		log.WithFields(log.Fields{
 			"error":  err,
@@ -234,35 +145,23 @@ func (b *BoltStore) put(key, bucket string, data interface{}) {
 			"bucket": bucket,
 			"data":   data,
 		}).Error("Error putting data into bolt store")
+		return
 	}
+	b.putBytes(bucket, key, bytes)
 }
 
 // get is a helper method to retrieve arbritrary data which has been marshalled
 // as a json string from the specified bucket
 func (b *BoltStore) get(key, bucket string, object interface{}) error {
-	err := b.db.View(func(tx *bolt.Tx) error {
-		b := tx.Bucket([]byte(bucket))
-		v := b.Get([]byte(key))
-		if v == nil {
-			return fmt.Errorf("Can't find object for key %s", key)
-		}
-		err := json.Unmarshal(v, object)
+	v, err := b.getBytes(bucket, key)
+	if err != nil {
 		return err
-	})
-	return err
-}
-
-// NotifyNodeOffline registers a handler which will be called as soon as a node
-// is considered offline by this data store
-func (b *BoltStore) NotifyNodeOffline(handler func(string)) {
-	b.gwOfflineHandler = append(b.gwOfflineHandler, handler)
+	}
+	return json.Unmarshal(v, object)
 }
 
-// NotifyNodeExpired registers a handler which is called as soon as node is
-// removed from this data store. If these handlers are called no node data is
-// available any more.
-func (b *BoltStore) NotifyNodeExpired(handler func(string)) {
-	b.expiredNodesHandler = append(b.expiredNodesHandler, handler)
+func (b *BoltStore) Events() *EventBus {
+	return b.events
 }
 
 func (b *BoltStore) GetNodeInfo(nodeId string) (NodeInfo, error) {
@@ -275,20 +174,31 @@ func (b *BoltStore) GetNodeInfo(nodeId string) (NodeInfo, error) {
 }
 
 func (b *BoltStore) PutNodeInfo(nodeInfo NodeInfo) {
+	b.index.putNodeInfo(nodeInfo)
 	b.put(nodeInfo.NodeId, NodeinfoBucket, nodeInfo)
 }
 
+// allValues calls iterFunc for every value of the bucket. Buffered values
+// replace the values of the database and deleted keys are left out.
 func (b *BoltStore) allValues(bucket string, iterFunc func(string, []byte)) error {
+	pending := b.writes.values(bucket)
 	err := b.db.View(func(tx *bolt.Tx) error {
 		b := tx.Bucket([]byte(bucket))
 		c := b.Cursor()
 
 		for k, v := c.First(); k != nil; k, v = c.Next() {
-			iterFunc(string(k), v)
+			if _, exists := pending[string(k)]; !exists {
+				iterFunc(string(k), v)
+			}
 		}
 
 		return nil
 	})
+	for key, value := range pending {
+		if value != nil {
+			iterFunc(key, value)
+		}
+	}
 	return err
 }
 
@@ -369,9 +279,24 @@ func (b *BoltStore) PutNodeStatusInfo(nodeId string, info NodeStatusInfo) {
 	if info.NodeId == "" {
 		info.NodeId = nodeId
 	}
+	b.statusLock.Lock()
+	defer b.statusLock.Unlock()
 	b.put(nodeId, StatusInfoBucket, info)
 }
 
+func (b *BoltStore) UpdateNodeStatusInfo(nodeId string, change func(info *NodeStatusInfo) bool) error {
+	b.statusLock.Lock()
+	defer b.statusLock.Unlock()
+	info, err := b.GetNodeStatusInfo(nodeId)
+	if err != nil {
+		return err
+	}
+	if change(&info) {
+		b.put(nodeId, StatusInfoBucket, info)
+	}
+	return nil
+}
+
 func (b *BoltStore) GetNodeStatusInfos() []NodeStatusInfo {
 	allStatusInfos := make([]NodeStatusInfo, 0, 500)
 	err := b.allValues(StatusInfoBucket, func(key string, data []byte) {
@@ -409,6 +334,7 @@ func (b *BoltStore) GetNodeNeighbours(nodeId string) (NeighbourStruct, error) {
 }
 
 func (b *BoltStore) PutNodeNeighbours(neighbours NeighbourStruct) {
+	b.index.putNeighbours(neighbours)
 	b.put(neighbours.NodeId, NeighboursBucket, neighbours)
 }
 
@@ -443,19 +369,67 @@ func (b *BoltStore) PutGateway(mac string) {
 func (b *BoltStore) IsGateway(mac string) bool {
 	result := &JsonBool{}
 	err := b.get(mac, GatewayBucket, result)
-	return err != nil && result.Value
+	return err == nil && result.Value
 }
 
 func (b *BoltStore) RemoveGateway(mac string) {
-	err := b.db.Update(func(tx *bolt.Tx) error {
-		b := tx.Bucket([]byte(GatewayBucket))
-		err := b.Delete([]byte(mac))
-		return err
+	b.deleteBytes(GatewayBucket, mac)
+}
+
+func (b *BoltStore) GetGateways() []string {
+	gateways := make([]string, 0, 10)
+	err := b.allValues(GatewayBucket, func(key string, data []byte) {
+		gateways = append(gateways, key)
 	})
 	if err != nil {
 		log.WithFields(log.Fields{
-			"error":       err,
-			"gateway-mac": mac,
-		}).Error("Error deleting gateway from bolt store")
+			"error":  err,
+			"bucket": GatewayBucket,
+		}).Error("Error iterating over all values")
+	}
+	return gateways
+}
+
+// Backup writes a consistent snapshot of the complete database to the writer.
+// The snapshot is taken in a read transaction, so the store stays usable.
+func (b *BoltStore) Backup(w io.Writer) (int64, error) {
+	var written int64
+	b.Flush()
+	err := b.db.View(func(tx *bolt.Tx) error {
+		var err error
+		written, err = tx.WriteTo(w)
+		return err
+	})
+	return written, err
+}
+
+// recordKey builds the key under which data of a response type is stored for a
+// node, i.e. the raw json or the response meta data.
+func recordKey(nodeId, responseType string) []byte {
+	return []byte(nodeId + "/" + responseType)
+}
+
+func (b *BoltStore) PutRawData(nodeId, responseType string, raw json.RawMessage) {
+	b.putBytes(RawBucket, string(recordKey(nodeId, responseType)), raw)
+}
+
+func (b *BoltStore) GetRawData(nodeId, responseType string) (json.RawMessage, error) {
+	raw, err := b.getBytes(RawBucket, string(recordKey(nodeId, responseType)))
+	if err != nil {
+		return nil, fmt.Errorf("No raw %s data for node id %s", responseType, nodeId)
+	}
+	return raw, nil
+}
+
+func (b *BoltStore) PutResponseMeta(nodeId, responseType string, meta ResponseMeta) {
+	b.put(string(recordKey(nodeId, responseType)), MetaBucket, meta)
+}
+
+func (b *BoltStore) GetResponseMeta(nodeId, responseType string) (ResponseMeta, error) {
+	meta := &ResponseMeta{}
+	err := b.get(string(recordKey(nodeId, responseType)), MetaBucket, meta)
+	if err != nil {
+		return ResponseMeta{}, err
 	}
+	return *meta, err
 }
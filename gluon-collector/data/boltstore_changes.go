@@ -0,0 +1,166 @@
+package data
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/boltdb/bolt"
+	conf "github.com/ffdo/node-informant/gluon-collector/config"
+)
+
+// ChangesBucket contains a bucket per node with the changes of its nodeinfo
+// keyed by time.
+const ChangesBucket string = "nodeinfochanges"
+
+// PutNodeinfoChanges stores the changes with the next flush of the buffered
+// writes.
+func (b *BoltStore) PutNodeinfoChanges(changes []NodeinfoChange) {
+	b.updateHistory(func(history pendingHistory) {
+		for _, change := range changes {
+			history.changes[change.NodeId] = append(history.changes[change.NodeId], change)
+		}
+	})
+}
+
+func putNodeinfoChanges(tx *bolt.Tx, changes []NodeinfoChange) error {
+	bucket := tx.Bucket([]byte(ChangesBucket))
+	for _, change := range changes {
+		nodeBucket, err := bucket.CreateBucketIfNotExists([]byte(change.NodeId))
+		if err != nil {
+			return err
+		}
+		value, err := json.Marshal(change)
+		if err != nil {
+			return err
+		}
+		if err := putTimeKeyed(nodeBucket, change.Time, value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func readChanges(nodeBucket *bolt.Bucket, since time.Time, changes []NodeinfoChange) []NodeinfoChange {
+	c := nodeBucket.Cursor()
+	for k, v := c.Seek(historyKey(since)); k != nil; k, v = c.Next() {
+		change := NodeinfoChange{}
+		if err := json.Unmarshal(v, &change); err != nil {
+			log.WithFields(log.Fields{
+				"error":      err,
+				"jsonString": string(v),
+			}).Error("Can't unmarshall nodeinfo change")
+			continue
+		}
+		changes = append(changes, change)
+	}
+	return changes
+}
+
+// pendingChanges appends the pending changes since the given time to changes.
+// Like putTimeKeyed identical changes are only kept once.
+func pendingChanges(pending []NodeinfoChange, since time.Time, changes []NodeinfoChange) []NodeinfoChange {
+	if len(pending) == 0 {
+		return changes
+	}
+	seen := make(map[string]bool, len(changes))
+	for _, change := range changes {
+		seen[jsonKey(change)] = true
+	}
+	for _, change := range pending {
+		key := jsonKey(change)
+		if !change.Time.Before(since) && !seen[key] {
+			seen[key] = true
+			changes = append(changes, change)
+		}
+	}
+	return changes
+}
+
+func (b *BoltStore) GetNodeinfoChanges(nodeId string, since time.Time) ([]NodeinfoChange, error) {
+	changes := make([]NodeinfoChange, 0, 50)
+	err := b.viewHistory(func(tx *bolt.Tx, pending pendingHistory) error {
+		bucket := tx.Bucket([]byte(ChangesBucket))
+		if nodeId != "" {
+			nodeBucket := bucket.Bucket([]byte(nodeId))
+			if pending.cleared[nodeId] {
+				nodeBucket = nil
+			}
+			if nodeBucket == nil && len(pending.changes[nodeId]) == 0 {
+				return fmt.Errorf("No nodeinfo changes for node id %s", nodeId)
+			}
+			if nodeBucket != nil {
+				changes = readChanges(nodeBucket, since, changes)
+			}
+			changes = pendingChanges(pending.changes[nodeId], since, changes)
+			return nil
+		}
+		err := bucket.ForEach(func(k, v []byte) error {
+			if v == nil && !pending.cleared[string(k)] {
+				changes = readChanges(bucket.Bucket(k), since, changes)
+			}
+			return nil
+		})
+		all := make([]NodeinfoChange, 0)
+		for _, nodeChanges := range pending.changes {
+			all = append(all, nodeChanges...)
+		}
+		changes = pendingChanges(all, since, changes)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Stable(changesByTime(changes))
+	return changes, nil
+}
+
+// pruneChanges deletes all nodeinfo changes older than the configured
+// retention with the next flush, so the buffered changes are pruned as well.
+func (b *BoltStore) pruneChanges() {
+	retention := time.Duration(conf.UInt("store.changeRetentionDays", 400)*24) * time.Hour
+	oldest := time.Now().Add(-retention)
+	b.update(func(tx *bolt.Tx) error {
+		if err := deleteChangesBefore(tx, oldest); err != nil {
+			log.WithFields(log.Fields{
+				"error": err,
+			}).Error("Error in database transaction while pruning nodeinfo changes")
+		}
+		return nil
+	})
+}
+
+// deleteChangesBefore deletes the nodeinfo changes older than oldest and the
+// buckets of the nodes without changes left.
+func deleteChangesBefore(tx *bolt.Tx, oldest time.Time) error {
+	bucket := tx.Bucket([]byte(ChangesBucket))
+	nodeIds := make([][]byte, 0, 500)
+	bucket.ForEach(func(k, v []byte) error {
+		if v == nil {
+			nodeIds = append(nodeIds, append([]byte{}, k...))
+		}
+		return nil
+	})
+	for _, nodeId := range nodeIds {
+		c := bucket.Bucket(nodeId).Cursor()
+		for k, _ := c.First(); k != nil && historyKeyTime(k).Before(oldest); k, _ = c.First() {
+			if err := c.Delete(); err != nil {
+				return err
+			}
+		}
+		if k, _ := c.First(); k == nil {
+			if err := bucket.DeleteBucket(nodeId); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+type changesByTime []NodeinfoChange
+
+func (c changesByTime) Len() int           { return len(c) }
+func (c changesByTime) Swap(i, j int)      { c[i], c[j] = c[j], c[i] }
+func (c changesByTime) Less(i, j int) bool { return c[i].Time.Before(c[j].Time) }
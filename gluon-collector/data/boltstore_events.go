@@ -0,0 +1,241 @@
+package data
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/boltdb/bolt"
+	conf "github.com/ffdo/node-informant/gluon-collector/config"
+)
+
+// EventBucket contains a bucket per node with its events keyed by time. The
+// events of a node are kept after the node expired, so we can tell when it
+// returns.
+const EventBucket string = "events"
+
+// putEvent stores the event inside an already running transaction.
+func putEvent(tx *bolt.Tx, event NodeEvent) error {
+	nodeBucket, err := tx.Bucket([]byte(EventBucket)).CreateBucketIfNotExists([]byte(event.NodeId))
+	if err != nil {
+		return err
+	}
+	value, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	return putTimeKeyed(nodeBucket, event.Time, value)
+}
+
+// PutEvent stores the event with the next flush of the buffered writes.
+func (b *BoltStore) PutEvent(event NodeEvent) {
+	b.updateHistory(func(history pendingHistory) {
+		history.events[event.NodeId] = append(history.events[event.NodeId], event)
+	})
+}
+
+func readEvents(nodeBucket *bolt.Bucket, since time.Time, events []NodeEvent) []NodeEvent {
+	c := nodeBucket.Cursor()
+	for k, v := c.Seek(historyKey(since)); k != nil; k, v = c.Next() {
+		event := NodeEvent{}
+		if err := json.Unmarshal(v, &event); err != nil {
+			log.WithFields(log.Fields{
+				"error":      err,
+				"jsonString": string(v),
+			}).Error("Can't unmarshall event")
+			continue
+		}
+		events = append(events, event)
+	}
+	return events
+}
+
+// pendingEvents appends the pending events since the given time to events.
+// Like putTimeKeyed identical events are only kept once.
+func pendingEvents(pending []NodeEvent, since time.Time, events []NodeEvent) []NodeEvent {
+	if len(pending) == 0 {
+		return events
+	}
+	seen := make(map[string]bool, len(events))
+	for _, event := range events {
+		seen[jsonKey(event)] = true
+	}
+	for _, event := range pending {
+		key := jsonKey(event)
+		if !event.Time.Before(since) && !seen[key] {
+			seen[key] = true
+			events = append(events, event)
+		}
+	}
+	return events
+}
+
+func (b *BoltStore) GetEvents(nodeId string, since time.Time) ([]NodeEvent, error) {
+	events := make([]NodeEvent, 0, 50)
+	err := b.viewHistory(func(tx *bolt.Tx, pending pendingHistory) error {
+		bucket := tx.Bucket([]byte(EventBucket))
+		if nodeId != "" {
+			nodeBucket := bucket.Bucket([]byte(nodeId))
+			if nodeBucket == nil && len(pending.events[nodeId]) == 0 {
+				return fmt.Errorf("No events for node id %s", nodeId)
+			}
+			if nodeBucket != nil {
+				events = readEvents(nodeBucket, since, events)
+			}
+			events = pendingEvents(pending.events[nodeId], since, events)
+			return nil
+		}
+		err := bucket.ForEach(func(k, v []byte) error {
+			if v == nil {
+				events = readEvents(bucket.Bucket(k), since, events)
+			}
+			return nil
+		})
+		all := make([]NodeEvent, 0)
+		for _, nodeEvents := range pending.events {
+			all = append(all, nodeEvents...)
+		}
+		events = pendingEvents(all, since, events)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Stable(eventsByTime(events))
+	return events, nil
+}
+
+func (b *BoltStore) GetLastEvent(nodeId string, before time.Time) (NodeEvent, error) {
+	event := NodeEvent{}
+	err := b.viewHistory(func(tx *bolt.Tx, pending pendingHistory) error {
+		nodeBucket := tx.Bucket([]byte(EventBucket)).Bucket([]byte(nodeId))
+		if nodeBucket == nil && len(pending.events[nodeId]) == 0 {
+			return fmt.Errorf("No events for node id %s", nodeId)
+		}
+		found := false
+		if nodeBucket != nil {
+			c := nodeBucket.Cursor()
+			k, v := c.Seek(historyKey(before))
+			if k == nil {
+				k, v = c.Last()
+			} else {
+				k, v = c.Prev()
+			}
+			if k != nil {
+				if err := json.Unmarshal(v, &event); err != nil {
+					return err
+				}
+				found = true
+			}
+		}
+		// Pending events are newer than stored events at the same time
+		for _, pendingEvent := range pending.events[nodeId] {
+			if pendingEvent.Time.Before(before) && (!found || !pendingEvent.Time.Before(event.Time)) {
+				event = pendingEvent
+				found = true
+			}
+		}
+		if !found {
+			return fmt.Errorf("No event for node id %s before %v", nodeId, before)
+		}
+		return nil
+	})
+	return event, err
+}
+
+// timeline returns the sorted events from the last one before since on.
+func timeline(events []NodeEvent, since time.Time) []NodeEvent {
+	sort.Stable(eventsByTime(events))
+	start := 0
+	for i, event := range events {
+		if event.Time.Before(since) {
+			start = i
+		}
+	}
+	return events[start:]
+}
+
+func (b *BoltStore) GetEventTimelines(since time.Time) (map[string][]NodeEvent, error) {
+	timelines := make(map[string][]NodeEvent)
+	err := b.viewHistory(func(tx *bolt.Tx, pending pendingHistory) error {
+		bucket := tx.Bucket([]byte(EventBucket))
+		err := bucket.ForEach(func(k, v []byte) error {
+			if v != nil {
+				return nil
+			}
+			nodeBucket := bucket.Bucket(k)
+			// Start with the last event before since
+			start := since
+			c := nodeBucket.Cursor()
+			last, _ := c.Seek(historyKey(since))
+			if last == nil {
+				last, _ = c.Last()
+			} else {
+				last, _ = c.Prev()
+			}
+			if last != nil {
+				start = historyKeyTime(last)
+			}
+			timelines[string(k)] = readEvents(nodeBucket, start, make([]NodeEvent, 0, 4))
+			return nil
+		})
+		for nodeId, events := range pending.events {
+			timelines[nodeId] = timeline(pendingEvents(events, time.Time{}, timelines[nodeId]), since)
+		}
+		return err
+	})
+	return timelines, err
+}
+
+// pruneEvents deletes all events older than the configured retention with the
+// next flush, so the buffered events are pruned as well. The retention should
+// be longer than the time after which nodes expire, otherwise returning nodes
+// are reported as new.
+func (b *BoltStore) pruneEvents() {
+	retention := time.Duration(conf.UInt("store.eventRetentionDays", 400)*24) * time.Hour
+	oldest := time.Now().Add(-retention)
+	b.update(func(tx *bolt.Tx) error {
+		if err := deleteEventsBefore(tx, oldest); err != nil {
+			log.WithFields(log.Fields{
+				"error": err,
+			}).Error("Error in database transaction while pruning events")
+		}
+		return nil
+	})
+}
+
+// deleteEventsBefore deletes the events older than oldest and the buckets of
+// the nodes without events left.
+func deleteEventsBefore(tx *bolt.Tx, oldest time.Time) error {
+	bucket := tx.Bucket([]byte(EventBucket))
+	nodeIds := make([][]byte, 0, 500)
+	bucket.ForEach(func(k, v []byte) error {
+		if v == nil {
+			nodeIds = append(nodeIds, append([]byte{}, k...))
+		}
+		return nil
+	})
+	for _, nodeId := range nodeIds {
+		nodeBucket := bucket.Bucket(nodeId)
+		c := nodeBucket.Cursor()
+		for k, _ := c.First(); k != nil && historyKeyTime(k).Before(oldest); k, _ = c.First() {
+			if err := c.Delete(); err != nil {
+				return err
+			}
+		}
+		if k, _ := c.First(); k == nil {
+			if err := bucket.DeleteBucket(nodeId); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+type eventsByTime []NodeEvent
+
+func (e eventsByTime) Len() int           { return len(e) }
+func (e eventsByTime) Swap(i, j int)      { e[i], e[j] = e[j], e[i] }
+func (e eventsByTime) Less(i, j int) bool { return e[i].Time.Before(e[j].Time) }
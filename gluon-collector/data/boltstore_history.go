@@ -0,0 +1,313 @@
+package data
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/boltdb/bolt"
+)
+
+// HistoryBucket contains a bucket per history tier, which contains a bucket per
+// node with the samples keyed by time.
+const HistoryBucket string = "history"
+
+func historyKey(t time.Time) []byte {
+	// Nanoseconds are only representable after 1970 as unsigned keys
+	if t.Before(time.Unix(0, 0)) {
+		t = time.Unix(0, 0)
+	}
+	key := make([]byte, 8)
+	binary.BigEndian.PutUint64(key, uint64(t.UnixNano()))
+	return key
+}
+
+// putTimeKeyed stores the value under the key of the given time. Entries at the
+// very same time don't overwrite each other, but identical entries are only
+// stored once, so importing the same data twice doesn't duplicate it.
+func putTimeKeyed(bucket *bolt.Bucket, t time.Time, value []byte) error {
+	key := historyKey(t)
+	for existing := bucket.Get(key); existing != nil; existing = bucket.Get(key) {
+		if bytes.Equal(existing, value) {
+			return nil
+		}
+		key = historyKey(historyKeyTime(key).Add(time.Nanosecond))
+	}
+	return bucket.Put(key, value)
+}
+
+// jsonKey identifies equal entries of the histories by their json encoding,
+// like putTimeKeyed does.
+func jsonKey(value interface{}) string {
+	bytes, _ := json.Marshal(value)
+	return string(bytes)
+}
+
+func historyKeyTime(key []byte) time.Time {
+	return time.Unix(0, int64(binary.BigEndian.Uint64(key)))
+}
+
+func putSample(bucket *bolt.Bucket, sample StatisticsSample) error {
+	value, err := json.Marshal(sample)
+	if err != nil {
+		return err
+	}
+	return bucket.Put(historyKey(sample.Time), value)
+}
+
+// putStatisticsSample stores the sample in the raw tier and merges it into the
+// interval of all downsampled tiers it falls into.
+func putStatisticsSample(tx *bolt.Tx, nodeId string, sample StatisticsSample) error {
+	history := tx.Bucket([]byte(HistoryBucket))
+	for _, tier := range HistoryTiers {
+		tierBucket, err := history.CreateBucketIfNotExists([]byte(tier.Name))
+		if err != nil {
+			return err
+		}
+		nodeBucket, err := tierBucket.CreateBucketIfNotExists([]byte(nodeId))
+		if err != nil {
+			return err
+		}
+		tierSample := sample
+		if tier.Step > 0 {
+			tierSample.Time = sample.Time.Truncate(tier.Step)
+			if v := nodeBucket.Get(historyKey(tierSample.Time)); v != nil {
+				existing := StatisticsSample{}
+				if err := json.Unmarshal(v, &existing); err != nil {
+					return err
+				}
+				tierSample = existing.Merge(sample)
+			}
+		}
+		if err := putSample(nodeBucket, tierSample); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// PutStatisticsSample stores the sample with the next flush of the buffered
+// writes.
+func (b *BoltStore) PutStatisticsSample(nodeId string, sample StatisticsSample) {
+	b.updateHistory(func(history pendingHistory) {
+		history.samples[nodeId] = append(history.samples[nodeId], sample)
+	})
+}
+
+// mergeSamples merges the pending samples into the stored samples of a tier
+// with the given step like putStatisticsSample does and sorts them by time.
+func mergeSamples(samples, pending []StatisticsSample, step time.Duration) []StatisticsSample {
+	if len(pending) == 0 {
+		return samples
+	}
+	indices := make(map[int64]int, len(samples))
+	for i, sample := range samples {
+		indices[sample.Time.UnixNano()] = i
+	}
+	for _, sample := range pending {
+		if step > 0 {
+			sample.Time = sample.Time.Truncate(step)
+		}
+		i, exists := indices[sample.Time.UnixNano()]
+		if !exists {
+			indices[sample.Time.UnixNano()] = len(samples)
+			samples = append(samples, sample)
+		} else if step > 0 {
+			samples[i] = samples[i].Merge(sample)
+		} else {
+			samples[i] = sample
+		}
+	}
+	sort.Sort(samplesByTime(samples))
+	return samples
+}
+
+// historyTier returns the tier with the given name.
+func historyTier(name string) (HistoryTier, bool) {
+	for _, tier := range HistoryTiers {
+		if tier.Name == name {
+			return tier, true
+		}
+	}
+	return HistoryTier{}, false
+}
+
+// historyNodeBucket returns the bucket with the stored samples of the node in
+// the tier, nil if there is none or it is deleted with the next flush.
+func historyNodeBucket(tx *bolt.Tx, pending pendingHistory, nodeId, tier string) *bolt.Bucket {
+	tierBucket := tx.Bucket([]byte(HistoryBucket)).Bucket([]byte(tier))
+	if tierBucket == nil || pending.cleared[nodeId] {
+		return nil
+	}
+	return tierBucket.Bucket([]byte(nodeId))
+}
+
+func (b *BoltStore) GetStatisticsHistory(nodeId string, from, to time.Time, step time.Duration) ([]StatisticsSample, error) {
+	tier := SelectHistoryTier(HistoryTiers, time.Now(), from)
+	samples := make([]StatisticsSample, 0, 100)
+	var pending []StatisticsSample
+	err := b.viewHistory(func(tx *bolt.Tx, history pendingHistory) error {
+		pending = history.samples[nodeId]
+		nodeBucket := historyNodeBucket(tx, history, nodeId, tier.Name)
+		if nodeBucket == nil && len(pending) == 0 {
+			return fmt.Errorf("No statistics history for node id %s", nodeId)
+		}
+		if nodeBucket == nil {
+			return nil
+		}
+		c := nodeBucket.Cursor()
+		end := historyKey(to)
+		for k, v := c.Seek(historyKey(from)); k != nil && bytes.Compare(k, end) <= 0; k, v = c.Next() {
+			sample := StatisticsSample{}
+			if err := json.Unmarshal(v, &sample); err != nil {
+				log.WithFields(log.Fields{
+					"error":      err,
+					"nodeid":     nodeId,
+					"jsonString": string(v),
+				}).Error("Can't unmarshall statistics sample")
+				continue
+			}
+			samples = append(samples, sample)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	merged := samples[:0]
+	for _, sample := range mergeSamples(samples, pending, tier.Step) {
+		if !sample.Time.Before(from) && !sample.Time.After(to) {
+			merged = append(merged, sample)
+		}
+	}
+	samples = merged
+	if step > tier.Step {
+		samples = Resample(samples, step)
+	}
+	return samples, nil
+}
+
+// pruneHistory is invoked once per hour to delete all samples which are older
+// than the retention of their tier with the next flush, so the buffered samples
+// are pruned as well. The buckets of nodes without samples left are deleted.
+func (b *BoltStore) pruneHistory() {
+	now := time.Now()
+	b.update(func(tx *bolt.Tx) error {
+		if err := deleteSamplesBefore(tx, now); err != nil {
+			log.WithFields(log.Fields{
+				"error": err,
+			}).Error("Error in database transaction while pruning statistics history")
+		}
+		return nil
+	})
+}
+
+// deleteSamplesBefore deletes the samples of every tier which are older than
+// its retention at the given time.
+func deleteSamplesBefore(tx *bolt.Tx, now time.Time) error {
+	history := tx.Bucket([]byte(HistoryBucket))
+	for _, tier := range HistoryTiers {
+		tierBucket := history.Bucket([]byte(tier.Name))
+		if tierBucket == nil {
+			continue
+		}
+		oldest := now.Add(-tier.Retention)
+		nodeIds := make([][]byte, 0, 500)
+		tierBucket.ForEach(func(k, v []byte) error {
+			// Only nested buckets have nil values
+			if v == nil {
+				nodeIds = append(nodeIds, append([]byte{}, k...))
+			}
+			return nil
+		})
+		for _, nodeId := range nodeIds {
+			c := tierBucket.Bucket(nodeId).Cursor()
+			k, _ := c.First()
+			for ; k != nil && historyKeyTime(k).Before(oldest); k, _ = c.First() {
+				if err := c.Delete(); err != nil {
+					return err
+				}
+			}
+			if k == nil {
+				if err := tierBucket.DeleteBucket(nodeId); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// deleteHistory removes the complete statistics history of a node.
+func deleteHistory(tx *bolt.Tx, nodeId string) {
+	history := tx.Bucket([]byte(HistoryBucket))
+	for _, tier := range HistoryTiers {
+		if tierBucket := history.Bucket([]byte(tier.Name)); tierBucket != nil {
+			tierBucket.DeleteBucket([]byte(nodeId))
+		}
+	}
+}
+
+func (b *BoltStore) GetHistoryTier(nodeId, tier string) ([]StatisticsSample, error) {
+	samples := make([]StatisticsSample, 0, 100)
+	configured, known := historyTier(tier)
+	var pending []StatisticsSample
+	err := b.viewHistory(func(tx *bolt.Tx, history pendingHistory) error {
+		if known {
+			// Samples are only put into the configured tiers
+			pending = history.samples[nodeId]
+		}
+		if tx.Bucket([]byte(HistoryBucket)).Bucket([]byte(tier)) == nil && len(pending) == 0 {
+			return fmt.Errorf("No statistics history tier %s", tier)
+		}
+		nodeBucket := historyNodeBucket(tx, history, nodeId, tier)
+		if nodeBucket == nil && len(pending) == 0 {
+			return fmt.Errorf("No statistics history for node id %s", nodeId)
+		}
+		if nodeBucket == nil {
+			return nil
+		}
+		return nodeBucket.ForEach(func(k, v []byte) error {
+			sample := StatisticsSample{}
+			if err := json.Unmarshal(v, &sample); err != nil {
+				return err
+			}
+			samples = append(samples, sample)
+			return nil
+		})
+	})
+	if err != nil {
+		return samples, err
+	}
+	return mergeSamples(samples, pending, configured.Step), nil
+}
+
+func (b *BoltStore) PutHistoryTier(nodeId, tier string, samples []StatisticsSample) {
+	err := b.db.Update(func(tx *bolt.Tx) error {
+		tierBucket, err := tx.Bucket([]byte(HistoryBucket)).CreateBucketIfNotExists([]byte(tier))
+		if err != nil {
+			return err
+		}
+		nodeBucket, err := tierBucket.CreateBucketIfNotExists([]byte(nodeId))
+		if err != nil {
+			return err
+		}
+		for _, sample := range samples {
+			if err := putSample(nodeBucket, sample); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		log.WithFields(log.Fields{
+			"error":  err,
+			"nodeid": nodeId,
+			"tier":   tier,
+		}).Error("Error putting statistics history into bolt store")
+	}
+}
@@ -3,6 +3,7 @@ package data
 import (
 	"os"
 	"testing"
+	"time"
 
 	log "github.com/Sirupsen/logrus"
 
@@ -28,3 +29,126 @@ func TestStoringNodeinfo(t *testing.T) {
 	assert.Equal("a", result.NodeId)
 	store.Close()
 }
+
+func TestBufferingWrites(t *testing.T) {
+	assert := assert.New(t)
+	dbPath := "./buffered.db"
+	defer os.RemoveAll(dbPath)
+
+	store, err := NewBoltStore(dbPath)
+	assert.Nil(err)
+	store.PutNodeInfo(NodeInfo{NodeId: "a", Hostname: "first"})
+	store.PutNodeInfo(NodeInfo{NodeId: "a", Hostname: "second"})
+	store.PutRawData("a", "nodeinfo", []byte(`{"node_id":"a"}`))
+	// Buffered puts are visible before they are written
+	result, err := store.GetNodeInfo("a")
+	assert.Nil(err)
+	assert.Equal("second", result.Hostname)
+	assert.Equal(1, len(store.GetNodeInfos()))
+	store.Close()
+
+	// Closing the store writes all buffered puts
+	store, err = NewBoltStore(dbPath)
+	assert.Nil(err)
+	defer store.Close()
+	result, err = store.GetNodeInfo("a")
+	assert.Nil(err)
+	assert.Equal("second", result.Hostname)
+	raw, err := store.GetRawData("a", "nodeinfo")
+	assert.Nil(err)
+	assert.Equal(`{"node_id":"a"}`, string(raw))
+}
+
+func TestBufferingDeletes(t *testing.T) {
+	assert := assert.New(t)
+	dbPath := "./deletes.db"
+	defer os.RemoveAll(dbPath)
+
+	store, err := NewBoltStore(dbPath)
+	assert.Nil(err)
+	store.PutNodeInfo(NodeInfo{NodeId: "a"})
+	store.PutNodeInfo(NodeInfo{NodeId: "b"})
+	store.PutEvent(NodeEvent{Time: time.Now(), NodeId: "a", Type: EventNew})
+	assert.Equal(2, len(store.GetNodeInfos()))
+	events, err := store.GetEvents("a", time.Unix(0, 0))
+	assert.Nil(err)
+	assert.Equal(1, len(events), "Buffered events are read")
+	assert.Equal(3, store.writes.count, "Reads don't flush the buffer")
+
+	// Puts still pending when the node is deleted don't bring it back
+	store.PutStatistics(StatisticsStruct{NodeId: "a"})
+	store.PutStatisticsSample("a", StatisticsSample{Time: time.Now(), Count: 1})
+	store.DeleteNode("a")
+	assert.Equal(1, len(store.GetNodeInfos()))
+	assert.Equal(0, len(store.GetAllStatistics()))
+	_, err = store.GetHistoryTier("a", "raw")
+	assert.NotNil(err, "Buffered samples of deleted nodes are dropped")
+	assert.NotEqual(0, store.writes.count)
+	store.Close()
+
+	store, err = NewBoltStore(dbPath)
+	assert.Nil(err)
+	defer store.Close()
+	_, err = store.GetNodeInfo("a")
+	assert.NotNil(err)
+	_, err = store.GetStatistics("a")
+	assert.NotNil(err)
+	_, err = store.GetHistoryTier("a", "raw")
+	assert.NotNil(err)
+	assert.Equal(1, len(store.GetNodeInfos()))
+}
+
+func TestBulkPuts(t *testing.T) {
+	assert := assert.New(t)
+	dbPath := "./bulk.db"
+	defer os.RemoveAll(dbPath)
+	boltStore, err := NewBoltStore(dbPath)
+	assert.Nil(err)
+	defer boltStore.Close()
+
+	for _, store := range []Nodeinfostore{boltStore, NewSimpleInMemoryStore()} {
+		bulk, ok := store.(BulkStore)
+		assert.True(ok)
+		bulk.PutNodeInfos([]NodeInfo{{NodeId: "a"}, {NodeId: "b"}})
+		bulk.PutAllStatistics([]StatisticsStruct{{NodeId: "a", Clients: ClientStatistics{Total: 3}}})
+		bulk.PutNodeStatusInfos([]NodeStatusInfo{{NodeId: "a", Online: true}, {Online: true}})
+		bulk.PutAllNeighbours([]NeighbourStruct{{NodeId: "b"}})
+
+		assert.Equal(2, len(store.GetNodeInfos()))
+		statistics, err := store.GetStatistics("a")
+		assert.Nil(err)
+		assert.Equal(3, statistics.Clients.Total)
+		assert.Equal(1, len(store.GetNodeStatusInfos()), "Status infos without node id are skipped")
+		_, err = store.GetNodeNeighbours("b")
+		assert.Nil(err)
+	}
+}
+
+func TestUpdatingStatusInfos(t *testing.T) {
+	assert := assert.New(t)
+	dbPath := "./update.db"
+	defer os.RemoveAll(dbPath)
+	boltStore, err := NewBoltStore(dbPath)
+	assert.Nil(err)
+	defer boltStore.Close()
+
+	for _, store := range []Nodeinfostore{boltStore, NewSimpleInMemoryStore()} {
+		assert.NotNil(store.UpdateNodeStatusInfo("a", func(info *NodeStatusInfo) bool {
+			return true
+		}), "Missing status infos aren't created")
+		store.PutNodeStatusInfo("a", NodeStatusInfo{NodeId: "a", Online: true})
+
+		assert.Nil(store.UpdateNodeStatusInfo("a", func(info *NodeStatusInfo) bool {
+			info.Gateway = true
+			return true
+		}))
+		assert.Nil(store.UpdateNodeStatusInfo("a", func(info *NodeStatusInfo) bool {
+			info.Online = false
+			return false
+		}))
+		status, err := store.GetNodeStatusInfo("a")
+		assert.Nil(err)
+		assert.True(status.Gateway)
+		assert.True(status.Online, "Changes are only stored if requested")
+	}
+}
@@ -0,0 +1,374 @@
+package data
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/boltdb/bolt"
+)
+
+// writeBuffer collects the puts of a BoltStore by bucket and key, so many of
+// them can be written in a single transaction. Only the last value put for a
+// key is kept, a nil value deletes the key.
+type writeBuffer struct {
+	lock sync.Mutex
+	// flushLock is held while the buffer is written and while the histories
+	// are read, so these reads see the pending history exactly once.
+	flushLock sync.Mutex
+	// pending are the values not written yet, flushing are the values of the
+	// transaction currently running. Both are read before the database.
+	pending  map[string]map[string][]byte
+	flushing map[string]map[string][]byte
+	// history are the additions to the histories not written yet.
+	history pendingHistory
+	// ops are maintenance writes like the pruning, which are run in order
+	// after everything else has been written.
+	ops   []func(tx *bolt.Tx) error
+	count int
+	// maxPending is the number of pending values which triggers a flush.
+	maxPending int
+	// buffered is false if every put is written immediately.
+	buffered bool
+}
+
+// pendingHistory are the events, nodeinfo changes and statistics samples not
+// written yet by node id. The reads of the histories merge them with the
+// stored histories.
+type pendingHistory struct {
+	events  map[string][]NodeEvent
+	changes map[string][]NodeinfoChange
+	samples map[string][]StatisticsSample
+	// cleared are the nodes whose stored statistics history and nodeinfo
+	// changes are deleted before the pending ones are written.
+	cleared map[string]bool
+}
+
+func newPendingHistory() pendingHistory {
+	return pendingHistory{
+		events:  make(map[string][]NodeEvent),
+		changes: make(map[string][]NodeinfoChange),
+		samples: make(map[string][]StatisticsSample),
+		cleared: make(map[string]bool),
+	}
+}
+
+// copy returns a copy which isn't changed by later additions.
+func (h pendingHistory) copy() pendingHistory {
+	c := newPendingHistory()
+	for nodeId, events := range h.events {
+		c.events[nodeId] = events[:len(events):len(events)]
+	}
+	for nodeId, changes := range h.changes {
+		c.changes[nodeId] = changes[:len(changes):len(changes)]
+	}
+	for nodeId, samples := range h.samples {
+		c.samples[nodeId] = samples[:len(samples):len(samples)]
+	}
+	for nodeId := range h.cleared {
+		c.cleared[nodeId] = true
+	}
+	return c
+}
+
+// write stores the pending history inside an already running transaction.
+// Errors are logged, so the other writes of the flush aren't lost.
+func (h pendingHistory) write(tx *bolt.Tx) {
+	for nodeId := range h.cleared {
+		deleteHistory(tx, nodeId)
+		tx.Bucket([]byte(ChangesBucket)).DeleteBucket([]byte(nodeId))
+	}
+	for _, events := range h.events {
+		for _, event := range events {
+			if err := putEvent(tx, event); err != nil {
+				log.WithFields(log.Fields{
+					"error":  err,
+					"nodeid": event.NodeId,
+					"type":   event.Type,
+				}).Error("Error putting event into bolt store")
+			}
+		}
+	}
+	for _, changes := range h.changes {
+		if err := putNodeinfoChanges(tx, changes); err != nil {
+			log.WithFields(log.Fields{
+				"error":   err,
+				"changes": changes,
+			}).Error("Error putting nodeinfo changes into bolt store")
+		}
+	}
+	for nodeId, samples := range h.samples {
+		for _, sample := range samples {
+			if err := putStatisticsSample(tx, nodeId, sample); err != nil {
+				log.WithFields(log.Fields{
+					"error":  err,
+					"nodeid": nodeId,
+				}).Error("Error putting statistics sample into bolt store")
+			}
+		}
+	}
+}
+
+func newWriteBuffer(flushInterval time.Duration, maxPending int) *writeBuffer {
+	return &writeBuffer{
+		pending:    make(map[string]map[string][]byte),
+		history:    newPendingHistory(),
+		maxPending: maxPending,
+		buffered:   flushInterval > 0,
+	}
+}
+
+// add puts the value into the pending values and returns whether they need to
+// be flushed now.
+func (w *writeBuffer) add(bucket, key string, value []byte) bool {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	entries, exists := w.pending[bucket]
+	if !exists {
+		entries = make(map[string][]byte)
+		w.pending[bucket] = entries
+	}
+	if _, exists := entries[key]; !exists {
+		w.count++
+	}
+	entries[key] = value
+	return !w.buffered || w.count >= w.maxPending
+}
+
+// addOp appends the write to the pending operations and returns whether they
+// need to be flushed now.
+func (w *writeBuffer) addOp(op func(tx *bolt.Tx) error) bool {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	w.ops = append(w.ops, op)
+	w.count++
+	return !w.buffered || w.count >= w.maxPending
+}
+
+// addHistory applies the addition to the pending history and returns whether
+// it needs to be flushed now.
+func (w *writeBuffer) addHistory(add func(history pendingHistory)) bool {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	add(w.history)
+	w.count++
+	return !w.buffered || w.count >= w.maxPending
+}
+
+// get returns the value which is going to be written for the key, if any. The
+// value is nil if the key is going to be deleted.
+func (w *writeBuffer) get(bucket, key string) ([]byte, bool) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	if value, exists := w.pending[bucket][key]; exists {
+		return value, true
+	}
+	value, exists := w.flushing[bucket][key]
+	return value, exists
+}
+
+// values returns all values which are going to be written into the bucket.
+func (w *writeBuffer) values(bucket string) map[string][]byte {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	values := make(map[string][]byte, len(w.flushing[bucket])+len(w.pending[bucket]))
+	for key, value := range w.flushing[bucket] {
+		values[key] = value
+	}
+	for key, value := range w.pending[bucket] {
+		values[key] = value
+	}
+	return values
+}
+
+// flush writes all pending values, the pending history and the operations in a
+// single transaction.
+func (w *writeBuffer) flush(db *bolt.DB) error {
+	// Flushes run one after another to keep the order of the puts
+	w.flushLock.Lock()
+	defer w.flushLock.Unlock()
+	w.lock.Lock()
+	if w.count == 0 {
+		w.lock.Unlock()
+		return nil
+	}
+	w.flushing, w.pending = w.pending, make(map[string]map[string][]byte)
+	history := w.history
+	w.history = newPendingHistory()
+	ops := w.ops
+	w.ops = nil
+	w.count = 0
+	flushing := w.flushing
+	w.lock.Unlock()
+
+	err := db.Update(func(tx *bolt.Tx) error {
+		for bucketName, entries := range flushing {
+			bucket := tx.Bucket([]byte(bucketName))
+			if bucket == nil {
+				return fmt.Errorf("Bucket %s was null", bucketName)
+			}
+			for key, value := range entries {
+				var err error
+				if value == nil {
+					err = bucket.Delete([]byte(key))
+				} else {
+					err = bucket.Put([]byte(key), value)
+				}
+				if err != nil {
+					return err
+				}
+			}
+		}
+		history.write(tx)
+		for _, op := range ops {
+			if err := op(tx); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	w.lock.Lock()
+	w.flushing = nil
+	w.lock.Unlock()
+	return err
+}
+
+// Flush writes all buffered puts to the database. It is called regularly, so
+// it only needs to be called to make sure everything is on disc.
+func (b *BoltStore) Flush() {
+	if err := b.writes.flush(b.db); err != nil {
+		log.WithFields(log.Fields{
+			"error": err,
+		}).Error("Error writing buffered data into bolt store")
+	}
+}
+
+// putBytes stores the value under the key in the given bucket. Unless the store
+// writes immediately, the value is written with the next flush, but returned
+// by all reads before.
+func (b *BoltStore) putBytes(bucket, key string, value []byte) {
+	if value == nil {
+		value = []byte{}
+	}
+	if b.writes.add(bucket, key, value) {
+		b.Flush()
+	}
+}
+
+// deleteBytes deletes the key from the given bucket with the next flush. Reads
+// don't find the key anymore.
+func (b *BoltStore) deleteBytes(bucket, key string) {
+	if b.writes.add(bucket, key, nil) {
+		b.Flush()
+	}
+}
+
+// update runs the write with the next flush, after all buffered puts and in
+// the order of the calls.
+func (b *BoltStore) update(op func(tx *bolt.Tx) error) {
+	if b.writes.addOp(op) {
+		b.Flush()
+	}
+}
+
+// updateHistory applies the addition to the pending history, which is written
+// with the next flush.
+func (b *BoltStore) updateHistory(add func(history pendingHistory)) {
+	if b.writes.addHistory(add) {
+		b.Flush()
+	}
+}
+
+// viewHistory runs the read of the histories together with a copy of the
+// pending history. No flush runs meanwhile, so the read sees every pending
+// addition either in the database or in the copy.
+func (b *BoltStore) viewHistory(read func(tx *bolt.Tx, pending pendingHistory) error) error {
+	b.writes.flushLock.Lock()
+	defer b.writes.flushLock.Unlock()
+	b.writes.lock.Lock()
+	pending := b.writes.history.copy()
+	b.writes.lock.Unlock()
+	return b.db.View(func(tx *bolt.Tx) error {
+		return read(tx, pending)
+	})
+}
+
+// getBytes retrieves the value stored under the key from the buffer or the
+// database. The value can be used after the transaction.
+func (b *BoltStore) getBytes(bucket, key string) ([]byte, error) {
+	if value, exists := b.writes.get(bucket, key); exists {
+		if value == nil {
+			return nil, fmt.Errorf("Can't find object for key %s", key)
+		}
+		return value, nil
+	}
+	var value []byte
+	err := b.db.View(func(tx *bolt.Tx) error {
+		v := tx.Bucket([]byte(bucket)).Get([]byte(key))
+		if v == nil {
+			return fmt.Errorf("Can't find object for key %s", key)
+		}
+		// Bolt values are only valid during the transaction
+		value = make([]byte, len(v))
+		copy(value, v)
+		return nil
+	})
+	return value, err
+}
+
+// putAll marshals all values as json and stores them in a single flush.
+func (b *BoltStore) putAll(bucket string, values map[string]interface{}) {
+	for key, value := range values {
+		bytes, err := json.Marshal(value)
+		if err != nil {
+			log.WithFields(log.Fields{
+				"error":  err,
+				"key":    key,
+				"bucket": bucket,
+			}).Error("Error marshalling data for bolt store")
+			continue
+		}
+		b.writes.add(bucket, key, bytes)
+	}
+	b.Flush()
+}
+
+func (b *BoltStore) PutNodeInfos(nodeinfos []NodeInfo) {
+	values := make(map[string]interface{}, len(nodeinfos))
+	for _, nodeinfo := range nodeinfos {
+		b.index.putNodeInfo(nodeinfo)
+		values[nodeinfo.NodeId] = nodeinfo
+	}
+	b.putAll(NodeinfoBucket, values)
+}
+
+func (b *BoltStore) PutAllStatistics(statistics []StatisticsStruct) {
+	values := make(map[string]interface{}, len(statistics))
+	for _, s := range statistics {
+		values[s.NodeId] = s
+	}
+	b.putAll(StatisticsBucket, values)
+}
+
+func (b *BoltStore) PutNodeStatusInfos(infos []NodeStatusInfo) {
+	values := make(map[string]interface{}, len(infos))
+	for _, info := range infos {
+		if info.NodeId != "" {
+			values[info.NodeId] = info
+		}
+	}
+	b.statusLock.Lock()
+	defer b.statusLock.Unlock()
+	b.putAll(StatusInfoBucket, values)
+}
+
+func (b *BoltStore) PutAllNeighbours(neighbours []NeighbourStruct) {
+	values := make(map[string]interface{}, len(neighbours))
+	for _, n := range neighbours {
+		b.index.putNeighbours(n)
+		values[n.NodeId] = n
+	}
+	b.putAll(NeighboursBucket, values)
+}
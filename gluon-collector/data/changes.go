@@ -0,0 +1,141 @@
+package data
+
+import (
+	"encoding/json"
+	"reflect"
+	"sort"
+	"time"
+)
+
+// FirmwareReleasePath is the path of the firmware release in the nodeinfo.
+const FirmwareReleasePath string = "software.firmware.release"
+
+// NodeinfoChange is a change of a single field of the nodeinfo of a node. The
+// path consists of the json field names joined by dots. Old is nil for added
+// fields and New is nil for removed ones.
+type NodeinfoChange struct {
+	Time   time.Time   `json:"time"`
+	NodeId string      `json:"node_id"`
+	Path   string      `json:"path"`
+	Old    interface{} `json:"old"`
+	New    interface{} `json:"new"`
+}
+
+// flatten adds all leaf values of the decoded json document to values keyed by
+// their path. Arrays are treated as leaf values.
+func flatten(prefix string, value interface{}, values map[string]interface{}) {
+	object, ok := value.(map[string]interface{})
+	if !ok {
+		values[prefix] = value
+		return
+	}
+	for key, child := range object {
+		path := key
+		if prefix != "" {
+			path = prefix + "." + key
+		}
+		flatten(path, child, values)
+	}
+}
+
+func flattenNodeinfo(nodeinfo NodeInfo) (map[string]interface{}, error) {
+	encoded, err := json.Marshal(nodeinfo)
+	if err != nil {
+		return nil, err
+	}
+	var decoded interface{}
+	if err := json.Unmarshal(encoded, &decoded); err != nil {
+		return nil, err
+	}
+	values := make(map[string]interface{})
+	flatten("", decoded, values)
+	return values, nil
+}
+
+// equalValues compares two values of the decoded json documents. Arrays are
+// compared as sets, since nodes don't announce lists like their addresses in a
+// stable order.
+func equalValues(a, b interface{}) bool {
+	aList, aIsList := a.([]interface{})
+	bList, bIsList := b.([]interface{})
+	if !aIsList || !bIsList {
+		return reflect.DeepEqual(a, b)
+	}
+	if len(aList) != len(bList) {
+		return false
+	}
+	matched := make([]bool, len(bList))
+	for _, aValue := range aList {
+		found := false
+		for i, bValue := range bList {
+			if !matched[i] && reflect.DeepEqual(aValue, bValue) {
+				matched[i] = true
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// DiffNodeinfo returns the changes of all fields between the old and the new
+// nodeinfo sorted by path. Reordered arrays are no change.
+func DiffNodeinfo(oldNodeinfo, newNodeinfo NodeInfo, changed time.Time) ([]NodeinfoChange, error) {
+	oldValues, err := flattenNodeinfo(oldNodeinfo)
+	if err != nil {
+		return nil, err
+	}
+	newValues, err := flattenNodeinfo(newNodeinfo)
+	if err != nil {
+		return nil, err
+	}
+	changes := make([]NodeinfoChange, 0, 2)
+	for path, newValue := range newValues {
+		oldValue := oldValues[path]
+		if !equalValues(oldValue, newValue) {
+			changes = append(changes, NodeinfoChange{Time: changed, NodeId: newNodeinfo.NodeId,
+				Path: path, Old: oldValue, New: newValue})
+		}
+	}
+	for path, oldValue := range oldValues {
+		if _, exists := newValues[path]; !exists {
+			changes = append(changes, NodeinfoChange{Time: changed, NodeId: newNodeinfo.NodeId,
+				Path: path, Old: oldValue})
+		}
+	}
+	sort.Sort(changesByPath(changes))
+	return changes, nil
+}
+
+type changesByPath []NodeinfoChange
+
+func (c changesByPath) Len() int           { return len(c) }
+func (c changesByPath) Swap(i, j int)      { c[i], c[j] = c[j], c[i] }
+func (c changesByPath) Less(i, j int) bool { return c[i].Path < c[j].Path }
+
+// NodeinfoHistoryStore can be implemented by stores which keep the changes of
+// the nodeinfos.
+type NodeinfoHistoryStore interface {
+
+	// PutNodeinfoChanges appends the changes to the history of their nodes.
+	PutNodeinfoChanges(changes []NodeinfoChange)
+
+	// GetNodeinfoChanges returns all changes of the nodeinfo of the node since
+	// the given time ordered by time. If the node id is empty the changes of all
+	// nodes are returned.
+	GetNodeinfoChanges(nodeId string, since time.Time) ([]NodeinfoChange, error)
+}
+
+// FilterChanges returns only the changes of the given path.
+func FilterChanges(changes []NodeinfoChange, path string) []NodeinfoChange {
+	filtered := make([]NodeinfoChange, 0, len(changes))
+	for _, change := range changes {
+		if change.Path == path {
+			filtered = append(filtered, change)
+		}
+	}
+	return filtered
+}
@@ -0,0 +1,92 @@
+package data
+
+import (
+	"encoding/json"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDiffingNodeinfos(t *testing.T) {
+	assert := assert.New(t)
+	changed := time.Date(2016, 3, 1, 12, 0, 0, 0, time.UTC)
+	oldNodeinfo, newNodeinfo := NodeInfo{}, NodeInfo{}
+	assert.Nil(json.Unmarshal([]byte(`{"node_id": "a", "hostname": "Old name", "owner": {"contact": "me"},
+		"software": {"firmware": {"base": "gluon-v2016.1", "release": "v2016.1"}}}`), &oldNodeinfo))
+	assert.Nil(json.Unmarshal([]byte(`{"node_id": "a", "hostname": "New name", "location": {"latitude": 51.5},
+		"software": {"firmware": {"base": "gluon-v2016.1", "release": "v2016.2"}}}`), &newNodeinfo))
+
+	changes, err := DiffNodeinfo(oldNodeinfo, newNodeinfo, changed)
+	assert.Nil(err)
+	paths := make([]string, 0, len(changes))
+	for _, change := range changes {
+		paths = append(paths, change.Path)
+		assert.Equal("a", change.NodeId)
+		assert.Equal(changed, change.Time)
+	}
+	assert.Equal([]string{"hostname", "location.latitude", "location.longitude",
+		"owner.contact", "software.firmware.release"}, paths)
+	assert.Equal("Old name", changes[0].Old)
+	assert.Equal("New name", changes[0].New)
+	assert.Nil(changes[1].Old, "Added fields have no old value")
+	assert.Nil(changes[3].New, "Removed fields have no new value")
+
+	changes, err = DiffNodeinfo(newNodeinfo, newNodeinfo, changed)
+	assert.Nil(err)
+	assert.Equal(0, len(changes))
+
+	reordered := newNodeinfo
+	newNodeinfo.Network.Addresses = []string{"fe80::1", "2001:db8::1"}
+	reordered.Network.Addresses = []string{"2001:db8::1", "fe80::1"}
+	changes, err = DiffNodeinfo(newNodeinfo, reordered, changed)
+	assert.Nil(err)
+	assert.Equal(0, len(changes), "Reordered arrays are no change")
+	reordered.Network.Addresses = []string{"2001:db8::1", "2001:db8::1"}
+	changes, err = DiffNodeinfo(newNodeinfo, reordered, changed)
+	assert.Nil(err)
+	assert.Equal(1, len(changes))
+}
+
+func TestStoringNodeinfoChanges(t *testing.T) {
+	assert := assert.New(t)
+	dbPath := "./changes.db"
+	defer os.RemoveAll(dbPath)
+	store, err := NewBoltStore(dbPath)
+	assert.Nil(err)
+	defer store.Close()
+
+	now := time.Now()
+	store.PutNodeinfoChanges([]NodeinfoChange{
+		NodeinfoChange{Time: now.Add(-48 * time.Hour), NodeId: "a", Path: "hostname", Old: "x", New: "y"},
+		NodeinfoChange{Time: now.Add(-48 * time.Hour), NodeId: "a", Path: FirmwareReleasePath, Old: "1", New: "2"},
+	})
+	store.PutNodeinfoChanges([]NodeinfoChange{
+		NodeinfoChange{Time: now.Add(-time.Hour), NodeId: "b", Path: FirmwareReleasePath, Old: "1", New: "2"},
+	})
+
+	changes, err := store.GetNodeinfoChanges("a", time.Unix(0, 0))
+	assert.Nil(err)
+	assert.Equal(2, len(changes), "Changes at the same time are kept")
+	changes, err = store.GetNodeinfoChanges("", now.Add(-72*time.Hour))
+	assert.Nil(err)
+	assert.Equal(2, len(FilterChanges(changes, FirmwareReleasePath)))
+	changes, err = store.GetNodeinfoChanges("", now.Add(-24*time.Hour))
+	assert.Nil(err)
+	assert.Equal(1, len(changes))
+	assert.Equal("b", changes[0].NodeId)
+	_, err = store.GetNodeinfoChanges("c", now)
+	assert.NotNil(err)
+
+	store.PutNodeinfoChanges([]NodeinfoChange{
+		NodeinfoChange{Time: now.Add(-500 * 24 * time.Hour), NodeId: "c", Path: "hostname", Old: "x", New: "y"},
+	})
+	store.pruneChanges()
+	store.Flush()
+	_, err = store.GetNodeinfoChanges("c", time.Unix(0, 0))
+	assert.NotNil(err, "Old changes are deleted")
+	changes, err = store.GetNodeinfoChanges("", time.Unix(0, 0))
+	assert.Nil(err)
+	assert.Equal(3, len(changes))
+}
@@ -1,13 +1,92 @@
 package data
 
+import (
+	"encoding/json"
+	"net"
+	"time"
+)
+
+// ResponseMeta is the envelope of a response. It carries everything we know
+// about the reception of a response from the receiver through the pipelines.
+type ResponseMeta struct {
+	// ClientAddr is the address the response was received from or nil if it
+	// is unknown.
+	ClientAddr net.Addr
+	// Receiver is the name of the receiver which received the response.
+	Receiver string
+	// Received is the time the response was received.
+	Received time.Time
+	// QueryRound is the number of the multicast query of the receiver this
+	// response answers.
+	QueryRound uint64
+	// Domain is the mesh domain configured for the receiver, if any.
+	Domain string
+	// IdentityConflict is set by the identity stage if responses claiming the
+	// node id have recently been received from other senders.
+	IdentityConflict bool
+}
+
+// jsonResponseMeta is the json representation of ResponseMeta. The address is
+// stored as string, since net.Addr is an interface.
+type jsonResponseMeta struct {
+	Source     string    `json:"source,omitempty"`
+	Receiver   string    `json:"receiver,omitempty"`
+	Received   time.Time `json:"received"`
+	QueryRound uint64    `json:"query_round"`
+	Domain     string    `json:"domain,omitempty"`
+	Conflict   bool      `json:"identity_conflict,omitempty"`
+}
+
+func (m ResponseMeta) MarshalJSON() ([]byte, error) {
+	jsonMeta := jsonResponseMeta{
+		Receiver:   m.Receiver,
+		Received:   m.Received,
+		QueryRound: m.QueryRound,
+		Domain:     m.Domain,
+		Conflict:   m.IdentityConflict,
+	}
+	if m.ClientAddr != nil {
+		jsonMeta.Source = m.ClientAddr.String()
+	}
+	return json.Marshal(jsonMeta)
+}
+
+func (m *ResponseMeta) UnmarshalJSON(data []byte) error {
+	jsonMeta := jsonResponseMeta{}
+	if err := json.Unmarshal(data, &jsonMeta); err != nil {
+		return err
+	}
+	m.Receiver = jsonMeta.Receiver
+	m.Received = jsonMeta.Received
+	m.QueryRound = jsonMeta.QueryRound
+	m.Domain = jsonMeta.Domain
+	m.IdentityConflict = jsonMeta.Conflict
+	m.ClientAddr = nil
+	if jsonMeta.Source != "" {
+		addr, err := net.ResolveUDPAddr("udp", jsonMeta.Source)
+		if err != nil {
+			return err
+		}
+		m.ClientAddr = addr
+	}
+	return nil
+}
+
 type ParsedResponse interface {
 	Type() string
 	ParsedData() interface{}
 	NodeId() string
+	// Meta returns the envelope with the reception details of the response.
+	Meta() ResponseMeta
+	// RawData returns the json document of the response as it was received,
+	// including all fields not modelled by the parsed data.
+	RawData() json.RawMessage
 }
 
 type NodeinfoResponse struct {
-	Nodeinfo NodeInfo
+	ResponseMeta ResponseMeta
+	Nodeinfo     NodeInfo
+	Raw          json.RawMessage
 }
 
 func (n NodeinfoResponse) Type() string {
@@ -22,8 +101,18 @@ func (n NodeinfoResponse) NodeId() string {
 	return n.Nodeinfo.NodeId
 }
 
+func (n NodeinfoResponse) Meta() ResponseMeta {
+	return n.ResponseMeta
+}
+
+func (n NodeinfoResponse) RawData() json.RawMessage {
+	return n.Raw
+}
+
 type StatisticsResponse struct {
-	Statistics *StatisticsStruct
+	ResponseMeta ResponseMeta
+	Statistics   *StatisticsStruct
+	Raw          json.RawMessage
 }
 
 func (s StatisticsResponse) Type() string {
@@ -38,8 +127,18 @@ func (s StatisticsResponse) NodeId() string {
 	return s.Statistics.NodeId
 }
 
+func (s StatisticsResponse) Meta() ResponseMeta {
+	return s.ResponseMeta
+}
+
+func (s StatisticsResponse) RawData() json.RawMessage {
+	return s.Raw
+}
+
 type NeighbourReponse struct {
-	Neighbours *NeighbourStruct
+	ResponseMeta ResponseMeta
+	Neighbours   *NeighbourStruct
+	Raw          json.RawMessage
 }
 
 func (n NeighbourReponse) Type() string {
@@ -54,7 +153,17 @@ func (n NeighbourReponse) NodeId() string {
 	return n.Neighbours.NodeId
 }
 
-type ErroredResponse struct{}
+func (n NeighbourReponse) Meta() ResponseMeta {
+	return n.ResponseMeta
+}
+
+func (n NeighbourReponse) RawData() json.RawMessage {
+	return n.Raw
+}
+
+type ErroredResponse struct {
+	ResponseMeta ResponseMeta
+}
 
 func (n ErroredResponse) Type() string {
 	return "errored"
@@ -67,3 +176,30 @@ func (n ErroredResponse) ParsedData() interface{} {
 func (n ErroredResponse) NodeId() string {
 	return ""
 }
+
+func (n ErroredResponse) Meta() ResponseMeta {
+	return n.ResponseMeta
+}
+
+func (n ErroredResponse) RawData() json.RawMessage {
+	return nil
+}
+
+// WithMeta returns a copy of the response with the given envelope.
+func WithMeta(response ParsedResponse, meta ResponseMeta) ParsedResponse {
+	switch r := response.(type) {
+	case NodeinfoResponse:
+		r.ResponseMeta = meta
+		return r
+	case StatisticsResponse:
+		r.ResponseMeta = meta
+		return r
+	case NeighbourReponse:
+		r.ResponseMeta = meta
+		return r
+	case ErroredResponse:
+		r.ResponseMeta = meta
+		return r
+	}
+	return response
+}
@@ -0,0 +1,80 @@
+package data
+
+import (
+	"encoding/json"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEncodingResponseMeta(t *testing.T) {
+	assert := assert.New(t)
+	received := time.Date(2016, 3, 1, 12, 0, 0, 0, time.UTC)
+	meta := ResponseMeta{
+		ClientAddr: &net.UDPAddr{IP: net.ParseIP("fe80::16cc:20ff:fe6f:a038"), Port: 1001, Zone: "bat0"},
+		Receiver:   "bat0",
+		Received:   received,
+		QueryRound: 42,
+	}
+	encoded, err := json.Marshal(meta)
+	assert.Nil(err)
+
+	decoded := ResponseMeta{}
+	assert.Nil(json.Unmarshal(encoded, &decoded))
+	assert.Equal("[fe80::16cc:20ff:fe6f:a038%bat0]:1001", decoded.ClientAddr.String())
+	assert.Equal("bat0", decoded.Receiver)
+	assert.True(received.Equal(decoded.Received))
+	assert.Equal(uint64(42), decoded.QueryRound)
+
+	encoded, err = json.Marshal(ResponseMeta{Received: received})
+	assert.Nil(err)
+	decoded = ResponseMeta{}
+	assert.Nil(json.Unmarshal(encoded, &decoded))
+	assert.Nil(decoded.ClientAddr)
+
+	encoded, err = json.Marshal(ResponseMeta{ClientAddr: &net.UDPAddr{IP: net.ParseIP("10.0.0.1"), Port: 1001}})
+	assert.Nil(err)
+	decoded = ResponseMeta{}
+	assert.Nil(json.Unmarshal(encoded, &decoded), "IPv4 sources are decoded too")
+	assert.Equal("10.0.0.1:1001", decoded.ClientAddr.String())
+}
+
+func TestEncodingResponses(t *testing.T) {
+	assert := assert.New(t)
+	response := NodeinfoResponse{
+		ResponseMeta: ResponseMeta{Receiver: "bat0"},
+		Nodeinfo:     NodeInfo{NodeId: "a"},
+	}
+	encoded, err := json.Marshal(response)
+	assert.Nil(err)
+	decoded := NodeinfoResponse{}
+	assert.Nil(json.Unmarshal(encoded, &decoded))
+	assert.Equal("a", decoded.Nodeinfo.NodeId, "The response is encoded, not only its meta data")
+	assert.Equal("bat0", decoded.ResponseMeta.Receiver)
+}
+
+func TestStoringResponseMeta(t *testing.T) {
+	assert := assert.New(t)
+	dbPath := "./meta.db"
+	defer os.RemoveAll(dbPath)
+
+	boltStore, err := NewBoltStore(dbPath)
+	assert.Nil(err)
+	defer boltStore.Close()
+	received := time.Date(2016, 3, 1, 12, 0, 0, 0, time.UTC)
+	for _, store := range []Nodeinfostore{boltStore, NewSimpleInMemoryStore()} {
+		store.PutResponseMeta("a", "statistics", ResponseMeta{
+			ClientAddr: &net.UDPAddr{IP: net.ParseIP("fe80::1"), Port: 1001},
+			Received:   received,
+		})
+		meta, err := store.GetResponseMeta("a", "statistics")
+		assert.Nil(err)
+		assert.Equal("[fe80::1]:1001", meta.ClientAddr.String())
+		assert.True(received.Equal(meta.Received))
+		_, err = store.GetResponseMeta("a", "nodeinfo")
+		assert.NotNil(err)
+	}
+}
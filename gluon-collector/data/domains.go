@@ -0,0 +1,23 @@
+package data
+
+// DomainNodes returns the ids of all nodes of the mesh domain.
+func DomainNodes(store Nodeinfostore, domain string) map[string]bool {
+	nodes := make(map[string]bool)
+	for _, status := range store.GetNodeStatusInfos() {
+		if status.Domain == domain && status.NodeId != "" {
+			nodes[status.NodeId] = true
+		}
+	}
+	return nodes
+}
+
+// Domains counts the nodes by mesh domain. Nodes without domain are left out.
+func Domains(store Nodeinfostore) map[string]int {
+	domains := make(map[string]int)
+	for _, status := range store.GetNodeStatusInfos() {
+		if status.Domain != "" {
+			domains[status.Domain]++
+		}
+	}
+	return domains
+}
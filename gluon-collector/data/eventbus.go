@@ -0,0 +1,169 @@
+package data
+
+import (
+	"sync"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// EventType is the type of the events published on the EventBus.
+type EventType string
+
+const (
+	// NodeNew is published when a node is seen for the first time or again
+	// after it has been expired.
+	NodeNew EventType = "NodeNew"
+	// NodeOnline is published when a node considered offline is seen again.
+	NodeOnline EventType = "NodeOnline"
+	// NodeOffline is published when a node missed too many of its reports.
+	NodeOffline EventType = "NodeOffline"
+	// NodeExpired is published after a node has been deleted from the store.
+	NodeExpired EventType = "NodeExpired"
+	// NodeStateChanged is published for every change of the state of a node,
+	// including the changes to and from degraded and gone.
+	NodeStateChanged EventType = "NodeStateChanged"
+	// NodeinfoChanged is published when a node reports a different nodeinfo.
+	NodeinfoChanged EventType = "NodeinfoChanged"
+	// GatewayChanged is published when a node switches to another gateway.
+	GatewayChanged EventType = "GatewayChanged"
+	// IdentityConflict is published when a response claims a node id its
+	// sender is not entitled to.
+	IdentityConflict EventType = "IdentityConflict"
+)
+
+// DefaultEventBufferSize is the number of events buffered per subscription.
+const DefaultEventBufferSize int = 1024
+
+// Event is a change in the life cycle of a node.
+type Event struct {
+	Type   EventType
+	NodeId string
+	Time   time.Time
+	// From and To are the previous and the new state of NodeStateChanged
+	// events and the previous and the new gateway of GatewayChanged events.
+	// For IdentityConflict events From is the address of the sender and To the
+	// node id known to own it, if any.
+	From string
+	To   string
+	// Reason describes why the response of IdentityConflict events conflicts.
+	Reason string
+	// Changes are the changed fields of NodeinfoChanged events.
+	Changes []NodeinfoChange
+}
+
+// Subscription delivers the events it is subscribed to to its handler.
+type Subscription struct {
+	bus     *EventBus
+	types   map[EventType]bool
+	events  chan Event
+	handler func(Event)
+	done    chan struct{}
+}
+
+// Unsubscribe stops the delivery of events to this subscription.
+func (s *Subscription) Unsubscribe() {
+	s.bus.Unsubscribe(s)
+}
+
+func (s *Subscription) wants(eventType EventType) bool {
+	return len(s.types) == 0 || s.types[eventType]
+}
+
+func (s *Subscription) deliver() {
+	defer close(s.done)
+	for event := range s.events {
+		s.handler(event)
+	}
+}
+
+// EventBus delivers the published events asynchronously to all subscriptions.
+// Every subscription buffers the events for its handler, so a slow handler
+// doesn't delay the publisher or the other handlers. Events which don't fit
+// into the buffer any more are dropped.
+type EventBus struct {
+	lock          sync.RWMutex
+	bufferSize    int
+	subscriptions map[*Subscription]bool
+}
+
+// NewEventBus creates an EventBus buffering up to bufferSize events per
+// subscription.
+func NewEventBus(bufferSize int) *EventBus {
+	return &EventBus{
+		bufferSize:    bufferSize,
+		subscriptions: make(map[*Subscription]bool),
+	}
+}
+
+// Subscribe registers the handler for the given event types or all events if
+// no type is given. The handler is called from a single go routine per
+// subscription, in the order the events were published.
+func (b *EventBus) Subscribe(handler func(Event), types ...EventType) *Subscription {
+	subscription := &Subscription{
+		bus:     b,
+		types:   make(map[EventType]bool),
+		events:  make(chan Event, b.bufferSize),
+		handler: handler,
+		done:    make(chan struct{}),
+	}
+	for _, eventType := range types {
+		subscription.types[eventType] = true
+	}
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.subscriptions[subscription] = true
+	go subscription.deliver()
+	return subscription
+}
+
+// Unsubscribe stops the delivery of events to the subscription. Events already
+// buffered are still delivered.
+func (b *EventBus) Unsubscribe(subscription *Subscription) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if b.subscriptions[subscription] {
+		delete(b.subscriptions, subscription)
+		close(subscription.events)
+	}
+}
+
+// Publish hands the event to all subscriptions of its type. If the time of the
+// event is not set, it is set to now.
+func (b *EventBus) Publish(event Event) {
+	if event.Time.IsZero() {
+		event.Time = time.Now()
+	}
+	b.lock.RLock()
+	defer b.lock.RUnlock()
+	for subscription := range b.subscriptions {
+		if !subscription.wants(event.Type) {
+			continue
+		}
+		select {
+		case subscription.events <- event:
+		default:
+			log.WithFields(log.Fields{
+				"type":   event.Type,
+				"nodeid": event.NodeId,
+			}).Error("Event buffer of subscription is full, dropping event")
+		}
+	}
+}
+
+// Close unsubscribes all subscriptions and waits until their buffered events
+// have been delivered.
+func (b *EventBus) Close() error {
+	b.lock.Lock()
+	subscriptions := make([]*Subscription, 0, len(b.subscriptions))
+	for subscription := range b.subscriptions {
+		delete(b.subscriptions, subscription)
+		close(subscription.events)
+		subscriptions = append(subscriptions, subscription)
+	}
+	b.lock.Unlock()
+	for _, subscription := range subscriptions {
+		<-subscription.done
+	}
+	return nil
+}
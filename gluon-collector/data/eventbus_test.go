@@ -0,0 +1,66 @@
+package data
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDeliveringEventsBySubscribedType(t *testing.T) {
+	assert := assert.New(t)
+	bus := NewEventBus(10)
+	all := make([]EventType, 0, 3)
+	offline := make([]string, 0, 1)
+	bus.Subscribe(func(event Event) {
+		all = append(all, event.Type)
+	})
+	bus.Subscribe(func(event Event) {
+		offline = append(offline, event.NodeId)
+	}, NodeOffline)
+
+	bus.Publish(Event{Type: NodeNew, NodeId: "a"})
+	bus.Publish(Event{Type: NodeOffline, NodeId: "b"})
+	bus.Publish(Event{Type: NodeExpired, NodeId: "b"})
+	// Close waits for the delivery of all published events
+	bus.Close()
+	assert.Equal([]EventType{NodeNew, NodeOffline, NodeExpired}, all)
+	assert.Equal([]string{"b"}, offline)
+}
+
+func TestUnsubscribing(t *testing.T) {
+	assert := assert.New(t)
+	bus := NewEventBus(10)
+	received := make(chan Event, 10)
+	subscription := bus.Subscribe(func(event Event) {
+		received <- event
+	})
+	bus.Publish(Event{Type: NodeNew, NodeId: "a"})
+	event := <-received
+	assert.Equal("a", event.NodeId)
+	assert.False(event.Time.IsZero(), "The time of published events is set")
+
+	subscription.Unsubscribe()
+	bus.Publish(Event{Type: NodeNew, NodeId: "b"})
+	bus.Close()
+	assert.Equal(0, len(received))
+	// Unsubscribing twice does no harm
+	subscription.Unsubscribe()
+}
+
+func TestDroppingEventsOfSlowSubscribers(t *testing.T) {
+	assert := assert.New(t)
+	bus := NewEventBus(1)
+	block := make(chan bool)
+	count := 0
+	bus.Subscribe(func(event Event) {
+		<-block
+		count++
+	})
+	for i := 0; i < 5; i++ {
+		// The first event is taken by the handler, one more fits into the buffer
+		bus.Publish(Event{Type: NodeOnline, NodeId: "a"})
+	}
+	close(block)
+	bus.Close()
+	assert.True(count >= 1 && count <= 2, "Events not fitting into the buffer are dropped")
+}
@@ -0,0 +1,156 @@
+package data
+
+import (
+	"time"
+)
+
+const (
+	// EventNew is recorded when a node is seen for the first time.
+	EventNew string = "new"
+	// EventOnline is recorded when a node considered offline is seen again.
+	EventOnline string = "online"
+	// EventOffline is recorded when a node missed too many updates.
+	EventOffline string = "offline"
+	// EventExpired is recorded when a node is deleted after being offline for
+	// too long.
+	EventExpired string = "expired"
+	// EventReturned is recorded when an expired node is seen again.
+	EventReturned string = "returned"
+)
+
+// NodeEvent is a transition of the status of a node.
+type NodeEvent struct {
+	Time   time.Time `json:"time"`
+	NodeId string    `json:"node_id"`
+	Type   string    `json:"type"`
+}
+
+// IsOnline tells whether the node is online after the event.
+func (e NodeEvent) IsOnline() bool {
+	return e.Type == EventNew || e.Type == EventOnline || e.Type == EventReturned
+}
+
+// AvailabilityWindows are the periods over which the availability of the nodes
+// is calculated by name.
+var AvailabilityWindows = map[string]time.Duration{
+	"24h": 24 * time.Hour,
+	"7d":  7 * 24 * time.Hour,
+	"30d": 30 * 24 * time.Hour,
+}
+
+// CalculateAvailability returns the share of the time between from and to the
+// node was online. The events need to be sorted by time and start with the last
+// event before from if there is one. Time before the node was seen for the first
+// time is not taken into account. The second return value is false if the
+// events don't say anything about this period.
+func CalculateAvailability(events []NodeEvent, from, to time.Time) (float64, bool) {
+	var known, online time.Duration
+	for i, event := range events {
+		start := event.Time
+		if start.Before(from) {
+			start = from
+		}
+		end := to
+		if i+1 < len(events) && events[i+1].Time.Before(to) {
+			end = events[i+1].Time
+		}
+		if !end.After(start) {
+			continue
+		}
+		known += end.Sub(start)
+		if event.IsOnline() {
+			online += end.Sub(start)
+		}
+	}
+	if known == 0 {
+		return 0, false
+	}
+	return float64(online) / float64(known), true
+}
+
+// EventLog can be implemented by stores which persist the transitions of the
+// node status.
+type EventLog interface {
+
+	// PutEvent appends the event to the log.
+	PutEvent(event NodeEvent)
+
+	// GetEvents returns all events of the node since the given time ordered by
+	// time. If the node id is empty the events of all nodes are returned.
+	GetEvents(nodeId string, since time.Time) ([]NodeEvent, error)
+
+	// GetLastEvent returns the latest event of the node before the given time or
+	// an error if there is none.
+	GetLastEvent(nodeId string, before time.Time) (NodeEvent, error)
+
+	// GetEventTimelines returns the events of all nodes since the given time by
+	// node id, each preceded by the last event of the node before that time if
+	// there is one. All events are read at once.
+	GetEventTimelines(since time.Time) (map[string][]NodeEvent, error)
+}
+
+// ExpireNode deletes the node from the store, records the EventExpired and
+// publishes the events of a node going away. Nodes which are still considered
+// online go offline first, so every node going online goes offline again.
+func ExpireNode(store Nodeinfostore, status NodeStatusInfo, now time.Time) {
+	store.DeleteNode(status.NodeId)
+	if eventLog, ok := store.(EventLog); ok {
+		eventLog.PutEvent(NodeEvent{Time: now, NodeId: status.NodeId, Type: EventExpired})
+	}
+	events := store.Events()
+	if status.Online {
+		events.Publish(Event{Type: NodeOffline, NodeId: status.NodeId, Time: now})
+	}
+	if from := status.CurrentState(); from != StateGone {
+		events.Publish(Event{Type: NodeStateChanged, NodeId: status.NodeId, Time: now, From: from, To: StateGone})
+	}
+	events.Publish(Event{Type: NodeExpired, NodeId: status.NodeId, Time: now})
+}
+
+// GetAvailability calculates the availability of the node for all
+// AvailabilityWindows ending now. Windows the log knows nothing about are left
+// out.
+func GetAvailability(log EventLog, nodeId string, now time.Time) map[string]float64 {
+	availability := make(map[string]float64)
+	for name, window := range AvailabilityWindows {
+		from := now.Add(-window)
+		events, err := log.GetEvents(nodeId, from)
+		if err != nil {
+			continue
+		}
+		if last, err := log.GetLastEvent(nodeId, from); err == nil {
+			events = append([]NodeEvent{last}, events...)
+		}
+		if value, ok := CalculateAvailability(events, from, now); ok {
+			availability[name] = value
+		}
+	}
+	return availability
+}
+
+// GetAllAvailabilities calculates the availability of all nodes like
+// GetAvailability, but reads the events of all nodes at once.
+func GetAllAvailabilities(log EventLog, now time.Time) map[string]map[string]float64 {
+	var longest time.Duration
+	for _, window := range AvailabilityWindows {
+		if window > longest {
+			longest = window
+		}
+	}
+	availabilities := make(map[string]map[string]float64)
+	timelines, err := log.GetEventTimelines(now.Add(-longest))
+	if err != nil {
+		return availabilities
+	}
+	for nodeId, events := range timelines {
+		availability := make(map[string]float64)
+		for name, window := range AvailabilityWindows {
+			// Events before the window don't count, except for the last one
+			if value, ok := CalculateAvailability(events, now.Add(-window), now); ok {
+				availability[name] = value
+			}
+		}
+		availabilities[nodeId] = availability
+	}
+	return availabilities
+}
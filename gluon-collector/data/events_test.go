@@ -0,0 +1,83 @@
+package data
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCalculatingAvailability(t *testing.T) {
+	assert := assert.New(t)
+	from := time.Date(2016, 3, 1, 0, 0, 0, 0, time.UTC)
+	to := from.Add(10 * time.Hour)
+
+	events := []NodeEvent{
+		NodeEvent{Time: from.Add(-time.Hour), Type: EventNew},
+		NodeEvent{Time: from.Add(2 * time.Hour), Type: EventOffline},
+		NodeEvent{Time: from.Add(3 * time.Hour), Type: EventOnline},
+	}
+	availability, ok := CalculateAvailability(events, from, to)
+	assert.True(ok)
+	assert.InDelta(0.9, availability, 0.0001)
+
+	events = []NodeEvent{
+		NodeEvent{Time: from.Add(5 * time.Hour), Type: EventNew},
+		NodeEvent{Time: from.Add(9 * time.Hour), Type: EventOffline},
+	}
+	availability, ok = CalculateAvailability(events, from, to)
+	assert.True(ok)
+	assert.InDelta(0.8, availability, 0.0001, "Time before the node was known doesn't count")
+
+	_, ok = CalculateAvailability([]NodeEvent{}, from, to)
+	assert.False(ok)
+}
+
+func TestStoringEvents(t *testing.T) {
+	assert := assert.New(t)
+	dbPath := "./events.db"
+	defer os.RemoveAll(dbPath)
+	store, err := NewBoltStore(dbPath)
+	assert.Nil(err)
+	defer store.Close()
+
+	now := time.Now()
+	store.PutEvent(NodeEvent{Time: now.Add(-48 * time.Hour), NodeId: "a", Type: EventNew})
+	store.PutEvent(NodeEvent{Time: now.Add(-12 * time.Hour), NodeId: "a", Type: EventOffline})
+	store.PutEvent(NodeEvent{Time: now.Add(-6 * time.Hour), NodeId: "a", Type: EventOnline})
+	store.PutEvent(NodeEvent{Time: now.Add(-6 * time.Hour), NodeId: "a", Type: EventOffline})
+	store.PutEvent(NodeEvent{Time: now.Add(-3 * time.Hour), NodeId: "b", Type: EventNew})
+
+	events, err := store.GetEvents("a", now.Add(-24*time.Hour))
+	assert.Nil(err)
+	assert.Equal(3, len(events), "Events at the same time are kept")
+	events, err = store.GetEvents("", now.Add(-24*time.Hour))
+	assert.Nil(err)
+	assert.Equal(4, len(events))
+	assert.Equal("b", events[3].NodeId)
+	_, err = store.GetEvents("c", now.Add(-24*time.Hour))
+	assert.NotNil(err)
+
+	last, err := store.GetLastEvent("a", now.Add(-24*time.Hour))
+	assert.Nil(err)
+	assert.Equal(EventNew, last.Type)
+	_, err = store.GetLastEvent("b", now.Add(-24*time.Hour))
+	assert.NotNil(err)
+
+	availability := GetAvailability(store, "a", now)
+	assert.InDelta(0.5, availability["24h"], 0.001)
+	assert.InDelta(0.75, availability["7d"], 0.001)
+	availability = GetAvailability(store, "b", now)
+	assert.InDelta(1, availability["30d"], 0.001)
+
+	timelines, err := store.GetEventTimelines(now.Add(-24 * time.Hour))
+	assert.Nil(err)
+	assert.Equal(4, len(timelines["a"]), "The timeline starts with the last event before")
+	assert.Equal(EventNew, timelines["a"][0].Type)
+	assert.Equal(1, len(timelines["b"]))
+	availabilities := GetAllAvailabilities(store, now)
+	assert.Equal(2, len(availabilities))
+	assert.Equal(GetAvailability(store, "a", now), availabilities["a"])
+	assert.Equal(GetAvailability(store, "b", now), availabilities["b"])
+}
@@ -0,0 +1,163 @@
+package data
+
+import (
+	"math"
+	"sort"
+	"time"
+)
+
+// StatisticsSample is a single point in the statistics history of a node. Samples
+// of the downsampled tiers contain the averages of the gauges and the highest
+// values of the counters of Count received statistics.
+type StatisticsSample struct {
+	Time         time.Time `json:"time"`
+	Count        int       `json:"count"`
+	Clients      float64   `json:"clients"`
+	LoadAverage  float64   `json:"loadavg"`
+	MemoryUsage  float64   `json:"memory_usage"`
+	RootFsUsage  float64   `json:"rootfs_usage"`
+	Uptime       float64   `json:"uptime"`
+	RxBytes      float64   `json:"rx_bytes"`
+	TxBytes      float64   `json:"tx_bytes"`
+	ForwardBytes float64   `json:"forward_bytes"`
+}
+
+func trafficBytes(traffic *TrafficObject) float64 {
+	if traffic == nil {
+		return 0
+	}
+	return traffic.Bytes
+}
+
+// NewStatisticsSample extracts the values kept in the history from statistics
+// received at the given time.
+func NewStatisticsSample(received time.Time, statistics StatisticsStruct) StatisticsSample {
+	sample := StatisticsSample{
+		Time:        received,
+		Count:       1,
+		Clients:     float64(statistics.Clients.Total),
+		LoadAverage: statistics.LoadAverage,
+		RootFsUsage: statistics.RootFsUsage,
+		Uptime:      statistics.Uptime,
+	}
+	memory := statistics.Memory
+	if memory.Total > 0 {
+		used := float64(memory.Total) - float64(memory.Free) - float64(memory.Buffers) - float64(memory.Cached)
+		sample.MemoryUsage = used / float64(memory.Total)
+	}
+	if statistics.Traffic != nil {
+		sample.RxBytes = trafficBytes(statistics.Traffic.Rx)
+		sample.TxBytes = trafficBytes(statistics.Traffic.Tx)
+		sample.ForwardBytes = trafficBytes(statistics.Traffic.Forward)
+	}
+	return sample
+}
+
+// Merge combines both samples. Gauges are averaged weighted by the number of
+// statistics the samples represent. Counters like the uptime and the traffic
+// only grow, so averaging them would be meaningless and the highest value is
+// kept instead. The time of the receiver is kept.
+func (s StatisticsSample) Merge(other StatisticsSample) StatisticsSample {
+	count := s.Count + other.Count
+	if count == 0 {
+		return s
+	}
+	avg := func(a, b float64) float64 {
+		return (a*float64(s.Count) + b*float64(other.Count)) / float64(count)
+	}
+	return StatisticsSample{
+		Time:         s.Time,
+		Count:        count,
+		Clients:      avg(s.Clients, other.Clients),
+		LoadAverage:  avg(s.LoadAverage, other.LoadAverage),
+		MemoryUsage:  avg(s.MemoryUsage, other.MemoryUsage),
+		RootFsUsage:  avg(s.RootFsUsage, other.RootFsUsage),
+		Uptime:       math.Max(s.Uptime, other.Uptime),
+		RxBytes:      math.Max(s.RxBytes, other.RxBytes),
+		TxBytes:      math.Max(s.TxBytes, other.TxBytes),
+		ForwardBytes: math.Max(s.ForwardBytes, other.ForwardBytes),
+	}
+}
+
+// HistoryTier is one resolution in which the statistics history is kept. A
+// Step of zero means that every received sample is kept as it is.
+type HistoryTier struct {
+	Name      string
+	Step      time.Duration
+	Retention time.Duration
+}
+
+// HistoryTiers are the resolutions of the statistics history ordered from the
+// finest to the coarsest one.
+var HistoryTiers = []HistoryTier{
+	HistoryTier{Name: "raw", Step: 0, Retention: 2 * 24 * time.Hour},
+	HistoryTier{Name: "5m", Step: 5 * time.Minute, Retention: 30 * 24 * time.Hour},
+	HistoryTier{Name: "1h", Step: time.Hour, Retention: 365 * 24 * time.Hour},
+}
+
+// SelectHistoryTier returns the finest tier which still holds data from the
+// given point of time on. If no tier reaches back that far the coarsest tier is
+// returned.
+func SelectHistoryTier(tiers []HistoryTier, now, from time.Time) HistoryTier {
+	for _, tier := range tiers {
+		if !from.Before(now.Add(-tier.Retention)) {
+			return tier
+		}
+	}
+	return tiers[len(tiers)-1]
+}
+
+// Resample merges all samples falling into the same interval of length step.
+// The samples need to be sorted by time. A step of zero returns the samples as
+// they are.
+func Resample(samples []StatisticsSample, step time.Duration) []StatisticsSample {
+	if step <= 0 || len(samples) == 0 {
+		return samples
+	}
+	buckets := make(map[int64]StatisticsSample)
+	for _, sample := range samples {
+		start := sample.Time.Truncate(step)
+		if existing, ok := buckets[start.UnixNano()]; ok {
+			buckets[start.UnixNano()] = existing.Merge(sample)
+		} else {
+			sample.Time = start
+			buckets[start.UnixNano()] = sample
+		}
+	}
+	resampled := make([]StatisticsSample, 0, len(buckets))
+	for _, sample := range buckets {
+		resampled = append(resampled, sample)
+	}
+	sort.Sort(samplesByTime(resampled))
+	return resampled
+}
+
+type samplesByTime []StatisticsSample
+
+func (s samplesByTime) Len() int           { return len(s) }
+func (s samplesByTime) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+func (s samplesByTime) Less(i, j int) bool { return s[i].Time.Before(s[j].Time) }
+
+// StatisticsHistoryStore can be implemented by stores which are able to keep the
+// history of the statistics of all nodes. Not every Nodeinfostore is required
+// to do so.
+type StatisticsHistoryStore interface {
+
+	// PutStatisticsSample appends the sample to the history of the node and
+	// updates all downsampled tiers.
+	PutStatisticsSample(nodeId string, sample StatisticsSample)
+
+	// GetStatisticsHistory returns the samples of the node between from and to
+	// ordered by time. The samples are taken from the finest tier holding data
+	// back to from and averaged over step if step is larger than the resolution
+	// of that tier.
+	GetStatisticsHistory(nodeId string, from, to time.Time, step time.Duration) ([]StatisticsSample, error)
+
+	// GetHistoryTier returns all samples of the node kept in the tier with the
+	// given name.
+	GetHistoryTier(nodeId, tier string) ([]StatisticsSample, error)
+
+	// PutHistoryTier stores the samples in the tier with the given name as they
+	// are, without updating the other tiers.
+	PutHistoryTier(nodeId, tier string, samples []StatisticsSample)
+}
@@ -0,0 +1,122 @@
+package data
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCreatingStatisticsSample(t *testing.T) {
+	assert := assert.New(t)
+	received := time.Date(2016, 3, 1, 12, 0, 0, 0, time.UTC)
+	statistics := StatisticsStruct{
+		NodeId:      "a",
+		Clients:     ClientStatistics{Total: 4},
+		LoadAverage: 0.5,
+		RootFsUsage: 0.25,
+		Uptime:      3600,
+		Memory:      MemoryStatistics{Total: 100, Free: 40, Buffers: 5, Cached: 5},
+		Traffic: &TrafficStruct{
+			Rx: &TrafficObject{Bytes: 1000},
+			Tx: &TrafficObject{Bytes: 500},
+		},
+	}
+	sample := NewStatisticsSample(received, statistics)
+	assert.Equal(received, sample.Time)
+	assert.Equal(1, sample.Count)
+	assert.Equal(4.0, sample.Clients)
+	assert.InDelta(0.5, sample.MemoryUsage, 0.0001)
+	assert.Equal(1000.0, sample.RxBytes)
+	assert.Equal(500.0, sample.TxBytes)
+	assert.Equal(0.0, sample.ForwardBytes)
+
+	merged := sample.Merge(StatisticsSample{Count: 3, Clients: 8, RxBytes: 4000, Uptime: 7200})
+	assert.Equal(4, merged.Count)
+	assert.Equal(7.0, merged.Clients)
+	assert.Equal(4000.0, merged.RxBytes, "Counters are not averaged")
+	assert.Equal(7200.0, merged.Uptime)
+	assert.Equal(received, merged.Time)
+}
+
+func TestResamplingStatistics(t *testing.T) {
+	assert := assert.New(t)
+	start := time.Date(2016, 3, 1, 12, 0, 0, 0, time.UTC)
+	samples := []StatisticsSample{
+		StatisticsSample{Time: start, Count: 1, Clients: 2},
+		StatisticsSample{Time: start.Add(time.Minute), Count: 1, Clients: 4},
+		StatisticsSample{Time: start.Add(6 * time.Minute), Count: 1, Clients: 10},
+	}
+	resampled := Resample(samples, 5*time.Minute)
+	assert.Equal(2, len(resampled))
+	assert.Equal(start, resampled[0].Time)
+	assert.Equal(3.0, resampled[0].Clients)
+	assert.Equal(start.Add(5*time.Minute), resampled[1].Time)
+	assert.Equal(10.0, resampled[1].Clients)
+
+	assert.Equal(3, len(Resample(samples, 0)))
+}
+
+func TestSelectingHistoryTier(t *testing.T) {
+	assert := assert.New(t)
+	now := time.Now()
+	assert.Equal("raw", SelectHistoryTier(HistoryTiers, now, now.Add(-time.Hour)).Name)
+	assert.Equal("5m", SelectHistoryTier(HistoryTiers, now, now.Add(-7*24*time.Hour)).Name)
+	assert.Equal("1h", SelectHistoryTier(HistoryTiers, now, now.Add(-90*24*time.Hour)).Name)
+	assert.Equal("1h", SelectHistoryTier(HistoryTiers, now, now.Add(-5*365*24*time.Hour)).Name)
+}
+
+func TestStoringStatisticsHistory(t *testing.T) {
+	assert := assert.New(t)
+	dbPath := "./history.db"
+	defer os.RemoveAll(dbPath)
+	store, err := NewBoltStore(dbPath)
+	assert.Nil(err)
+	defer store.Close()
+
+	now := time.Now().Truncate(time.Hour)
+	store.PutStatisticsSample("a", StatisticsSample{Time: now.Add(-40 * 24 * time.Hour), Count: 1, Clients: 1})
+	for i := 0; i < 4; i++ {
+		store.PutStatisticsSample("a", StatisticsSample{Time: now.Add(time.Duration(i) * time.Minute), Count: 1, Clients: float64(i)})
+	}
+
+	samples, err := store.GetStatisticsHistory("a", now.Add(-time.Hour), now.Add(time.Hour), 0)
+	assert.Nil(err)
+	assert.Equal(4, len(samples), "Raw samples are kept")
+
+	samples, err = store.GetStatisticsHistory("a", now.Add(-time.Hour), now.Add(time.Hour), 2*time.Minute)
+	assert.Nil(err)
+	assert.Equal(2, len(samples))
+	assert.Equal(0.5, samples[0].Clients)
+
+	samples, err = store.GetStatisticsHistory("a", now.Add(-7*24*time.Hour), now.Add(time.Hour), 0)
+	assert.Nil(err)
+	assert.Equal(1, len(samples), "Five minute averages are used")
+	assert.Equal(4, samples[0].Count)
+	assert.Equal(1.5, samples[0].Clients)
+
+	samples, err = store.GetStatisticsHistory("a", now.Add(-60*24*time.Hour), now.Add(time.Hour), 0)
+	assert.Nil(err)
+	assert.Equal(2, len(samples), "Hourly averages are used")
+
+	store.pruneHistory()
+	store.Flush()
+	samples, err = store.GetStatisticsHistory("a", now.Add(-60*24*time.Hour), now.Add(time.Hour), 0)
+	assert.Nil(err)
+	assert.Equal(2, len(samples), "Hourly averages are kept for a year")
+	samples, err = store.GetStatisticsHistory("a", now.Add(-7*24*time.Hour), now.Add(time.Hour), 0)
+	assert.Nil(err)
+	assert.Equal(1, len(samples))
+
+	_, err = store.GetStatisticsHistory("b", now.Add(-time.Hour), now, 0)
+	assert.NotNil(err)
+
+	store.PutStatisticsSample("c", StatisticsSample{Time: now.Add(-3 * 24 * time.Hour), Count: 1})
+	store.pruneHistory()
+	store.Flush()
+	_, err = store.GetHistoryTier("c", "raw")
+	assert.NotNil(err, "Empty buckets are deleted")
+	_, err = store.GetHistoryTier("c", "5m")
+	assert.Nil(err)
+}
@@ -0,0 +1,255 @@
+package data
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// nodeIndex maps the macs, addresses, hostnames and site codes of the nodes to
+// their node ids, so nodes can be found without scanning all nodeinfos. It is
+// kept in memory by both stores and updated on every put of a nodeinfo or of
+// mesh neighbours.
+type nodeIndex struct {
+	lock      sync.RWMutex
+	macs      map[string]string
+	addresses map[string]string
+	hostnames map[string]string
+	sites     map[string]map[string]bool
+	// The keys indexed per node, so they can be removed when they change.
+	nodeinfoKeys  map[string]nodeinfoKeys
+	neighbourMacs map[string][]string
+}
+
+type nodeinfoKeys struct {
+	macs      []string
+	addresses []string
+	hostname  string
+	site      string
+}
+
+func newNodeIndex() *nodeIndex {
+	return &nodeIndex{
+		macs:          make(map[string]string),
+		addresses:     make(map[string]string),
+		hostnames:     make(map[string]string),
+		sites:         make(map[string]map[string]bool),
+		nodeinfoKeys:  make(map[string]nodeinfoKeys),
+		neighbourMacs: make(map[string][]string),
+	}
+}
+
+func normalizeMac(mac string) string {
+	return strings.ToLower(strings.TrimSpace(mac))
+}
+
+// normalizeAddress brings ip addresses into their canonical form, so different
+// notations of the same IPv6 address are found.
+func normalizeAddress(address string) string {
+	address = strings.TrimSpace(address)
+	if ip := net.ParseIP(address); ip != nil {
+		return ip.String()
+	}
+	return strings.ToLower(address)
+}
+
+func normalizeHostname(hostname string) string {
+	return strings.ToLower(strings.TrimSpace(hostname))
+}
+
+// nodeinfoMacs returns the primary mac and the macs of all mesh interfaces
+// announced in the nodeinfo.
+func nodeinfoMacs(nodeinfo NodeInfo) []string {
+	network := nodeinfo.Network
+	interfaces := network.Mesh.Bat0.Interfaces
+	macs := make([]string, 0, 1+len(interfaces.Wireless)+len(interfaces.Other)+len(interfaces.Tunnel)+len(network.MeshInterfaces))
+	if network.Mac != "" {
+		macs = append(macs, network.Mac)
+	}
+	macs = append(macs, interfaces.Wireless...)
+	macs = append(macs, interfaces.Other...)
+	macs = append(macs, interfaces.Tunnel...)
+	macs = append(macs, network.MeshInterfaces...)
+	for i, mac := range macs {
+		macs[i] = normalizeMac(mac)
+	}
+	return macs
+}
+
+// removeKey deletes the key from the index unless it has been taken over by
+// another node in the meantime.
+func removeKey(index map[string]string, key, nodeId string) {
+	if index[key] == nodeId {
+		delete(index, key)
+	}
+}
+
+// restoreKey indexes the key for the node again, unless it has been taken over
+// by another node in the meantime.
+func restoreKey(index map[string]string, key, nodeId string) {
+	if owner, exists := index[key]; !exists || owner == nodeId {
+		index[key] = nodeId
+	}
+}
+
+func (i *nodeIndex) removeNodeinfo(nodeId string) {
+	keys, exists := i.nodeinfoKeys[nodeId]
+	if !exists {
+		return
+	}
+	for _, mac := range keys.macs {
+		removeKey(i.macs, mac, nodeId)
+	}
+	for _, address := range keys.addresses {
+		removeKey(i.addresses, address, nodeId)
+	}
+	removeKey(i.hostnames, keys.hostname, nodeId)
+	if nodes, exists := i.sites[keys.site]; exists {
+		delete(nodes, nodeId)
+		if len(nodes) == 0 {
+			delete(i.sites, keys.site)
+		}
+	}
+	delete(i.nodeinfoKeys, nodeId)
+	// Macs only known from the neighbours must stay
+	for _, mac := range i.neighbourMacs[nodeId] {
+		restoreKey(i.macs, mac, nodeId)
+	}
+}
+
+func (i *nodeIndex) removeNeighbours(nodeId string) {
+	for _, mac := range i.neighbourMacs[nodeId] {
+		removeKey(i.macs, mac, nodeId)
+	}
+	delete(i.neighbourMacs, nodeId)
+	for _, mac := range i.nodeinfoKeys[nodeId].macs {
+		restoreKey(i.macs, mac, nodeId)
+	}
+}
+
+func (i *nodeIndex) putNodeInfo(nodeinfo NodeInfo) {
+	if nodeinfo.NodeId == "" {
+		return
+	}
+	i.lock.Lock()
+	defer i.lock.Unlock()
+	i.removeNodeinfo(nodeinfo.NodeId)
+	keys := nodeinfoKeys{
+		macs:      nodeinfoMacs(nodeinfo),
+		addresses: make([]string, 0, len(nodeinfo.Network.Addresses)),
+		hostname:  normalizeHostname(nodeinfo.Hostname),
+		site:      nodeinfo.System.SiteCode,
+	}
+	for _, mac := range keys.macs {
+		i.macs[mac] = nodeinfo.NodeId
+	}
+	for _, address := range nodeinfo.Network.Addresses {
+		address = normalizeAddress(address)
+		keys.addresses = append(keys.addresses, address)
+		i.addresses[address] = nodeinfo.NodeId
+	}
+	if keys.hostname != "" {
+		i.hostnames[keys.hostname] = nodeinfo.NodeId
+	}
+	if keys.site != "" {
+		nodes, exists := i.sites[keys.site]
+		if !exists {
+			nodes = make(map[string]bool)
+			i.sites[keys.site] = nodes
+		}
+		nodes[nodeinfo.NodeId] = true
+	}
+	i.nodeinfoKeys[nodeinfo.NodeId] = keys
+}
+
+// putNeighbours indexes the own batman interfaces of the node, which are the
+// macs other nodes see it by.
+func (i *nodeIndex) putNeighbours(neighbours NeighbourStruct) {
+	if neighbours.NodeId == "" {
+		return
+	}
+	i.lock.Lock()
+	defer i.lock.Unlock()
+	i.removeNeighbours(neighbours.NodeId)
+	macs := make([]string, 0, len(neighbours.Batadv))
+	for ownMac := range neighbours.Batadv {
+		mac := normalizeMac(ownMac)
+		macs = append(macs, mac)
+		i.macs[mac] = neighbours.NodeId
+	}
+	i.neighbourMacs[neighbours.NodeId] = macs
+}
+
+func (i *nodeIndex) deleteNode(nodeId string) {
+	i.lock.Lock()
+	defer i.lock.Unlock()
+	i.removeNeighbours(nodeId)
+	i.removeNodeinfo(nodeId)
+}
+
+func lookup(index map[string]string, lock *sync.RWMutex, key, kind string) (string, error) {
+	lock.RLock()
+	defer lock.RUnlock()
+	nodeId, exists := index[key]
+	if !exists {
+		return "", fmt.Errorf("No node with %s %s", kind, key)
+	}
+	return nodeId, nil
+}
+
+func (i *nodeIndex) lookupMac(mac string) (string, error) {
+	return lookup(i.macs, &i.lock, normalizeMac(mac), "mac")
+}
+
+func (i *nodeIndex) lookupAddress(address string) (string, error) {
+	return lookup(i.addresses, &i.lock, normalizeAddress(address), "address")
+}
+
+func (i *nodeIndex) lookupHostname(hostname string) (string, error) {
+	return lookup(i.hostnames, &i.lock, normalizeHostname(hostname), "hostname")
+}
+
+func (i *nodeIndex) lookupSite(siteCode string) []string {
+	i.lock.RLock()
+	defer i.lock.RUnlock()
+	nodeIds := make([]string, 0, len(i.sites[siteCode]))
+	for nodeId := range i.sites[siteCode] {
+		nodeIds = append(nodeIds, nodeId)
+	}
+	sort.Strings(nodeIds)
+	return nodeIds
+}
+
+func (b *BoltStore) LookupMac(mac string) (string, error) {
+	return b.index.lookupMac(mac)
+}
+
+func (b *BoltStore) LookupAddress(address string) (string, error) {
+	return b.index.lookupAddress(address)
+}
+
+func (b *BoltStore) LookupHostname(hostname string) (string, error) {
+	return b.index.lookupHostname(hostname)
+}
+
+func (b *BoltStore) LookupSite(siteCode string) []string {
+	return b.index.lookupSite(siteCode)
+}
+
+func (s *SimpleInMemoryStore) LookupMac(mac string) (string, error) {
+	return s.index.lookupMac(mac)
+}
+
+func (s *SimpleInMemoryStore) LookupAddress(address string) (string, error) {
+	return s.index.lookupAddress(address)
+}
+
+func (s *SimpleInMemoryStore) LookupHostname(hostname string) (string, error) {
+	return s.index.lookupHostname(hostname)
+}
+
+func (s *SimpleInMemoryStore) LookupSite(siteCode string) []string {
+	return s.index.lookupSite(siteCode)
+}
@@ -0,0 +1,108 @@
+package data
+
+import (
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func indexedNodeinfo(nodeId, hostname, mac string) NodeInfo {
+	nodeinfo := NodeInfo{NodeId: nodeId, Hostname: hostname}
+	nodeinfo.Network.Mac = mac
+	nodeinfo.System.SiteCode = "ffdo"
+	return nodeinfo
+}
+
+func TestLookingUpNodes(t *testing.T) {
+	assert := assert.New(t)
+	dbPath := "./index.db"
+	defer os.RemoveAll(dbPath)
+	boltStore, err := NewBoltStore(dbPath)
+	assert.Nil(err)
+	defer boltStore.Close()
+
+	for _, store := range []Nodeinfostore{boltStore, NewSimpleInMemoryStore()} {
+		nodeinfo := indexedNodeinfo("a", "Node-A", "de:ad:be:ef:00:01")
+		nodeinfo.Network.Addresses = []string{"2a03:2260:0:0::1"}
+		nodeinfo.Network.Mesh.Bat0.Interfaces.Tunnel = []string{"DE:AD:BE:EF:00:02"}
+		store.PutNodeInfo(nodeinfo)
+		store.PutNodeInfo(indexedNodeinfo("b", "node-b", "de:ad:be:ef:00:03"))
+		store.PutNodeNeighbours(NeighbourStruct{NodeId: "b",
+			Batadv: map[string]BatadvNeighbours{"de:ad:be:ef:00:04": {}}})
+
+		for mac, expected := range map[string]string{"de:ad:be:ef:00:01": "a",
+			"de:ad:be:ef:00:02": "a", "DE:AD:BE:EF:00:03": "b", "de:ad:be:ef:00:04": "b"} {
+			nodeId, err := store.LookupMac(mac)
+			assert.Nil(err, mac)
+			assert.Equal(expected, nodeId, mac)
+		}
+		nodeId, err := store.LookupAddress("2a03:2260::1")
+		assert.Nil(err)
+		assert.Equal("a", nodeId)
+		nodeId, err = store.LookupHostname("node-a")
+		assert.Nil(err)
+		assert.Equal("a", nodeId)
+		assert.Equal([]string{"a", "b"}, store.LookupSite("ffdo"))
+
+		// Changed nodeinfos replace the old keys
+		store.PutNodeInfo(indexedNodeinfo("a", "renamed", "de:ad:be:ef:00:01"))
+		_, err = store.LookupHostname("node-a")
+		assert.NotNil(err)
+		_, err = store.LookupMac("de:ad:be:ef:00:02")
+		assert.NotNil(err)
+		_, err = store.LookupAddress("2a03:2260::1")
+		assert.NotNil(err)
+
+		store.DeleteNode("b")
+		_, err = store.LookupMac("de:ad:be:ef:00:04")
+		assert.NotNil(err)
+		assert.Equal([]string{"a"}, store.LookupSite("ffdo"))
+	}
+}
+
+func TestMovingMacsBetweenNodes(t *testing.T) {
+	assert := assert.New(t)
+	dbPath := "./index.db"
+	defer os.RemoveAll(dbPath)
+	boltStore, err := NewBoltStore(dbPath)
+	assert.Nil(err)
+	defer boltStore.Close()
+
+	for _, store := range []Nodeinfostore{boltStore, NewSimpleInMemoryStore()} {
+		mac := "de:ad:be:ef:00:05"
+		store.PutNodeInfo(indexedNodeinfo("a", "node-a", mac))
+		store.PutNodeNeighbours(NeighbourStruct{NodeId: "a",
+			Batadv: map[string]BatadvNeighbours{mac: {}}})
+		// The hardware of node a is now used by node b
+		store.PutNodeInfo(indexedNodeinfo("b", "node-b", mac))
+		store.PutNodeInfo(indexedNodeinfo("a", "node-a", "de:ad:be:ef:00:06"))
+		store.PutNodeNeighbours(NeighbourStruct{NodeId: "a",
+			Batadv: map[string]BatadvNeighbours{"de:ad:be:ef:00:06": {}}})
+		nodeId, err := store.LookupMac(mac)
+		assert.Nil(err)
+		assert.Equal("b", nodeId)
+
+		store.DeleteNode("a")
+		nodeId, err = store.LookupMac(mac)
+		assert.Nil(err)
+		assert.Equal("b", nodeId)
+	}
+}
+
+func TestRebuildingIndexOnOpen(t *testing.T) {
+	assert := assert.New(t)
+	dbPath := "./index.db"
+	defer os.RemoveAll(dbPath)
+	store, err := NewBoltStore(dbPath)
+	assert.Nil(err)
+	store.PutNodeInfo(indexedNodeinfo("a", "node-a", "de:ad:be:ef:00:01"))
+	store.Close()
+
+	store, err = NewBoltStore(dbPath)
+	assert.Nil(err)
+	defer store.Close()
+	nodeId, err := store.LookupMac("de:ad:be:ef:00:01")
+	assert.Nil(err)
+	assert.Equal("a", nodeId)
+}
@@ -1,147 +1,285 @@
 package data
 
 import (
+	"encoding/json"
 	"fmt"
-	"time"
-
-	conf "github.com/ffdo/node-informant/gluon-collector/config"
-	"github.com/muesli/cache2go"
+	"sync"
 )
 
 // SimpleInMemoryStore is a simple implementation of Nodeinfostore using maps
-// caches to store data in ram. This data store is not persistent.
+// to store data in ram. This data store is not persistent, but otherwise
+// behaves like the BoltStore. It is safe for concurrent use.
 type SimpleInMemoryStore struct {
-	Nodeinfos map[string]NodeInfo
-	//Statistics      map[string]*StatisticsStruct
-	statistics      *cache2go.CacheTable
-	StatusInfo      map[string]NodeStatusInfo
-	NodesJsonPath   string
-	CachedNodesJson string
-	GatewayList     map[string]bool
-	neighbourCache  *cache2go.CacheTable
-	//NeighbourInfos  map[string]*NeighbourStruct
-}
-
-// NewSimpleInMemoryStore creates a new SimpleInMemoryStore. Simple as that.
+	lock         sync.RWMutex
+	nodeinfos    map[string]NodeInfo
+	statistics   map[string]StatisticsStruct
+	statusInfo   map[string]NodeStatusInfo
+	neighbours   map[string]NeighbourStruct
+	gateways     map[string]bool
+	rawData      map[string]json.RawMessage
+	responseMeta map[string]ResponseMeta
+	index        *nodeIndex
+	events       *EventBus
+}
+
+// NewSimpleInMemoryStore creates a new SimpleInMemoryStore. Every store has
+// its own data, even if multiple stores are created.
 func NewSimpleInMemoryStore() *SimpleInMemoryStore {
 	return &SimpleInMemoryStore{
-		Nodeinfos: make(map[string]NodeInfo),
-		//Statistics: make(map[string]*StatisticsStruct),
-		statistics: cache2go.Cache("statistics"),
-		StatusInfo: make(map[string]NodeStatusInfo),
-		//NeighbourInfos: make(map[string]*NeighbourStruct),
-		neighbourCache: cache2go.Cache("neighbours"),
-		GatewayList:    make(map[string]bool),
+		nodeinfos:    make(map[string]NodeInfo),
+		statistics:   make(map[string]StatisticsStruct),
+		statusInfo:   make(map[string]NodeStatusInfo),
+		neighbours:   make(map[string]NeighbourStruct),
+		gateways:     make(map[string]bool),
+		rawData:      make(map[string]json.RawMessage),
+		responseMeta: make(map[string]ResponseMeta),
+		index:        newNodeIndex(),
+		events:       NewEventBus(DefaultEventBufferSize),
+	}
+}
+
+func (s *SimpleInMemoryStore) Events() *EventBus {
+	return s.events
+}
+
+// Close stops the delivery of the events published on the store.
+func (s *SimpleInMemoryStore) Close() error {
+	return s.events.Close()
+}
+
+// DeleteNode removes all data of the node.
+func (s *SimpleInMemoryStore) DeleteNode(nodeId string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if nodeinfo, exists := s.nodeinfos[nodeId]; exists {
+		delete(s.gateways, nodeinfo.Network.Mac)
+	}
+	delete(s.gateways, nodeId)
+	s.index.deleteNode(nodeId)
+	delete(s.nodeinfos, nodeId)
+	delete(s.statistics, nodeId)
+	delete(s.statusInfo, nodeId)
+	delete(s.neighbours, nodeId)
+	for _, responseType := range RawResponseTypes {
+		delete(s.rawData, nodeId+"/"+responseType)
+		delete(s.responseMeta, nodeId+"/"+responseType)
 	}
 }
 
-func (s *SimpleInMemoryStore) GetNodeStatusInfo(nodeId string) (status NodeStatusInfo, err error) {
-	status, exists := s.StatusInfo[nodeId]
+func (s *SimpleInMemoryStore) GetNodeStatusInfo(nodeId string) (NodeStatusInfo, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	status, exists := s.statusInfo[nodeId]
 	if !exists {
-		err = fmt.Errorf("NodeId %s has no status info", nodeId)
+		return status, fmt.Errorf("NodeId %s has no status info", nodeId)
 	}
-	return
+	return status, nil
 }
 
 func (s *SimpleInMemoryStore) GetNodeStatusInfos() []NodeStatusInfo {
-	list := make([]NodeStatusInfo, 0, len(s.StatusInfo))
-	for _, status := range s.StatusInfo {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	list := make([]NodeStatusInfo, 0, len(s.statusInfo))
+	for _, status := range s.statusInfo {
 		list = append(list, status)
 	}
 	return list
 }
 
 func (s *SimpleInMemoryStore) PutNodeStatusInfo(nodeId string, info NodeStatusInfo) {
-	s.StatusInfo[nodeId] = info
+	if info.NodeId == "" {
+		info.NodeId = nodeId
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.statusInfo[nodeId] = info
 }
 
-func (s *SimpleInMemoryStore) GetStatistics(nodeId string) (Statistics StatisticsStruct, err error) {
-	data, err := s.statistics.Value(nodeId)
-	if err != nil {
-		err = fmt.Errorf("NodeId %s has no Statistics", nodeId)
-		return
+func (s *SimpleInMemoryStore) UpdateNodeStatusInfo(nodeId string, change func(info *NodeStatusInfo) bool) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	info, exists := s.statusInfo[nodeId]
+	if !exists {
+		return fmt.Errorf("NodeId %s has no status info", nodeId)
+	}
+	if change(&info) {
+		s.statusInfo[nodeId] = info
+	}
+	return nil
+}
+
+func (s *SimpleInMemoryStore) GetStatistics(nodeId string) (StatisticsStruct, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	statistics, exists := s.statistics[nodeId]
+	if !exists {
+		return statistics, fmt.Errorf("NodeId %s has no Statistics", nodeId)
 	}
-	Statistics = *data.Data().(*StatisticsStruct)
-	return
+	return statistics, nil
 }
 
 func (s *SimpleInMemoryStore) GetAllStatistics() []StatisticsStruct {
-	list := make([]StatisticsStruct, 0, s.statistics.Count())
-	s.neighbourCache.Foreach(func(key interface{}, item *cache2go.CacheItem) {
-		list = append(list, *item.Data().(*StatisticsStruct))
-	})
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	list := make([]StatisticsStruct, 0, len(s.statistics))
+	for _, statistics := range s.statistics {
+		list = append(list, statistics)
+	}
 	return list
 }
 
 func (s *SimpleInMemoryStore) PutStatistics(statistics StatisticsStruct) {
-	s.statistics.Add(statistics.NodeId,
-		time.Second*time.Duration(conf.UInt("announced.interval.statistics", 300)*2),
-		&statistics)
-	//s.Statistics[statistics.NodeId] = &statistics
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.statistics[statistics.NodeId] = statistics
 }
 
-func (s *SimpleInMemoryStore) GetNodeNeighbours(nodeId string) (neighbours NeighbourStruct, err error) {
-	data, err := s.neighbourCache.Value(nodeId)
-	if err != nil {
-		err = fmt.Errorf("NodeId %s has no neighbour info", nodeId)
-		return
+func (s *SimpleInMemoryStore) GetNodeNeighbours(nodeId string) (NeighbourStruct, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	neighbours, exists := s.neighbours[nodeId]
+	if !exists {
+		return neighbours, fmt.Errorf("NodeId %s has no neighbour info", nodeId)
 	}
-	neighbours = *data.Data().(*NeighbourStruct)
-	return
+	return neighbours, nil
 }
 
 func (s *SimpleInMemoryStore) GetAllNeighbours() []NeighbourStruct {
-	list := make([]NeighbourStruct, 0, s.neighbourCache.Count())
-	s.neighbourCache.Foreach(func(key interface{}, item *cache2go.CacheItem) {
-		list = append(list, *item.Data().(*NeighbourStruct))
-	})
-	return list
-	/*for _, neighbour := range s.NeighbourInfos {
-		list = append(list, *neighbour)
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	list := make([]NeighbourStruct, 0, len(s.neighbours))
+	for _, neighbours := range s.neighbours {
+		list = append(list, neighbours)
 	}
-	return list*/
+	return list
 }
 
 func (s *SimpleInMemoryStore) PutNodeNeighbours(neighbours NeighbourStruct) {
-	s.neighbourCache.Add(neighbours.NodeId,
-		time.Second*time.Duration(conf.UInt("announced.interval.statistics", 300)*2),
-		&neighbours)
-	//s.NeighbourInfos[neighbours.NodeId] = &neighbours
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.index.putNeighbours(neighbours)
+	s.neighbours[neighbours.NodeId] = neighbours
 }
 
-func (s *SimpleInMemoryStore) GetNodeInfo(nodeId string) (info NodeInfo, err error) {
-	info, exists := s.Nodeinfos[nodeId]
+func (s *SimpleInMemoryStore) GetNodeInfo(nodeId string) (NodeInfo, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	info, exists := s.nodeinfos[nodeId]
 	if !exists {
-		err = fmt.Errorf("NodeId %s does not exist", nodeId)
+		return info, fmt.Errorf("NodeId %s does not exist", nodeId)
 	}
-	return
+	return info, nil
 }
 
 func (s *SimpleInMemoryStore) PutNodeInfo(nodeInfo NodeInfo) {
-	s.Nodeinfos[nodeInfo.NodeId] = nodeInfo
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.index.putNodeInfo(nodeInfo)
+	s.nodeinfos[nodeInfo.NodeId] = nodeInfo
 }
 
 func (s *SimpleInMemoryStore) GetNodeInfos() []NodeInfo {
-	list := make([]NodeInfo, 0, len(s.Nodeinfos))
-	for _, nodeinfo := range s.Nodeinfos {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	list := make([]NodeInfo, 0, len(s.nodeinfos))
+	for _, nodeinfo := range s.nodeinfos {
 		list = append(list, nodeinfo)
 	}
 	return list
 }
 
 func (s *SimpleInMemoryStore) PutGateway(mac string) {
-	s.GatewayList[mac] = true
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.gateways[mac] = true
 }
 
 func (s *SimpleInMemoryStore) IsGateway(mac string) bool {
-	isGateway, exists := s.GatewayList[mac]
-	return exists && isGateway
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	return s.gateways[mac]
 }
 
 func (s *SimpleInMemoryStore) RemoveGateway(mac string) {
-	delete(s.GatewayList, mac)
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	delete(s.gateways, mac)
+}
+
+func (s *SimpleInMemoryStore) GetGateways() []string {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	list := make([]string, 0, len(s.gateways))
+	for mac := range s.gateways {
+		list = append(list, mac)
+	}
+	return list
+}
+
+func (s *SimpleInMemoryStore) PutRawData(nodeId, responseType string, raw json.RawMessage) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.rawData[nodeId+"/"+responseType] = raw
+}
+
+func (s *SimpleInMemoryStore) GetRawData(nodeId, responseType string) (json.RawMessage, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	raw, exists := s.rawData[nodeId+"/"+responseType]
+	if !exists {
+		return nil, fmt.Errorf("No raw %s data for node id %s", responseType, nodeId)
+	}
+	return raw, nil
+}
+
+func (s *SimpleInMemoryStore) PutResponseMeta(nodeId, responseType string, meta ResponseMeta) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.responseMeta[nodeId+"/"+responseType] = meta
+}
+
+func (s *SimpleInMemoryStore) GetResponseMeta(nodeId, responseType string) (ResponseMeta, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	meta, exists := s.responseMeta[nodeId+"/"+responseType]
+	if !exists {
+		return ResponseMeta{}, fmt.Errorf("No %s response meta data for node id %s", responseType, nodeId)
+	}
+	return meta, nil
+}
+
+func (s *SimpleInMemoryStore) PutNodeInfos(nodeinfos []NodeInfo) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for _, nodeinfo := range nodeinfos {
+		s.index.putNodeInfo(nodeinfo)
+		s.nodeinfos[nodeinfo.NodeId] = nodeinfo
+	}
+}
+
+func (s *SimpleInMemoryStore) PutAllStatistics(statistics []StatisticsStruct) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for _, stats := range statistics {
+		s.statistics[stats.NodeId] = stats
+	}
+}
+
+func (s *SimpleInMemoryStore) PutNodeStatusInfos(infos []NodeStatusInfo) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for _, info := range infos {
+		if info.NodeId != "" {
+			s.statusInfo[info.NodeId] = info
+		}
+	}
 }
 
-func (s *SimpleInMemoryStore) NotifyNodeOffline(handler func(string)) {
-	// TODO not implemented yet.
+func (s *SimpleInMemoryStore) PutAllNeighbours(neighbours []NeighbourStruct) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for _, n := range neighbours {
+		s.index.putNeighbours(n)
+		s.neighbours[n.NodeId] = n
+	}
 }
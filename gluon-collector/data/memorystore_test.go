@@ -0,0 +1,96 @@
+package data
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMemoryStoresAreIndependent(t *testing.T) {
+	assert := assert.New(t)
+	first := NewSimpleInMemoryStore()
+	second := NewSimpleInMemoryStore()
+
+	first.PutStatistics(StatisticsStruct{NodeId: "a"})
+	first.PutNodeNeighbours(NeighbourStruct{NodeId: "a"})
+	_, err := second.GetStatistics("a")
+	assert.NotNil(err)
+	assert.Equal(1, len(first.GetAllStatistics()))
+	assert.Equal("a", first.GetAllStatistics()[0].NodeId)
+	assert.Equal(0, len(second.GetAllNeighbours()))
+}
+
+func TestStoresDeleteNodes(t *testing.T) {
+	assert := assert.New(t)
+	dbPath := "./delete.db"
+	defer os.RemoveAll(dbPath)
+	boltStore, err := NewBoltStore(dbPath)
+	assert.Nil(err)
+	defer boltStore.Close()
+	for _, store := range []Nodeinfostore{boltStore, NewSimpleInMemoryStore()} {
+		nodeinfo := NodeInfo{NodeId: "a"}
+		nodeinfo.Network.Mac = "de:ad:be:ef:00:01"
+		store.PutNodeInfo(nodeinfo)
+		store.PutGateway("de:ad:be:ef:00:01")
+		store.PutStatistics(StatisticsStruct{NodeId: "a"})
+		store.PutResponseMeta("a", "statistics", ResponseMeta{Received: time.Now()})
+		store.PutNodeStatusInfo("a", NodeStatusInfo{Lastseen: "2000-01-01T00:00:00Z"})
+		store.PutNodeStatusInfo("b", NodeStatusInfo{Lastseen: time.Now().Format(TimeFormat)})
+
+		store.DeleteNode("a")
+		_, err := store.GetNodeInfo("a")
+		assert.NotNil(err)
+		_, err = store.GetStatistics("a")
+		assert.NotNil(err)
+		_, err = store.GetResponseMeta("a", "statistics")
+		assert.NotNil(err)
+		assert.False(store.IsGateway("de:ad:be:ef:00:01"))
+		assert.Equal(1, len(store.GetNodeStatusInfos()))
+	}
+}
+
+func TestConcurrentMemoryStoreAccess(t *testing.T) {
+	store := NewSimpleInMemoryStore()
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			nodeId := fmt.Sprintf("node%d", i)
+			for j := 0; j < 100; j++ {
+				store.PutNodeInfo(NodeInfo{NodeId: nodeId})
+				store.PutNodeStatusInfo(nodeId, NodeStatusInfo{Online: true, Lastseen: time.Now().Format(TimeFormat)})
+				store.GetNodeInfos()
+				store.GetNodeStatusInfos()
+			}
+		}(i)
+	}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for j := 0; j < 10; j++ {
+			store.DeleteNode(fmt.Sprintf("node%d", j))
+		}
+	}()
+	wg.Wait()
+}
+
+func TestStoresKnowGateways(t *testing.T) {
+	assert := assert.New(t)
+	dbPath := "./gateways.db"
+	defer os.RemoveAll(dbPath)
+	store, err := NewBoltStore(dbPath)
+	assert.Nil(err)
+	defer store.Close()
+	for _, s := range []Nodeinfostore{store, NewSimpleInMemoryStore()} {
+		s.PutGateway("de:ad:be:ef:00:01")
+		assert.True(s.IsGateway("de:ad:be:ef:00:01"))
+		assert.False(s.IsGateway("de:ad:be:ef:00:02"))
+		s.RemoveGateway("de:ad:be:ef:00:01")
+		assert.False(s.IsGateway("de:ad:be:ef:00:01"))
+	}
+}
@@ -0,0 +1,209 @@
+package data
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/boltdb/bolt"
+)
+
+const (
+	// MetadataBucket contains information about the database itself like the
+	// schema version.
+	MetadataBucket string = "metadata"
+	// SchemaVersionKey is the key of the schema version in the MetadataBucket.
+	SchemaVersionKey string = "schema_version"
+)
+
+// Migration converts the data of a database from the previous schema version to
+// its version. Migrate returns the number of changed records.
+type Migration struct {
+	Version     int
+	Description string
+	Migrate     func(tx *bolt.Tx) (int, error)
+}
+
+// MigrationResult reports what a migration did or would have done in a dry run.
+type MigrationResult struct {
+	Version     int
+	Description string
+	Changed     int
+}
+
+// Migrations are all known migrations ordered by version. New migrations need
+// to be appended with the next version.
+var Migrations = []Migration{
+	Migration{Version: 1, Description: "Normalize all stored times to RFC3339", Migrate: normalizeTimes},
+}
+
+// errDryRun is returned from the transaction of a dry run to roll it back.
+var errDryRun = errors.New("Dry run")
+
+// CurrentSchemaVersion is the schema version of databases after all migrations
+// have been run.
+func CurrentSchemaVersion() int {
+	if len(Migrations) == 0 {
+		return 0
+	}
+	return Migrations[len(Migrations)-1].Version
+}
+
+// schemaVersion reads the schema version of the database. Databases created
+// before versioning was introduced have the version 0.
+func schemaVersion(tx *bolt.Tx) (int, error) {
+	bucket := tx.Bucket([]byte(MetadataBucket))
+	if bucket == nil {
+		return 0, nil
+	}
+	value := bucket.Get([]byte(SchemaVersionKey))
+	if value == nil {
+		return 0, nil
+	}
+	version := 0
+	err := json.Unmarshal(value, &version)
+	return version, err
+}
+
+func putSchemaVersion(tx *bolt.Tx, version int) error {
+	bucket, err := tx.CreateBucketIfNotExists([]byte(MetadataBucket))
+	if err != nil {
+		return err
+	}
+	value, err := json.Marshal(version)
+	if err != nil {
+		return err
+	}
+	return bucket.Put([]byte(SchemaVersionKey), value)
+}
+
+// migrate runs all migrations the database hasn't seen yet in a single
+// transaction, so either all or none of them are applied.
+func migrate(db *bolt.DB, dryRun bool) ([]MigrationResult, error) {
+	results := make([]MigrationResult, 0, len(Migrations))
+	err := db.Update(func(tx *bolt.Tx) error {
+		version, err := schemaVersion(tx)
+		if err != nil {
+			return err
+		}
+		if version > CurrentSchemaVersion() {
+			return fmt.Errorf("The database has schema version %d, but only version %d is known",
+				version, CurrentSchemaVersion())
+		}
+		for _, migration := range Migrations {
+			if migration.Version <= version {
+				continue
+			}
+			changed, err := migration.Migrate(tx)
+			if err != nil {
+				return fmt.Errorf("Migration to version %d failed: %v", migration.Version, err)
+			}
+			results = append(results, MigrationResult{
+				Version:     migration.Version,
+				Description: migration.Description,
+				Changed:     changed,
+			})
+			log.WithFields(log.Fields{
+				"version": migration.Version,
+				"changed": changed,
+				"dryRun":  dryRun,
+			}).Info(migration.Description)
+			if err := putSchemaVersion(tx, migration.Version); err != nil {
+				return err
+			}
+		}
+		if dryRun {
+			return errDryRun
+		}
+		return nil
+	})
+	if err == errDryRun {
+		err = nil
+	}
+	return results, err
+}
+
+// MigrateBoltDatabase runs all pending migrations on the bolt database at the
+// given path. In a dry run the changes are rolled back, the results tell what
+// would have been changed.
+func MigrateBoltDatabase(path string, dryRun bool) ([]MigrationResult, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+	return migrate(db, dryRun)
+}
+
+// NormalizeTime converts a time in RFC3339 or in the legacy format of
+// ffmap-backend to RFC3339 in UTC. Empty times stay empty.
+func NormalizeTime(value string) (string, error) {
+	if value == "" {
+		return value, nil
+	}
+	t, err := time.Parse(TimeFormat, value)
+	if err != nil {
+		t, err = time.Parse(LegacyTimeFormat, value)
+		if err != nil {
+			return value, err
+		}
+	}
+	return t.UTC().Format(TimeFormat), nil
+}
+
+// normalizeTimes converts the first and last seen times of all nodes to RFC3339,
+// since data imported from ffmap-backend uses its own format.
+func normalizeTimes(tx *bolt.Tx) (int, error) {
+	bucket := tx.Bucket([]byte(StatusInfoBucket))
+	if bucket == nil {
+		return 0, nil
+	}
+	updates := make(map[string][]byte)
+	err := bucket.ForEach(func(k, v []byte) error {
+		status := NodeStatusInfo{}
+		if err := json.Unmarshal(v, &status); err != nil {
+			log.WithFields(log.Fields{
+				"error":      err,
+				"nodeId":     string(k),
+				"jsonString": string(v),
+			}).Error("Can't unmarshall json from node status info, leaving it untouched")
+			return nil
+		}
+		firstseen, err := NormalizeTime(status.Firstseen)
+		if err != nil {
+			log.WithFields(log.Fields{
+				"error":  err,
+				"nodeId": string(k),
+			}).Error("Can't parse firstseen time, leaving it untouched")
+		}
+		lastseen, err := NormalizeTime(status.Lastseen)
+		if err != nil {
+			log.WithFields(log.Fields{
+				"error":  err,
+				"nodeId": string(k),
+			}).Error("Can't parse lastseen time, leaving it untouched")
+		}
+		if firstseen == status.Firstseen && lastseen == status.Lastseen {
+			return nil
+		}
+		status.Firstseen, status.Lastseen = firstseen, lastseen
+		data, err := json.Marshal(status)
+		if err != nil {
+			return err
+		}
+		updates[string(k)] = data
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	// Bolt doesn't allow modifications while iterating with ForEach
+	for key, data := range updates {
+		if err := bucket.Put([]byte(key), data); err != nil {
+			return 0, err
+		}
+	}
+	return len(updates), nil
+}
@@ -0,0 +1,74 @@
+package data
+
+import (
+	"encoding/json"
+	"os"
+	"testing"
+
+	"github.com/boltdb/bolt"
+	"github.com/stretchr/testify/assert"
+)
+
+// createLegacyDatabase creates a database without schema version containing a
+// status info with times in the format of ffmap-backend.
+func createLegacyDatabase(path string) error {
+	db, err := bolt.Open(path, 0600, nil)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	return db.Update(func(tx *bolt.Tx) error {
+		bucket, err := tx.CreateBucketIfNotExists([]byte(StatusInfoBucket))
+		if err != nil {
+			return err
+		}
+		status, _ := json.Marshal(NodeStatusInfo{
+			NodeId:    "a",
+			Firstseen: "2016-01-02T03:04:05",
+			Lastseen:  "2016-03-01T12:00:00+01:00",
+		})
+		return bucket.Put([]byte("a"), status)
+	})
+}
+
+func TestMigratingDatabase(t *testing.T) {
+	assert := assert.New(t)
+	dbPath := "./migrate.db"
+	defer os.RemoveAll(dbPath)
+	assert.Nil(createLegacyDatabase(dbPath))
+
+	results, err := MigrateBoltDatabase(dbPath, true)
+	assert.Nil(err)
+	assert.Equal(1, len(results))
+	assert.Equal(1, results[0].Changed)
+	results, err = MigrateBoltDatabase(dbPath, true)
+	assert.Nil(err)
+	assert.Equal(1, len(results), "A dry run changes nothing")
+
+	store, err := NewBoltStore(dbPath)
+	assert.Nil(err)
+	status, err := store.GetNodeStatusInfo("a")
+	assert.Nil(err)
+	assert.Equal("2016-01-02T03:04:05Z", status.Firstseen)
+	assert.Equal("2016-03-01T11:00:00Z", status.Lastseen)
+	store.Close()
+
+	results, err = MigrateBoltDatabase(dbPath, false)
+	assert.Nil(err)
+	assert.Equal(0, len(results), "Migrations run on open")
+}
+
+func TestRefusingNewerDatabase(t *testing.T) {
+	assert := assert.New(t)
+	dbPath := "./newer.db"
+	defer os.RemoveAll(dbPath)
+	db, err := bolt.Open(dbPath, 0600, nil)
+	assert.Nil(err)
+	assert.Nil(db.Update(func(tx *bolt.Tx) error {
+		return putSchemaVersion(tx, CurrentSchemaVersion()+1)
+	}))
+	db.Close()
+
+	_, err = NewBoltStore(dbPath)
+	assert.NotNil(err)
+}
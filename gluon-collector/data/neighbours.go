@@ -1,5 +1,11 @@
 package data
 
+import (
+	"bytes"
+	"encoding/json"
+	"math"
+)
+
 /*
 {
   "neighbours": {
@@ -45,7 +51,7 @@ package data
 
 type WifiLink struct {
 	Inactive int `json:"inactive"`
-	Noise    int `json:"nois"`
+	Noise    int `json:"noise"`
 	Signal   int `json:"signal"`
 }
 
@@ -58,12 +64,172 @@ type BatadvNeighbours struct {
 	Neighbours map[string]BatmanLink `json:"neighbours"`
 }
 
+// WifiLinks maps the macs of wifi neighbours to the link information.
+type WifiLinks map[string]WifiLink
+
 type WifiNeighbours struct {
-	Neighbours map[string]WifiLink `json:"neighbours"`
+	Neighbours WifiLinks `json:"neighbours"`
+}
+
+// isEmptyArray checks whether the json document is an array. Gluon encodes empty
+// objects as empty arrays, since lua doesn't know the difference.
+func isEmptyArray(data []byte) bool {
+	return bytes.HasPrefix(bytes.TrimSpace(data), []byte("["))
+}
+
+// UnmarshalJSON accepts empty arrays for nodes without wifi neighbours.
+func (w *WifiLinks) UnmarshalJSON(data []byte) error {
+	*w = make(WifiLinks)
+	if isEmptyArray(data) {
+		return nil
+	}
+	return json.Unmarshal(data, (*map[string]WifiLink)(w))
+}
+
+// wifiNeighbours is WifiNeighbours without the custom UnmarshalJSON method.
+type wifiNeighbours WifiNeighbours
+
+// UnmarshalJSON accepts empty arrays for wifi interfaces without neighbours.
+func (w *WifiNeighbours) UnmarshalJSON(data []byte) error {
+	if isEmptyArray(data) {
+		w.Neighbours = make(WifiLinks)
+		return nil
+	}
+	return json.Unmarshal(data, (*wifiNeighbours)(w))
+}
+
+// BabelLink is a link to a babel neighbour as reported by gluon-mesh-babel.
+type BabelLink struct {
+	Cost         int `json:"cost"`
+	RxCost       int `json:"rxcost"`
+	TxCost       int `json:"txcost"`
+	Reachability int `json:"reachability"`
+}
+
+// BabelLinks maps the link local addresses of babel neighbours to the link
+// information.
+type BabelLinks map[string]BabelLink
+
+// UnmarshalJSON accepts empty arrays for interfaces without babel neighbours.
+func (b *BabelLinks) UnmarshalJSON(data []byte) error {
+	*b = make(BabelLinks)
+	if isEmptyArray(data) {
+		return nil
+	}
+	return json.Unmarshal(data, (*map[string]BabelLink)(b))
+}
+
+// BabelNeighbours contains the babel neighbours seen on a single interface and
+// the link local address of the interface itself.
+type BabelNeighbours struct {
+	Protocol         string     `json:"protocol"`
+	LinkLocalAddress string     `json:"ll-addr"`
+	Neighbours       BabelLinks `json:"neighbours"`
 }
 
 type NeighbourStruct struct {
 	Batadv map[string]BatadvNeighbours `json:"batadv"`
-	//WifiNeighbours map[string]WifiNeighbours   `json:"wifi"`
-	NodeId string `json:"node_id"`
+	Babel  map[string]BabelNeighbours  `json:"babel,omitempty"`
+	Wifi   map[string]WifiNeighbours   `json:"wifi,omitempty"`
+	NodeId string                      `json:"node_id"`
+}
+
+// GetWifiLink returns the wifi link information the node has about the link from
+// its interface with ownMac to the neighbour with peerMac.
+func (n NeighbourStruct) GetWifiLink(ownMac, peerMac string) (WifiLink, bool) {
+	neighbours, exists := n.Wifi[ownMac]
+	if !exists {
+		return WifiLink{}, false
+	}
+	link, exists := neighbours.Neighbours[peerMac]
+	return link, exists
+}
+
+const (
+	ProtocolBatadv string = "batadv"
+	ProtocolBabel  string = "babel"
+
+	// babelBestCost is the cost babel assigns to perfect wireless links. Wired
+	// links have even smaller costs.
+	babelBestCost float64 = 256
+)
+
+// NeighbourLink is a link to a mesh neighbour independent of the routing
+// protocol. For batman the addresses are the macs of the batman interfaces, for
+// babel the link local addresses of the interfaces.
+type NeighbourLink struct {
+	Protocol    string `json:"protocol"`
+	Interface   string `json:"interface"`
+	Address     string `json:"address"`
+	PeerAddress string `json:"peer_address"`
+	// Cost is the routing metric of the protocol, the tq for batman and the
+	// cost for babel.
+	Cost float64 `json:"cost"`
+	// Quality is the link quality between 0 and 1 comparable between the
+	// protocols.
+	Quality float64 `json:"quality"`
+}
+
+func babelQuality(cost int) float64 {
+	if cost <= 0 {
+		return 0
+	}
+	return math.Min(1.0, babelBestCost/float64(cost))
+}
+
+// Links returns the links to all mesh neighbours of all routing protocols.
+func (n NeighbourStruct) Links() []NeighbourLink {
+	links := make([]NeighbourLink, 0, len(n.Batadv)+len(n.Babel))
+	for ownMac, batInfo := range n.Batadv {
+		for peerMac, link := range batInfo.Neighbours {
+			links = append(links, NeighbourLink{
+				Protocol:    ProtocolBatadv,
+				Interface:   ownMac,
+				Address:     ownMac,
+				PeerAddress: peerMac,
+				Cost:        float64(link.Tq),
+				Quality:     float64(link.Tq) / 255.0,
+			})
+		}
+	}
+	for iface, babelInfo := range n.Babel {
+		for peerAddress, link := range babelInfo.Neighbours {
+			links = append(links, NeighbourLink{
+				Protocol:    ProtocolBabel,
+				Interface:   iface,
+				Address:     babelInfo.LinkLocalAddress,
+				PeerAddress: peerAddress,
+				Cost:        float64(link.Cost),
+				Quality:     babelQuality(link.Cost),
+			})
+		}
+	}
+	return links
+}
+
+// Addresses returns the addresses of the node itself on all interfaces of all
+// routing protocols. These are the peer addresses other nodes use in their links
+// to this node.
+func (n NeighbourStruct) Addresses() []string {
+	addresses := make([]string, 0, len(n.Batadv)+len(n.Babel))
+	for ownMac := range n.Batadv {
+		addresses = append(addresses, ownMac)
+	}
+	for _, babelInfo := range n.Babel {
+		if babelInfo.LinkLocalAddress != "" {
+			addresses = append(addresses, babelInfo.LinkLocalAddress)
+		}
+	}
+	return addresses
+}
+
+// GetLink returns the link from the own address to the peer address of the
+// given protocol.
+func (n NeighbourStruct) GetLink(protocol, address, peerAddress string) (NeighbourLink, bool) {
+	for _, link := range n.Links() {
+		if link.Protocol == protocol && link.Address == address && link.PeerAddress == peerAddress {
+			return link, true
+		}
+	}
+	return NeighbourLink{}, false
 }
@@ -0,0 +1,74 @@
+package data
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var exampleNeighbours = `{"neighbours":{"wifi":{"ea:e1:29:65:a0:e1":{"neighbours":[]},
+	"ea:e1:2a:65:a0:e1":[],
+	"ea:e1:28:65:a0:e1":{"neighbours":{"c6:71:20:ff:03:57":{"noise":-95,"inactive":10,"signal":-84}}}},
+	"batadv":{"ea:e1:28:65:a0:e1":{"neighbours":{"c6:71:20:ff:03:57":{"lastseen":1.13,"tq":255}}}},
+	"node_id":"e8de2765a0e1"}}`
+
+func TestParsingWifiNeighbours(t *testing.T) {
+	assert := assert.New(t)
+	response := &RespondNodeinfo{}
+	err := json.Unmarshal([]byte(exampleNeighbours), response)
+	assert.Nil(err)
+	assert.NotNil(response.Neighbours)
+	assert.Equal(3, len(response.Neighbours.Wifi))
+
+	link, exists := response.Neighbours.GetWifiLink("ea:e1:28:65:a0:e1", "c6:71:20:ff:03:57")
+	assert.True(exists)
+	assert.Equal(-84, link.Signal)
+	assert.Equal(-95, link.Noise)
+	assert.Equal(10, link.Inactive)
+
+	_, exists = response.Neighbours.GetWifiLink("ea:e1:29:65:a0:e1", "c6:71:20:ff:03:57")
+	assert.False(exists)
+	_, exists = response.Neighbours.GetWifiLink("unknown", "c6:71:20:ff:03:57")
+	assert.False(exists)
+}
+
+var exampleBabelNeighbours = `{"neighbours":{"babel":{
+	"mesh-vpn":{"protocol":"babel","ll-addr":"fe80::1","neighbours":{"fe80::2":{"cost":96,"rxcost":96,"txcost":96,"reachability":65535}}},
+	"mesh0":{"protocol":"babel","ll-addr":"fe80::3","neighbours":[]},
+	"mesh1":{"protocol":"babel","ll-addr":"fe80::4","neighbours":{"fe80::5":{"cost":512,"rxcost":256,"txcost":256,"reachability":65280}}}},
+	"node_id":"e8de2765a0e1"}}`
+
+func TestParsingBabelNeighbours(t *testing.T) {
+	assert := assert.New(t)
+	response := &RespondNodeinfo{}
+	err := json.Unmarshal([]byte(exampleBabelNeighbours), response)
+	assert.Nil(err)
+	assert.Equal(3, len(response.Neighbours.Babel))
+	assert.Equal("fe80::1", response.Neighbours.Babel["mesh-vpn"].LinkLocalAddress)
+	assert.Equal(3, len(response.Neighbours.Addresses()))
+
+	links := response.Neighbours.Links()
+	assert.Equal(2, len(links))
+	link, exists := response.Neighbours.GetLink(ProtocolBabel, "fe80::1", "fe80::2")
+	assert.True(exists)
+	assert.Equal("mesh-vpn", link.Interface)
+	assert.Equal(96.0, link.Cost)
+	assert.Equal(1.0, link.Quality)
+	link, exists = response.Neighbours.GetLink(ProtocolBabel, "fe80::4", "fe80::5")
+	assert.True(exists)
+	assert.Equal(0.5, link.Quality)
+}
+
+func TestBatmanLinks(t *testing.T) {
+	assert := assert.New(t)
+	response := &RespondNodeinfo{}
+	err := json.Unmarshal([]byte(exampleNeighbours), response)
+	assert.Nil(err)
+	links := response.Neighbours.Links()
+	assert.Equal(1, len(links))
+	assert.Equal(ProtocolBatadv, links[0].Protocol)
+	assert.Equal("ea:e1:28:65:a0:e1", links[0].Address)
+	assert.Equal("c6:71:20:ff:03:57", links[0].PeerAddress)
+	assert.Equal(1.0, links[0].Quality)
+}
@@ -1,5 +1,7 @@
 package data
 
+import "encoding/json"
+
 type NetworkStruct struct {
 	Mac       string   `json:"mac"`
 	Addresses []string `json:"addresses"`
@@ -21,6 +23,8 @@ type OwnerStruct struct {
 
 type SystemStruct struct {
 	SiteCode string `json:"site_code"`
+	// DomainCode is announced by nodes of multi domain sites.
+	DomainCode string `json:"domain_code,omitempty"`
 }
 
 type LocationStruct struct {
@@ -67,8 +71,31 @@ type NodeInfo struct {
 	Hardware HardwareStruct  `json:"hardware"`
 }
 
+// Domain returns the mesh domain the node announces, which is its domain code
+// or its site code for sites with a single domain.
+func (n NodeInfo) Domain() string {
+	if n.System.DomainCode != "" {
+		return n.System.DomainCode
+	}
+	return n.System.SiteCode
+}
+
+// Macs returns the primary mac and the macs of all mesh interfaces announced
+// by the node in lower case.
+func (n NodeInfo) Macs() []string {
+	return nodeinfoMacs(n)
+}
+
 type RespondNodeinfo struct {
 	Nodeinfo   *NodeInfo         `json:"nodeinfo"`
 	Statistics *StatisticsStruct `json:"statistics"`
 	Neighbours *NeighbourStruct  `json:"neighbours"`
 }
+
+// RawRespondNodeinfo holds the unparsed json documents of a response, so we can
+// keep all fields, even the ones not modelled by our structs.
+type RawRespondNodeinfo struct {
+	Nodeinfo   json.RawMessage `json:"nodeinfo"`
+	Statistics json.RawMessage `json:"statistics"`
+	Neighbours json.RawMessage `json:"neighbours"`
+}
@@ -123,3 +123,11 @@ func TestParsingNodeStatistics(t *testing.T) {
 	assert.Equal("02:ce:ef:ca:fe:2a", nodeinfo.Statistics.Gateway)
 	assert.Equal(uint64(5531082), nodeinfo.Statistics.Traffic.Tx.Packets)
 }
+
+func TestNodeinfoDomain(t *testing.T) {
+	assert := assert.New(t)
+	nodeinfo := NodeInfo{System: SystemStruct{SiteCode: "ffdo"}}
+	assert.Equal("ffdo", nodeinfo.Domain(), "Single domain sites use the site code")
+	nodeinfo.System.DomainCode = "dom1"
+	assert.Equal("dom1", nodeinfo.Domain())
+}
@@ -0,0 +1,333 @@
+package data
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+
+	"github.com/ffdo/node-informant/gluon-collector/scheduler"
+)
+
+// Override is the patch an operator configured for a node, in the spirit of
+// the alias.json of ffmap-backend. All fields are merged into the nodeinfo of
+// the node as json merge patch, so a null value removes a field. Two fields are
+// special: hidden removes the node from all outputs and status is merged into
+// the NodeStatusInfo of the node.
+type Override map[string]interface{}
+
+// Hidden checks whether the node is hidden from all outputs.
+func (o Override) Hidden() bool {
+	hidden, _ := o["hidden"].(bool)
+	return hidden
+}
+
+func (o Override) nodeinfoPatch() map[string]interface{} {
+	patch := make(map[string]interface{}, len(o))
+	for key, value := range o {
+		if key != "hidden" && key != "status" {
+			patch[key] = value
+		}
+	}
+	return patch
+}
+
+func (o Override) statusPatch() map[string]interface{} {
+	patch, _ := o["status"].(map[string]interface{})
+	return patch
+}
+
+// mergePatch merges the patch into the target as described by RFC 7386.
+func mergePatch(target interface{}, patch map[string]interface{}) map[string]interface{} {
+	result, ok := target.(map[string]interface{})
+	if !ok {
+		result = make(map[string]interface{})
+	}
+	for key, value := range patch {
+		if value == nil {
+			delete(result, key)
+		} else if valuePatch, ok := value.(map[string]interface{}); ok {
+			result[key] = mergePatch(result[key], valuePatch)
+		} else {
+			result[key] = value
+		}
+	}
+	return result
+}
+
+// applyPatch merges the patch into the json encoding of in and decodes the
+// result into out.
+func applyPatch(in interface{}, patch map[string]interface{}, out interface{}) error {
+	encoded, err := json.Marshal(in)
+	if err != nil {
+		return err
+	}
+	var document interface{}
+	if err := json.Unmarshal(encoded, &document); err != nil {
+		return err
+	}
+	encoded, err = json.Marshal(mergePatch(document, patch))
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(encoded, out)
+}
+
+// Overrides holds the overrides of all nodes read from a json file which maps
+// node ids to their Override. The file is read again whenever it changes.
+type Overrides struct {
+	Path string
+
+	lock      sync.RWMutex
+	overrides map[string]Override
+	modTime   time.Time
+	watchJob  *scheduler.ScheduledJob
+	// hideHandlers are called for every node which becomes hidden.
+	hideHandlers []func(nodeId string)
+}
+
+// NewOverrides reads the overrides from the file at path. A missing file is
+// treated as empty and created on the first change via the API.
+func NewOverrides(path string) (*Overrides, error) {
+	overrides := &Overrides{Path: path, overrides: make(map[string]Override)}
+	return overrides, overrides.Load()
+}
+
+// Load reads the overrides file, replacing all current overrides.
+func (o *Overrides) Load() error {
+	info, err := os.Stat(o.Path)
+	if os.IsNotExist(err) {
+		o.lock.Lock()
+		o.overrides = make(map[string]Override)
+		o.modTime = time.Time{}
+		o.lock.Unlock()
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	content, err := ioutil.ReadFile(o.Path)
+	if err != nil {
+		return err
+	}
+	overrides := make(map[string]Override)
+	if err := json.Unmarshal(content, &overrides); err != nil {
+		return fmt.Errorf("Invalid overrides file %s: %v", o.Path, err)
+	}
+	o.lock.Lock()
+	previous := o.overrides
+	o.overrides = overrides
+	o.modTime = info.ModTime()
+	o.lock.Unlock()
+	o.notifyHidden(previous, overrides)
+	return nil
+}
+
+// Reload reads the overrides file again if it has been modified since it was
+// read last. If the file is invalid the current overrides are kept.
+func (o *Overrides) Reload() {
+	info, err := os.Stat(o.Path)
+	o.lock.RLock()
+	modTime := o.modTime
+	o.lock.RUnlock()
+	if err == nil && info.ModTime().Equal(modTime) || os.IsNotExist(err) && modTime.IsZero() {
+		return
+	}
+	if err := o.Load(); err != nil {
+		log.WithFields(log.Fields{
+			"error": err,
+			"path":  o.Path,
+		}).Error("Can't reload overrides, keeping the current ones")
+		return
+	}
+	log.WithFields(log.Fields{
+		"path":      o.Path,
+		"overrides": len(o.All()),
+	}).Info("Reloaded overrides")
+}
+
+// Watch checks the overrides file for changes in the given interval.
+func (o *Overrides) Watch(interval time.Duration) {
+	o.watchJob = scheduler.NewJob(interval, o.Reload, false)
+}
+
+// Close stops watching the overrides file.
+func (o *Overrides) Close() error {
+	if o.watchJob != nil {
+		o.watchJob.Stop()
+	}
+	return nil
+}
+
+// Get returns the override of the node.
+func (o *Overrides) Get(nodeId string) (Override, bool) {
+	if o == nil {
+		return nil, false
+	}
+	o.lock.RLock()
+	defer o.lock.RUnlock()
+	override, exists := o.overrides[nodeId]
+	return override, exists
+}
+
+// All returns the overrides of all nodes.
+func (o *Overrides) All() map[string]Override {
+	o.lock.RLock()
+	defer o.lock.RUnlock()
+	all := make(map[string]Override, len(o.overrides))
+	for nodeId, override := range o.overrides {
+		all[nodeId] = override
+	}
+	return all
+}
+
+// Put replaces the override of the node and writes the overrides file.
+func (o *Overrides) Put(nodeId string, override Override) error {
+	return o.update(func(overrides map[string]Override) {
+		overrides[nodeId] = override
+	})
+}
+
+// Delete removes the override of the node and writes the overrides file.
+func (o *Overrides) Delete(nodeId string) error {
+	return o.update(func(overrides map[string]Override) {
+		delete(overrides, nodeId)
+	})
+}
+
+// OnHide registers a handler which is called with the node id whenever an
+// override hides a node which wasn't hidden before.
+func (o *Overrides) OnHide(handler func(nodeId string)) {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+	o.hideHandlers = append(o.hideHandlers, handler)
+}
+
+// notifyHidden calls the hide handlers for the nodes hidden by the current
+// but not by the previous overrides. The lock must not be held by the caller.
+func (o *Overrides) notifyHidden(previous, current map[string]Override) {
+	o.lock.RLock()
+	handlers := o.hideHandlers
+	o.lock.RUnlock()
+	for nodeId, override := range current {
+		if !override.Hidden() || previous[nodeId].Hidden() {
+			continue
+		}
+		for _, handler := range handlers {
+			handler(nodeId)
+		}
+	}
+}
+
+func (o *Overrides) update(change func(overrides map[string]Override)) error {
+	o.lock.Lock()
+	previous := o.overrides
+	overrides, err := o.write(change)
+	o.lock.Unlock()
+	if err != nil {
+		return err
+	}
+	o.notifyHidden(previous, overrides)
+	return nil
+}
+
+// write applies the change to a copy of the overrides and writes them into the
+// file. The lock needs to be held by the caller.
+func (o *Overrides) write(change func(overrides map[string]Override)) (map[string]Override, error) {
+	overrides := make(map[string]Override, len(o.overrides)+1)
+	for nodeId, override := range o.overrides {
+		overrides[nodeId] = override
+	}
+	change(overrides)
+	content, err := json.MarshalIndent(overrides, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	// Write the file atomically, so a concurrent reload never sees half of it
+	tmpPath := o.Path + ".tmp"
+	if err := ioutil.WriteFile(tmpPath, content, 0644); err != nil {
+		return nil, err
+	}
+	if err := os.Rename(tmpPath, o.Path); err != nil {
+		return nil, err
+	}
+	if info, err := os.Stat(o.Path); err == nil {
+		o.modTime = info.ModTime()
+	}
+	o.overrides = overrides
+	return overrides, nil
+}
+
+// Hidden checks whether the node is hidden from all outputs.
+func (o *Overrides) Hidden(nodeId string) bool {
+	override, exists := o.Get(nodeId)
+	return exists && override.Hidden()
+}
+
+// ApplyNodeInfo merges the override of the node into the nodeinfo.
+func (o *Overrides) ApplyNodeInfo(nodeinfo NodeInfo) NodeInfo {
+	override, exists := o.Get(nodeinfo.NodeId)
+	if !exists {
+		return nodeinfo
+	}
+	patch := override.nodeinfoPatch()
+	if len(patch) == 0 {
+		return nodeinfo
+	}
+	patched := NodeInfo{}
+	if err := applyPatch(nodeinfo, patch, &patched); err != nil {
+		log.WithFields(log.Fields{
+			"error":  err,
+			"nodeid": nodeinfo.NodeId,
+		}).Error("Can't apply override to nodeinfo")
+		return nodeinfo
+	}
+	// The node id identifies the override and can't be changed by it
+	patched.NodeId = nodeinfo.NodeId
+	return patched
+}
+
+// ApplyRawNodeInfo merges the override of the node into the nodeinfo as it
+// was received, so the fields passed through keep matching the NodeInfo.
+func (o *Overrides) ApplyRawNodeInfo(nodeId string, raw json.RawMessage) json.RawMessage {
+	override, exists := o.Get(nodeId)
+	if !exists {
+		return raw
+	}
+	patch := override.nodeinfoPatch()
+	delete(patch, "node_id")
+	if len(patch) == 0 {
+		return raw
+	}
+	var patched json.RawMessage
+	if err := applyPatch(raw, patch, &patched); err != nil {
+		return raw
+	}
+	return patched
+}
+
+// ApplyStatus merges the status override of the node into the status info.
+func (o *Overrides) ApplyStatus(status NodeStatusInfo) NodeStatusInfo {
+	override, exists := o.Get(status.NodeId)
+	if !exists {
+		return status
+	}
+	patch := override.statusPatch()
+	if len(patch) == 0 {
+		return status
+	}
+	patched := NodeStatusInfo{}
+	if err := applyPatch(status, patch, &patched); err != nil {
+		log.WithFields(log.Fields{
+			"error":  err,
+			"nodeid": status.NodeId,
+		}).Error("Can't apply override to node status")
+		return status
+	}
+	patched.NodeId = status.NodeId
+	return patched
+}
@@ -0,0 +1,109 @@
+package data
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const testOverrides = `{
+  "a": {
+    "hostname": "Renamed",
+    "location": {"latitude": 51.5, "longitude": 7.4},
+    "owner": null,
+    "status": {"Gateway": true}
+  },
+  "b": {"hidden": true}
+}`
+
+func TestApplyingOverrides(t *testing.T) {
+	assert := assert.New(t)
+	path := "./overrides.json"
+	defer os.RemoveAll(path)
+	assert.Nil(ioutil.WriteFile(path, []byte(testOverrides), 0644))
+
+	overrides, err := NewOverrides(path)
+	assert.Nil(err)
+	memoryStore := NewSimpleInMemoryStore()
+	for _, nodeId := range []string{"a", "b", "c"} {
+		memoryStore.PutNodeInfo(NodeInfo{NodeId: nodeId, Hostname: "Node " + nodeId,
+			Owner: &OwnerStruct{Contact: "owner@example.org"}})
+		memoryStore.PutNodeStatusInfo(nodeId, NodeStatusInfo{NodeId: nodeId, Online: true})
+	}
+	memoryStore.PutRawData("a", "nodeinfo", []byte(`{"node_id": "a", "hostname": "Node a", "custom": 1}`))
+	store := NewOverrideStore(memoryStore, overrides)
+
+	nodeinfo, err := store.GetNodeInfo("a")
+	assert.Nil(err)
+	assert.Equal("a", nodeinfo.NodeId)
+	assert.Equal("Renamed", nodeinfo.Hostname)
+	assert.Equal(51.5, nodeinfo.Location.Latitude)
+	assert.Nil(nodeinfo.Owner, "Null removes a field")
+	status, err := store.GetNodeStatusInfo("a")
+	assert.Nil(err)
+	assert.True(status.Gateway)
+	assert.True(status.Online, "Fields not overridden are kept")
+	raw, err := store.GetRawData("a", "nodeinfo")
+	assert.Nil(err)
+	var rawNodeinfo map[string]interface{}
+	assert.Nil(json.Unmarshal(raw, &rawNodeinfo))
+	assert.Equal("Renamed", rawNodeinfo["hostname"])
+	assert.Equal(1.0, rawNodeinfo["custom"], "Unknown fields are kept")
+	assert.NotNil(rawNodeinfo["location"])
+
+	_, err = store.GetNodeInfo("b")
+	assert.NotNil(err, "Hidden nodes are unknown")
+	assert.Equal(2, len(store.GetNodeInfos()))
+	assert.Equal(2, len(store.GetNodeStatusInfos()))
+
+	nodeId, err := store.LookupHostname("renamed")
+	assert.Nil(err)
+	assert.Equal("a", nodeId)
+	_, err = store.LookupHostname("Node a")
+	assert.NotNil(err)
+	_, err = store.LookupHostname("Node b")
+	assert.NotNil(err)
+
+	stored, _ := memoryStore.GetNodeInfo("a")
+	assert.Equal("Node a", stored.Hostname, "The decorated store is not changed")
+}
+
+func TestReloadingOverrides(t *testing.T) {
+	assert := assert.New(t)
+	path := "./overrides.json"
+	defer os.RemoveAll(path)
+
+	overrides, err := NewOverrides(path)
+	assert.Nil(err, "A missing file is no error")
+	assert.Equal(0, len(overrides.All()))
+	hidden := make([]string, 0)
+	overrides.OnHide(func(nodeId string) {
+		hidden = append(hidden, nodeId)
+	})
+
+	assert.Nil(ioutil.WriteFile(path, []byte(testOverrides), 0644))
+	overrides.Reload()
+	assert.True(overrides.Hidden("b"))
+	assert.Equal([]string{"b"}, hidden)
+
+	// Make sure the modification time changes
+	modTime := time.Now().Add(time.Second)
+	assert.Nil(ioutil.WriteFile(path, []byte(`{"b": {`), 0644))
+	assert.Nil(os.Chtimes(path, modTime, modTime))
+	overrides.Reload()
+	assert.True(overrides.Hidden("b"), "Invalid files are ignored")
+
+	assert.Nil(overrides.Delete("b"))
+	assert.Nil(overrides.Put("c", Override{"hidden": true}))
+	assert.Nil(overrides.Put("c", Override{"hidden": true, "hostname": "c"}))
+	assert.Equal([]string{"b", "c"}, hidden, "Nodes are only reported when they become hidden")
+	reread, err := NewOverrides(path)
+	assert.Nil(err)
+	assert.False(reread.Hidden("b"))
+	assert.True(reread.Hidden("c"))
+	assert.Equal(2, len(reread.All()))
+}
@@ -0,0 +1,274 @@
+package data
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// OverrideStore applies the Overrides to all data read from the decorated
+// store. Hidden nodes are left out as if they were unknown. Writes go to the
+// decorated store unchanged, so the OverrideStore is meant for the outputs
+// only, while the pipelines work on the decorated store.
+type OverrideStore struct {
+	Nodeinfostore
+	Overrides *Overrides
+}
+
+// historyStore is a store keeping all histories, like the BoltStore.
+type historyStore interface {
+	Nodeinfostore
+	EventLog
+	StatisticsHistoryStore
+	NodeinfoHistoryStore
+}
+
+// historyOverrideStore is an OverrideStore which additionally hides the
+// histories of hidden nodes.
+type historyOverrideStore struct {
+	*OverrideStore
+	history historyStore
+}
+
+// NewOverrideStore decorates the store with the overrides. The returned store
+// keeps the same histories as the given store.
+func NewOverrideStore(store Nodeinfostore, overrides *Overrides) Nodeinfostore {
+	overrideStore := &OverrideStore{Nodeinfostore: store, Overrides: overrides}
+	if history, ok := store.(historyStore); ok {
+		return &historyOverrideStore{OverrideStore: overrideStore, history: history}
+	}
+	return overrideStore
+}
+
+func hiddenError(nodeId string) error {
+	return fmt.Errorf("NodeId %s is unknown", nodeId)
+}
+
+func (o *OverrideStore) GetNodeInfo(nodeId string) (NodeInfo, error) {
+	if o.Overrides.Hidden(nodeId) {
+		return NodeInfo{}, hiddenError(nodeId)
+	}
+	nodeinfo, err := o.Nodeinfostore.GetNodeInfo(nodeId)
+	if err != nil {
+		return nodeinfo, err
+	}
+	return o.Overrides.ApplyNodeInfo(nodeinfo), nil
+}
+
+func (o *OverrideStore) GetNodeInfos() []NodeInfo {
+	nodeinfos := o.Nodeinfostore.GetNodeInfos()
+	result := make([]NodeInfo, 0, len(nodeinfos))
+	for _, nodeinfo := range nodeinfos {
+		if !o.Overrides.Hidden(nodeinfo.NodeId) {
+			result = append(result, o.Overrides.ApplyNodeInfo(nodeinfo))
+		}
+	}
+	return result
+}
+
+func (o *OverrideStore) GetStatistics(nodeId string) (StatisticsStruct, error) {
+	if o.Overrides.Hidden(nodeId) {
+		return StatisticsStruct{}, hiddenError(nodeId)
+	}
+	return o.Nodeinfostore.GetStatistics(nodeId)
+}
+
+func (o *OverrideStore) GetAllStatistics() []StatisticsStruct {
+	allStatistics := o.Nodeinfostore.GetAllStatistics()
+	result := make([]StatisticsStruct, 0, len(allStatistics))
+	for _, statistics := range allStatistics {
+		if !o.Overrides.Hidden(statistics.NodeId) {
+			result = append(result, statistics)
+		}
+	}
+	return result
+}
+
+func (o *OverrideStore) GetNodeStatusInfo(nodeId string) (NodeStatusInfo, error) {
+	if o.Overrides.Hidden(nodeId) {
+		return NodeStatusInfo{}, hiddenError(nodeId)
+	}
+	status, err := o.Nodeinfostore.GetNodeStatusInfo(nodeId)
+	if err != nil {
+		return status, err
+	}
+	return o.Overrides.ApplyStatus(status), nil
+}
+
+func (o *OverrideStore) GetNodeStatusInfos() []NodeStatusInfo {
+	statusInfos := o.Nodeinfostore.GetNodeStatusInfos()
+	result := make([]NodeStatusInfo, 0, len(statusInfos))
+	for _, status := range statusInfos {
+		if !o.Overrides.Hidden(status.NodeId) {
+			result = append(result, o.Overrides.ApplyStatus(status))
+		}
+	}
+	return result
+}
+
+func (o *OverrideStore) GetNodeNeighbours(nodeId string) (NeighbourStruct, error) {
+	if o.Overrides.Hidden(nodeId) {
+		return NeighbourStruct{}, hiddenError(nodeId)
+	}
+	return o.Nodeinfostore.GetNodeNeighbours(nodeId)
+}
+
+func (o *OverrideStore) GetAllNeighbours() []NeighbourStruct {
+	allNeighbours := o.Nodeinfostore.GetAllNeighbours()
+	result := make([]NeighbourStruct, 0, len(allNeighbours))
+	for _, neighbours := range allNeighbours {
+		if !o.Overrides.Hidden(neighbours.NodeId) {
+			result = append(result, neighbours)
+		}
+	}
+	return result
+}
+
+func (o *OverrideStore) GetRawData(nodeId, responseType string) (json.RawMessage, error) {
+	if o.Overrides.Hidden(nodeId) {
+		return nil, hiddenError(nodeId)
+	}
+	raw, err := o.Nodeinfostore.GetRawData(nodeId, responseType)
+	if err != nil || responseType != "nodeinfo" {
+		return raw, err
+	}
+	return o.Overrides.ApplyRawNodeInfo(nodeId, raw), nil
+}
+
+func (o *OverrideStore) GetResponseMeta(nodeId, responseType string) (ResponseMeta, error) {
+	if o.Overrides.Hidden(nodeId) {
+		return ResponseMeta{}, hiddenError(nodeId)
+	}
+	return o.Nodeinfostore.GetResponseMeta(nodeId, responseType)
+}
+
+// visible filters the result of a lookup in the decorated store.
+func (o *OverrideStore) visible(nodeId string, err error) (string, error) {
+	if err == nil && o.Overrides.Hidden(nodeId) {
+		return "", hiddenError(nodeId)
+	}
+	return nodeId, err
+}
+
+func (o *OverrideStore) LookupMac(mac string) (string, error) {
+	return o.visible(o.Nodeinfostore.LookupMac(mac))
+}
+
+func (o *OverrideStore) LookupAddress(address string) (string, error) {
+	return o.visible(o.Nodeinfostore.LookupAddress(address))
+}
+
+// LookupHostname finds the nodes by the hostnames set by the overrides. A node
+// whose hostname has been overridden isn't found by its own hostname anymore.
+func (o *OverrideStore) LookupHostname(hostname string) (string, error) {
+	for nodeId, override := range o.Overrides.All() {
+		overridden, ok := override["hostname"].(string)
+		if ok && !override.Hidden() && strings.EqualFold(overridden, strings.TrimSpace(hostname)) {
+			return nodeId, nil
+		}
+	}
+	nodeId, err := o.visible(o.Nodeinfostore.LookupHostname(hostname))
+	if err != nil {
+		return nodeId, err
+	}
+	if override, exists := o.Overrides.Get(nodeId); exists {
+		if _, ok := override["hostname"]; ok {
+			return "", fmt.Errorf("No node with hostname %s", hostname)
+		}
+	}
+	return nodeId, nil
+}
+
+func (o *OverrideStore) LookupSite(siteCode string) []string {
+	nodeIds := o.Nodeinfostore.LookupSite(siteCode)
+	result := make([]string, 0, len(nodeIds))
+	for _, nodeId := range nodeIds {
+		if !o.Overrides.Hidden(nodeId) {
+			result = append(result, nodeId)
+		}
+	}
+	return result
+}
+
+func (h *historyOverrideStore) PutEvent(event NodeEvent) {
+	h.history.PutEvent(event)
+}
+
+// GetEvents leaves out the events of hidden nodes.
+func (h *historyOverrideStore) GetEvents(nodeId string, since time.Time) ([]NodeEvent, error) {
+	if h.Overrides.Hidden(nodeId) {
+		return nil, hiddenError(nodeId)
+	}
+	events, err := h.history.GetEvents(nodeId, since)
+	if err != nil || nodeId != "" {
+		return events, err
+	}
+	result := make([]NodeEvent, 0, len(events))
+	for _, event := range events {
+		if !h.Overrides.Hidden(event.NodeId) {
+			result = append(result, event)
+		}
+	}
+	return result, nil
+}
+
+func (h *historyOverrideStore) GetLastEvent(nodeId string, before time.Time) (NodeEvent, error) {
+	if h.Overrides.Hidden(nodeId) {
+		return NodeEvent{}, hiddenError(nodeId)
+	}
+	return h.history.GetLastEvent(nodeId, before)
+}
+
+func (h *historyOverrideStore) GetEventTimelines(since time.Time) (map[string][]NodeEvent, error) {
+	timelines, err := h.history.GetEventTimelines(since)
+	if err != nil {
+		return timelines, err
+	}
+	for nodeId := range timelines {
+		if h.Overrides.Hidden(nodeId) {
+			delete(timelines, nodeId)
+		}
+	}
+	return timelines, nil
+}
+
+func (h *historyOverrideStore) PutStatisticsSample(nodeId string, sample StatisticsSample) {
+	h.history.PutStatisticsSample(nodeId, sample)
+}
+
+func (h *historyOverrideStore) GetStatisticsHistory(nodeId string, from, to time.Time, step time.Duration) ([]StatisticsSample, error) {
+	if h.Overrides.Hidden(nodeId) {
+		return nil, hiddenError(nodeId)
+	}
+	return h.history.GetStatisticsHistory(nodeId, from, to, step)
+}
+
+func (h *historyOverrideStore) GetHistoryTier(nodeId, tier string) ([]StatisticsSample, error) {
+	if h.Overrides.Hidden(nodeId) {
+		return nil, hiddenError(nodeId)
+	}
+	return h.history.GetHistoryTier(nodeId, tier)
+}
+
+func (h *historyOverrideStore) PutNodeinfoChanges(changes []NodeinfoChange) {
+	h.history.PutNodeinfoChanges(changes)
+}
+
+// GetNodeinfoChanges leaves out the changes of hidden nodes.
+func (h *historyOverrideStore) GetNodeinfoChanges(nodeId string, since time.Time) ([]NodeinfoChange, error) {
+	if h.Overrides.Hidden(nodeId) {
+		return nil, hiddenError(nodeId)
+	}
+	changes, err := h.history.GetNodeinfoChanges(nodeId, since)
+	if err != nil || nodeId != "" {
+		return changes, err
+	}
+	result := make([]NodeinfoChange, 0, len(changes))
+	for _, change := range changes {
+		if !h.Overrides.Hidden(change.NodeId) {
+			result = append(result, change)
+		}
+	}
+	return result, nil
+}
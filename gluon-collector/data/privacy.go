@@ -0,0 +1,353 @@
+package data
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"strings"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+
+	conf "github.com/ffdo/node-informant/gluon-collector/config"
+)
+
+// PrivacyPolicy describes which personal data of the nodes is published on the
+// public outputs. Fields are given as paths of json field names joined by dots,
+// like the paths of the NodeinfoChanges.
+type PrivacyPolicy struct {
+	// RemoveFields are removed from the nodeinfos of all nodes.
+	RemoveFields []string
+	// LocationPrecision is the number of decimal places the coordinates are
+	// rounded to. A negative precision keeps the exact location.
+	LocationPrecision int
+	// OptOutPath is the field by which owners opt out of publishing their
+	// data. If it is true the OptOutFields are removed from the nodeinfo.
+	OptOutPath   string
+	OptOutFields []string
+}
+
+// NewPrivacyPolicy creates the policy configured in the privacy section of the
+// global configuration. The defaults hide the contact and the addresses of the
+// nodes, round the location to about 100m and respect owner.opt_out.
+func NewPrivacyPolicy() PrivacyPolicy {
+	policy := PrivacyPolicy{
+		RemoveFields:      []string{"owner.contact", "network.addresses"},
+		LocationPrecision: conf.UInt("privacy.locationPrecision", 3),
+		OptOutPath:        conf.UString("privacy.optOutPath", "owner.opt_out"),
+		OptOutFields:      []string{"owner", "location"},
+	}
+	if conf.Global == nil {
+		return policy
+	}
+	if fields, err := conf.Global.List("privacy.removeFields"); err == nil {
+		policy.RemoveFields = stringList(fields)
+	}
+	if fields, err := conf.Global.List("privacy.optOutFields"); err == nil {
+		policy.OptOutFields = stringList(fields)
+	}
+	return policy
+}
+
+func stringList(values []interface{}) []string {
+	list := make([]string, 0, len(values))
+	for _, value := range values {
+		if s, ok := value.(string); ok {
+			list = append(list, s)
+		}
+	}
+	return list
+}
+
+func splitPath(path string) []string {
+	return strings.Split(path, ".")
+}
+
+// lookupPath returns the value at the path of the decoded json document.
+func lookupPath(document map[string]interface{}, path string) (interface{}, bool) {
+	keys := splitPath(path)
+	for _, key := range keys[:len(keys)-1] {
+		child, ok := document[key].(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		document = child
+	}
+	value, exists := document[keys[len(keys)-1]]
+	return value, exists
+}
+
+// removePath deletes the value at the path of the decoded json document.
+func removePath(document map[string]interface{}, path string) {
+	keys := splitPath(path)
+	for _, key := range keys[:len(keys)-1] {
+		child, ok := document[key].(map[string]interface{})
+		if !ok {
+			return
+		}
+		document = child
+	}
+	delete(document, keys[len(keys)-1])
+}
+
+// roundCoordinate rounds the coordinate to the precision of the policy.
+func (p PrivacyPolicy) roundCoordinate(value interface{}) interface{} {
+	coordinate, ok := value.(float64)
+	if !ok || p.LocationPrecision < 0 {
+		return value
+	}
+	factor := math.Pow10(p.LocationPrecision)
+	return math.Floor(coordinate*factor+0.5) / factor
+}
+
+// optedOut checks whether the owner of the node opted out of publishing the
+// personal data of the node in its nodeinfo.
+func (p PrivacyPolicy) optedOut(document map[string]interface{}) bool {
+	if p.OptOutPath == "" {
+		return false
+	}
+	optOut, _ := lookupPath(document, p.OptOutPath)
+	optedOut, _ := optOut.(bool)
+	return optedOut
+}
+
+// applyToDocument applies the policy to the decoded json nodeinfo.
+func (p PrivacyPolicy) applyToDocument(document map[string]interface{}, optedOut bool) {
+	if optedOut || p.optedOut(document) {
+		for _, path := range p.OptOutFields {
+			removePath(document, path)
+		}
+	}
+	for _, path := range p.RemoveFields {
+		removePath(document, path)
+	}
+	if location, ok := document["location"].(map[string]interface{}); ok {
+		for _, key := range []string{"latitude", "longitude"} {
+			if value, exists := location[key]; exists {
+				location[key] = p.roundCoordinate(value)
+			}
+		}
+	}
+}
+
+// applyTo decodes in as json document, applies the policy and decodes the
+// result into out.
+func (p PrivacyPolicy) applyTo(in interface{}, optedOut bool, out interface{}) error {
+	encoded, err := json.Marshal(in)
+	if err != nil {
+		return err
+	}
+	document := make(map[string]interface{})
+	if err := json.Unmarshal(encoded, &document); err != nil {
+		return err
+	}
+	p.applyToDocument(document, optedOut)
+	encoded, err = json.Marshal(document)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(encoded, out)
+}
+
+// ApplyNodeInfo removes the data from the nodeinfo which must not be published.
+// Since the NodeInfo doesn't keep unknown fields, the caller needs to tell
+// whether the owner opted out.
+func (p PrivacyPolicy) ApplyNodeInfo(nodeinfo NodeInfo, optedOut bool) NodeInfo {
+	filtered := NodeInfo{}
+	if err := p.applyTo(nodeinfo, optedOut, &filtered); err != nil {
+		// Better publish nothing than too much
+		log.WithFields(log.Fields{
+			"error":  err,
+			"nodeid": nodeinfo.NodeId,
+		}).Error("Can't apply privacy policy to nodeinfo")
+		return NodeInfo{NodeId: nodeinfo.NodeId}
+	}
+	return filtered
+}
+
+// ApplyRawNodeInfo removes the data from the nodeinfo as it was received from
+// the node which must not be published.
+func (p PrivacyPolicy) ApplyRawNodeInfo(raw json.RawMessage) json.RawMessage {
+	var filtered json.RawMessage
+	if err := p.applyTo(raw, false, &filtered); err != nil {
+		return json.RawMessage("{}")
+	}
+	return filtered
+}
+
+// removed checks whether the field at the path is removed for all nodes.
+func (p PrivacyPolicy) removed(path string, fields []string) bool {
+	for _, field := range fields {
+		if path == field || strings.HasPrefix(path, field+".") {
+			return true
+		}
+	}
+	return false
+}
+
+// ApplyChange applies the policy to a change of the nodeinfo of a node, whose
+// owner might have opted out. The second return value is false if the change
+// must not be published at all.
+func (p PrivacyPolicy) ApplyChange(change NodeinfoChange, optedOut bool) (NodeinfoChange, bool) {
+	if p.removed(change.Path, p.RemoveFields) || optedOut && p.removed(change.Path, p.OptOutFields) {
+		return change, false
+	}
+	if change.Path == "location.latitude" || change.Path == "location.longitude" {
+		change.Old = p.roundCoordinate(change.Old)
+		change.New = p.roundCoordinate(change.New)
+		if change.Old == change.New {
+			return change, false
+		}
+	}
+	return change, true
+}
+
+// PrivacyStore applies the PrivacyPolicy to all nodeinfos read from the
+// decorated store. It is meant for the public outputs.
+type PrivacyStore struct {
+	Nodeinfostore
+	Policy PrivacyPolicy
+}
+
+// historyPrivacyStore is a PrivacyStore which additionally applies the policy
+// to the nodeinfo history.
+type historyPrivacyStore struct {
+	*PrivacyStore
+	history historyStore
+}
+
+// NewPrivacyStore decorates the store with the policy. The returned store keeps
+// the same histories as the given store.
+func NewPrivacyStore(store Nodeinfostore, policy PrivacyPolicy) Nodeinfostore {
+	privacyStore := &PrivacyStore{Nodeinfostore: store, Policy: policy}
+	if history, ok := store.(historyStore); ok {
+		return &historyPrivacyStore{PrivacyStore: privacyStore, history: history}
+	}
+	return privacyStore
+}
+
+func (p *PrivacyStore) GetNodeInfo(nodeId string) (NodeInfo, error) {
+	nodeinfo, err := p.Nodeinfostore.GetNodeInfo(nodeId)
+	if err != nil {
+		return nodeinfo, err
+	}
+	return p.Policy.ApplyNodeInfo(nodeinfo, p.optedOut(nodeId)), nil
+}
+
+func (p *PrivacyStore) GetNodeInfos() []NodeInfo {
+	nodeinfos := p.Nodeinfostore.GetNodeInfos()
+	result := make([]NodeInfo, 0, len(nodeinfos))
+	for _, nodeinfo := range nodeinfos {
+		result = append(result, p.Policy.ApplyNodeInfo(nodeinfo, p.optedOut(nodeinfo.NodeId)))
+	}
+	return result
+}
+
+func (p *PrivacyStore) GetRawData(nodeId, responseType string) (json.RawMessage, error) {
+	raw, err := p.Nodeinfostore.GetRawData(nodeId, responseType)
+	if err != nil || responseType != "nodeinfo" {
+		return raw, err
+	}
+	return p.Policy.ApplyRawNodeInfo(raw), nil
+}
+
+// published checks whether the field at the path of the nodeinfo of the node
+// is published by the policy.
+func (p *PrivacyStore) published(nodeId, path string) bool {
+	if p.Policy.removed(path, p.Policy.RemoveFields) {
+		return false
+	}
+	return !p.Policy.removed(path, p.Policy.OptOutFields) || !p.optedOut(nodeId)
+}
+
+// lookup filters the result of a lookup by a field, so removed fields can't be
+// confirmed by looking nodes up by them.
+func (p *PrivacyStore) lookup(path, kind, key, nodeId string, err error) (string, error) {
+	if err == nil && !p.published(nodeId, path) {
+		return "", fmt.Errorf("No node with %s %s", kind, key)
+	}
+	return nodeId, err
+}
+
+func (p *PrivacyStore) LookupAddress(address string) (string, error) {
+	nodeId, err := p.Nodeinfostore.LookupAddress(address)
+	return p.lookup("network.addresses", "address", address, nodeId, err)
+}
+
+func (p *PrivacyStore) LookupMac(mac string) (string, error) {
+	nodeId, err := p.Nodeinfostore.LookupMac(mac)
+	return p.lookup("network.mac", "mac", mac, nodeId, err)
+}
+
+func (p *PrivacyStore) LookupHostname(hostname string) (string, error) {
+	nodeId, err := p.Nodeinfostore.LookupHostname(hostname)
+	return p.lookup("hostname", "hostname", hostname, nodeId, err)
+}
+
+// optedOut checks whether the owner of the node opted out, based on the last
+// nodeinfo received from the node.
+func (p *PrivacyStore) optedOut(nodeId string) bool {
+	raw, err := p.Nodeinfostore.GetRawData(nodeId, "nodeinfo")
+	if err != nil {
+		return false
+	}
+	document := make(map[string]interface{})
+	if err := json.Unmarshal(raw, &document); err != nil {
+		return false
+	}
+	return p.Policy.optedOut(document)
+}
+
+func (h *historyPrivacyStore) PutEvent(event NodeEvent) {
+	h.history.PutEvent(event)
+}
+
+func (h *historyPrivacyStore) GetEvents(nodeId string, since time.Time) ([]NodeEvent, error) {
+	return h.history.GetEvents(nodeId, since)
+}
+
+func (h *historyPrivacyStore) GetLastEvent(nodeId string, before time.Time) (NodeEvent, error) {
+	return h.history.GetLastEvent(nodeId, before)
+}
+
+func (h *historyPrivacyStore) GetEventTimelines(since time.Time) (map[string][]NodeEvent, error) {
+	return h.history.GetEventTimelines(since)
+}
+
+func (h *historyPrivacyStore) PutStatisticsSample(nodeId string, sample StatisticsSample) {
+	h.history.PutStatisticsSample(nodeId, sample)
+}
+
+func (h *historyPrivacyStore) GetStatisticsHistory(nodeId string, from, to time.Time, step time.Duration) ([]StatisticsSample, error) {
+	return h.history.GetStatisticsHistory(nodeId, from, to, step)
+}
+
+func (h *historyPrivacyStore) GetHistoryTier(nodeId, tier string) ([]StatisticsSample, error) {
+	return h.history.GetHistoryTier(nodeId, tier)
+}
+
+func (h *historyPrivacyStore) PutNodeinfoChanges(changes []NodeinfoChange) {
+	h.history.PutNodeinfoChanges(changes)
+}
+
+// GetNodeinfoChanges leaves out the changes of removed fields and rounds the
+// changes of the location.
+func (h *historyPrivacyStore) GetNodeinfoChanges(nodeId string, since time.Time) ([]NodeinfoChange, error) {
+	changes, err := h.history.GetNodeinfoChanges(nodeId, since)
+	if err != nil {
+		return changes, err
+	}
+	optedOut := make(map[string]bool)
+	result := make([]NodeinfoChange, 0, len(changes))
+	for _, change := range changes {
+		nodeOptedOut, known := optedOut[change.NodeId]
+		if !known {
+			nodeOptedOut = h.optedOut(change.NodeId)
+			optedOut[change.NodeId] = nodeOptedOut
+		}
+		if change, ok := h.Policy.ApplyChange(change, nodeOptedOut); ok {
+			result = append(result, change)
+		}
+	}
+	return result, nil
+}
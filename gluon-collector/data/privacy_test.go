@@ -0,0 +1,87 @@
+package data
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestApplyingPrivacyPolicy(t *testing.T) {
+	assert := assert.New(t)
+	memoryStore := NewSimpleInMemoryStore()
+	raw := []byte(`{"node_id": "a", "hostname": "Node a",
+		"owner": {"contact": "owner@example.org"},
+		"network": {"mac": "c4:6e:1f:b6:4f:70", "addresses": ["2a03:2260:50:5::1"]},
+		"location": {"latitude": 51.512345, "longitude": 7.463456}}`)
+	nodeinfo := NodeInfo{}
+	assert.Nil(json.Unmarshal(raw, &nodeinfo))
+	memoryStore.PutNodeInfo(nodeinfo)
+	memoryStore.PutRawData("a", "nodeinfo", raw)
+	store := NewPrivacyStore(memoryStore, NewPrivacyPolicy())
+
+	filtered, err := store.GetNodeInfo("a")
+	assert.Nil(err)
+	assert.Equal("Node a", filtered.Hostname)
+	assert.Equal("c4:6e:1f:b6:4f:70", filtered.Network.Mac)
+	assert.Equal(0, len(filtered.Network.Addresses))
+	assert.Equal("", filtered.Owner.Contact)
+	assert.Equal(51.512, filtered.Location.Latitude)
+	assert.Equal(7.463, filtered.Location.Longtitude)
+
+	filteredRaw, err := store.GetRawData("a", "nodeinfo")
+	assert.Nil(err)
+	assert.NotContains(string(filteredRaw), "owner@example.org")
+	assert.NotContains(string(filteredRaw), "2a03:2260:50:5::1")
+
+	// Removed fields can't be confirmed by looking them up
+	_, err = store.LookupAddress("2a03:2260:50:5::1")
+	assert.NotNil(err)
+	nodeId, err := store.LookupMac("c4:6e:1f:b6:4f:70")
+	assert.Nil(err)
+	assert.Equal("a", nodeId)
+
+	stored, _ := memoryStore.GetNodeInfo("a")
+	assert.Equal("owner@example.org", stored.Owner.Contact, "The decorated store is not changed")
+
+	// The opt out is only known from the raw nodeinfo
+	memoryStore.PutRawData("a", "nodeinfo", []byte(`{"node_id": "a", "owner": {"opt_out": true}}`))
+	filtered, err = store.GetNodeInfo("a")
+	assert.Nil(err)
+	assert.Nil(filtered.Owner)
+	assert.Nil(filtered.Location)
+	assert.Equal("Node a", store.GetNodeInfos()[0].Hostname)
+	assert.Nil(store.GetNodeInfos()[0].Location)
+}
+
+func TestRoundingCoordinates(t *testing.T) {
+	assert := assert.New(t)
+	policy := PrivacyPolicy{LocationPrecision: 2}
+	assert.Equal(51.51, policy.roundCoordinate(51.5149))
+	assert.Equal(7.47, policy.roundCoordinate(7.4651))
+	assert.Equal(-0.13, policy.roundCoordinate(-0.1288))
+	assert.Equal("x", policy.roundCoordinate("x"))
+	policy.LocationPrecision = -1
+	assert.Equal(51.5149, policy.roundCoordinate(51.5149))
+}
+
+func TestApplyingPrivacyPolicyToChanges(t *testing.T) {
+	assert := assert.New(t)
+	policy := NewPrivacyPolicy()
+	now := time.Now()
+
+	_, published := policy.ApplyChange(NodeinfoChange{Time: now, Path: "owner.contact", New: "me"}, false)
+	assert.False(published)
+	_, published = policy.ApplyChange(NodeinfoChange{Time: now, Path: "network.addresses", New: []interface{}{}}, false)
+	assert.False(published)
+	_, published = policy.ApplyChange(NodeinfoChange{Time: now, Path: "location.latitude", Old: 51.51231, New: 51.51234}, false)
+	assert.False(published, "Changes hidden by the rounding are left out")
+	change, published := policy.ApplyChange(NodeinfoChange{Time: now, Path: "location.latitude", Old: 51.5, New: 51.51234}, false)
+	assert.True(published)
+	assert.Equal(51.512, change.New)
+	_, published = policy.ApplyChange(NodeinfoChange{Time: now, Path: "location.latitude", Old: 51.5, New: 51.51234}, true)
+	assert.False(published)
+	_, published = policy.ApplyChange(NodeinfoChange{Time: now, Path: "hostname", Old: "a", New: "b"}, true)
+	assert.True(published)
+}
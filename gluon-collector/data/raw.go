@@ -0,0 +1,50 @@
+package data
+
+import (
+	"bytes"
+	"encoding/json"
+)
+
+// decodeObject decodes a json object keeping numbers as they are, so big
+// counters don't lose precision.
+func decodeObject(document []byte) (map[string]interface{}, error) {
+	decoder := json.NewDecoder(bytes.NewReader(document))
+	decoder.UseNumber()
+	object := make(map[string]interface{})
+	err := decoder.Decode(&object)
+	return object, err
+}
+
+// mergeObjects adds all fields of source missing in target to target. Objects
+// present in both are merged recursively, for all other fields the value in
+// target wins.
+func mergeObjects(target, source map[string]interface{}) {
+	for key, sourceValue := range source {
+		targetValue, exists := target[key]
+		if !exists {
+			target[key] = sourceValue
+			continue
+		}
+		targetObject, targetIsObject := targetValue.(map[string]interface{})
+		sourceObject, sourceIsObject := sourceValue.(map[string]interface{})
+		if targetIsObject && sourceIsObject {
+			mergeObjects(targetObject, sourceObject)
+		}
+	}
+}
+
+// MergeUnknownFields adds all fields of the raw json document which are not
+// part of the typed json document. This way outputs generated from our structs
+// still contain the fields of newer firmwares we don't know about.
+func MergeUnknownFields(typed []byte, raw json.RawMessage) ([]byte, error) {
+	typedObject, err := decodeObject(typed)
+	if err != nil {
+		return nil, err
+	}
+	rawObject, err := decodeObject(raw)
+	if err != nil {
+		return nil, err
+	}
+	mergeObjects(typedObject, rawObject)
+	return json.Marshal(typedObject)
+}
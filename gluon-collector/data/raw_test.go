@@ -0,0 +1,44 @@
+package data
+
+import (
+	"encoding/json"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMergingUnknownFields(t *testing.T) {
+	assert := assert.New(t)
+	typed := []byte(`{"node_id": "a", "system": {"site_code": "ffdo"}}`)
+	raw := json.RawMessage(`{"node_id": "b", "system": {"site_code": "x", "domain_code": "d"},
+		"wireless": [1], "counter": 18446744073709551615}`)
+	merged, err := MergeUnknownFields(typed, raw)
+	assert.Nil(err)
+	expected, _ := decodeObject([]byte(`{"node_id": "a", "system": {"site_code": "ffdo", "domain_code": "d"},
+		"wireless": [1], "counter": 18446744073709551615}`))
+	result, err := decodeObject(merged)
+	assert.Nil(err)
+	assert.Equal(expected, result)
+
+	_, err = MergeUnknownFields(typed, json.RawMessage(`[]`))
+	assert.NotNil(err)
+}
+
+func TestStoringRawData(t *testing.T) {
+	assert := assert.New(t)
+	dbPath := "./raw.db"
+	defer os.RemoveAll(dbPath)
+
+	boltStore, err := NewBoltStore(dbPath)
+	assert.Nil(err)
+	defer boltStore.Close()
+	for _, store := range []Nodeinfostore{boltStore, NewSimpleInMemoryStore()} {
+		store.PutRawData("a", "nodeinfo", json.RawMessage(`{"node_id": "a"}`))
+		raw, err := store.GetRawData("a", "nodeinfo")
+		assert.Nil(err)
+		assert.Equal(`{"node_id": "a"}`, string(raw))
+		_, err = store.GetRawData("a", "statistics")
+		assert.NotNil(err)
+	}
+}
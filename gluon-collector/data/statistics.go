@@ -9,8 +9,11 @@ type MeshVPNPeerGroup struct {
 	Groups map[string]*MeshVPNPeerGroup `json:"groups"`
 }
 
+// MeshVPNStruct contains the state of the mesh vpn. fastd reports its peers in
+// groups, tunneldigger reports the peers directly.
 type MeshVPNStruct struct {
 	Groups map[string]*MeshVPNPeerGroup `json:"groups,omitempty"`
+	Peers  map[string]*MeshVPNPeerLink  `json:"peers,omitempty"`
 }
 
 type TrafficObject struct {
@@ -28,8 +31,63 @@ type TrafficStruct struct {
 }
 
 type ClientStatistics struct {
-	Wifi  int `json:"wifi"`
-	Total int `json:"total"`
+	Wifi   int `json:"wifi"`
+	Wifi24 int `json:"wifi24,omitempty"`
+	Wifi5  int `json:"wifi5,omitempty"`
+	Owe    int `json:"owe,omitempty"`
+	Total  int `json:"total"`
+}
+
+// WirelessStatistics contains the airtime counters of a single radio in
+// milliseconds. The counters are accumulated since the radio came up.
+type WirelessStatistics struct {
+	Frequency int    `json:"frequency"`
+	Noise     int    `json:"noise"`
+	Active    uint64 `json:"active"`
+	Busy      uint64 `json:"busy"`
+	Rx        uint64 `json:"rx"`
+	Tx        uint64 `json:"tx"`
+}
+
+// ChannelUtilization returns the share of the time the channel was busy.
+func (w WirelessStatistics) ChannelUtilization() float64 {
+	if w.Active == 0 {
+		return 0
+	}
+	return float64(w.Busy) / float64(w.Active)
+}
+
+// CPUStatistics contains the cpu time in jiffies per mode as found in /proc/stat.
+type CPUStatistics struct {
+	User    uint64 `json:"user"`
+	Nice    uint64 `json:"nice"`
+	System  uint64 `json:"system"`
+	Idle    uint64 `json:"idle"`
+	IOWait  uint64 `json:"iowait"`
+	Irq     uint64 `json:"irq"`
+	SoftIrq uint64 `json:"softirq"`
+}
+
+// Modes returns the cpu time of all modes by name.
+func (c CPUStatistics) Modes() map[string]uint64 {
+	return map[string]uint64{
+		"user":    c.User,
+		"nice":    c.Nice,
+		"system":  c.System,
+		"idle":    c.Idle,
+		"iowait":  c.IOWait,
+		"irq":     c.Irq,
+		"softirq": c.SoftIrq,
+	}
+}
+
+// StatStatistics contains the kernel counters from /proc/stat.
+type StatStatistics struct {
+	CPU       *CPUStatistics `json:"cpu,omitempty"`
+	Intr      uint64         `json:"intr"`
+	Ctxt      uint64         `json:"ctxt"`
+	Processes uint64         `json:"processes"`
+	SoftIrq   uint64         `json:"softirq"`
 }
 
 type MemoryStatistics struct {
@@ -48,10 +106,16 @@ type StatisticsStruct struct {
 	Uptime      float64          `json:"uptime"`
 	Idletime    float64          `json:"idletime"`
 	Gateway     string           `json:"gateway"`
-	Processes   struct {
+	// GatewayNexthop is the mac of the neighbour over which the gateway is
+	// reached.
+	GatewayNexthop string `json:"gateway_nexthop,omitempty"`
+	Gateway6       string `json:"gateway6,omitempty"`
+	Processes      struct {
 		Total   uint64 `json:"total"`
 		Running uint64 `json:"running"`
 	} `json:"processes"`
-	LoadAverage float64        `json:"loadavg"`
-	MeshVpn     *MeshVPNStruct `json:"mesh_vpn,omitempty"`
+	LoadAverage float64              `json:"loadavg"`
+	MeshVpn     *MeshVPNStruct       `json:"mesh_vpn,omitempty"`
+	Wireless    []WirelessStatistics `json:"wireless,omitempty"`
+	Stat        *StatStatistics      `json:"stat,omitempty"`
 }
@@ -42,3 +42,40 @@ func TestParsingMeshVPNStats(t *testing.T) {
 	assert.NotNil(meshVpn.Groups["do01"].Peers["do01100"])
 	assert.Equal(float64(522401.615), meshVpn.Groups["do01"].Peers["do01100"].Established)
 }
+
+var extendedStats = `
+{
+  "node_id": "c46e1fc70bbc",
+  "clients": {"total": 9, "wifi": 8, "wifi24": 5, "wifi5": 3, "owe": 2},
+  "gateway": "02:ce:ef:ca:fe:2a",
+  "gateway_nexthop": "c6:71:20:ff:03:57",
+  "gateway6": "02:ce:ef:ca:fe:2b",
+  "wireless": [
+    {"frequency": 2412, "noise": -95, "active": 1000, "busy": 250, "rx": 100, "tx": 50},
+    {"frequency": 5180, "noise": -102, "active": 0, "busy": 0, "rx": 0, "tx": 0}
+  ],
+  "stat": {"cpu": {"user": 100, "nice": 1, "system": 50, "idle": 1000, "iowait": 2, "irq": 3, "softirq": 4},
+    "intr": 12345, "ctxt": 23456, "processes": 789, "softirq": 3456},
+  "mesh_vpn": {"peers": {"broker1": {"established": 12.5}, "broker2": null}}
+}
+`
+
+func TestParsingExtendedStatistics(t *testing.T) {
+	assert := assert.New(t)
+	stats := StatisticsStruct{}
+	err := json.Unmarshal([]byte(extendedStats), &stats)
+	assert.Nil(err)
+	assert.Equal(5, stats.Clients.Wifi24)
+	assert.Equal(3, stats.Clients.Wifi5)
+	assert.Equal(2, stats.Clients.Owe)
+	assert.Equal("c6:71:20:ff:03:57", stats.GatewayNexthop)
+	assert.Equal("02:ce:ef:ca:fe:2b", stats.Gateway6)
+	assert.Equal(2, len(stats.Wireless))
+	assert.Equal(0.25, stats.Wireless[0].ChannelUtilization())
+	assert.Equal(0.0, stats.Wireless[1].ChannelUtilization())
+	assert.NotNil(stats.Stat)
+	assert.Equal(uint64(1000), stats.Stat.CPU.Modes()["idle"])
+	assert.Equal(uint64(23456), stats.Stat.Ctxt)
+	assert.Equal(12.5, stats.MeshVpn.Peers["broker1"].Established)
+	assert.Nil(stats.MeshVpn.Peers["broker2"])
+}
@@ -1,16 +1,71 @@
 package data
 
-import "time"
+import (
+	"encoding/json"
+	"time"
+)
+
+// RawResponseTypes are the response types for which the raw json documents and
+// the response meta data are stored.
+var RawResponseTypes = []string{"nodeinfo", "statistics", "neighbours"}
 
 const LegacyTimeFormat string = "2006-01-02T15:04:05"
 const TimeFormat string = time.RFC3339
 
+const (
+	// StateOnline means the node delivers all expected responses in time.
+	StateOnline string = "online"
+	// StateDegraded means the node is reachable, but some expected responses
+	// are missing or outdated.
+	StateDegraded string = "degraded"
+	// StateOffline means the node missed too many of its expected reports.
+	StateOffline string = "offline"
+	// StateGone means the node has been offline for a long time and will be
+	// deleted eventually.
+	StateGone string = "gone"
+)
+
 type NodeStatusInfo struct {
 	Firstseen string
 	Lastseen  string
-	Online    bool
-	Gateway   bool
-	NodeId    string
+	// Online is true as long as the State is online or degraded.
+	Online  bool
+	Gateway bool
+	NodeId  string
+	// IdentityConflict is set while responses claiming this node id are
+	// received from senders which don't match the known identity of the node.
+	IdentityConflict bool
+	// State is one of StateOnline, StateDegraded, StateOffline or StateGone.
+	// It is empty for status infos which have never been evaluated.
+	State string
+	// ExpectedInterval is the learned or configured interval in seconds in
+	// which the node is expected to report, zero if it is unknown.
+	ExpectedInterval int
+	// Domain is the mesh domain of the node, taken from the receiver or from
+	// its nodeinfo.
+	Domain string
+}
+
+// LastseenTime parses the Lastseen time, which is either in RFC3339 or in the
+// format of ffmap-backend for imported data.
+func (s NodeStatusInfo) LastseenTime() (time.Time, error) {
+	lastseen, err := time.Parse(TimeFormat, s.Lastseen)
+	if err != nil {
+		lastseen, err = time.Parse(LegacyTimeFormat, s.Lastseen)
+	}
+	return lastseen, err
+}
+
+// CurrentState returns the State of the node. For status infos which have not
+// been evaluated yet it is derived from the Online flag.
+func (s NodeStatusInfo) CurrentState() string {
+	if s.State != "" {
+		return s.State
+	}
+	if s.Online {
+		return StateOnline
+	}
+	return StateOffline
 }
 
 // Nodeinfostore needs to implemented by all types which want to store node
@@ -56,6 +111,12 @@ type Nodeinfostore interface {
 	// node id. This is not checked or handled currently.
 	PutNodeStatusInfo(nodeId string, info NodeStatusInfo)
 
+	// UpdateNodeStatusInfo changes the stored NodeStatusInfo of the node in a
+	// single step, so no put in between is overwritten. The change is only
+	// stored if it returns true. An error is returned if no NodeStatusInfo is
+	// available.
+	UpdateNodeStatusInfo(nodeId string, change func(info *NodeStatusInfo) bool) error
+
 	// GetNodeNeighbours retrives the mesh neighbour information for the specified
 	// node id or returns an error if no mesh neighbour information is available
 	// for the specified node id.
@@ -81,7 +142,65 @@ type Nodeinfostore interface {
 	// node with this mac address is not a gateway any more.
 	RemoveGateway(mac string)
 
-	NotifyNodeOffline(handler func(string))
+	// GetGateways returns the macs of all known gateways.
+	GetGateways() []string
+
+	// PutRawData stores the json document of a response of the given type
+	// (nodeinfo, statistics or neighbours) exactly as it was received.
+	PutRawData(nodeId, responseType string, raw json.RawMessage)
+
+	// GetRawData retrieves the json document of the last received response of
+	// the given type for the node id or returns an error if there is none.
+	GetRawData(nodeId, responseType string) (json.RawMessage, error)
+
+	// PutResponseMeta stores from where and when the last response of the
+	// given type was received from the node.
+	PutResponseMeta(nodeId, responseType string, meta ResponseMeta)
+
+	// GetResponseMeta retrieves the envelope of the last received response of the
+	// given type for the node id or returns an error if there is none.
+	GetResponseMeta(nodeId, responseType string) (ResponseMeta, error)
+
+	// DeleteNode removes all data of the node from the data store.
+	DeleteNode(nodeId string)
+
+	// LookupMac returns the id of the node with the given primary or mesh
+	// interface mac or an error if no node is known by this mac.
+	LookupMac(mac string) (string, error)
+
+	// LookupAddress returns the id of the node announcing the given ip address or
+	// an error if no node announces it.
+	LookupAddress(address string) (string, error)
+
+	// LookupHostname returns the id of the node with the given hostname, ignoring
+	// the case, or an error if there is none.
+	LookupHostname(hostname string) (string, error)
+
+	// LookupSite returns the ids of all nodes of the given site code.
+	LookupSite(siteCode string) []string
+
+	// Events returns the EventBus on which the changes in the life cycle of the
+	// nodes in this data store are published.
+	Events() *EventBus
+}
+
+// BulkStore can be implemented by stores which can put the data of many nodes
+// at once faster than node by node, for example when importing or loading data
+// of a large mesh.
+type BulkStore interface {
+
+	// PutNodeInfos stores all given NodeInfos.
+	PutNodeInfos(nodeinfos []NodeInfo)
+
+	// PutAllStatistics stores all given statistics.
+	PutAllStatistics(statistics []StatisticsStruct)
+
+	// PutNodeStatusInfos stores all given status infos by their node id. Status
+	// infos without node id are skipped.
+	PutNodeStatusInfos(infos []NodeStatusInfo)
+
+	// PutAllNeighbours stores all given mesh neighbour informations.
+	PutAllNeighbours(neighbours []NeighbourStruct)
 }
 
 // NodeFileImporter is a poor name for this. This interface can be implemented by
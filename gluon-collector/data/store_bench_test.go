@@ -0,0 +1,139 @@
+package data
+
+import (
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// benchmarkNodes is the size of a large mesh the stores need to keep up with.
+const benchmarkNodes int = 5000
+
+func benchmarkData() ([]NodeInfo, []StatisticsStruct, []NodeStatusInfo) {
+	nodeinfos := make([]NodeInfo, benchmarkNodes)
+	statistics := make([]StatisticsStruct, benchmarkNodes)
+	statusInfos := make([]NodeStatusInfo, benchmarkNodes)
+	now := time.Now().Format(TimeFormat)
+	for i := 0; i < benchmarkNodes; i++ {
+		nodeId := fmt.Sprintf("%012x", i)
+		nodeinfos[i] = NodeInfo{NodeId: nodeId, Hostname: "node-" + nodeId}
+		statistics[i] = StatisticsStruct{NodeId: nodeId, Uptime: float64(i),
+			Clients: ClientStatistics{Wifi: i % 20, Total: i % 20}}
+		statusInfos[i] = NodeStatusInfo{NodeId: nodeId, Online: true, Lastseen: now}
+	}
+	return nodeinfos, statistics, statusInfos
+}
+
+// putSingle puts the data of every node one by one, as the pipeline does.
+func putSingle(store Nodeinfostore, nodeinfos []NodeInfo, statistics []StatisticsStruct, statusInfos []NodeStatusInfo) {
+	for i := range nodeinfos {
+		store.PutNodeInfo(nodeinfos[i])
+		store.PutStatistics(statistics[i])
+		store.PutNodeStatusInfo(statusInfos[i].NodeId, statusInfos[i])
+	}
+}
+
+func putBulk(store BulkStore, nodeinfos []NodeInfo, statistics []StatisticsStruct, statusInfos []NodeStatusInfo) {
+	store.PutNodeInfos(nodeinfos)
+	store.PutAllStatistics(statistics)
+	store.PutNodeStatusInfos(statusInfos)
+}
+
+func benchmarkBoltStore(b *testing.B, flushInterval time.Duration, bulk bool) {
+	log.SetLevel(log.ErrorLevel)
+	dbPath := "./bench.db"
+	defer os.RemoveAll(dbPath)
+	store, err := NewBoltStore(dbPath)
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer store.Close()
+	if store.flushJob != nil {
+		store.flushJob.Stop()
+		store.flushJob = nil
+	}
+	store.writes = newWriteBuffer(flushInterval, benchmarkNodes)
+	nodeinfos, statistics, statusInfos := benchmarkData()
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if bulk {
+			putBulk(store, nodeinfos, statistics, statusInfos)
+		} else {
+			putSingle(store, nodeinfos, statistics, statusInfos)
+		}
+		store.Flush()
+	}
+}
+
+// benchmarkHistory puts a statistics sample, an event and a nodeinfo change of
+// every node one by one, as the collectors do.
+func benchmarkHistory(b *testing.B, flushInterval time.Duration) {
+	log.SetLevel(log.ErrorLevel)
+	dbPath := "./bench.db"
+	defer os.RemoveAll(dbPath)
+	store, err := NewBoltStore(dbPath)
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer store.Close()
+	if store.flushJob != nil {
+		store.flushJob.Stop()
+		store.flushJob = nil
+	}
+	store.writes = newWriteBuffer(flushInterval, benchmarkNodes)
+	_, statistics, _ := benchmarkData()
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		now := time.Now()
+		for _, s := range statistics {
+			store.PutStatisticsSample(s.NodeId, NewStatisticsSample(now, s))
+			store.PutEvent(NodeEvent{Time: now, NodeId: s.NodeId, Type: EventOnline})
+			store.PutNodeinfoChanges([]NodeinfoChange{NodeinfoChange{Time: now,
+				NodeId: s.NodeId, Path: "hostname", Old: "old", New: "new"}})
+		}
+		store.Flush()
+	}
+}
+
+func BenchmarkMemoryStore5000Nodes(b *testing.B) {
+	store := NewSimpleInMemoryStore()
+	nodeinfos, statistics, statusInfos := benchmarkData()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		putSingle(store, nodeinfos, statistics, statusInfos)
+	}
+}
+
+func BenchmarkMemoryStoreBulk5000Nodes(b *testing.B) {
+	store := NewSimpleInMemoryStore()
+	nodeinfos, statistics, statusInfos := benchmarkData()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		putBulk(store, nodeinfos, statistics, statusInfos)
+	}
+}
+
+func BenchmarkBoltStoreUnbuffered5000Nodes(b *testing.B) {
+	benchmarkBoltStore(b, 0, false)
+}
+
+func BenchmarkBoltStoreBuffered5000Nodes(b *testing.B) {
+	benchmarkBoltStore(b, time.Second, false)
+}
+
+func BenchmarkBoltStoreBulk5000Nodes(b *testing.B) {
+	benchmarkBoltStore(b, time.Second, true)
+}
+
+func BenchmarkBoltStoreHistoryUnbuffered5000Nodes(b *testing.B) {
+	benchmarkHistory(b, 0)
+}
+
+func BenchmarkBoltStoreHistoryBuffered5000Nodes(b *testing.B) {
+	benchmarkHistory(b, time.Second)
+}
@@ -0,0 +1,206 @@
+package dedupe
+
+import (
+	"crypto/sha1"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/ffdo/node-informant/gluon-collector/data"
+	"github.com/ffdo/node-informant/gluon-collector/httpserver"
+	"github.com/ffdo/node-informant/gluon-collector/prometheus"
+	"github.com/gorilla/mux"
+)
+
+// DedupePipe suppresses identical responses from the same node received more
+// than once within the window, i.e. because several receivers see the same node.
+// Only the first copy is passed on to the following pipes. Additionally it
+// records which receivers saw each node, so we know from which segments a node
+// is reachable.
+type DedupePipe struct {
+	// Window is the duration in which identical responses are considered
+	// duplicates.
+	Window time.Duration
+	// ReceiverRetention is the duration after which a receiver is forgotten
+	// for a node if it didn't see this node anymore.
+	ReceiverRetention time.Duration
+	// Public is the store the receivers are published for, i.e. with the
+	// overrides and the privacy policy applied. The receivers of nodes it
+	// doesn't know are left out. Nil publishes the receivers of all nodes.
+	Public data.Nodeinfostore
+
+	lock        sync.Mutex
+	seen        map[string]time.Time
+	receivers   map[string]map[string]time.Time
+	lastCleanup time.Time
+}
+
+// NewDedupePipe creates a new DedupePipe with the given window and retention
+// for receiver information.
+func NewDedupePipe(window, receiverRetention time.Duration) *DedupePipe {
+	return &DedupePipe{
+		Window:            window,
+		ReceiverRetention: receiverRetention,
+		seen:              make(map[string]time.Time),
+		receivers:         make(map[string]map[string]time.Time),
+	}
+}
+
+// responseKey builds the key identifying a response by node id, response type
+// and a hash of its content.
+func responseKey(response data.ParsedResponse) (string, error) {
+	content, err := json.Marshal(response.ParsedData())
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%s|%s|%x", response.NodeId(), response.Type(), sha1.Sum(content)), nil
+}
+
+// cleanup forgets all responses outside of the window and all receivers outside
+// of the retention. The lock needs to be held by the caller.
+func (d *DedupePipe) cleanup(now time.Time) {
+	if now.Sub(d.lastCleanup) < d.Window {
+		return
+	}
+	d.lastCleanup = now
+	for key, firstseen := range d.seen {
+		if now.Sub(firstseen) > d.Window {
+			delete(d.seen, key)
+		}
+	}
+	for nodeId, receivers := range d.receivers {
+		for receiver, lastseen := range receivers {
+			if now.Sub(lastseen) > d.ReceiverRetention {
+				delete(receivers, receiver)
+			}
+		}
+		if len(receivers) == 0 {
+			delete(d.receivers, nodeId)
+		}
+	}
+}
+
+// isDuplicate records the response and checks whether we have seen the same
+// response within the window.
+func (d *DedupePipe) isDuplicate(response data.ParsedResponse, now time.Time) bool {
+	nodeId := response.NodeId()
+	if nodeId == "" {
+		return false
+	}
+	key, err := responseKey(response)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"error":  err,
+			"nodeid": nodeId,
+		}).Error("Can't hash response content")
+		return false
+	}
+
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	d.cleanup(now)
+	if receiver := response.Meta().Receiver; receiver != "" {
+		if _, exists := d.receivers[nodeId]; !exists {
+			d.receivers[nodeId] = make(map[string]time.Time)
+		}
+		d.receivers[nodeId][receiver] = now
+	}
+	if firstseen, exists := d.seen[key]; exists && now.Sub(firstseen) <= d.Window {
+		return true
+	}
+	d.seen[key] = now
+	return false
+}
+
+func (d *DedupePipe) Process(in chan data.ParsedResponse) chan data.ParsedResponse {
+	out := make(chan data.ParsedResponse)
+	go func() {
+		for response := range in {
+			if d.isDuplicate(response, time.Now()) {
+				prometheus.DuplicateResponses.Inc()
+				log.WithFields(log.Fields{
+					"nodeid":   response.NodeId(),
+					"type":     response.Type(),
+					"receiver": response.Meta().Receiver,
+				}).Debug("Dropping duplicate response")
+				continue
+			}
+			out <- response
+		}
+	}()
+	return out
+}
+
+// visible checks whether the node is known to the public store.
+func (d *DedupePipe) visible(nodeId string) bool {
+	if d.Public == nil {
+		return true
+	}
+	_, err := d.Public.GetNodeStatusInfo(nodeId)
+	return err == nil
+}
+
+// GetReceivers returns the names of all receivers which have seen the node
+// recently and when they saw it the last time.
+func (d *DedupePipe) GetReceivers(nodeId string) (map[string]time.Time, error) {
+	if !d.visible(nodeId) {
+		return nil, fmt.Errorf("No receiver has seen node id %s", nodeId)
+	}
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	receivers, exists := d.receivers[nodeId]
+	if !exists {
+		return nil, fmt.Errorf("No receiver has seen node id %s", nodeId)
+	}
+	result := make(map[string]time.Time, len(receivers))
+	for receiver, lastseen := range receivers {
+		result[receiver] = lastseen
+	}
+	return result, nil
+}
+
+// GetAllReceivers returns the names of the receivers which have seen a node
+// recently for all public nodes.
+func (d *DedupePipe) GetAllReceivers() map[string][]string {
+	d.lock.Lock()
+	result := make(map[string][]string, len(d.receivers))
+	for nodeId, receivers := range d.receivers {
+		names := make([]string, 0, len(receivers))
+		for receiver := range receivers {
+			names = append(names, receiver)
+		}
+		result[nodeId] = names
+	}
+	d.lock.Unlock()
+	// The public store is only accessed without holding the lock
+	for nodeId := range result {
+		if !d.visible(nodeId) {
+			delete(result, nodeId)
+		}
+	}
+	return result
+}
+
+func (d *DedupePipe) GetAllReceiversRest(w http.ResponseWriter, r *http.Request) {
+	httpserver.RespondOK(w, d.GetAllReceivers())
+}
+
+func (d *DedupePipe) GetReceiversRest(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	receivers, err := d.GetReceivers(vars["nodeid"])
+	if err == nil {
+		httpserver.RespondOK(w, receivers)
+	} else {
+		httpserver.RespondMissing(w, err)
+	}
+}
+
+func (d *DedupePipe) Routes() []httpserver.Route {
+	return []httpserver.Route{
+		httpserver.Route{"AllReceivers", "GET", "/receivers", d.GetAllReceiversRest},
+		httpserver.Route{"NodeReceivers", "GET", "/receivers/{nodeid}", d.GetReceiversRest},
+	}
+}
@@ -0,0 +1,75 @@
+package dedupe
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ffdo/node-informant/gluon-collector/config"
+	"github.com/ffdo/node-informant/gluon-collector/data"
+	"github.com/ffdo/node-informant/gluon-collector/pipeline"
+	"github.com/ffdo/node-informant/gluon-collector/prometheus"
+	cfg "github.com/olebedev/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func statisticsResponse(receiver string, clients int) data.StatisticsResponse {
+	return data.StatisticsResponse{
+		Statistics: &data.StatisticsStruct{
+			NodeId:  "e8de27252554",
+			Clients: data.ClientStatistics{Total: clients},
+		},
+		ResponseMeta: data.ResponseMeta{Receiver: receiver},
+	}
+}
+
+func TestSuppressingDuplicates(t *testing.T) {
+	assert := assert.New(t)
+	now := time.Now()
+	dedupe := NewDedupePipe(time.Minute, time.Hour)
+
+	assert.False(dedupe.isDuplicate(statisticsResponse("bat0", 3), now))
+	assert.True(dedupe.isDuplicate(statisticsResponse("bat1", 3), now))
+	assert.False(dedupe.isDuplicate(statisticsResponse("bat1", 4), now), "Changed content is no duplicate")
+	assert.False(dedupe.isDuplicate(statisticsResponse("bat0", 3), now.Add(time.Minute*2)), "The window has passed")
+	assert.False(dedupe.isDuplicate(data.ErroredResponse{}, now))
+
+	receivers, err := dedupe.GetReceivers("e8de27252554")
+	assert.Nil(err)
+	assert.Equal(2, len(receivers))
+	assert.Equal(2, len(dedupe.GetAllReceivers()["e8de27252554"]))
+
+	_, err = dedupe.GetReceivers("unknown")
+	assert.NotNil(err)
+
+	// Nodes unknown to the public store, like hidden nodes, are left out
+	dedupe.Public = data.NewSimpleInMemoryStore()
+	_, err = dedupe.GetReceivers("e8de27252554")
+	assert.NotNil(err)
+	assert.Equal(0, len(dedupe.GetAllReceivers()))
+	dedupe.Public.PutNodeStatusInfo("e8de27252554", data.NodeStatusInfo{NodeId: "e8de27252554"})
+	assert.Equal(1, len(dedupe.GetAllReceivers()))
+}
+
+func TestDedupeInProcessPipeline(t *testing.T) {
+	assert := assert.New(t)
+	config.Global = &cfg.Config{}
+	prometheus.Init()
+	processPipeline := pipeline.NewProcessPipeline(NewDedupePipe(time.Minute, time.Hour))
+	received := make(chan data.ParsedResponse, 3)
+	go processPipeline.Dequeue(func(response data.ParsedResponse) {
+		received <- response
+	})
+	processPipeline.Enqueue(statisticsResponse("bat0", 3))
+	processPipeline.Enqueue(statisticsResponse("bat1", 3))
+	processPipeline.Enqueue(statisticsResponse("bat1", 5))
+
+	first := <-received
+	second := <-received
+	assert.Equal("bat0", first.Meta().Receiver)
+	assert.Equal(5, second.ParsedData().(*data.StatisticsStruct).Clients.Total)
+	select {
+	case <-received:
+		assert.Fail("Duplicate response was passed on")
+	case <-time.After(time.Millisecond * 50):
+	}
+}
@@ -0,0 +1,23 @@
+package dedupe
+
+import (
+	"time"
+
+	"github.com/ffdo/node-informant/gluon-collector/data"
+	"github.com/ffdo/node-informant/gluon-collector/pipeline"
+	cfg "github.com/olebedev/config"
+)
+
+// PublicStore is the store the receivers of the nodes are published for. Nil
+// publishes the receivers of all nodes.
+var PublicStore data.Nodeinfostore
+
+func init() {
+	pipeline.RegisterProcessPipe("dedupe", func(store data.Nodeinfostore, options *cfg.Config) (pipeline.ProcessPipe, error) {
+		window := time.Second * time.Duration(options.UInt("window", 60))
+		retention := time.Second * time.Duration(options.UInt("receiverRetention", 86400))
+		pipe := NewDedupePipe(window, retention)
+		pipe.Public = PublicStore
+		return pipe, nil
+	})
+}
@@ -0,0 +1,301 @@
+package denylist
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/ffdo/node-informant/gluon-collector/data"
+)
+
+// Rule denies all nodes matching every criterion given. Hostnames are matched
+// as shell pattern, i.e. "test-*", and like macs ignoring the case. A node
+// matches a mac if it is its primary mac or the mac of one of its mesh
+// interfaces.
+type Rule struct {
+	Id       string `json:"id"`
+	NodeId   string `json:"node_id,omitempty"`
+	Mac      string `json:"mac,omitempty"`
+	Hostname string `json:"hostname,omitempty"`
+	SiteCode string `json:"site_code,omitempty"`
+	Comment  string `json:"comment,omitempty"`
+	// Configured rules can't be deleted via the API.
+	Configured bool `json:"configured"`
+}
+
+// Validate checks that the rule has at least one valid criterion.
+func (r Rule) Validate() error {
+	if r.NodeId == "" && r.Mac == "" && r.Hostname == "" && r.SiteCode == "" {
+		return fmt.Errorf("A rule needs a node_id, mac, hostname or site_code")
+	}
+	if _, err := path.Match(strings.ToLower(r.Hostname), ""); err != nil {
+		return fmt.Errorf("Invalid hostname pattern %s", r.Hostname)
+	}
+	return nil
+}
+
+// needsNodeinfo checks whether the rule can only be evaluated on a nodeinfo.
+func (r Rule) needsNodeinfo() bool {
+	return r.Mac != "" || r.Hostname != "" || r.SiteCode != ""
+}
+
+func (r Rule) hasMac(macs []string) bool {
+	mac := strings.ToLower(r.Mac)
+	for _, nodeMac := range macs {
+		if nodeMac == mac {
+			return true
+		}
+	}
+	return false
+}
+
+// Matches checks whether the rule denies the node. The nodeinfo may be nil if
+// only the node id is known, rules with other criteria don't match then.
+func (r Rule) Matches(nodeId string, nodeinfo *data.NodeInfo) bool {
+	if r.NodeId != "" && r.NodeId != nodeId {
+		return false
+	}
+	if !r.needsNodeinfo() {
+		return true
+	}
+	if nodeinfo == nil {
+		return false
+	}
+	if r.Mac != "" && !r.hasMac(nodeinfo.Macs()) {
+		return false
+	}
+	if r.Hostname != "" {
+		matched, _ := path.Match(strings.ToLower(r.Hostname), strings.ToLower(nodeinfo.Hostname))
+		if !matched {
+			return false
+		}
+	}
+	return r.SiteCode == "" || r.SiteCode == nodeinfo.System.SiteCode
+}
+
+// Reason describes why the rule denies a node.
+func (r Rule) Reason() string {
+	criteria := make([]string, 0, 4)
+	if r.NodeId != "" {
+		criteria = append(criteria, "node_id "+r.NodeId)
+	}
+	if r.Mac != "" {
+		criteria = append(criteria, "mac "+r.Mac)
+	}
+	if r.Hostname != "" {
+		criteria = append(criteria, "hostname "+r.Hostname)
+	}
+	if r.SiteCode != "" {
+		criteria = append(criteria, "site_code "+r.SiteCode)
+	}
+	return fmt.Sprintf("Denied by rule %s (%s)", r.Id, strings.Join(criteria, ", "))
+}
+
+// List holds the configured rules and the rules added via the API. The latter
+// are written to the file at Path if it is set, so they survive restarts. The
+// List also remembers the nodes denied by their nodeinfo, since their other
+// responses can't be matched by the rules.
+type List struct {
+	Path string
+
+	lock   sync.RWMutex
+	rules  []Rule
+	nextId int
+	// denied maps the node ids denied by their nodeinfo to the rule ids.
+	denied map[string]string
+}
+
+// listFile is the content of the file at the Path of the List.
+type listFile struct {
+	Rules  []Rule            `json:"rules"`
+	Denied map[string]string `json:"denied"`
+}
+
+// NewList creates a list of the configured rules and the rules and denied
+// nodes stored in the file at path, if any. Configured rules are numbered by
+// their position, the stored rules keep their ids.
+func NewList(configured []Rule, path string) (*List, error) {
+	list := &List{Path: path, rules: make([]Rule, 0, len(configured)),
+		denied: make(map[string]string)}
+	for i, rule := range configured {
+		if err := rule.Validate(); err != nil {
+			return nil, err
+		}
+		rule.Configured = true
+		rule.Id = "config-" + strconv.Itoa(i+1)
+		list.rules = append(list.rules, rule)
+	}
+	if path == "" {
+		return list, nil
+	}
+	content, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return list, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	stored := listFile{}
+	if err := json.Unmarshal(content, &stored); err != nil {
+		// Files written before the denied nodes were kept only contain the rules
+		if err := json.Unmarshal(content, &stored.Rules); err != nil {
+			return nil, fmt.Errorf("Invalid deny list file %s: %v", path, err)
+		}
+	}
+	for _, rule := range stored.Rules {
+		rule.Configured = false
+		if id, err := strconv.Atoi(rule.Id); err == nil && id > list.nextId {
+			list.nextId = id
+		}
+		list.rules = append(list.rules, rule)
+	}
+	// Rules written by hand may lack an id
+	for i := range list.rules {
+		if list.rules[i].Id == "" {
+			list.rules[i].Id = list.newId()
+		}
+	}
+	for nodeId, ruleId := range stored.Denied {
+		if list.rule(ruleId) != nil {
+			list.denied[nodeId] = ruleId
+		}
+	}
+	return list, nil
+}
+
+// newId returns the next rule id. The lock needs to be held by the caller.
+func (l *List) newId() string {
+	l.nextId++
+	return strconv.Itoa(l.nextId)
+}
+
+// rule returns the rule with the id or nil. The lock needs to be held by the
+// caller.
+func (l *List) rule(id string) *Rule {
+	for _, rule := range l.rules {
+		if rule.Id == id {
+			matched := rule
+			return &matched
+		}
+	}
+	return nil
+}
+
+// Rules returns all rules in the order they have been added.
+func (l *List) Rules() []Rule {
+	l.lock.RLock()
+	defer l.lock.RUnlock()
+	return append([]Rule{}, l.rules...)
+}
+
+// Match returns the first rule denying the node or nil if the node isn't
+// denied.
+func (l *List) Match(nodeId string, nodeinfo *data.NodeInfo) *Rule {
+	l.lock.RLock()
+	defer l.lock.RUnlock()
+	for _, rule := range l.rules {
+		if rule.Matches(nodeId, nodeinfo) {
+			matched := rule
+			return &matched
+		}
+	}
+	return nil
+}
+
+// Denied returns the rule which denied the nodeinfo of the node or nil.
+func (l *List) Denied(nodeId string) *Rule {
+	l.lock.RLock()
+	defer l.lock.RUnlock()
+	ruleId, denied := l.denied[nodeId]
+	if !denied {
+		return nil
+	}
+	return l.rule(ruleId)
+}
+
+// Deny remembers that the rule denied the nodeinfo of the node. It returns
+// whether the node hasn't been denied before.
+func (l *List) Deny(nodeId, ruleId string) (bool, error) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	previous, denied := l.denied[nodeId]
+	if previous == ruleId {
+		return false, nil
+	}
+	l.denied[nodeId] = ruleId
+	return !denied, l.write()
+}
+
+// Allow forgets that the node has been denied by its nodeinfo.
+func (l *List) Allow(nodeId string) error {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	if _, denied := l.denied[nodeId]; !denied {
+		return nil
+	}
+	delete(l.denied, nodeId)
+	return l.write()
+}
+
+// Add appends the rule to the list and returns it with its id.
+func (l *List) Add(rule Rule) (Rule, error) {
+	if err := rule.Validate(); err != nil {
+		return rule, err
+	}
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	rule.Configured = false
+	rule.Id = l.newId()
+	l.rules = append(l.rules, rule)
+	return rule, l.write()
+}
+
+// Remove deletes the rule with the given id. Configured rules can't be removed.
+func (l *List) Remove(id string) error {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	for i, rule := range l.rules {
+		if rule.Id != id {
+			continue
+		}
+		if rule.Configured {
+			return fmt.Errorf("Rule %s is configured and can't be removed", id)
+		}
+		l.rules = append(l.rules[:i], l.rules[i+1:]...)
+		for nodeId, ruleId := range l.denied {
+			if ruleId == id {
+				delete(l.denied, nodeId)
+			}
+		}
+		return l.write()
+	}
+	return fmt.Errorf("No rule with id %s", id)
+}
+
+// write stores the rules added via the API and the denied nodes. The lock
+// needs to be held by the caller.
+func (l *List) write() error {
+	if l.Path == "" {
+		return nil
+	}
+	stored := listFile{Rules: make([]Rule, 0, len(l.rules)), Denied: l.denied}
+	for _, rule := range l.rules {
+		if !rule.Configured {
+			stored.Rules = append(stored.Rules, rule)
+		}
+	}
+	content, err := json.MarshalIndent(stored, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmpPath := l.Path + ".tmp"
+	if err := ioutil.WriteFile(tmpPath, content, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, l.Path)
+}
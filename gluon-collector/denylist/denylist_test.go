@@ -0,0 +1,162 @@
+package denylist
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/ffdo/node-informant/gluon-collector/config"
+	"github.com/ffdo/node-informant/gluon-collector/data"
+	"github.com/ffdo/node-informant/gluon-collector/pipeline"
+	"github.com/ffdo/node-informant/gluon-collector/prometheus"
+	"github.com/ffdo/node-informant/gluon-collector/quarantine"
+	cfg "github.com/olebedev/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func testNodeinfo(nodeId, hostname, siteCode, mac string) data.NodeInfo {
+	nodeinfo := data.NodeInfo{NodeId: nodeId, Hostname: hostname}
+	nodeinfo.System.SiteCode = siteCode
+	nodeinfo.Network.Mac = mac
+	return nodeinfo
+}
+
+func TestMatchingRules(t *testing.T) {
+	assert := assert.New(t)
+	nodeinfo := testNodeinfo("c46e1fb64f70", "FF-DO-Test-1", "ffdo", "c4:6e:1f:b6:4f:70")
+
+	assert.True(Rule{NodeId: "c46e1fb64f70"}.Matches("c46e1fb64f70", nil))
+	assert.False(Rule{NodeId: "c46e1fb64f71"}.Matches("c46e1fb64f70", &nodeinfo))
+	assert.True(Rule{Mac: "C4:6E:1F:B6:4F:70"}.Matches("c46e1fb64f70", &nodeinfo))
+	assert.False(Rule{Mac: "C4:6E:1F:B6:4F:70"}.Matches("c46e1fb64f70", nil), "Macs are only known from nodeinfos")
+	assert.True(Rule{Hostname: "ff-do-test-*"}.Matches("c46e1fb64f70", &nodeinfo))
+	assert.False(Rule{Hostname: "ff-do-test"}.Matches("c46e1fb64f70", &nodeinfo))
+	assert.True(Rule{SiteCode: "ffdo"}.Matches("c46e1fb64f70", &nodeinfo))
+	assert.False(Rule{SiteCode: "ffdo", Hostname: "other-*"}.Matches("c46e1fb64f70", &nodeinfo),
+		"All criteria of a rule need to match")
+
+	assert.NotNil(Rule{}.Validate())
+	assert.NotNil(Rule{Hostname: "[test"}.Validate())
+}
+
+func TestManagingRules(t *testing.T) {
+	assert := assert.New(t)
+	path := "./denylist.json"
+	defer os.RemoveAll(path)
+
+	list, err := NewList([]Rule{Rule{SiteCode: "ffmuc"}}, path)
+	assert.Nil(err)
+	added, err := list.Add(Rule{NodeId: "a", Comment: "Test node"})
+	assert.Nil(err)
+	assert.Equal("1", added.Id)
+	added, err = list.Add(Rule{NodeId: "b"})
+	assert.Nil(err)
+	assert.Equal("2", added.Id)
+	_, err = list.Add(Rule{})
+	assert.NotNil(err)
+	assert.NotNil(list.Remove("config-1"), "Configured rules can't be removed")
+	assert.Nil(list.Remove("1"))
+
+	reread, err := NewList([]Rule{Rule{SiteCode: "ffmuc"}, Rule{SiteCode: "ffdo"}}, path)
+	assert.Nil(err)
+	assert.Equal(3, len(reread.Rules()))
+	assert.Equal("2", reread.Match("b", nil).Id, "Stored rules keep their ids")
+	added, err = reread.Add(Rule{NodeId: "c"})
+	assert.Nil(err)
+	assert.Equal("3", added.Id, "Ids of removed rules are not reused")
+	assert.Nil(reread.Remove("2"))
+	assert.Nil(reread.Match("b", nil))
+	assert.NotNil(reread.Remove("2"))
+}
+
+func TestKeepingDeniedNodes(t *testing.T) {
+	assert := assert.New(t)
+	path := "./denylist.json"
+	defer os.RemoveAll(path)
+
+	list, err := NewList(nil, path)
+	assert.Nil(err)
+	rule, err := list.Add(Rule{Hostname: "test-*"})
+	assert.Nil(err)
+	newlyDenied, err := list.Deny("a", rule.Id)
+	assert.Nil(err)
+	assert.True(newlyDenied)
+	newlyDenied, err = list.Deny("a", rule.Id)
+	assert.Nil(err)
+	assert.False(newlyDenied)
+
+	reread, err := NewList(nil, path)
+	assert.Nil(err)
+	assert.NotNil(reread.Denied("a"), "Denied nodes are known after a restart")
+	assert.Nil(reread.Remove(rule.Id))
+	assert.Nil(reread.Denied("a"))
+}
+
+func TestDroppingDeniedNodes(t *testing.T) {
+	assert := assert.New(t)
+	config.Global = &cfg.Config{}
+	prometheus.Init()
+	store := data.NewSimpleInMemoryStore()
+	store.PutNodeInfo(testNodeinfo("stored", "test-stored", "ffdo", ""))
+	store.PutNodeStatusInfo("stored", data.NodeStatusInfo{NodeId: "stored", Online: true})
+	list, err := NewList([]Rule{Rule{Hostname: "test-*"}}, "")
+	assert.Nil(err)
+	area := quarantine.NewArea(10)
+	denyPipe := NewDenyPipe(store, list, area)
+	_, err = store.GetNodeInfo("stored")
+	assert.NotNil(err, "Stored nodes are purged")
+
+	processPipeline := pipeline.NewProcessPipeline(denyPipe)
+	received := make(chan data.ParsedResponse, 4)
+	go processPipeline.Dequeue(func(response data.ParsedResponse) {
+		received <- response
+	})
+	processPipeline.Enqueue(data.NodeinfoResponse{Nodeinfo: testNodeinfo("a", "test-a", "ffdo", "")})
+	processPipeline.Enqueue(data.StatisticsResponse{Statistics: &data.StatisticsStruct{NodeId: "a"}})
+	processPipeline.Enqueue(data.NodeinfoResponse{Nodeinfo: testNodeinfo("b", "node-b", "ffdo", "")})
+	processPipeline.Enqueue(data.StatisticsResponse{Statistics: &data.StatisticsStruct{NodeId: "b"}})
+
+	first := <-received
+	second := <-received
+	assert.Equal("b", first.NodeId())
+	assert.Equal("b", second.NodeId())
+	select {
+	case <-received:
+		assert.Fail("Response of a denied node was passed on")
+	case <-time.After(time.Millisecond * 50):
+	}
+	entries, err := area.Get("a")
+	assert.Nil(err)
+	assert.Equal(2, len(entries), "The statistics of nodes denied by their nodeinfo are denied too")
+}
+
+func TestExpiringNodesDeniedLater(t *testing.T) {
+	assert := assert.New(t)
+	config.Global = &cfg.Config{}
+	prometheus.Init()
+	store := data.NewSimpleInMemoryStore()
+	list, err := NewList(nil, "")
+	assert.Nil(err)
+	denyPipe := NewDenyPipe(store, list, nil)
+	expired := make(chan data.Event, 4)
+	store.Events().Subscribe(func(event data.Event) {
+		expired <- event
+	}, data.NodeExpired)
+
+	// The node is stored and only matches after changing its hostname
+	store.PutNodeInfo(testNodeinfo("a", "node-a", "ffdo", ""))
+	store.PutNodeStatusInfo("a", data.NodeStatusInfo{NodeId: "a", Online: true})
+	_, err = list.Add(Rule{Hostname: "test-*"})
+	assert.Nil(err)
+	assert.Nil(denyPipe.match(data.NodeinfoResponse{Nodeinfo: testNodeinfo("a", "node-a", "ffdo", "")}))
+	assert.NotNil(denyPipe.match(data.NodeinfoResponse{Nodeinfo: testNodeinfo("a", "test-a", "ffdo", "")}))
+
+	_, err = store.GetNodeStatusInfo("a")
+	assert.NotNil(err, "Nodes denied by a new nodeinfo are deleted")
+	select {
+	case event := <-expired:
+		assert.Equal("a", event.NodeId)
+	case <-time.After(time.Second):
+		assert.Fail("The node didn't expire")
+	}
+}
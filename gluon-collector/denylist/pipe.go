@@ -0,0 +1,180 @@
+package denylist
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+
+	"github.com/ffdo/node-informant/gluon-collector/data"
+	"github.com/ffdo/node-informant/gluon-collector/httpserver"
+	"github.com/ffdo/node-informant/gluon-collector/prometheus"
+	"github.com/ffdo/node-informant/gluon-collector/quarantine"
+	"github.com/gorilla/mux"
+)
+
+// DenyPipe drops all responses of the nodes denied by the List. If a
+// quarantine Area is set, the responses are moved there instead. Since only
+// nodeinfos carry macs, hostnames and site codes, the List remembers the nodes
+// denied by them, so their statistics and neighbours are dropped as well.
+type DenyPipe struct {
+	Store      data.Nodeinfostore
+	List       *List
+	Quarantine *quarantine.Area
+}
+
+// NewDenyPipe creates a DenyPipe and removes the nodes denied by the list from
+// the store.
+func NewDenyPipe(store data.Nodeinfostore, list *List, area *quarantine.Area) *DenyPipe {
+	pipe := &DenyPipe{Store: store, List: list, Quarantine: area}
+	pipe.Purge()
+	return pipe
+}
+
+// logListError logs errors writing the deny list file. The denied node is
+// dropped anyway.
+func logListError(err error, nodeId string) {
+	if err != nil {
+		log.WithFields(log.Fields{
+			"error":  err,
+			"nodeid": nodeId,
+		}).Error("Can't write deny list file")
+	}
+}
+
+// match returns the rule denying the node of the response or nil. A node which
+// gets denied by its nodeinfo is removed from the store.
+func (d *DenyPipe) match(response data.ParsedResponse) *Rule {
+	nodeId := response.NodeId()
+	if response.Type() == "nodeinfo" {
+		nodeinfo := response.ParsedData().(data.NodeInfo)
+		rule := d.List.Match(nodeId, &nodeinfo)
+		if rule == nil {
+			logListError(d.List.Allow(nodeId), nodeId)
+			return nil
+		}
+		newlyDenied, err := d.List.Deny(nodeId, rule.Id)
+		logListError(err, nodeId)
+		if newlyDenied {
+			d.remove(nodeId, rule.Id, time.Now())
+		}
+		return rule
+	}
+	if rule := d.List.Match(nodeId, nil); rule != nil {
+		return rule
+	}
+	return d.List.Denied(nodeId)
+}
+
+func (d *DenyPipe) Process(in chan data.ParsedResponse) chan data.ParsedResponse {
+	out := make(chan data.ParsedResponse)
+	go func() {
+		for response := range in {
+			if rule := d.match(response); rule != nil {
+				prometheus.DeniedResponses.Inc()
+				if d.Quarantine != nil {
+					d.Quarantine.Put(rule.Reason(), response)
+				}
+				continue
+			}
+			out <- response
+		}
+	}()
+	return out
+}
+
+// remove deletes the denied node from the store. Nodes with a status expire
+// like nodes gone forever, so the event log and all subscribers learn about it.
+func (d *DenyPipe) remove(nodeId, ruleId string, now time.Time) {
+	status, statusErr := d.Store.GetNodeStatusInfo(nodeId)
+	_, nodeinfoErr := d.Store.GetNodeInfo(nodeId)
+	if statusErr != nil && nodeinfoErr != nil {
+		return
+	}
+	log.WithFields(log.Fields{
+		"nodeid": nodeId,
+		"rule":   ruleId,
+	}).Info("Deleting node denied by the deny list")
+	if statusErr != nil {
+		d.Store.DeleteNode(nodeId)
+		return
+	}
+	data.ExpireNode(d.Store, status, now)
+}
+
+// Purge removes all stored nodes denied by the list from the store. Nodes are
+// matched with their stored nodeinfo if there is one.
+func (d *DenyPipe) Purge() {
+	now := time.Now()
+	nodeinfos := make(map[string]*data.NodeInfo)
+	for _, nodeinfo := range d.Store.GetNodeInfos() {
+		info := nodeinfo
+		nodeinfos[nodeinfo.NodeId] = &info
+	}
+	for _, status := range d.Store.GetNodeStatusInfos() {
+		if _, known := nodeinfos[status.NodeId]; !known {
+			nodeinfos[status.NodeId] = nil
+		}
+	}
+	for nodeId, nodeinfo := range nodeinfos {
+		rule := d.List.Match(nodeId, nodeinfo)
+		if rule != nil && nodeinfo != nil && rule.needsNodeinfo() {
+			_, err := d.List.Deny(nodeId, rule.Id)
+			logListError(err, nodeId)
+		}
+		if rule == nil {
+			rule = d.List.Denied(nodeId)
+		}
+		if rule != nil {
+			d.remove(nodeId, rule.Id, now)
+		}
+	}
+}
+
+func (d *DenyPipe) GetRulesRest(w http.ResponseWriter, r *http.Request) {
+	httpserver.RespondOK(w, d.List.Rules())
+}
+
+// PostRuleRest adds the rule in the request body to the list and removes the
+// nodes it denies from the store.
+func (d *DenyPipe) PostRuleRest(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+	rule := Rule{}
+	if err := json.NewDecoder(r.Body).Decode(&rule); err != nil {
+		httpserver.RespondBadRequest(w, fmt.Errorf("Invalid rule: %v", err))
+		return
+	}
+	rule, err := d.List.Add(rule)
+	if err != nil {
+		httpserver.RespondBadRequest(w, err)
+		return
+	}
+	d.Purge()
+	httpserver.RespondOK(w, rule)
+}
+
+// DeleteRuleRest removes the rule. Nodes denied by it reappear with their next
+// responses.
+func (d *DenyPipe) DeleteRuleRest(w http.ResponseWriter, r *http.Request) {
+	if err := d.List.Remove(mux.Vars(r)["id"]); err != nil {
+		httpserver.RespondBadRequest(w, err)
+		return
+	}
+	httpserver.RespondOK(w, "Rule removed")
+}
+
+func (d *DenyPipe) Routes() []httpserver.Route {
+	routes := []httpserver.Route{
+		httpserver.Route{"DenyList", "GET", "/admin/denylist", httpserver.RequireAuth(d.GetRulesRest)},
+		httpserver.Route{"AddDenyRule", "POST", "/admin/denylist", httpserver.RequireAuth(d.PostRuleRest)},
+		httpserver.Route{"RemoveDenyRule", "DELETE", "/admin/denylist/{id}", httpserver.RequireAuth(d.DeleteRuleRest)},
+	}
+	if d.Quarantine != nil {
+		routes = append(routes,
+			httpserver.Route{"DenyQuarantine", "GET", "/admin/denylist/quarantine", httpserver.RequireAuth(d.Quarantine.GetAllRest)},
+			httpserver.Route{"NodeDenyQuarantine", "GET", "/admin/denylist/quarantine/{nodeid}", httpserver.RequireAuth(d.Quarantine.GetNodeRest)})
+	}
+	return routes
+}
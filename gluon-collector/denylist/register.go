@@ -0,0 +1,47 @@
+package denylist
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/ffdo/node-informant/gluon-collector/data"
+	"github.com/ffdo/node-informant/gluon-collector/pipeline"
+	"github.com/ffdo/node-informant/gluon-collector/quarantine"
+	cfg "github.com/olebedev/config"
+)
+
+// configuredRules decodes the rules option, a list of maps with the fields of
+// the Rule.
+func configuredRules(options *cfg.Config) ([]Rule, error) {
+	list, err := options.List("rules")
+	if err != nil {
+		return nil, nil
+	}
+	encoded, err := json.Marshal(list)
+	if err != nil {
+		return nil, err
+	}
+	rules := make([]Rule, 0, len(list))
+	if err := json.Unmarshal(encoded, &rules); err != nil {
+		return nil, fmt.Errorf("Invalid deny list rules: %v", err)
+	}
+	return rules, nil
+}
+
+func init() {
+	pipeline.RegisterProcessPipe("denylist", func(store data.Nodeinfostore, options *cfg.Config) (pipeline.ProcessPipe, error) {
+		rules, err := configuredRules(options)
+		if err != nil {
+			return nil, err
+		}
+		list, err := NewList(rules, options.UString("path", ""))
+		if err != nil {
+			return nil, err
+		}
+		var area *quarantine.Area
+		if options.UBool("quarantine", false) {
+			area = quarantine.NewArea(options.UInt("quarantineSize", 10))
+		}
+		return NewDenyPipe(store, list, area), nil
+	})
+}
@@ -0,0 +1,379 @@
+package gateways
+
+import (
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+
+	conf "github.com/ffdo/node-informant/gluon-collector/config"
+	"github.com/ffdo/node-informant/gluon-collector/data"
+	"github.com/ffdo/node-informant/gluon-collector/httpserver"
+	"github.com/ffdo/node-informant/gluon-collector/prometheus"
+	"github.com/ffdo/node-informant/gluon-collector/scheduler"
+)
+
+// Gateway is a gateway mac reported by the nodes together with the node it
+// belongs to, if that node is known.
+type Gateway struct {
+	Mac      string `json:"mac"`
+	NodeId   string `json:"node_id,omitempty"`
+	Hostname string `json:"hostname,omitempty"`
+	// Users is the number of online nodes currently using the gateway.
+	Users int `json:"users"`
+	// Clients is the number of clients of these nodes.
+	Clients int `json:"clients"`
+	// Switches is the number of nodes which switched to or from the gateway
+	// within the SwitchWindow.
+	Switches int       `json:"switches"`
+	LastUsed time.Time `json:"last_used"`
+}
+
+// Switch is a node switching from one gateway to another.
+type Switch struct {
+	NodeId string    `json:"node_id"`
+	Time   time.Time `json:"time"`
+	From   string    `json:"from"`
+	To     string    `json:"to"`
+}
+
+// FlappingNode is a node which switched its gateway at least FlapThreshold
+// times within the SwitchWindow.
+type FlappingNode struct {
+	NodeId   string `json:"node_id"`
+	Switches int    `json:"switches"`
+}
+
+// Tracker maps the gateway macs reported in the statistics of the nodes to the
+// nodes owning them and maintains the Gateway flag of their status infos. A
+// gateway which hasn't been used by any online node for RemoveAfter is removed
+// from the store and its node loses the flag. Additionally the Tracker counts
+// the nodes and clients served by every gateway and keeps the gateway switches
+// of the nodes within the SwitchWindow, so flapping nodes can be found.
+type Tracker struct {
+	Store data.Nodeinfostore
+	// Public is the store the gateways are published from, i.e. with the
+	// overrides and the privacy policy applied. Nodes it doesn't know are left
+	// out of the gateways, switches and flapping nodes.
+	Public      data.Nodeinfostore
+	RemoveAfter time.Duration
+	// SwitchWindow is the duration for which gateway switches are kept.
+	SwitchWindow time.Duration
+	// FlapThreshold is the number of switches within the SwitchWindow from
+	// which on a node is considered flapping.
+	FlapThreshold int
+
+	lock         sync.Mutex
+	lastUsed     map[string]time.Time
+	gateways     []Gateway
+	switches     []Switch
+	updateJob    *scheduler.ScheduledJob
+	subscription *data.Subscription
+}
+
+// NewTracker creates a Tracker for the store publishing from the public store,
+// configured from the gateways section of the global configuration.
+func NewTracker(store, public data.Nodeinfostore) *Tracker {
+	return &Tracker{
+		Store:         store,
+		Public:        public,
+		RemoveAfter:   time.Duration(conf.UInt("gateways.removeAfterMinutes", 60)) * time.Minute,
+		SwitchWindow:  time.Duration(conf.UInt("gateways.switchWindowMinutes", 60)) * time.Minute,
+		FlapThreshold: conf.UInt("gateways.flapThreshold", 3),
+		lastUsed:      make(map[string]time.Time),
+		gateways:      make([]Gateway, 0),
+		switches:      make([]Switch, 0),
+	}
+}
+
+// Start records the gateway switches published by the store and updates the
+// gateways once a minute.
+func (t *Tracker) Start() {
+	t.subscription = t.Store.Events().Subscribe(t.RecordSwitch, data.GatewayChanged)
+	t.updateJob = scheduler.NewJob(time.Minute*1, func() {
+		t.Update(time.Now())
+	}, true)
+}
+
+// Close stops the updates.
+func (t *Tracker) Close() error {
+	if t.updateJob != nil {
+		t.updateJob.Stop()
+	}
+	if t.subscription != nil {
+		t.subscription.Unsubscribe()
+	}
+	return nil
+}
+
+// RecordSwitch records the GatewayChanged event. Nodes getting or losing their
+// gateway are switches too.
+func (t *Tracker) RecordSwitch(event data.Event) {
+	if event.Type != data.GatewayChanged {
+		return
+	}
+	prometheus.GatewaySwitches.WithLabelValues(event.From, event.To).Inc()
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	t.pruneSwitches(event.Time)
+	t.switches = append(t.switches, Switch{NodeId: event.NodeId, Time: event.Time, From: event.From, To: event.To})
+	count := 0
+	for _, s := range t.switches {
+		if s.NodeId == event.NodeId {
+			count++
+		}
+	}
+	if count == t.FlapThreshold {
+		log.WithFields(log.Fields{
+			"nodeid":   event.NodeId,
+			"switches": count,
+			"window":   t.SwitchWindow.String(),
+		}).Warn("Node is flapping between gateways")
+	}
+}
+
+// pruneSwitches forgets the switches outside of the SwitchWindow. The lock needs
+// to be held by the caller.
+func (t *Tracker) pruneSwitches(now time.Time) {
+	kept := t.switches[:0]
+	for _, s := range t.switches {
+		if now.Sub(s.Time) <= t.SwitchWindow {
+			kept = append(kept, s)
+		}
+	}
+	t.switches = kept
+}
+
+// users counts the online nodes and their clients by the gateway they use.
+func (t *Tracker) users() (map[string]int, map[string]int) {
+	users := make(map[string]int)
+	clients := make(map[string]int)
+	for _, statistics := range t.Store.GetAllStatistics() {
+		if statistics.Gateway == "" {
+			continue
+		}
+		status, err := t.Store.GetNodeStatusInfo(statistics.NodeId)
+		if err == nil && status.Online {
+			users[statistics.Gateway]++
+			clients[statistics.Gateway] += statistics.Clients.Total
+		}
+	}
+	return users, clients
+}
+
+// Update resolves all gateways to their nodes, removes gateways not used
+// anymore and sets the Gateway flag of exactly the nodes owning a gateway.
+// Gateways of nodes hidden from the public store aren't published. The store is
+// only accessed without holding the lock.
+func (t *Tracker) Update(now time.Time) {
+	users, clients := t.users()
+	stored := t.Store.GetGateways()
+
+	t.lock.Lock()
+	for mac := range users {
+		t.lastUsed[mac] = now
+	}
+	t.pruneSwitches(now)
+	switches := make(map[string]int)
+	for _, s := range t.switches {
+		switches[s.From]++
+		switches[s.To]++
+	}
+	removed := make([]string, 0)
+	gateways := make([]Gateway, 0, len(stored))
+	for _, mac := range stored {
+		lastUsed, known := t.lastUsed[mac]
+		if !known {
+			// Gateways stored before the start get the full time to be used again
+			lastUsed = now
+			t.lastUsed[mac] = now
+		}
+		if now.Sub(lastUsed) > t.RemoveAfter {
+			log.WithFields(log.Fields{
+				"gateway-mac": mac,
+				"lastUsed":    lastUsed,
+			}).Info("Removing gateway which is not used anymore")
+			removed = append(removed, mac)
+			delete(t.lastUsed, mac)
+			continue
+		}
+		gateways = append(gateways, Gateway{Mac: mac, Users: users[mac],
+			Clients: clients[mac], Switches: switches[mac], LastUsed: lastUsed})
+	}
+	t.lock.Unlock()
+
+	for _, mac := range removed {
+		t.Store.RemoveGateway(mac)
+	}
+	gatewayNodes := make(map[string]bool)
+	published := gateways[:0]
+	for _, gateway := range gateways {
+		nodeId, err := t.Store.LookupMac(gateway.Mac)
+		if err != nil {
+			published = append(published, gateway)
+			continue
+		}
+		gatewayNodes[nodeId] = true
+		if gateway.NodeId, err = t.Public.LookupMac(gateway.Mac); err != nil {
+			continue
+		}
+		if nodeinfo, err := t.Public.GetNodeInfo(gateway.NodeId); err == nil {
+			gateway.Hostname = nodeinfo.Hostname
+		}
+		published = append(published, gateway)
+	}
+	gateways = published
+	sort.Sort(byMac(gateways))
+
+	t.lock.Lock()
+	previous := t.gateways
+	t.gateways = gateways
+	flapping := t.flapping()
+	t.lock.Unlock()
+
+	updateMetrics(previous, gateways)
+	prometheus.FlappingNodes.Set(float64(len(t.visibleFlapping(flapping))))
+
+	for _, status := range t.Store.GetNodeStatusInfos() {
+		if status.NodeId != "" && status.Gateway != gatewayNodes[status.NodeId] {
+			t.setFlag(status.NodeId, gatewayNodes[status.NodeId])
+		}
+	}
+}
+
+// updateMetrics sets the users and clients of the gateways and deletes the
+// series of the gateways which are gone. The series are never reset, so every
+// scrape sees all current gateways.
+func updateMetrics(previous, gateways []Gateway) {
+	current := make(map[string]bool, len(gateways))
+	for _, gateway := range gateways {
+		current[gateway.Mac] = true
+		prometheus.GatewayNodes.WithLabelValues(gateway.Mac).Set(float64(gateway.Users))
+		prometheus.GatewayClients.WithLabelValues(gateway.Mac).Set(float64(gateway.Clients))
+	}
+	for _, gateway := range previous {
+		if !current[gateway.Mac] {
+			prometheus.GatewayNodes.DeleteLabelValues(gateway.Mac)
+			prometheus.GatewayClients.DeleteLabelValues(gateway.Mac)
+		}
+	}
+}
+
+// setFlag changes the Gateway flag of the node in a single store update, so
+// the status written by the pipeline in the meantime is kept.
+func (t *Tracker) setFlag(nodeId string, gateway bool) {
+	t.Store.UpdateNodeStatusInfo(nodeId, func(status *data.NodeStatusInfo) bool {
+		if status.Gateway == gateway {
+			return false
+		}
+		log.WithFields(log.Fields{
+			"nodeid":  nodeId,
+			"gateway": gateway,
+		}).Info("Changing gateway flag of node")
+		status.Gateway = gateway
+		return true
+	})
+}
+
+// Gateways returns the gateways as of the last update ordered by mac.
+func (t *Tracker) Gateways() []Gateway {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	return append([]Gateway{}, t.gateways...)
+}
+
+// Switches returns the gateway switches of the public nodes within the
+// SwitchWindow in the order they have been published.
+func (t *Tracker) Switches() []Switch {
+	t.lock.Lock()
+	all := append([]Switch{}, t.switches...)
+	t.lock.Unlock()
+	switches := all[:0]
+	for _, s := range all {
+		if t.visible(s.NodeId) {
+			switches = append(switches, s)
+		}
+	}
+	return switches
+}
+
+// Flapping returns the public nodes which switched their gateway at least
+// FlapThreshold times within the SwitchWindow.
+func (t *Tracker) Flapping() []FlappingNode {
+	t.lock.Lock()
+	flapping := t.flapping()
+	t.lock.Unlock()
+	return t.visibleFlapping(flapping)
+}
+
+// visibleFlapping leaves the nodes out which aren't public.
+func (t *Tracker) visibleFlapping(flapping []FlappingNode) []FlappingNode {
+	nodes := flapping[:0]
+	for _, node := range flapping {
+		if t.visible(node.NodeId) {
+			nodes = append(nodes, node)
+		}
+	}
+	return nodes
+}
+
+// visible checks whether the node is known to the public store.
+func (t *Tracker) visible(nodeId string) bool {
+	_, err := t.Public.GetNodeStatusInfo(nodeId)
+	return err == nil
+}
+
+// flapping counts the switches by node. The lock needs to be held by the caller.
+func (t *Tracker) flapping() []FlappingNode {
+	counts := make(map[string]int)
+	for _, s := range t.switches {
+		counts[s.NodeId]++
+	}
+	nodes := make([]FlappingNode, 0)
+	for nodeId, count := range counts {
+		if count >= t.FlapThreshold {
+			nodes = append(nodes, FlappingNode{NodeId: nodeId, Switches: count})
+		}
+	}
+	sort.Sort(bySwitches(nodes))
+	return nodes
+}
+
+type bySwitches []FlappingNode
+
+func (f bySwitches) Len() int      { return len(f) }
+func (f bySwitches) Swap(i, j int) { f[i], f[j] = f[j], f[i] }
+func (f bySwitches) Less(i, j int) bool {
+	if f[i].Switches != f[j].Switches {
+		return f[i].Switches > f[j].Switches
+	}
+	return f[i].NodeId < f[j].NodeId
+}
+
+type byMac []Gateway
+
+func (g byMac) Len() int           { return len(g) }
+func (g byMac) Swap(i, j int)      { g[i], g[j] = g[j], g[i] }
+func (g byMac) Less(i, j int) bool { return g[i].Mac < g[j].Mac }
+
+func (t *Tracker) GetGatewaysRest(w http.ResponseWriter, r *http.Request) {
+	httpserver.RespondOK(w, t.Gateways())
+}
+
+func (t *Tracker) GetSwitchesRest(w http.ResponseWriter, r *http.Request) {
+	httpserver.RespondOK(w, t.Switches())
+}
+
+func (t *Tracker) GetFlappingRest(w http.ResponseWriter, r *http.Request) {
+	httpserver.RespondOK(w, t.Flapping())
+}
+
+func (t *Tracker) Routes() []httpserver.Route {
+	return []httpserver.Route{
+		httpserver.Route{"Gateways", "GET", "/gateways", t.GetGatewaysRest},
+		httpserver.Route{"GatewaySwitches", "GET", "/gateways/switches", t.GetSwitchesRest},
+		httpserver.Route{"FlappingNodes", "GET", "/gateways/flapping", t.GetFlappingRest},
+	}
+}
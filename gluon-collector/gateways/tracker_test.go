@@ -0,0 +1,150 @@
+package gateways
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	cfg "github.com/olebedev/config"
+
+	"github.com/ffdo/node-informant/gluon-collector/config"
+	"github.com/ffdo/node-informant/gluon-collector/data"
+	"github.com/ffdo/node-informant/gluon-collector/prometheus"
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestTracker(store data.Nodeinfostore) *Tracker {
+	config.Global = &cfg.Config{}
+	prometheus.Init()
+	return NewTracker(store, store)
+}
+
+func putNode(store data.Nodeinfostore, nodeId, mac, gateway string, online bool) {
+	nodeinfo := data.NodeInfo{NodeId: nodeId, Hostname: "host-" + nodeId}
+	nodeinfo.Network.Mac = mac
+	store.PutNodeInfo(nodeinfo)
+	store.PutStatistics(data.StatisticsStruct{NodeId: nodeId, Gateway: gateway,
+		Clients: data.ClientStatistics{Total: 5}})
+	store.PutNodeStatusInfo(nodeId, data.NodeStatusInfo{NodeId: nodeId, Online: online,
+		Lastseen: time.Now().Format(data.TimeFormat)})
+	if gateway != "" {
+		store.PutGateway(gateway)
+	}
+}
+
+func isGatewayNode(store data.Nodeinfostore, nodeId string) bool {
+	status, _ := store.GetNodeStatusInfo(nodeId)
+	return status.Gateway
+}
+
+func TestResolvingGateways(t *testing.T) {
+	assert := assert.New(t)
+	store := data.NewSimpleInMemoryStore()
+	tracker := newTestTracker(store)
+	tracker.RemoveAfter = time.Hour
+	now := time.Now()
+
+	putNode(store, "gw1", "de:ad:be:ef:00:01", "", true)
+	putNode(store, "a", "de:ad:be:ef:00:0a", "de:ad:be:ef:00:01", true)
+	putNode(store, "b", "de:ad:be:ef:00:0b", "de:ad:be:ef:00:01", true)
+	putNode(store, "c", "de:ad:be:ef:00:0c", "de:ad:be:ef:00:01", false)
+	putNode(store, "d", "de:ad:be:ef:00:0d", "de:ad:be:ef:00:99", true)
+
+	tracker.Update(now)
+	assert.Equal([]Gateway{
+		{Mac: "de:ad:be:ef:00:01", NodeId: "gw1", Hostname: "host-gw1", Users: 2, Clients: 10, LastUsed: now},
+		{Mac: "de:ad:be:ef:00:99", Users: 1, Clients: 5, LastUsed: now},
+	}, tracker.Gateways())
+	assert.True(isGatewayNode(store, "gw1"))
+	assert.False(isGatewayNode(store, "a"))
+}
+
+func TestRemovingUnusedGateways(t *testing.T) {
+	assert := assert.New(t)
+	store := data.NewSimpleInMemoryStore()
+	tracker := newTestTracker(store)
+	tracker.RemoveAfter = time.Hour
+	now := time.Now()
+
+	putNode(store, "gw1", "de:ad:be:ef:00:01", "", true)
+	putNode(store, "a", "de:ad:be:ef:00:0a", "de:ad:be:ef:00:01", true)
+	tracker.Update(now)
+	assert.True(isGatewayNode(store, "gw1"))
+
+	// The node switched to a gateway without node
+	putNode(store, "a", "de:ad:be:ef:00:0a", "de:ad:be:ef:00:02", true)
+	tracker.Update(now.Add(30 * time.Minute))
+	assert.True(isGatewayNode(store, "gw1"), "Unused gateways are kept for a while")
+	assert.Equal(0, tracker.Gateways()[0].Users)
+
+	tracker.Update(now.Add(2 * time.Hour))
+	assert.False(store.IsGateway("de:ad:be:ef:00:01"))
+	assert.False(isGatewayNode(store, "gw1"))
+	assert.Equal(1, len(tracker.Gateways()))
+	// Only the series of the removed gateway is deleted
+	assert.False(prometheus.GatewayNodes.DeleteLabelValues("de:ad:be:ef:00:01"))
+	assert.True(prometheus.GatewayNodes.DeleteLabelValues("de:ad:be:ef:00:02"))
+}
+
+func TestDetectingFlappingNodes(t *testing.T) {
+	assert := assert.New(t)
+	store := data.NewSimpleInMemoryStore()
+	tracker := newTestTracker(store)
+	tracker.SwitchWindow = time.Hour
+	tracker.FlapThreshold = 3
+	now := time.Now()
+	putNode(store, "a", "de:ad:be:ef:00:0a", "de:ad:be:ef:00:01", true)
+	putNode(store, "b", "de:ad:be:ef:00:0b", "de:ad:be:ef:00:02", true)
+
+	gateways := []string{"de:ad:be:ef:00:01", "de:ad:be:ef:00:02"}
+	for i := 0; i < 4; i++ {
+		tracker.RecordSwitch(data.Event{Type: data.GatewayChanged, NodeId: "a",
+			Time: now.Add(time.Duration(i) * 10 * time.Minute), From: gateways[i%2], To: gateways[(i+1)%2]})
+	}
+	tracker.RecordSwitch(data.Event{Type: data.GatewayChanged, NodeId: "b", Time: now,
+		From: gateways[1], To: gateways[0]})
+
+	assert.Equal(5, len(tracker.Switches()))
+	assert.Equal([]FlappingNode{{NodeId: "a", Switches: 4}}, tracker.Flapping())
+	tracker.Update(now.Add(30 * time.Minute))
+	for _, gateway := range tracker.Gateways() {
+		assert.Equal(5, gateway.Switches, gateway.Mac)
+	}
+
+	// Old switches are forgotten
+	tracker.Update(now.Add(85 * time.Minute))
+	assert.Equal(1, len(tracker.Switches()))
+	assert.Equal(0, len(tracker.Flapping()))
+}
+
+func TestHidingGatewayNodes(t *testing.T) {
+	assert := assert.New(t)
+	path := "./overrides.json"
+	defer os.RemoveAll(path)
+	assert.Nil(ioutil.WriteFile(path, []byte(`{"gw1": {"hidden": true}, "gw2": {"hostname": "Renamed"}}`), 0644))
+	overrides, err := data.NewOverrides(path)
+	assert.Nil(err)
+	defer overrides.Close()
+
+	store := data.NewSimpleInMemoryStore()
+	tracker := newTestTracker(store)
+	tracker.Public = data.NewOverrideStore(store, overrides)
+	tracker.FlapThreshold = 1
+	now := time.Now()
+	putNode(store, "gw1", "de:ad:be:ef:00:01", "", true)
+	putNode(store, "gw2", "de:ad:be:ef:00:02", "", true)
+	putNode(store, "a", "de:ad:be:ef:00:0a", "de:ad:be:ef:00:01", true)
+	putNode(store, "b", "de:ad:be:ef:00:0b", "de:ad:be:ef:00:02", true)
+	tracker.RecordSwitch(data.Event{Type: data.GatewayChanged, NodeId: "gw1", Time: now,
+		To: "de:ad:be:ef:00:02"})
+
+	tracker.Update(now)
+	assert.Equal([]Gateway{
+		{Mac: "de:ad:be:ef:00:02", NodeId: "gw2", Hostname: "Renamed", Users: 1, Clients: 5,
+			Switches: 1, LastUsed: now},
+	}, tracker.Gateways())
+	assert.True(isGatewayNode(store, "gw1"), "Hidden nodes still get the flag")
+	assert.Equal(0, len(tracker.Switches()))
+	assert.Equal(0, len(tracker.Flapping()))
+}
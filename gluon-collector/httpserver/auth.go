@@ -0,0 +1,98 @@
+package httpserver
+
+import (
+	"crypto/subtle"
+	"errors"
+	"net/http"
+	"strings"
+
+	log "github.com/Sirupsen/logrus"
+	conf "github.com/ffdo/node-informant/gluon-collector/config"
+)
+
+var (
+	errAuthDisabled = errors.New("Authentication is not configured, protected endpoints are disabled")
+	errUnauthorized = errors.New("Authentication required")
+)
+
+func equalSecrets(given, expected string) bool {
+	return subtle.ConstantTimeCompare([]byte(given), []byte(expected)) == 1
+}
+
+// Authenticate checks the credentials of the request against the configured
+// bearer token (http.auth.token) and basic auth credentials (http.auth.user and
+// http.auth.password). If neither is configured every request is rejected.
+func Authenticate(r *http.Request) error {
+	token := conf.UString("http.auth.token", "")
+	user := conf.UString("http.auth.user", "")
+	password := conf.UString("http.auth.password", "")
+	if token == "" && (user == "" || password == "") {
+		return errAuthDisabled
+	}
+	if token != "" {
+		header := r.Header.Get("Authorization")
+		if strings.HasPrefix(header, "Bearer ") && equalSecrets(strings.TrimPrefix(header, "Bearer "), token) {
+			return nil
+		}
+	}
+	if user != "" && password != "" {
+		givenUser, givenPassword, ok := r.BasicAuth()
+		// Evaluate both comparisons to not leak which one failed
+		userOk := equalSecrets(givenUser, user)
+		passwordOk := equalSecrets(givenPassword, password)
+		if ok && userOk && passwordOk {
+			return nil
+		}
+	}
+	return errUnauthorized
+}
+
+// RequireAuth wraps the handler, so it is only called for authenticated
+// requests. All others are answered with status code 401, or 403 if no
+// credentials are configured at all.
+func RequireAuth(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		err := Authenticate(r)
+		switch err {
+		case nil:
+			handler(w, r)
+		case errAuthDisabled:
+			Respond(w, err.Error(), http.StatusForbidden)
+		default:
+			log.WithFields(log.Fields{
+				"remoteAddr": r.RemoteAddr,
+				"path":       r.URL.Path,
+			}).Warn("Rejected unauthenticated request")
+			w.Header().Set("WWW-Authenticate", `Basic realm="gluon-collector"`)
+			Respond(w, err.Error(), http.StatusUnauthorized)
+		}
+	}
+}
+
+// InternalPrefix is the path below which the internal views are served.
+const InternalPrefix = "/internal"
+
+type internalServeable struct {
+	serveable HttpServeable
+}
+
+// Internal serves all routes of the serveable below InternalPrefix for
+// authenticated requests only. This way the same API can be served publicly on
+// filtered data and internally on the full data.
+func Internal(serveable HttpServeable) HttpServeable {
+	return &internalServeable{serveable: serveable}
+}
+
+func (i *internalServeable) Routes() []Route {
+	routes := i.serveable.Routes()
+	internalRoutes := make([]Route, 0, len(routes))
+	for _, route := range routes {
+		internalRoutes = append(internalRoutes, Route{
+			Name:        "Internal" + route.Name,
+			Method:      route.Method,
+			Pattern:     InternalPrefix + route.Pattern,
+			HandlerFunc: RequireAuth(route.HandlerFunc),
+		})
+	}
+	return internalRoutes
+}
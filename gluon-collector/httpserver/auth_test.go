@@ -0,0 +1,94 @@
+package httpserver
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	conf "github.com/ffdo/node-informant/gluon-collector/config"
+	cfg "github.com/olebedev/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func protectedStatus(prepare func(r *http.Request)) int {
+	handler := RequireAuth(func(w http.ResponseWriter, r *http.Request) {
+		RespondOK(w, "secret")
+	})
+	request, _ := http.NewRequest("GET", "/admin/backup", nil)
+	prepare(request)
+	recorder := httptest.NewRecorder()
+	handler(recorder, request)
+	return recorder.Code
+}
+
+func TestRequiringAuthentication(t *testing.T) {
+	assert := assert.New(t)
+	conf.Global = &cfg.Config{}
+	assert.Equal(http.StatusForbidden, protectedStatus(func(r *http.Request) {}),
+		"Protected endpoints are disabled without credentials")
+
+	var err error
+	conf.Global, err = cfg.ParseYaml(`
+http:
+  auth:
+    token: abc
+    user: admin
+    password: secret
+`)
+	assert.Nil(err)
+	assert.Equal(http.StatusUnauthorized, protectedStatus(func(r *http.Request) {}))
+	assert.Equal(http.StatusOK, protectedStatus(func(r *http.Request) {
+		r.Header.Set("Authorization", "Bearer abc")
+	}))
+	assert.Equal(http.StatusUnauthorized, protectedStatus(func(r *http.Request) {
+		r.Header.Set("Authorization", "Bearer abd")
+	}))
+	assert.Equal(http.StatusOK, protectedStatus(func(r *http.Request) {
+		r.SetBasicAuth("admin", "secret")
+	}))
+	assert.Equal(http.StatusUnauthorized, protectedStatus(func(r *http.Request) {
+		r.SetBasicAuth("admin", "wrong")
+	}))
+	conf.Global = nil
+}
+
+func TestServingInternalRoutes(t *testing.T) {
+	assert := assert.New(t)
+	var err error
+	conf.Global, err = cfg.ParseYaml(`
+http:
+  auth:
+    token: abc
+`)
+	assert.Nil(err)
+	public := &testServeable{routes: []Route{
+		Route{"Nodes", "GET", "/nodes.json", func(w http.ResponseWriter, r *http.Request) {
+			RespondOK(w, "nodes")
+		}},
+	}}
+	router := AssembleRouter(public, Internal(public))
+
+	request, _ := http.NewRequest("GET", "/nodes.json", nil)
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, request)
+	assert.Equal(http.StatusOK, recorder.Code)
+
+	request, _ = http.NewRequest("GET", "/internal/nodes.json", nil)
+	recorder = httptest.NewRecorder()
+	router.ServeHTTP(recorder, request)
+	assert.Equal(http.StatusUnauthorized, recorder.Code)
+
+	request.Header.Set("Authorization", "Bearer abc")
+	recorder = httptest.NewRecorder()
+	router.ServeHTTP(recorder, request)
+	assert.Equal(http.StatusOK, recorder.Code)
+	conf.Global = nil
+}
+
+type testServeable struct {
+	routes []Route
+}
+
+func (t *testServeable) Routes() []Route {
+	return t.routes
+}
@@ -0,0 +1,36 @@
+package httpserver
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// Respond encodes data as json and writes it with the given status code.
+func Respond(w http.ResponseWriter, data interface{}, status int) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(data)
+}
+
+// RespondOK writes data as json with status code 200.
+func RespondOK(w http.ResponseWriter, data interface{}) {
+	Respond(w, data, http.StatusOK)
+}
+
+// RespondMissing writes the error as json with status code 404.
+func RespondMissing(w http.ResponseWriter, data error) {
+	Respond(w, data, http.StatusNotFound)
+}
+
+// RespondRaw writes an already encoded json document unchanged with status
+// code 200.
+func RespondRaw(w http.ResponseWriter, raw json.RawMessage) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.WriteHeader(http.StatusOK)
+	w.Write(raw)
+}
+
+// RespondBadRequest writes the error message as json with status code 400.
+func RespondBadRequest(w http.ResponseWriter, err error) {
+	Respond(w, err.Error(), http.StatusBadRequest)
+}
@@ -0,0 +1,115 @@
+package identity
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/ffdo/node-informant/gluon-collector/data"
+	"github.com/ffdo/node-informant/gluon-collector/httpserver"
+	"github.com/ffdo/node-informant/gluon-collector/quarantine"
+	"github.com/ffdo/node-informant/gluon-collector/scheduler"
+	"github.com/gorilla/mux"
+)
+
+// CheckPipe validates the node id of every response against the identity
+// Tracker and publishes an IdentityConflict event for every conflict.
+// Responses of nodes with recent conflicts are flagged in their meta data, so
+// the status stage can flag them in the NodeStatusInfo. If a quarantine Area
+// is set, conflicting responses are moved there instead of being passed on to
+// the following pipes.
+type CheckPipe struct {
+	Events     *data.EventBus
+	Tracker    *Tracker
+	Quarantine *quarantine.Area
+
+	sweepJob *scheduler.ScheduledJob
+}
+
+// NewCheckPipe creates a CheckPipe and sweeps the tracker periodically until
+// the pipe is closed.
+func NewCheckPipe(events *data.EventBus, tracker *Tracker, area *quarantine.Area) *CheckPipe {
+	pipe := &CheckPipe{Events: events, Tracker: tracker, Quarantine: area}
+	pipe.sweepJob = scheduler.NewJob(time.Minute*1, func() {
+		tracker.Sweep(time.Now())
+	}, false)
+	return pipe
+}
+
+// Close stops sweeping the tracker.
+func (c *CheckPipe) Close() error {
+	if c.sweepJob != nil {
+		c.sweepJob.Stop()
+	}
+	return nil
+}
+
+// nodeMacs returns the primary mac and the macs of all interfaces of the node
+// if the response contains them.
+func nodeMacs(response data.ParsedResponse) (string, []string) {
+	if response.Type() == "nodeinfo" {
+		nodeinfo := response.ParsedData().(data.NodeInfo)
+		return nodeinfo.Network.Mac, nodeinfo.Macs()
+	}
+	return "", nil
+}
+
+func (c *CheckPipe) Process(in chan data.ParsedResponse) chan data.ParsedResponse {
+	out := make(chan data.ParsedResponse)
+	go func() {
+		for response := range in {
+			now := time.Now()
+			nodeId := response.NodeId()
+			mac, macs := nodeMacs(response)
+			conflict := c.Tracker.Check(nodeId, response.Meta().ClientAddr, mac, macs, now)
+			if conflict != nil {
+				c.Events.Publish(data.Event{
+					Type:   data.IdentityConflict,
+					NodeId: nodeId,
+					Time:   now,
+					From:   conflict.Address,
+					To:     conflict.OwnerId,
+					Reason: conflict.Reason,
+				})
+			}
+			if conflict != nil && c.Quarantine != nil {
+				c.Quarantine.Put(conflict.Reason, response)
+				continue
+			}
+			if nodeId != "" {
+				meta := response.Meta()
+				meta.IdentityConflict = c.Tracker.HasConflict(nodeId, now)
+				response = data.WithMeta(response, meta)
+			}
+			out <- response
+		}
+	}()
+	return out
+}
+
+func (c *CheckPipe) GetConflictsRest(w http.ResponseWriter, r *http.Request) {
+	httpserver.RespondOK(w, c.Tracker.Conflicts())
+}
+
+func (c *CheckPipe) GetIdentityRest(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	identity, err := c.Tracker.GetIdentity(vars["nodeid"])
+	if err == nil {
+		httpserver.RespondOK(w, identity)
+	} else {
+		httpserver.RespondMissing(w, err)
+	}
+}
+
+// Routes serves the conflicts and identities, and the quarantine if there is
+// one. Since the identities contain the source addresses and macs of the
+// nodes, authentication is required.
+func (c *CheckPipe) Routes() []httpserver.Route {
+	routes := []httpserver.Route{
+		httpserver.Route{"IdentityConflicts", "GET", "/conflicts", httpserver.RequireAuth(c.GetConflictsRest)},
+		httpserver.Route{"NodeIdentity", "GET", "/identities/{nodeid}", httpserver.RequireAuth(c.GetIdentityRest)},
+	}
+	if c.Quarantine != nil {
+		routes = append(routes, c.Quarantine.Routes()...)
+	}
+	return routes
+}
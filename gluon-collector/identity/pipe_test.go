@@ -0,0 +1,58 @@
+package identity
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/ffdo/node-informant/gluon-collector/data"
+	"github.com/ffdo/node-informant/gluon-collector/pipeline"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPublishingAndFlaggingConflicts(t *testing.T) {
+	log.SetLevel(log.ErrorLevel)
+	assert := assert.New(t)
+	events := data.NewEventBus(10)
+	defer events.Close()
+	conflicts := make(chan data.Event, 10)
+	events.Subscribe(func(event data.Event) {
+		conflicts <- event
+	}, data.IdentityConflict)
+	checkPipe := NewCheckPipe(events, NewTracker(time.Hour, true), nil)
+	defer checkPipe.Close()
+
+	processPipeline := pipeline.NewProcessPipeline(checkPipe)
+	received := make(chan data.ParsedResponse, 4)
+	go processPipeline.Dequeue(func(response data.ParsedResponse) {
+		received <- response
+	})
+	response := func(addr net.Addr) data.ParsedResponse {
+		return data.StatisticsResponse{
+			ResponseMeta: data.ResponseMeta{ClientAddr: addr},
+			Statistics:   &data.StatisticsStruct{NodeId: "e8de27252554"},
+		}
+	}
+	// The nodeinfo teaches the tracker the macs of the node
+	nodeinfo := data.NodeInfo{NodeId: "e8de27252554"}
+	nodeinfo.Network.Mac = "e8:de:27:25:25:54"
+	processPipeline.Enqueue(data.NodeinfoResponse{
+		ResponseMeta: data.ResponseMeta{ClientAddr: ownAddr},
+		Nodeinfo:     nodeinfo,
+	})
+	assert.False((<-received).Meta().IdentityConflict)
+	processPipeline.Enqueue(response(otherAddr))
+	assert.True((<-received).Meta().IdentityConflict, "Responses are passed on without quarantine")
+	processPipeline.Enqueue(response(ownAddr))
+	assert.True((<-received).Meta().IdentityConflict, "The node is flagged until the window has passed")
+
+	select {
+	case event := <-conflicts:
+		assert.Equal("e8de27252554", event.NodeId)
+		assert.Equal(otherAddr.IP.String(), event.From)
+		assert.Equal("14cc206fa038", event.To)
+	case <-time.After(time.Second):
+		assert.Fail("No IdentityConflict event was published")
+	}
+}
@@ -0,0 +1,21 @@
+package identity
+
+import (
+	"time"
+
+	"github.com/ffdo/node-informant/gluon-collector/data"
+	"github.com/ffdo/node-informant/gluon-collector/pipeline"
+	"github.com/ffdo/node-informant/gluon-collector/quarantine"
+	cfg "github.com/olebedev/config"
+)
+
+func init() {
+	pipeline.RegisterProcessPipe("identity", func(store data.Nodeinfostore, options *cfg.Config) (pipeline.ProcessPipe, error) {
+		window := time.Second * time.Duration(options.UInt("window", 3600))
+		var area *quarantine.Area
+		if options.UBool("quarantine", false) {
+			area = quarantine.NewArea(options.UInt("quarantineSize", 10))
+		}
+		return NewCheckPipe(store.Events(), NewTracker(window, options.UBool("checkeui64", true)), area), nil
+	})
+}
@@ -0,0 +1,295 @@
+package identity
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// maxConflicts is the number of recent conflicts kept in memory.
+const maxConflicts int = 200
+
+// Conflict describes a response whose node id doesn't match what we know about
+// the sender or about the node itself.
+type Conflict struct {
+	NodeId  string    `json:"node_id"`
+	Address string    `json:"address"`
+	Reason  string    `json:"reason"`
+	OwnerId string    `json:"owner_id,omitempty"`
+	Time    time.Time `json:"time"`
+}
+
+// NodeIdentity is everything we have learned about the identity of a single
+// node: its primary mac, the macs of its interfaces and the addresses it has
+// answered from.
+type NodeIdentity struct {
+	NodeId       string               `json:"node_id"`
+	Mac          string               `json:"mac,omitempty"`
+	Macs         []string             `json:"macs,omitempty"`
+	Addresses    map[string]time.Time `json:"addresses"`
+	LastConflict *time.Time           `json:"last_conflict,omitempty"`
+}
+
+// hasMac checks whether the mac belongs to the node, either as primary mac or
+// as mac of one of its interfaces.
+func (n *NodeIdentity) hasMac(mac string) bool {
+	if NodeIdFromMac(mac) == n.NodeId || mac == n.Mac {
+		return true
+	}
+	for _, nodeMac := range n.Macs {
+		if nodeMac == mac {
+			return true
+		}
+	}
+	return false
+}
+
+// hasOtherLinkLocal checks whether the node has been seen from another link
+// local address than the given one.
+func (n *NodeIdentity) hasOtherLinkLocal(address string) bool {
+	for knownAddress := range n.Addresses {
+		if knownAddress != address && net.ParseIP(knownAddress).IsLinkLocalUnicast() {
+			return true
+		}
+	}
+	return false
+}
+
+type addressOwner struct {
+	nodeId   string
+	lastseen time.Time
+}
+
+// Tracker keeps track of the source addresses and primary macs seen per node
+// id and detects responses which claim a node id they are not entitled to.
+// Conflicting responses are never learned, so a spoofing host can't take over
+// the identity of another node while the legitimate node is still reporting.
+type Tracker struct {
+	lock       sync.Mutex
+	window     time.Duration
+	checkEUI64 bool
+	nodes      map[string]*NodeIdentity
+	owners     map[string]addressOwner
+	conflicts  []Conflict
+}
+
+// NewTracker creates a new Tracker. Addresses which haven't been seen for longer
+// than window are forgotten. If checkEUI64 is true, the mac address encoded in
+// EUI-64 link local addresses is compared to the macs of the claimed node id
+// as soon as they are known.
+func NewTracker(window time.Duration, checkEUI64 bool) *Tracker {
+	return &Tracker{
+		window:     window,
+		checkEUI64: checkEUI64,
+		nodes:      make(map[string]*NodeIdentity),
+		owners:     make(map[string]addressOwner),
+		conflicts:  make([]Conflict, 0, maxConflicts),
+	}
+}
+
+// addressIP extracts the ip address from a net.Addr.
+func addressIP(addr net.Addr) net.IP {
+	switch a := addr.(type) {
+	case *net.UDPAddr:
+		return a.IP
+	case *net.IPAddr:
+		return a.IP
+	}
+	host, _, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		host = addr.String()
+	}
+	return net.ParseIP(strings.Split(host, "%")[0])
+}
+
+// macFromLinkLocal extracts the mac address of the interface embedded in an
+// EUI-64 link local address.
+func macFromLinkLocal(ip net.IP) (string, bool) {
+	ip = ip.To16()
+	if ip == nil || !ip.IsLinkLocalUnicast() || ip[11] != 0xff || ip[12] != 0xfe {
+		return "", false
+	}
+	mac := net.HardwareAddr{ip[8] ^ 0x02, ip[9], ip[10], ip[13], ip[14], ip[15]}
+	return mac.String(), true
+}
+
+// nodeIdFromLinkLocal calculates the node id from the mac address embedded in
+// an EUI-64 link local address. Gluon uses the primary mac as node id and
+// answers from a link local address derived from it.
+func nodeIdFromLinkLocal(ip net.IP) (string, bool) {
+	mac, ok := macFromLinkLocal(ip)
+	if !ok {
+		return "", false
+	}
+	return NodeIdFromMac(mac), true
+}
+
+// NodeIdFromMac converts a mac address to the node id format used by gluon.
+func NodeIdFromMac(mac string) string {
+	return strings.ToLower(strings.Replace(mac, ":", "", -1))
+}
+
+// Sweep forgets all addresses which haven't been seen within the window and
+// all nodes without addresses and recent conflicts. It is called periodically
+// by the CheckPipe.
+func (t *Tracker) Sweep(now time.Time) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	for ip, owner := range t.owners {
+		if now.Sub(owner.lastseen) > t.window {
+			delete(t.owners, ip)
+		}
+	}
+	for nodeId, node := range t.nodes {
+		for address, lastseen := range node.Addresses {
+			if now.Sub(lastseen) > t.window {
+				delete(node.Addresses, address)
+			}
+		}
+		recentConflict := node.LastConflict != nil && now.Sub(*node.LastConflict) <= t.window
+		if len(node.Addresses) == 0 && !recentConflict {
+			delete(t.nodes, nodeId)
+		}
+	}
+}
+
+// check looks for reasons why the response can't have been sent by the node.
+// Nodes answer from the link local addresses of several interfaces, so they
+// are compared to the macs of the node instead of to each other. The lock needs
+// to be held by the caller.
+func (t *Tracker) check(nodeId string, ip net.IP, mac string, macs []string, now time.Time) (reason, ownerId string) {
+	address := ip.String()
+	node, exists := t.nodes[nodeId]
+	if !exists {
+		node = &NodeIdentity{NodeId: nodeId}
+	}
+	if len(macs) > 0 {
+		// The macs announced by the response itself are checked against the
+		// address
+		node = &NodeIdentity{NodeId: nodeId, Mac: node.Mac, Macs: macs, Addresses: node.Addresses}
+	}
+	// The interface macs are only known from the nodeinfo, before that the
+	// link local addresses of the mesh interfaces can't be checked
+	if interfaceMac, ok := macFromLinkLocal(ip); ok && len(node.Macs) > 0 && !node.hasMac(interfaceMac) {
+		derivedId := NodeIdFromMac(interfaceMac)
+		if t.checkEUI64 {
+			return fmt.Sprintf("Link local address %s belongs to node id %s", address, derivedId), derivedId
+		}
+		if node.hasOtherLinkLocal(address) {
+			return fmt.Sprintf("Link local address %s doesn't belong to an interface of the node", address), derivedId
+		}
+	}
+	if owner, exists := t.owners[address]; exists && owner.nodeId != nodeId && now.Sub(owner.lastseen) <= t.window {
+		return fmt.Sprintf("Address %s was recently used by node %s", address, owner.nodeId), owner.nodeId
+	}
+	if mac != "" && node.Mac != "" && node.Mac != mac {
+		return fmt.Sprintf("Primary mac %s differs from known mac %s", mac, node.Mac), ""
+	}
+	return "", ""
+}
+
+// Check validates that a response claiming nodeId may have been sent from addr.
+// The mac is the primary mac and macs are the macs of all interfaces of the
+// node if they are known from the response, otherwise they should be empty.
+// Check returns nil if no conflict was found and remembers the address and
+// macs for this node id.
+func (t *Tracker) Check(nodeId string, addr net.Addr, mac string, macs []string, now time.Time) *Conflict {
+	if nodeId == "" || addr == nil {
+		return nil
+	}
+	ip := addressIP(addr)
+	if ip == nil {
+		return nil
+	}
+	mac = strings.ToLower(mac)
+	lowerMacs := make([]string, 0, len(macs))
+	for _, interfaceMac := range macs {
+		lowerMacs = append(lowerMacs, strings.ToLower(interfaceMac))
+	}
+
+	t.lock.Lock()
+	reason, ownerId := t.check(nodeId, ip, mac, lowerMacs, now)
+	if reason == "" {
+		node, exists := t.nodes[nodeId]
+		if !exists {
+			node = &NodeIdentity{NodeId: nodeId, Addresses: make(map[string]time.Time)}
+			t.nodes[nodeId] = node
+		}
+		node.Addresses[ip.String()] = now
+		if mac != "" {
+			node.Mac = mac
+		}
+		if len(lowerMacs) > 0 {
+			node.Macs = lowerMacs
+		}
+		t.owners[ip.String()] = addressOwner{nodeId: nodeId, lastseen: now}
+		t.lock.Unlock()
+		return nil
+	}
+
+	conflict := Conflict{
+		NodeId:  nodeId,
+		Address: ip.String(),
+		Reason:  reason,
+		OwnerId: ownerId,
+		Time:    now,
+	}
+	if len(t.conflicts) >= maxConflicts {
+		t.conflicts = t.conflicts[1:]
+	}
+	t.conflicts = append(t.conflicts, conflict)
+	if node, exists := t.nodes[nodeId]; exists {
+		node.LastConflict = &now
+	} else {
+		t.nodes[nodeId] = &NodeIdentity{
+			NodeId:       nodeId,
+			Addresses:    make(map[string]time.Time),
+			LastConflict: &now,
+		}
+	}
+	t.lock.Unlock()
+
+	log.WithFields(log.Fields{
+		"nodeid":  nodeId,
+		"address": conflict.Address,
+		"reason":  reason,
+	}).Warn("Detected node id conflict")
+	return &conflict
+}
+
+// HasConflict returns true if a conflict for this node id was detected within
+// the window.
+func (t *Tracker) HasConflict(nodeId string, now time.Time) bool {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	node, exists := t.nodes[nodeId]
+	return exists && node.LastConflict != nil && now.Sub(*node.LastConflict) <= t.window
+}
+
+// GetIdentity returns a copy of the known identity of a node.
+func (t *Tracker) GetIdentity(nodeId string) (NodeIdentity, error) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	node, exists := t.nodes[nodeId]
+	if !exists {
+		return NodeIdentity{}, fmt.Errorf("No identity known for node id %s", nodeId)
+	}
+	identity := *node
+	identity.Macs = append([]string{}, node.Macs...)
+	identity.Addresses = make(map[string]time.Time, len(node.Addresses))
+	for address, lastseen := range node.Addresses {
+		identity.Addresses[address] = lastseen
+	}
+	return identity, nil
+}
+
+// Conflicts returns the most recent conflicts, oldest first.
+func (t *Tracker) Conflicts() []Conflict {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	return append([]Conflict{}, t.conflicts...)
+}
@@ -0,0 +1,104 @@
+package identity
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+var (
+	ownAddr   = &net.UDPAddr{IP: net.ParseIP("fe80::eade:27ff:fe25:2554"), Port: 1001, Zone: "bat0"}
+	otherAddr = &net.UDPAddr{IP: net.ParseIP("fe80::16cc:20ff:fe6f:a038"), Port: 1001, Zone: "bat0"}
+	plainAddr = &net.UDPAddr{IP: net.ParseIP("fe80::1"), Port: 1001, Zone: "bat0"}
+)
+
+func TestDerivingNodeIdFromLinkLocal(t *testing.T) {
+	assert := assert.New(t)
+	nodeId, ok := nodeIdFromLinkLocal(ownAddr.IP)
+	assert.True(ok)
+	assert.Equal("e8de27252554", nodeId)
+
+	_, ok = nodeIdFromLinkLocal(plainAddr.IP)
+	assert.False(ok)
+}
+
+func TestDetectingSpoofedNodeId(t *testing.T) {
+	log.SetLevel(log.ErrorLevel)
+	assert := assert.New(t)
+	now := time.Now()
+	tracker := NewTracker(time.Hour, true)
+
+	assert.Nil(tracker.Check("e8de27252554", ownAddr, "e8:de:27:25:25:54", []string{"e8:de:27:25:25:54"}, now))
+	conflict := tracker.Check("e8de27252554", otherAddr, "", nil, now)
+	assert.NotNil(conflict)
+	assert.Equal("14cc206fa038", conflict.OwnerId)
+	assert.True(tracker.HasConflict("e8de27252554", now))
+	assert.False(tracker.HasConflict("e8de27252554", now.Add(time.Hour*2)))
+
+	identity, err := tracker.GetIdentity("e8de27252554")
+	assert.Nil(err)
+	assert.Equal(1, len(identity.Addresses))
+	assert.Equal(1, len(tracker.Conflicts()))
+}
+
+func TestDetectingAddressAndMacChanges(t *testing.T) {
+	log.SetLevel(log.ErrorLevel)
+	assert := assert.New(t)
+	now := time.Now()
+	tracker := NewTracker(time.Hour, false)
+
+	macs := []string{"00:11:22:33:44:55"}
+	assert.Nil(tracker.Check("a", plainAddr, "00:11:22:33:44:55", macs, now))
+	assert.NotNil(tracker.Check("b", plainAddr, "", nil, now), "Address is still owned by node a")
+	assert.NotNil(tracker.Check("a", otherAddr, "", nil, now), "Node a was seen from a link local address of another interface")
+	assert.NotNil(tracker.Check("a", plainAddr, "00:11:22:33:44:66", nil, now), "Primary mac of node a changed")
+	assert.Equal(3, len(tracker.Conflicts()))
+
+	// After the window has passed the address may be used by another node
+	assert.Nil(tracker.Check("b", plainAddr, "", nil, now.Add(time.Hour*2)))
+}
+
+func TestAcceptingLinkLocalAddressesOfAllInterfaces(t *testing.T) {
+	log.SetLevel(log.ErrorLevel)
+	assert := assert.New(t)
+	now := time.Now()
+	tracker := NewTracker(time.Hour, true)
+	macs := []string{"e8:de:27:25:25:54", "14:CC:20:6F:A0:38"}
+
+	assert.Nil(tracker.Check("e8de27252554", ownAddr, "e8:de:27:25:25:54", macs, now))
+	assert.Nil(tracker.Check("e8de27252554", otherAddr, "", nil, now),
+		"The mesh interface of the node has its own link local address")
+	identity, err := tracker.GetIdentity("e8de27252554")
+	assert.Nil(err)
+	assert.Equal(2, len(identity.Addresses))
+
+	// Before the interface macs are known every link local address is accepted
+	tracker = NewTracker(time.Hour, true)
+	assert.Nil(tracker.Check("e8de27252554", otherAddr, "", nil, now))
+	assert.Nil(tracker.Check("e8de27252554", ownAddr, "e8:de:27:25:25:54", macs, now))
+}
+
+func TestSweepingTracker(t *testing.T) {
+	log.SetLevel(log.ErrorLevel)
+	assert := assert.New(t)
+	now := time.Now()
+	tracker := NewTracker(time.Hour, false)
+
+	assert.Nil(tracker.Check("a", plainAddr, "", nil, now))
+	assert.NotNil(tracker.Check("b", plainAddr, "", nil, now.Add(time.Minute*45)))
+	tracker.Sweep(now.Add(time.Minute * 90))
+	_, err := tracker.GetIdentity("a")
+	assert.NotNil(err, "Nodes which went away are forgotten")
+	_, err = tracker.GetIdentity("b")
+	assert.Nil(err, "Nodes with recent conflicts are kept")
+	assert.True(tracker.HasConflict("b", now.Add(time.Minute*90)))
+}
+
+func TestIgnoringResponsesWithoutSource(t *testing.T) {
+	tracker := NewTracker(time.Hour, true)
+	assert.Nil(t, tracker.Check("a", nil, "", nil, time.Now()))
+	assert.Nil(t, tracker.Check("", ownAddr, "", nil, time.Now()))
+}
@@ -1,9 +1,7 @@
 package main
 
 import (
-	"bufio"
 	"flag"
-	"fmt"
 	"io"
 	"os"
 	"os/signal"
@@ -12,50 +10,30 @@ import (
 
 	log "github.com/Sirupsen/logrus"
 
-	"github.com/ffdo/node-informant/announced"
 	"github.com/ffdo/node-informant/gluon-collector/api"
 	"github.com/ffdo/node-informant/gluon-collector/assemble"
 	conf "github.com/ffdo/node-informant/gluon-collector/config"
 	"github.com/ffdo/node-informant/gluon-collector/data"
+	"github.com/ffdo/node-informant/gluon-collector/dedupe"
+	"github.com/ffdo/node-informant/gluon-collector/gateways"
 	"github.com/ffdo/node-informant/gluon-collector/httpserver"
 	"github.com/ffdo/node-informant/gluon-collector/meshviewer"
 	"github.com/ffdo/node-informant/gluon-collector/prometheus"
 	"github.com/ffdo/node-informant/gluon-collector/scheduler"
+	"github.com/ffdo/node-informant/gluon-collector/status"
 )
 
 var importPath = flag.String("import", "", "Import data from this path")
-var importType = flag.String("importType", "ffmap-backend", "The data format to import from, i.e ffmap-backend")
+var importType = flag.String("importType", "ffmap-backend", "The data format to import from, i.e ffmap-backend or archive")
+var exportPath = flag.String("export", "", "Export all data as json archive to this path and exit")
+var migrateOnly = flag.Bool("migrate", false, "Migrate the bolt database to the current schema version and exit")
+var dryRun = flag.Bool("dryrun", false, "Only report what the migrations would change, used with -migrate")
 
 var DataStore data.Nodeinfostore
 var Closeables []io.Closer
 
-type LogPipe struct {
-	logFile *bufio.Writer
-}
-
-func NewLogPipe() *LogPipe {
-	logFile, err := os.Create("/opt/rawdata.log")
-	if err != nil {
-		log.Fatalf("Cannot open raw data logfile")
-	}
-	writer := bufio.NewWriter(logFile)
-	return &LogPipe{logFile: writer}
-}
-
-func (l *LogPipe) Process(in chan announced.Response) chan announced.Response {
-	out := make(chan announced.Response)
-	go func() {
-		for response := range in {
-			_, err := l.logFile.WriteString(fmt.Sprintf("%s|", response.String()))
-			l.logFile.Flush()
-			if err != nil {
-				log.Fatalf("Can't write to logfile: %v", err)
-			}
-			out <- response
-		}
-	}()
-	return out
-}
+// Overrides are nil if no overrides file is configured.
+var Overrides *data.Overrides
 
 /* func getProcessPipes(store data.Nodeinfostore) []pipeline.ProcessPipe {
 	pipes := make([]pipeline.ProcessPipe, 0, 10)
@@ -95,8 +73,23 @@ func BuildPipelines(store data.Nodeinfostore, receiver announced.AnnouncedPacket
 }*/
 
 func Assemble() ([]io.Closer, error) {
+	// The outputs see the nodes with their overrides, the pipelines the nodes
+	// as they report themselves
+	outputStore := DataStore
+	if Overrides != nil {
+		outputStore = data.NewOverrideStore(DataStore, Overrides)
+	}
+	// With a privacy policy the public outputs are filtered, the full data is
+	// only served below /internal
+	publicStore := outputStore
+	var internalNodesGenerator *meshviewer.NodesJsonGenerator
+	if _, err := conf.Global.Get("privacy"); err == nil {
+		publicStore = data.NewPrivacyStore(outputStore, data.NewPrivacyPolicy())
+		internalNodesGenerator = meshviewer.NewNodesJsonGenerator(outputStore)
+	}
+	dedupe.PublicStore = publicStore
 	requester := buildReceiver()
-	closeables, err := assemble.BuildPipelines(DataStore, requester, func(response data.ParsedResponse) {
+	closeables, serveables, err := assemble.BuildPipelines(DataStore, requester, func(response data.ParsedResponse) {
 		//Do nothing. This is the last step and we do not need to do anything here,
 		// just pull the chan clean
 	})
@@ -104,10 +97,21 @@ func Assemble() ([]io.Closer, error) {
 	if err != nil {
 		return closeables, err
 	}
-	graphGenerator := &meshviewer.GraphGenerator{Store: DataStore}
-	nodesGenerator := meshviewer.NewNodesJsonGenerator(DataStore)
+	graphGenerator := &meshviewer.GraphGenerator{
+		Store:           publicStore,
+		DeriveWifiLinks: conf.UBool("meshviewer_wifi_links", false),
+	}
+	nodesGenerator := meshviewer.NewNodesJsonGenerator(publicStore)
 	missingUpdate := &MissingUpdater{Store: DataStore, Requester: requester}
-	DataStore.NotifyNodeOffline(missingUpdate.CheckNodeUnicast)
+	DataStore.Events().Subscribe(func(event data.Event) {
+		missingUpdate.CheckNodeUnicast(event.NodeId)
+	}, data.NodeOffline)
+	statusEngine := status.NewEngine(DataStore)
+	statusEngine.Start()
+	Closeables = append(Closeables, statusEngine)
+	gatewayTracker := gateways.NewTracker(DataStore, publicStore)
+	gatewayTracker.Start()
+	Closeables = append(Closeables, gatewayTracker)
 	nodesGenerator.UpdateNodesJson()
 	graphGenerator.UpdateGraphJson()
 
@@ -134,9 +138,19 @@ func Assemble() ([]io.Closer, error) {
 
 	scheduler.NewJob(time.Minute*1, func() {
 		nodesGenerator.UpdateNodesJson()
+		if internalNodesGenerator != nil {
+			internalNodesGenerator.UpdateNodesJson()
+		}
 	}, false)
-	httpApi := &api.HttpApi{Store: DataStore}
-	httpserver.StartHttpServerBlocking(httpApi, graphGenerator, nodesGenerator)
+	httpApi := &api.HttpApi{Store: publicStore}
+	adminApi := &api.AdminApi{Store: DataStore, Overrides: Overrides}
+	serveables = append(serveables, httpApi, adminApi, graphGenerator, nodesGenerator, gatewayTracker)
+	if internalNodesGenerator != nil {
+		internalNodesGenerator.UpdateNodesJson()
+		serveables = append(serveables, httpserver.Internal(&api.HttpApi{Store: outputStore}),
+			httpserver.Internal(internalNodesGenerator))
+	}
+	httpserver.StartHttpServerBlocking(serveables...)
 	return closeables, nil
 }
 
@@ -169,7 +183,9 @@ func CreateDataStore() {
 	dbType := conf.UString("store.type", "memory")
 	switch dbType {
 	case "memory":
-		DataStore = data.NewSimpleInMemoryStore()
+		memoryStore := data.NewSimpleInMemoryStore()
+		Closeables = append(Closeables, memoryStore)
+		DataStore = memoryStore
 	case "bolt":
 		storagePath := conf.UString("store.path", "/opt/gluon-collector/collector.db")
 		boltStore, err := data.NewBoltStore(storagePath)
@@ -188,6 +204,27 @@ func CreateDataStore() {
 	}
 }
 
+// LoadOverrides reads the overrides file if one is configured and reloads it
+// whenever it changes.
+func LoadOverrides() {
+	path := conf.UString("overrides.path", "")
+	if path == "" {
+		return
+	}
+	overrides, err := data.NewOverrides(path)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"error": err,
+			"path":  path,
+		}).Fatal("Can't load overrides")
+	}
+	overrides.Watch(time.Second * time.Duration(conf.UInt("overrides.reloadInterval", 10)))
+	Closeables = append(Closeables, overrides)
+	overrides.OnHide(prometheus.DeleteNodeMetrics)
+	prometheus.NodeOverrides = overrides
+	Overrides = overrides
+}
+
 func Stop() {
 	for _, c := range Closeables {
 		c.Close()
@@ -205,10 +242,65 @@ func ListenToSig() {
 	}()
 }
 
+// MigrateDatabase runs the pending migrations on the configured bolt database.
+func MigrateDatabase() {
+	storagePath := conf.UString("store.path", "/opt/gluon-collector/collector.db")
+	results, err := data.MigrateBoltDatabase(storagePath, *dryRun)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"error":     err,
+			"storePath": storagePath,
+		}).Fatal("Can't migrate bolt store")
+	}
+	// The single migrations are logged while they run
+	log.WithFields(log.Fields{
+		"migrations": len(results),
+		"version":    data.CurrentSchemaVersion(),
+		"dryRun":     *dryRun,
+	}).Info("Database migrated")
+}
+
+// archiveLoader imports a json archive written by -export or /admin/export.
+type archiveLoader struct {
+	Store data.Nodeinfostore
+}
+
+func (a *archiveLoader) LoadNodesFromFile(path string) error {
+	archiveFile, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer archiveFile.Close()
+	return data.ReadArchive(archiveFile, a.Store)
+}
+
+// ExportData writes all data of the store as json archive to the export path.
+func ExportData() {
+	log.Infof("Exporting all data to file %s", *exportPath)
+	archiveFile, err := os.Create(*exportPath)
+	if err == nil {
+		err = data.WriteArchive(archiveFile, DataStore)
+		archiveFile.Close()
+	}
+	if err != nil {
+		log.WithFields(log.Fields{
+			"error": err,
+			"path":  *exportPath,
+		}).Fatal("Can't export data to file")
+	}
+}
+
 func ImportData() {
 	log.Infof("Loading node information from file %s", *importPath)
-	// TODO choose DataLoader depending on importType
-	loader := &meshviewer.FFMapBackendDataLoader{Store: DataStore}
+	var loader data.NodeFileImporter
+	switch *importType {
+	case "archive":
+		loader = &archiveLoader{Store: DataStore}
+	case "ffmap-backend":
+		loader = &meshviewer.FFMapBackendDataLoader{Store: DataStore}
+	default:
+		log.Fatalf("Unknown import type %s", *importType)
+	}
 	err := loader.LoadNodesFromFile(*importPath)
 	if err != nil {
 		log.WithFields(log.Fields{
@@ -227,11 +319,21 @@ func main() {
 		log.Fatal("Configuration couldn't be parsed")
 	}
 	ConfigureLogger()
+	if *migrateOnly {
+		MigrateDatabase()
+		return
+	}
 	CreateDataStore()
+	LoadOverrides()
 	prometheus.ProcessStoredValues(DataStore)
 	if *importPath != "" {
 		ImportData()
 	}
+	if *exportPath != "" {
+		ExportData()
+		Stop()
+		return
+	}
 	closeables, err := Assemble()
 	Closeables = append(Closeables, closeables...)
 	ListenToSig()
@@ -5,11 +5,13 @@ import (
 	"fmt"
 	"math"
 	"net/http"
+	"sync"
 
 	log "github.com/Sirupsen/logrus"
 
 	"github.com/ffdo/node-informant/gluon-collector/data"
 	"github.com/ffdo/node-informant/gluon-collector/httpserver"
+	"github.com/gorilla/mux"
 )
 
 type GraphNode struct {
@@ -18,12 +20,24 @@ type GraphNode struct {
 	tableId int
 }
 
+// GraphWifiLink contains the wifi link quality as seen by one side of a link.
+type GraphWifiLink struct {
+	Signal   int `json:"signal"`
+	Noise    int `json:"noise"`
+	Inactive int `json:"inactive"`
+}
+
 type GraphLink struct {
 	Bidirect bool    `json:"bidirect"`
 	Source   int     `json:"source"`
 	Target   int     `json:"target"`
 	Tq       float64 `json:"tq"`
 	Vpn      bool    `json:"vpn"`
+	Wireless bool    `json:"wireless,omitempty"`
+	// SourceWifi and TargetWifi contain the wifi link quality as reported by
+	// the source and the target node if they reported it.
+	SourceWifi *GraphWifiLink `json:"source_wifi,omitempty"`
+	TargetWifi *GraphWifiLink `json:"target_wifi,omitempty"`
 }
 
 type BatadvGraph struct {
@@ -40,8 +54,13 @@ type GraphJson struct {
 }
 
 type GraphGenerator struct {
-	Store            data.Nodeinfostore
+	Store data.Nodeinfostore
+	// DeriveWifiLinks adds links for wifi neighbours which are not batman
+	// neighbours. Since there is no tq for these links, they get the best tq.
+	DeriveWifiLinks  bool
 	cachedJsonString string
+	lock             sync.RWMutex
+	cachedDomains    map[string]string
 }
 
 func FindInLinks(links []*GraphLink, sourceIndex, targetIndex int) (link *GraphLink, err error) {
@@ -56,20 +75,35 @@ func FindInLinks(links []*GraphLink, sourceIndex, targetIndex int) (link *GraphL
 	return
 }
 
+// buildNodeTableAndList creates a graph node for every online node. The node
+// table maps the addresses of the nodes for all routing protocols to the graph
+// nodes, since the links reference their peers by these addresses.
 func (g *GraphGenerator) buildNodeTableAndList() (map[string]*GraphNode, []*GraphNode) {
+	return g.buildNodeTableAndListOf(nil)
+}
+
+// buildNodeTableAndListOf creates the graph nodes for the online nodes in
+// include or for all online nodes if include is nil.
+func (g *GraphGenerator) buildNodeTableAndListOf(include map[string]bool) (map[string]*GraphNode, []*GraphNode) {
 	allNeighbours := g.Store.GetAllNeighbours()
 	nodeList := make([]*GraphNode, 0, len(allNeighbours))
 	nodeTable := make(map[string]*GraphNode)
 	counter := 0
 	for _, neighbourInfo := range allNeighbours {
+		if include != nil && !include[neighbourInfo.NodeId] {
+			continue
+		}
 		status, _ := g.Store.GetNodeStatusInfo(neighbourInfo.NodeId)
 		if status.Online {
 			node := &GraphNode{
 				NodeId: neighbourInfo.NodeId,
 			}
-			for mac, _ := range neighbourInfo.Batadv {
-				node.Id = mac
-				nodeTable[mac] = node
+			for _, address := range neighbourInfo.Addresses() {
+				// Prefer batman macs as id, meshviewer expects them
+				if _, isBatmanMac := neighbourInfo.Batadv[address]; isBatmanMac || node.Id == "" {
+					node.Id = address
+				}
+				nodeTable[address] = node
 			}
 			node.tableId = counter
 			nodeList = append(nodeList, node)
@@ -80,21 +114,30 @@ func (g *GraphGenerator) buildNodeTableAndList() (map[string]*GraphNode, []*Grap
 	return nodeTable, nodeList
 }
 
-func calculateTq(tqSource, tqTarget int) float64 {
-	min := math.Min(float64(tqSource), float64(tqTarget))
-	return (1.0 / (min / 255.0))
+// linkTq converts a link quality between 0 and 1 to the tq meshviewer expects,
+// where 1 is the best value and higher values are worse.
+func linkTq(quality float64) float64 {
+	if quality <= 0 {
+		// Avoid infinite values, which can't be encoded as json
+		quality = 1.0 / 255.0
+	}
+	return 1.0 / quality
+}
+
+func calculateTq(qualitySource, qualityTarget float64) float64 {
+	return linkTq(math.Min(qualitySource, qualityTarget))
 }
 
-func (g *GraphGenerator) buildLink(nodeTable map[string]*GraphNode, sourceMac, targetMac string, sourceLinkInfo data.BatmanLink) *GraphLink {
-	sourceNode, sourceExists := nodeTable[sourceMac]
-	targetNode, targetExists := nodeTable[targetMac]
+func (g *GraphGenerator) buildLink(nodeTable map[string]*GraphNode, sourceLinkInfo data.NeighbourLink) *GraphLink {
+	sourceNode, sourceExists := nodeTable[sourceLinkInfo.Address]
+	targetNode, targetExists := nodeTable[sourceLinkInfo.PeerAddress]
 
 	if !sourceExists {
-		log.Debugf("Building link with nonexistant source node %s", sourceMac)
+		log.Debugf("Building link with nonexistant source node %s", sourceLinkInfo.Address)
 		return nil
 	}
 	if !targetExists {
-		log.Debugf("Building link with nonexistant target node %s", targetMac)
+		log.Debugf("Building link with nonexistant target node %s", sourceLinkInfo.PeerAddress)
 		return nil
 	}
 	link := &GraphLink{
@@ -102,28 +145,24 @@ func (g *GraphGenerator) buildLink(nodeTable map[string]*GraphNode, sourceMac, t
 		Source:   sourceNode.tableId,
 		Target:   targetNode.tableId,
 		Vpn:      false,
-		Tq:       float64(1.0 / (float64(sourceLinkInfo.Tq) / 255.0)),
+		Tq:       linkTq(sourceLinkInfo.Quality),
 	}
 	targetNeighbourInfo, err := g.Store.GetNodeNeighbours(targetNode.NodeId)
 	if err != nil {
 		log.Debugf("Can't find neighbourinfos for nodeId %s", targetNode.NodeId)
 	}
 
-	targetBatInfo, exists := targetNeighbourInfo.Batadv[targetMac]
+	targetLinkInfo, exists := targetNeighbourInfo.GetLink(sourceLinkInfo.Protocol,
+		sourceLinkInfo.PeerAddress, sourceLinkInfo.Address)
 	if !exists {
-		log.Debugf("Can't find Batadv links for %s", targetMac)
+		log.Debugf("Can't find linkinfo from %s to %s", sourceLinkInfo.PeerAddress, sourceLinkInfo.Address)
 		link.Bidirect = false
 	} else {
-		targetLinkInfo, exists := targetBatInfo.Neighbours[sourceMac]
-		if !exists {
-			log.Debugf("Can't find linkinfo from %s to %s", targetMac, sourceMac)
-			link.Bidirect = false
-		} else {
-			link.Bidirect = true
-			// TODO How do we calculate a valid Tq value for meshviewer
-			link.Tq = calculateTq(sourceLinkInfo.Tq, targetLinkInfo.Tq)
-		}
+		link.Bidirect = true
+		// TODO How do we calculate a valid Tq value for meshviewer
+		link.Tq = calculateTq(sourceLinkInfo.Quality, targetLinkInfo.Quality)
 	}
+	link.Vpn = g.markVpn(sourceNode.NodeId, targetNode.NodeId, sourceLinkInfo, targetLinkInfo)
 	return link
 }
 
@@ -157,40 +196,126 @@ func isTunnelMac(info data.NodeInfo, mac string) bool {
 	return false
 }
 
-func (g *GraphGenerator) markVpn(sourceNodeId, targetNodeId, sourceMac, targetMac string) bool {
+// vpnInterface is the name gluon uses for the mesh vpn interface in babel
+// neighbour information.
+const vpnInterface = "mesh-vpn"
+
+// markVpn checks whether one side of the link is a vpn interface. The reverse
+// link is empty if the target didn't report the link.
+func (g *GraphGenerator) markVpn(sourceNodeId, targetNodeId string, link, reverseLink data.NeighbourLink) bool {
+	if link.Protocol == data.ProtocolBabel {
+		return link.Interface == vpnInterface || reverseLink.Interface == vpnInterface
+	}
 	sourceInfos, _ := g.Store.GetNodeInfo(sourceNodeId)
 	targetInfos, _ := g.Store.GetNodeInfo(targetNodeId)
 
-	return isTunnelMac(sourceInfos, sourceMac) || isTunnelMac(targetInfos, targetMac)
+	return isTunnelMac(sourceInfos, link.Address) || isTunnelMac(targetInfos, link.PeerAddress)
+}
+
+func isWirelessMac(info data.NodeInfo, mac string) bool {
+	for _, wirelessMac := range info.Network.Mesh.Bat0.Interfaces.Wireless {
+		if wirelessMac == mac {
+			return true
+		}
+	}
+	return false
+}
+
+func toGraphWifiLink(link data.WifiLink, exists bool) *GraphWifiLink {
+	if !exists {
+		return nil
+	}
+	return &GraphWifiLink{
+		Signal:   link.Signal,
+		Noise:    link.Noise,
+		Inactive: link.Inactive,
+	}
+}
+
+// annotateWifi adds the wifi link quality reported by both nodes to the link and
+// marks it as wireless if one of the nodes reported a wifi link or the macs
+// belong to wireless mesh interfaces.
+func (g *GraphGenerator) annotateWifi(link *GraphLink, sourceNeighbours data.NeighbourStruct, targetNodeId, sourceMac, targetMac string) {
+	targetNeighbours, _ := g.Store.GetNodeNeighbours(targetNodeId)
+	link.SourceWifi = toGraphWifiLink(sourceNeighbours.GetWifiLink(sourceMac, targetMac))
+	link.TargetWifi = toGraphWifiLink(targetNeighbours.GetWifiLink(targetMac, sourceMac))
+	if link.SourceWifi != nil || link.TargetWifi != nil {
+		link.Wireless = true
+		return
+	}
+	sourceInfos, _ := g.Store.GetNodeInfo(sourceNeighbours.NodeId)
+	targetInfos, _ := g.Store.GetNodeInfo(targetNodeId)
+	link.Wireless = isWirelessMac(sourceInfos, sourceMac) || isWirelessMac(targetInfos, targetMac)
+}
+
+// deriveWifiLinks builds links for all wifi neighbours of the node, which are
+// not already linked via batman. Only online nodes are part of the node table.
+func (g *GraphGenerator) deriveWifiLinks(nodeTable map[string]*GraphNode, neighbourInfo data.NeighbourStruct, links []*GraphLink) []*GraphLink {
+	for ownMac, wifiInfo := range neighbourInfo.Wifi {
+		for peerMac := range wifiInfo.Neighbours {
+			sourceNode, sourceExists := nodeTable[ownMac]
+			targetNode, targetExists := nodeTable[peerMac]
+			if !sourceExists || !targetExists || linkExists(links, sourceNode.tableId, targetNode.tableId) {
+				continue
+			}
+			link := &GraphLink{
+				Source: sourceNode.tableId,
+				Target: targetNode.tableId,
+				Tq:     1.0,
+			}
+			g.annotateWifi(link, neighbourInfo, targetNode.NodeId, ownMac, peerMac)
+			link.Bidirect = link.TargetWifi != nil
+			links = append(links, link)
+		}
+	}
+	return links
 }
 
 func (g *GraphGenerator) GenerateGraph() GraphJson {
-	nodeTable, nodeList := g.buildNodeTableAndList()
+	return g.generateGraph(nil)
+}
+
+// generateGraph creates the graph of the nodes in include or of all nodes if
+// include is nil. Links to nodes not in the graph are left out.
+func (g *GraphGenerator) generateGraph(include map[string]bool) GraphJson {
+	nodeTable, nodeList := g.buildNodeTableAndListOf(include)
 
 	allNeighbours := g.Store.GetAllNeighbours()
+	if include != nil {
+		included := make([]data.NeighbourStruct, 0, len(include))
+		for _, neighbourInfo := range allNeighbours {
+			if include[neighbourInfo.NodeId] {
+				included = append(included, neighbourInfo)
+			}
+		}
+		allNeighbours = included
+	}
 
 	bidirectionalLinks := make([]*GraphLink, 0, len(allNeighbours))
 	unidirectionalLinks := make([]*GraphLink, 0, len(allNeighbours))
 	for _, neighbourInfo := range allNeighbours {
-		for ownMac, batInfo := range neighbourInfo.Batadv {
-			for peerMac, linkInfo := range batInfo.Neighbours {
-				link := g.buildLink(nodeTable, ownMac, peerMac, linkInfo)
-				if link == nil {
-					log.Debugf("Couldn't form link between %s and %s", ownMac, peerMac)
-					continue
-				}
-				link.Vpn = g.markVpn(nodeTable[ownMac].NodeId, nodeTable[peerMac].NodeId, ownMac, peerMac)
-				if link.Bidirect {
-					bidirectionalLinks = append(bidirectionalLinks, link)
-				} else {
-					unidirectionalLinks = append(unidirectionalLinks, link)
-				}
+		for _, linkInfo := range neighbourInfo.Links() {
+			link := g.buildLink(nodeTable, linkInfo)
+			if link == nil {
+				log.Debugf("Couldn't form link between %s and %s", linkInfo.Address, linkInfo.PeerAddress)
+				continue
+			}
+			g.annotateWifi(link, neighbourInfo, nodeTable[linkInfo.PeerAddress].NodeId, linkInfo.Address, linkInfo.PeerAddress)
+			if link.Bidirect {
+				bidirectionalLinks = append(bidirectionalLinks, link)
+			} else {
+				unidirectionalLinks = append(unidirectionalLinks, link)
 			}
 		}
 	}
 	allLinks := make([]*GraphLink, 0, len(unidirectionalLinks)+len(bidirectionalLinks))
 	allLinks = append(allLinks, bidirectionalLinks...)
 	allLinks = append(allLinks, unidirectionalLinks...)
+	if g.DeriveWifiLinks {
+		for _, neighbourInfo := range allNeighbours {
+			allLinks = g.deriveWifiLinks(nodeTable, neighbourInfo, allLinks)
+		}
+	}
 	batGraph := BatadvGraph{
 		Multigraph: false,
 		Directed:   false,
@@ -203,6 +328,8 @@ func (g *GraphGenerator) GenerateGraph() GraphJson {
 	}
 }
 
+// UpdateGraphJson generates the graph.json of all nodes and of every mesh
+// domain and caches them for the REST handlers.
 func (g *GraphGenerator) UpdateGraphJson() {
 	graph := g.GenerateGraph()
 	jsonBytes, err := json.Marshal(graph)
@@ -213,6 +340,21 @@ func (g *GraphGenerator) UpdateGraphJson() {
 		return
 	}
 	g.cachedJsonString = string(jsonBytes)
+	domains := make(map[string]string)
+	for domain := range data.Domains(g.Store) {
+		jsonBytes, err := json.Marshal(g.generateGraph(data.DomainNodes(g.Store, domain)))
+		if err != nil {
+			log.WithFields(log.Fields{
+				"error":  err,
+				"domain": domain,
+			}).Error("Failed to marshall graph.json of domain")
+			continue
+		}
+		domains[domain] = string(jsonBytes)
+	}
+	g.lock.Lock()
+	g.cachedDomains = domains
+	g.lock.Unlock()
 }
 
 func (g *GraphGenerator) GetGraphJsonRest(w http.ResponseWriter, r *http.Request) {
@@ -221,8 +363,24 @@ func (g *GraphGenerator) GetGraphJsonRest(w http.ResponseWriter, r *http.Request
 	w.Write([]byte(g.cachedJsonString))
 }
 
+// GetDomainGraphJsonRest sends the cached graph.json of the mesh domain.
+func (g *GraphGenerator) GetDomainGraphJsonRest(w http.ResponseWriter, r *http.Request) {
+	domain := mux.Vars(r)["domain"]
+	g.lock.RLock()
+	graphJson, exists := g.cachedDomains[domain]
+	g.lock.RUnlock()
+	if !exists {
+		httpserver.RespondMissing(w, fmt.Errorf("Unknown domain %s", domain))
+		return
+	}
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte(graphJson))
+}
+
 func (g *GraphGenerator) Routes() []httpserver.Route {
 	return []httpserver.Route{
 		httpserver.Route{"GraphJson", "GET", "/graph.json", g.GetGraphJsonRest},
+		httpserver.Route{"DomainGraphJson", "GET", "/domains/{domain}/graph.json", g.GetDomainGraphJsonRest},
 	}
 }
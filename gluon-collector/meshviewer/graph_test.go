@@ -90,3 +90,65 @@ func TestGeneratingNodeGraph(t *testing.T) {
 	assert.NotNil(graphData)
 	testForDoublettes(assert, graphData.Batadv.Nodes)
 }
+
+func TestAnnotatingWifiLinks(t *testing.T) {
+	assert := assert.New(t)
+	log.SetLevel(log.ErrorLevel)
+	store := data.NewSimpleInMemoryStore()
+	test.ExecuteCompletePipe(t, store)
+
+	graphGenerator := &GraphGenerator{Store: store}
+	graph := graphGenerator.GenerateGraph()
+	wirelessLinks := 0
+	for _, link := range graph.Batadv.Links {
+		if link.SourceWifi != nil || link.TargetWifi != nil {
+			assert.True(link.Wireless)
+			wirelessLinks++
+		}
+		if link.Wireless {
+			assert.False(link.Vpn, "A vpn link can't be wireless")
+		}
+	}
+	assert.True(wirelessLinks > 0, "No link was annotated with wifi information")
+
+	graphGenerator.DeriveWifiLinks = true
+	derivedGraph := graphGenerator.GenerateGraph()
+	assert.True(len(derivedGraph.Batadv.Links) >= len(graph.Batadv.Links))
+	testForDoublettes(assert, derivedGraph.Batadv.Nodes)
+}
+
+func babelNeighbours(nodeId, iface, address, peerAddress string, cost int) data.NeighbourStruct {
+	return data.NeighbourStruct{
+		NodeId: nodeId,
+		Babel: map[string]data.BabelNeighbours{
+			iface: data.BabelNeighbours{
+				Protocol:         "babel",
+				LinkLocalAddress: address,
+				Neighbours: data.BabelLinks{
+					peerAddress: data.BabelLink{Cost: cost},
+				},
+			},
+		},
+	}
+}
+
+func TestGeneratingBabelGraph(t *testing.T) {
+	assert := assert.New(t)
+	store := data.NewSimpleInMemoryStore()
+	store.PutNodeNeighbours(babelNeighbours("a", "mesh-vpn", "fe80::a", "fe80::b", 96))
+	store.PutNodeNeighbours(babelNeighbours("b", "mesh0", "fe80::b", "fe80::a", 512))
+	// A batman node in the same domain
+	store.PutNodeNeighbours(neighbourInfos[0])
+	for _, nodeId := range []string{"a", "b", "001122334455"} {
+		store.PutNodeStatusInfo(nodeId, data.NodeStatusInfo{NodeId: nodeId, Online: true})
+	}
+
+	graphGenerator := &GraphGenerator{Store: store}
+	graph := graphGenerator.GenerateGraph()
+	assert.Equal(3, len(graph.Batadv.Nodes))
+	assert.Equal(1, len(graph.Batadv.Links))
+	link := graph.Batadv.Links[0]
+	assert.True(link.Bidirect)
+	assert.True(link.Vpn)
+	assert.Equal(2.0, link.Tq)
+}
@@ -56,9 +56,12 @@ func (l *FFMapBackendDataLoader) LoadNodesFromFile(path string) error {
 	for nodeId, nodeJsonInfo := range nodesJson.Nodes {
 		nodeinfos := nodeJsonInfo.Nodeinfo
 		nodeStats := nodeJsonInfo.Statistics
+		// Times we can't parse are kept as they are
+		firstseen, _ := data.NormalizeTime(nodeJsonInfo.Firstseen)
+		lastseen, _ := data.NormalizeTime(nodeJsonInfo.Lastseen)
 		nodeStatus := data.NodeStatusInfo{
-			Firstseen: nodeJsonInfo.Firstseen,
-			Lastseen:  nodeJsonInfo.Lastseen,
+			Firstseen: firstseen,
+			Lastseen:  lastseen,
 			Online:    nodeJsonInfo.Flags.Online,
 			Gateway:   nodeJsonInfo.Flags.Gateway,
 		}
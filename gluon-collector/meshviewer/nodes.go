@@ -2,13 +2,16 @@ package meshviewer
 
 import (
 	"encoding/json"
+	"fmt"
 	"net/http"
+	"sync"
 	"time"
 
 	log "github.com/Sirupsen/logrus"
 	"github.com/ffdo/node-informant/gluon-collector/config"
 	"github.com/ffdo/node-informant/gluon-collector/data"
 	"github.com/ffdo/node-informant/gluon-collector/httpserver"
+	"github.com/gorilla/mux"
 )
 
 const TimeFormat string = time.RFC3339
@@ -27,6 +30,36 @@ type NodesJsonNode struct {
 	Flags      NodeFlags         `json:"flags"`
 	Lastseen   string            `json:"lastseen"`
 	Firstseen  string            `json:"firstseen"`
+	// RawNodeinfo is the nodeinfo as received from the node. All fields not
+	// modelled by data.NodeInfo are passed through from it.
+	RawNodeinfo json.RawMessage `json:"-"`
+}
+
+// nodesJsonNode has the same fields as NodesJsonNode, but not its MarshalJSON
+// method.
+type nodesJsonNode NodesJsonNode
+
+// MarshalJSON encodes the node and adds all unknown fields of the raw nodeinfo.
+func (n NodesJsonNode) MarshalJSON() ([]byte, error) {
+	if n.RawNodeinfo == nil {
+		return json.Marshal(nodesJsonNode(n))
+	}
+	typed, err := json.Marshal(n.Nodeinfo)
+	if err != nil {
+		return nil, err
+	}
+	nodeinfo, err := data.MergeUnknownFields(typed, n.RawNodeinfo)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"error":  err,
+			"nodeid": n.Nodeinfo.NodeId,
+		}).Warn("Can't merge raw nodeinfo, using only known fields")
+		return json.Marshal(nodesJsonNode(n))
+	}
+	return json.Marshal(struct {
+		nodesJsonNode
+		Nodeinfo json.RawMessage `json:"nodeinfo"`
+	}{nodesJsonNode(n), nodeinfo})
 }
 
 // NodesJson is the top level structure for nodes.json
@@ -46,6 +79,8 @@ type NodesJsonGenerator struct {
 	Store             data.Nodeinfostore
 	CachedNodesJson   string
 	meshviewerVersion int
+	lock              sync.RWMutex
+	cachedDomains     map[string]string
 }
 
 func NewNodesJsonGenerator(store data.Nodeinfostore) *NodesJsonGenerator {
@@ -60,6 +95,7 @@ func NewNodesJsonGenerator(store data.Nodeinfostore) *NodesJsonGenerator {
 func (n *NodesJsonGenerator) Routes() []httpserver.Route {
 	return []httpserver.Route{
 		httpserver.Route{"NodesJson", "GET", "/nodes.json", n.GetNodesJsonRest},
+		httpserver.Route{"DomainNodesJson", "GET", "/domains/{domain}/nodes.json", n.GetDomainNodesJsonRest},
 	}
 }
 
@@ -69,17 +105,39 @@ func (n *NodesJsonGenerator) GetNodesJsonRest(w http.ResponseWriter, r *http.Req
 	w.Write([]byte(n.CachedNodesJson))
 }
 
+// GetDomainNodesJsonRest sends the cached nodes.json of the mesh domain.
+func (n *NodesJsonGenerator) GetDomainNodesJsonRest(w http.ResponseWriter, r *http.Request) {
+	domain := mux.Vars(r)["domain"]
+	n.lock.RLock()
+	nodesJson, exists := n.cachedDomains[domain]
+	n.lock.RUnlock()
+	if !exists {
+		httpserver.RespondMissing(w, fmt.Errorf("Unknown domain %s", domain))
+		return
+	}
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte(nodesJson))
+}
+
 // convertToMeshviewerStatistics takes care of converting statistics received by
 // announced to something meshviewer can digest.
 func convertToMeshviewerStatistics(in *data.StatisticsStruct) StatisticsStruct {
 	return StatisticsStruct{
-		Clients:     in.Clients.Total,
-		Gateway:     in.Gateway,
-		Loadavg:     in.LoadAverage,
-		MemoryUsage: ((float64(in.Memory.Total) - float64(in.Memory.Free) - float64(in.Memory.Buffers) - float64(in.Memory.Cached)) / float64(in.Memory.Total)),
-		RootfsUsage: in.RootFsUsage,
-		Traffic:     in.Traffic,
-		Uptime:      in.Uptime,
+		Clients:        in.Clients.Total,
+		ClientsWifi:    in.Clients.Wifi,
+		ClientsWifi24:  in.Clients.Wifi24,
+		ClientsWifi5:   in.Clients.Wifi5,
+		ClientsOwe:     in.Clients.Owe,
+		Gateway:        in.Gateway,
+		GatewayNexthop: in.GatewayNexthop,
+		Gateway6:       in.Gateway6,
+		Loadavg:        in.LoadAverage,
+		MemoryUsage:    ((float64(in.Memory.Total) - float64(in.Memory.Free) - float64(in.Memory.Buffers) - float64(in.Memory.Cached)) / float64(in.Memory.Total)),
+		RootfsUsage:    in.RootFsUsage,
+		Traffic:        in.Traffic,
+		Uptime:         in.Uptime,
+		Wireless:       in.Wireless,
 	}
 }
 
@@ -104,40 +162,57 @@ func determineUplink(stats data.StatisticsStruct) bool {
 				return true
 			}
 		}
+		// tunneldigger reports its peers without groups
+		if checkGroupForUplinks(&data.MeshVPNPeerGroup{Peers: stats.MeshVpn.Peers}) {
+			return true
+		}
 	}
 	return false
 }
 
+// buildNode collects all information meshviewer needs about the node.
+func (n *NodesJsonGenerator) buildNode(nodeInfo data.NodeInfo) NodesJsonNode {
+	nodeId := nodeInfo.NodeId
+	status, _ := n.Store.GetNodeStatusInfo(nodeId)
+	var stats StatisticsStruct
+	flags := NodeFlags{
+		Online:  status.Online,
+		Gateway: status.Gateway,
+	}
+	if storedStats, err := n.Store.GetStatistics(nodeId); err == nil {
+		if !status.Online {
+			storedStats.Clients = data.ClientStatistics{}
+		}
+		flags.Uplink = determineUplink(storedStats)
+		stats = convertToMeshviewerStatistics(&storedStats)
+	} else {
+		stats = StatisticsStruct{}
+	}
+	rawNodeinfo, _ := n.Store.GetRawData(nodeId, "nodeinfo")
+	return NodesJsonNode{
+		Nodeinfo:    nodeInfo,
+		Statistics:  &stats,
+		Lastseen:    status.Lastseen,
+		Firstseen:   status.Firstseen,
+		Flags:       flags,
+		RawNodeinfo: rawNodeinfo,
+	}
+}
+
 // GetNodesJson fills a NodesJson struct with all information stored in the
 // Nodeinfostore
 func (n *NodesJsonGenerator) GetNodesJson() NodesJson {
+	return n.getNodesJson(nil)
+}
+
+// getNodesJson fills a NodesJson struct with all nodes in include or with all
+// nodes if include is nil.
+func (n *NodesJsonGenerator) getNodesJson(include map[string]bool) NodesJson {
 	timestamp := time.Now().Format(TimeFormat)
 	nodes := make(map[string]NodesJsonNode)
 	for _, nodeInfo := range n.Store.GetNodeInfos() {
-		nodeId := nodeInfo.NodeId
-		status, _ := n.Store.GetNodeStatusInfo(nodeId)
-		var stats StatisticsStruct
-		flags := NodeFlags{
-			Online:  status.Online,
-			Gateway: status.Gateway,
-		}
-		if storedStats, err := n.Store.GetStatistics(nodeId); err == nil {
-			if !status.Online {
-				storedStats.Clients.Wifi = 0
-				storedStats.Clients.Total = 0
-			}
-			flags.Uplink = determineUplink(storedStats)
-			stats = convertToMeshviewerStatistics(&storedStats)
-		} else {
-			stats = StatisticsStruct{}
-		}
-
-		nodes[nodeId] = NodesJsonNode{
-			Nodeinfo:   nodeInfo,
-			Statistics: &stats,
-			Lastseen:   status.Lastseen,
-			Firstseen:  status.Firstseen,
-			Flags:      flags,
+		if include == nil || include[nodeInfo.NodeId] {
+			nodes[nodeInfo.NodeId] = n.buildNode(nodeInfo)
 		}
 	}
 
@@ -151,35 +226,17 @@ func (n *NodesJsonGenerator) GetNodesJson() NodesJson {
 // GetNodesJson fills a NodesJsonV2 struct with all information stored in the
 // Nodeinfostore
 func (n *NodesJsonGenerator) GetNodesJsonV2() NodesJsonV2 {
+	return n.getNodesJsonV2(nil)
+}
+
+func (n *NodesJsonGenerator) getNodesJsonV2(include map[string]bool) NodesJsonV2 {
 	timestamp := time.Now().Format(TimeFormat)
 	nodeInfos := n.Store.GetNodeInfos()
 	nodes := make([]NodesJsonNode, 0, len(nodeInfos))
 	for _, nodeInfo := range nodeInfos {
-		nodeId := nodeInfo.NodeId
-		status, _ := n.Store.GetNodeStatusInfo(nodeId)
-		var stats StatisticsStruct
-		flags := NodeFlags{
-			Online:  status.Online,
-			Gateway: status.Gateway,
-		}
-		if storedStats, err := n.Store.GetStatistics(nodeId); err == nil {
-			if !status.Online {
-				storedStats.Clients.Wifi = 0
-				storedStats.Clients.Total = 0
-			}
-			flags.Uplink = determineUplink(storedStats)
-			stats = convertToMeshviewerStatistics(&storedStats)
-		} else {
-			stats = StatisticsStruct{}
+		if include == nil || include[nodeInfo.NodeId] {
+			nodes = append(nodes, n.buildNode(nodeInfo))
 		}
-		node := NodesJsonNode{
-			Nodeinfo:   nodeInfo,
-			Statistics: &stats,
-			Lastseen:   status.Lastseen,
-			Firstseen:  status.Firstseen,
-			Flags:      flags,
-		}
-		nodes = append(nodes, node)
 	}
 	nodesJson := NodesJsonV2{
 		Timestamp: timestamp,
@@ -189,26 +246,45 @@ func (n *NodesJsonGenerator) GetNodesJsonV2() NodesJsonV2 {
 	return nodesJson
 }
 
-// UpdateNodesJson creates a new json string from a freshly generated NodesJson
-// and caches it so, that the REST handlers can simply send the cached string.
-func (n *NodesJsonGenerator) UpdateNodesJson() {
-
+// marshalNodesJson generates the nodes.json in the configured version for the
+// nodes in include or all nodes if include is nil.
+func (n *NodesJsonGenerator) marshalNodesJson(include map[string]bool) (string, error) {
 	var nodeData interface{}
 	switch n.meshviewerVersion {
 	case 1:
-		nodeData = n.GetNodesJson()
+		nodeData = n.getNodesJson(include)
 	case 2:
-		nodeData = n.GetNodesJsonV2()
+		nodeData = n.getNodesJsonV2(include)
 	}
-
 	data, err := json.Marshal(&nodeData)
+	return string(data), err
+}
+
+// UpdateNodesJson creates a new json string from a freshly generated NodesJson
+// and caches it so, that the REST handlers can simply send the cached string.
+// A nodes.json for every mesh domain is cached as well.
+func (n *NodesJsonGenerator) UpdateNodesJson() {
+	nodesJson, err := n.marshalNodesJson(nil)
 	if err != nil {
 		log.WithFields(log.Fields{
-			"error":  err,
-			"value":  err.(*json.UnsupportedValueError).Value,
-			"string": err.(*json.UnsupportedValueError).Str,
+			"error": err,
 		}).Errorf("Error marshalling nodes.json")
-	} else {
-		n.CachedNodesJson = string(data)
+		return
+	}
+	n.CachedNodesJson = nodesJson
+	domains := make(map[string]string)
+	for domain := range data.Domains(n.Store) {
+		domainJson, err := n.marshalNodesJson(data.DomainNodes(n.Store, domain))
+		if err != nil {
+			log.WithFields(log.Fields{
+				"error":  err,
+				"domain": domain,
+			}).Errorf("Error marshalling nodes.json of domain")
+			continue
+		}
+		domains[domain] = domainJson
 	}
+	n.lock.Lock()
+	n.cachedDomains = domains
+	n.lock.Unlock()
 }
@@ -258,3 +258,86 @@ func TestFlaggingUplink(t *testing.T) {
 	assert.Nil(err)
 	assert.False(determineUplink(statistics))
 }
+
+func TestNodesJsonPassesUnknownFields(t *testing.T) {
+	assert := assert.New(t)
+	store := data.NewSimpleInMemoryStore()
+	raw := []byte(`{"node_id": "e8de27252554", "hostname": "FF-DO-Josephstr-13",
+		"system": {"site_code": "ffdo", "domain_code": "dom1"},
+		"software": {"tunneldigger": {"enabled": true}}}`)
+	nodeinfo := data.NodeInfo{}
+	assert.Nil(json.Unmarshal(raw, &nodeinfo))
+	nodeinfo.Hostname = "Changed"
+	store.PutNodeInfo(nodeinfo)
+	store.PutRawData(nodeinfo.NodeId, "nodeinfo", raw)
+
+	generator := &NodesJsonGenerator{Store: store}
+	encoded, err := json.Marshal(generator.GetNodesJson())
+	assert.Nil(err)
+
+	var decoded map[string]interface{}
+	assert.Nil(json.Unmarshal(encoded, &decoded))
+	node := decoded["nodes"].(map[string]interface{})["e8de27252554"].(map[string]interface{})
+	encodedNodeinfo := node["nodeinfo"].(map[string]interface{})
+	assert.Equal("Changed", encodedNodeinfo["hostname"], "Known fields are taken from the struct")
+	system := encodedNodeinfo["system"].(map[string]interface{})
+	assert.Equal("ffdo", system["site_code"])
+	assert.Equal("dom1", system["domain_code"])
+	software := encodedNodeinfo["software"].(map[string]interface{})
+	assert.NotNil(software["tunneldigger"])
+	assert.NotNil(node["flags"])
+}
+
+func TestFlaggingTunneldiggerUplink(t *testing.T) {
+	assert := assert.New(t)
+	statistics := data.StatisticsStruct{
+		MeshVpn: &data.MeshVPNStruct{
+			Peers: map[string]*data.MeshVPNPeerLink{
+				"broker1": nil,
+				"broker2": &data.MeshVPNPeerLink{Established: 12.5},
+			},
+		},
+	}
+	assert.True(determineUplink(statistics))
+	statistics.MeshVpn.Peers["broker2"] = nil
+	assert.False(determineUplink(statistics))
+}
+
+func TestConvertingExtendedStatistics(t *testing.T) {
+	assert := assert.New(t)
+	statistics := data.StatisticsStruct{
+		Clients:        data.ClientStatistics{Total: 9, Wifi: 8, Wifi24: 5, Wifi5: 3, Owe: 2},
+		GatewayNexthop: "c6:71:20:ff:03:57",
+		Wireless:       []data.WirelessStatistics{data.WirelessStatistics{Frequency: 2412}},
+	}
+	converted := convertToMeshviewerStatistics(&statistics)
+	assert.Equal(9, converted.Clients)
+	assert.Equal(5, converted.ClientsWifi24)
+	assert.Equal(3, converted.ClientsWifi5)
+	assert.Equal(2, converted.ClientsOwe)
+	assert.Equal("c6:71:20:ff:03:57", converted.GatewayNexthop)
+	assert.Equal(1, len(converted.Wireless))
+}
+
+func TestPartitioningNodesJsonByDomain(t *testing.T) {
+	assert := assert.New(t)
+	store := data.NewSimpleInMemoryStore()
+	domains := map[string]string{"node1": "dom1", "node2": "dom1", "node3": "dom2"}
+	for nodeId, domain := range domains {
+		store.PutNodeInfo(data.NodeInfo{NodeId: nodeId})
+		store.PutNodeStatusInfo(nodeId, data.NodeStatusInfo{NodeId: nodeId, Online: true, Domain: domain})
+	}
+
+	generator := &NodesJsonGenerator{Store: store, meshviewerVersion: 1}
+	generator.UpdateNodesJson()
+	for domain, expected := range map[string]int{"dom1": 2, "dom2": 1} {
+		nodesJson := NodesJson{}
+		assert.Nil(json.Unmarshal([]byte(generator.cachedDomains[domain]), &nodesJson))
+		assert.Equal(expected, len(nodesJson.Nodes))
+		for nodeId := range nodesJson.Nodes {
+			assert.Equal(domain, domains[nodeId])
+		}
+	}
+	_, exists := generator.cachedDomains["dom3"]
+	assert.False(exists)
+}
@@ -40,11 +40,18 @@ import "github.com/ffdo/node-informant/gluon-collector/data"
 // Unfortunately the statistics structure expected by meshviewer looks slightly
 // different than the statistics structure returned from announced.
 type StatisticsStruct struct {
-	Clients     int                 `json:"clients"`
-	Gateway     string              `json:"gateway"`
-	Loadavg     float64             `json:"loadavg"`
-	MemoryUsage float64             `json:"memory_usage"`
-	RootfsUsage float64             `json:"rootfs_usage"`
-	Uptime      float64             `json:"uptime"`
-	Traffic     *data.TrafficStruct `json:"traffic"`
+	Clients        int                       `json:"clients"`
+	ClientsWifi    int                       `json:"clients_wifi,omitempty"`
+	ClientsWifi24  int                       `json:"clients_wifi24,omitempty"`
+	ClientsWifi5   int                       `json:"clients_wifi5,omitempty"`
+	ClientsOwe     int                       `json:"clients_owe,omitempty"`
+	Gateway        string                    `json:"gateway"`
+	GatewayNexthop string                    `json:"gateway_nexthop,omitempty"`
+	Gateway6       string                    `json:"gateway6,omitempty"`
+	Loadavg        float64                   `json:"loadavg"`
+	MemoryUsage    float64                   `json:"memory_usage"`
+	RootfsUsage    float64                   `json:"rootfs_usage"`
+	Uptime         float64                   `json:"uptime"`
+	Traffic        *data.TrafficStruct       `json:"traffic"`
+	Wireless       []data.WirelessStatistics `json:"wireless,omitempty"`
 }
@@ -0,0 +1,69 @@
+package pipeline
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/ffdo/node-informant/announced"
+)
+
+// CapturePipe writes every received Response unmodified to a file, before any
+// other processing happens. The written file has the same format as the
+// testdata.raw used by the tests, so captures can be used as test data.
+type CapturePipe struct {
+	lock    sync.Mutex
+	file    io.WriteCloser
+	writer  *bufio.Writer
+	errored bool
+}
+
+// NewCapturePipe creates a CapturePipe writing to the file at path. An existing
+// file is truncated.
+func NewCapturePipe(path string) (*CapturePipe, error) {
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	return &CapturePipe{file: file, writer: bufio.NewWriter(file)}, nil
+}
+
+func (c *CapturePipe) capture(response announced.Response) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.errored || response.ClientAddr == nil {
+		return
+	}
+	_, err := c.writer.WriteString(fmt.Sprintf("%s|", response.String()))
+	if err == nil {
+		err = c.writer.Flush()
+	}
+	if err != nil {
+		log.WithFields(log.Fields{
+			"error": err,
+		}).Error("Can't write to capture file, stopping capture")
+		c.errored = true
+	}
+}
+
+func (c *CapturePipe) Process(in chan announced.Response) chan announced.Response {
+	out := make(chan announced.Response)
+	go func() {
+		for response := range in {
+			c.capture(response)
+			out <- response
+		}
+	}()
+	return out
+}
+
+// Close flushes and closes the capture file.
+func (c *CapturePipe) Close() error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.writer.Flush()
+	return c.file.Close()
+}
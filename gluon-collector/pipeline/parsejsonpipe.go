@@ -2,6 +2,7 @@ package pipeline
 
 import (
 	"encoding/json"
+	"time"
 
 	log "github.com/Sirupsen/logrus"
 	"github.com/ffdo/node-informant/announced"
@@ -12,42 +13,70 @@ import (
 // expect the response to have string payload containing json encoded data. It is
 // possible that the ReceivePipeline needs to some processing (like deflating)to
 // ensure this. All unparseable packets are discarded and written to the error log.
+// Beside the parsed structs every response carries its original json document.
 type JsonParsePipe struct {
 }
 
+// responseMeta builds the envelope for all responses parsed from the received
+// packet. Packets without receive time get the current time.
+func responseMeta(response announced.Response) data.ResponseMeta {
+	received := response.Received
+	if received.IsZero() {
+		received = time.Now()
+	}
+	return data.ResponseMeta{
+		ClientAddr: response.ClientAddr,
+		Receiver:   response.Receiver,
+		Received:   received,
+		QueryRound: response.QueryRound,
+		Domain:     response.Domain,
+	}
+}
+
 func (j *JsonParsePipe) Process(in chan announced.Response) chan data.ParsedResponse {
 	out := make(chan data.ParsedResponse)
 	go func() {
 		for response := range in {
+			meta := responseMeta(response)
 			if !response.Errored {
 				respondInfo := &data.RespondNodeinfo{}
+				rawInfo := &data.RawRespondNodeinfo{}
 				err := json.Unmarshal(response.Payload, respondInfo)
+				if err == nil {
+					err = json.Unmarshal(response.Payload, rawInfo)
+				}
 				if err != nil {
 					log.WithFields(log.Fields{
 						"error":  err,
 						"client": response.ClientAddr,
 						"json":   string(response.Payload),
 					}).Error("Error parsing json")
-					out <- data.ErroredResponse{}
+					out <- data.ErroredResponse{ResponseMeta: meta}
 				} else {
 					if respondInfo.Nodeinfo != nil {
 						out <- data.NodeinfoResponse{
-							Nodeinfo: *respondInfo.Nodeinfo,
+							ResponseMeta: meta,
+							Nodeinfo:     *respondInfo.Nodeinfo,
+							Raw:          rawInfo.Nodeinfo,
 						}
 					}
 					if respondInfo.Statistics != nil {
 						out <- data.StatisticsResponse{
-							Statistics: respondInfo.Statistics,
+							ResponseMeta: meta,
+							Statistics:   respondInfo.Statistics,
+							Raw:          rawInfo.Statistics,
 						}
 					}
 					if respondInfo.Neighbours != nil {
 						out <- data.NeighbourReponse{
-							Neighbours: respondInfo.Neighbours,
+							ResponseMeta: meta,
+							Neighbours:   respondInfo.Neighbours,
+							Raw:          rawInfo.Neighbours,
 						}
 					}
 				}
 			} else {
-				out <- data.ErroredResponse{}
+				out <- data.ErroredResponse{ResponseMeta: meta}
 			}
 		}
 	}()
@@ -26,13 +26,9 @@ type ReceivePipeline struct {
 // through all ReceivePipes and at the end let the result be parsed by the ParsePipe.
 func NewReceivePipeline(parsePipe ParsePipe, pipes ...ReceivePipe) *ReceivePipeline {
 	head := make(chan announced.Response)
-	var next_chan chan announced.Response
+	next_chan := head
 	for _, pipe := range pipes {
-		if next_chan == nil {
-			next_chan = pipe.Process(head)
-		} else {
-			next_chan = pipe.Process(next_chan)
-		}
+		next_chan = pipe.Process(next_chan)
 	}
 	last_chan := parsePipe.Process(next_chan)
 	return &ReceivePipeline{head: head, tail: last_chan}
@@ -76,7 +72,9 @@ type ProcessPipeline struct {
 
 func (pipeline *ProcessPipeline) Close() error {
 	close(pipeline.head)
-	close(pipeline.tail)
+	if pipeline.tail != pipeline.head {
+		close(pipeline.tail)
+	}
 	return nil
 }
 
@@ -96,13 +94,9 @@ func (pipeline *ProcessPipeline) Dequeue(handler func(data.ParsedResponse)) {
 // NewProcessPipeline creates a new ProcessPipeline connecting all specified ProcessPipes
 func NewProcessPipeline(pipes ...ProcessPipe) *ProcessPipeline {
 	head := make(chan data.ParsedResponse)
-	var next_chan chan data.ParsedResponse
+	next_chan := head
 	for _, pipe := range pipes {
-		if next_chan == nil {
-			next_chan = pipe.Process(head)
-		} else {
-			next_chan = pipe.Process(next_chan)
-		}
+		next_chan = pipe.Process(next_chan)
 	}
 	return &ProcessPipeline{head: head, tail: next_chan}
 }
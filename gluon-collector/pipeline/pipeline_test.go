@@ -37,3 +37,30 @@ func TestDeflatingReceivePipeline(t *testing.T) {
 		assert.Equal(2, receivedPackets)
 	}()
 }
+
+func TestParsePipeKeepsResponseMeta(t *testing.T) {
+	assert := assert.New(t)
+	received := time.Date(2016, 3, 1, 12, 0, 0, 0, time.UTC)
+	in := make(chan announced.Response, 1)
+	out := (&JsonParsePipe{}).Process(in)
+	in <- announced.Response{
+		ClientAddr: testPacket1.ClientAddr,
+		Payload:    []byte(`{"statistics": {"node_id": "a"}}`),
+		Receiver:   "bat0",
+		Received:   received,
+		QueryRound: 3,
+	}
+	response := <-out
+	meta := response.Meta()
+	assert.Equal("statistics", response.Type())
+	assert.Equal(testPacket1.ClientAddr, meta.ClientAddr)
+	assert.Equal("bat0", meta.Receiver)
+	assert.Equal(received, meta.Received)
+	assert.Equal(uint64(3), meta.QueryRound)
+
+	in <- announced.Response{Payload: []byte(`not json`)}
+	response = <-out
+	assert.Equal("errored", response.Type())
+	assert.False(response.Meta().Received.IsZero(), "Missing receive times are set")
+	close(in)
+}
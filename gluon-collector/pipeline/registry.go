@@ -0,0 +1,153 @@
+package pipeline
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/ffdo/node-informant/gluon-collector/data"
+	cfg "github.com/olebedev/config"
+)
+
+// ReceivePipeFactory creates a ReceivePipe configured by the given options.
+type ReceivePipeFactory func(options *cfg.Config) (ReceivePipe, error)
+
+// ParsePipeFactory creates a ParsePipe configured by the given options.
+type ParsePipeFactory func(options *cfg.Config) (ParsePipe, error)
+
+// ProcessPipeFactory creates a ProcessPipe working on the given store and
+// configured by the given options.
+type ProcessPipeFactory func(store data.Nodeinfostore, options *cfg.Config) (ProcessPipe, error)
+
+var (
+	registryLock     sync.RWMutex
+	receiveFactories = make(map[string]ReceivePipeFactory)
+	parseFactories   = make(map[string]ParsePipeFactory)
+	processFactories = make(map[string]ProcessPipeFactory)
+)
+
+// RegisterReceivePipe makes a ReceivePipe available under the given name. It
+// is meant to be called from the init function of the package implementing the
+// pipe. Registering the same name twice panics.
+func RegisterReceivePipe(name string, factory ReceivePipeFactory) {
+	registryLock.Lock()
+	defer registryLock.Unlock()
+	if _, exists := receiveFactories[name]; exists {
+		panic(fmt.Sprintf("Receive pipe %s registered twice", name))
+	}
+	receiveFactories[name] = factory
+}
+
+// RegisterParsePipe makes a ParsePipe available under the given name. The same
+// rules as for RegisterReceivePipe apply.
+func RegisterParsePipe(name string, factory ParsePipeFactory) {
+	registryLock.Lock()
+	defer registryLock.Unlock()
+	if _, exists := parseFactories[name]; exists {
+		panic(fmt.Sprintf("Parse pipe %s registered twice", name))
+	}
+	parseFactories[name] = factory
+}
+
+// RegisterProcessPipe makes a ProcessPipe available under the given name. The
+// same rules as for RegisterReceivePipe apply.
+func RegisterProcessPipe(name string, factory ProcessPipeFactory) {
+	registryLock.Lock()
+	defer registryLock.Unlock()
+	if _, exists := processFactories[name]; exists {
+		panic(fmt.Sprintf("Process pipe %s registered twice", name))
+	}
+	processFactories[name] = factory
+}
+
+// EmptyOptions returns options without any value set, so all stages fall back
+// to their defaults.
+func EmptyOptions() *cfg.Config {
+	return &cfg.Config{Root: make(map[string]interface{})}
+}
+
+func optionsOrEmpty(options *cfg.Config) *cfg.Config {
+	if options == nil || options.Root == nil {
+		return EmptyOptions()
+	}
+	return options
+}
+
+// NewReceivePipe creates the ReceivePipe registered under the given name.
+func NewReceivePipe(name string, options *cfg.Config) (ReceivePipe, error) {
+	registryLock.RLock()
+	factory, exists := receiveFactories[name]
+	registryLock.RUnlock()
+	if !exists {
+		return nil, fmt.Errorf("Unknown receive pipe %s", name)
+	}
+	return factory(optionsOrEmpty(options))
+}
+
+// NewParsePipe creates the ParsePipe registered under the given name.
+func NewParsePipe(name string, options *cfg.Config) (ParsePipe, error) {
+	registryLock.RLock()
+	factory, exists := parseFactories[name]
+	registryLock.RUnlock()
+	if !exists {
+		return nil, fmt.Errorf("Unknown parse pipe %s", name)
+	}
+	return factory(optionsOrEmpty(options))
+}
+
+// NewProcessPipe creates the ProcessPipe registered under the given name.
+func NewProcessPipe(name string, store data.Nodeinfostore, options *cfg.Config) (ProcessPipe, error) {
+	registryLock.RLock()
+	factory, exists := processFactories[name]
+	registryLock.RUnlock()
+	if !exists {
+		return nil, fmt.Errorf("Unknown process pipe %s", name)
+	}
+	return factory(store, optionsOrEmpty(options))
+}
+
+func sortedNames(names []string) []string {
+	sort.Strings(names)
+	return names
+}
+
+// ReceivePipeNames returns the names of all registered ReceivePipes.
+func ReceivePipeNames() []string {
+	registryLock.RLock()
+	defer registryLock.RUnlock()
+	names := make([]string, 0, len(receiveFactories))
+	for name := range receiveFactories {
+		names = append(names, name)
+	}
+	return sortedNames(names)
+}
+
+// ProcessPipeNames returns the names of all registered ProcessPipes.
+func ProcessPipeNames() []string {
+	registryLock.RLock()
+	defer registryLock.RUnlock()
+	names := make([]string, 0, len(processFactories))
+	for name := range processFactories {
+		names = append(names, name)
+	}
+	return sortedNames(names)
+}
+
+func init() {
+	RegisterReceivePipe("deflate", func(options *cfg.Config) (ReceivePipe, error) {
+		return &DeflatePipe{}, nil
+	})
+	RegisterReceivePipe("capture", func(options *cfg.Config) (ReceivePipe, error) {
+		path, err := options.String("path")
+		if err != nil {
+			return nil, fmt.Errorf("The capture pipe needs a path: %v", err)
+		}
+		return NewCapturePipe(path)
+	})
+	RegisterParsePipe("json", func(options *cfg.Config) (ParsePipe, error) {
+		return &JsonParsePipe{}, nil
+	})
+	RegisterProcessPipe("validate", func(store data.Nodeinfostore, options *cfg.Config) (ProcessPipe, error) {
+		return &ValidationPipe{}, nil
+	})
+}
@@ -0,0 +1,28 @@
+package pipeline
+
+import (
+	log "github.com/Sirupsen/logrus"
+	"github.com/ffdo/node-informant/gluon-collector/data"
+)
+
+// ValidationPipe drops all responses which couldn't be parsed or don't carry a
+// node id, so following pipes only get usable data.
+type ValidationPipe struct {
+}
+
+func (v *ValidationPipe) Process(in chan data.ParsedResponse) chan data.ParsedResponse {
+	out := make(chan data.ParsedResponse)
+	go func() {
+		for response := range in {
+			if response.Type() == "errored" || response.NodeId() == "" {
+				log.WithFields(log.Fields{
+					"type":   response.Type(),
+					"client": response.Meta().ClientAddr,
+				}).Debug("Dropping invalid response")
+				continue
+			}
+			out <- response
+		}
+	}()
+	return out
+}
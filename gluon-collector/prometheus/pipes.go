@@ -1,60 +1,24 @@
 package prometheus
 
 import (
+	"strconv"
+
 	log "github.com/Sirupsen/logrus"
 	"github.com/ffdo/node-informant/gluon-collector/config"
 	"github.com/ffdo/node-informant/gluon-collector/data"
 	"github.com/ffdo/node-informant/gluon-collector/pipeline"
 	stat "github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
 )
 
-// NodeCountPipe simply increments the TotalNodes Gauge if we receive a response from
-// a node we don't habe a NodeStatusInfo object for. This indicates that we didn't had
-// contact with this node before and therefore it is new.
-// It also increments the OnlineNodes Gauge by one in case the stored NodeStatusInfo
-// indicates that this node wasn't online before.
-type NodeCountPipe struct {
-	Store data.Nodeinfostore
-}
+// deprecatedPipe passes all responses unchanged. It replaces stages which are
+// not needed any more, so existing pipeline configurations keep working.
+type deprecatedPipe struct{}
 
-func (n *NodeCountPipe) Process(in chan data.ParsedResponse) chan data.ParsedResponse {
+func (d *deprecatedPipe) Process(in chan data.ParsedResponse) chan data.ParsedResponse {
 	out := make(chan data.ParsedResponse)
 	go func() {
 		for response := range in {
-			if response.Type() == "nodeinfo" {
-				_, err := n.Store.GetNodeStatusInfo(response.NodeId())
-				if err != nil {
-					log.WithFields(log.Fields{
-						"nodeid": response.NodeId(),
-					}).Info("Discovered new node")
-					OnlineNodes.Inc()
-					TotalNodes.Inc()
-				}
-			}
-			out <- response
-		}
-	}()
-	return out
-}
-
-// ReturnedNodeDetector handles incrementing the online node metric for returning
-// nodes.
-type ReturnedNodeDetector struct {
-	Store data.Nodeinfostore
-}
-
-func (r *ReturnedNodeDetector) Process(in chan data.ParsedResponse) chan data.ParsedResponse {
-	out := make(chan data.ParsedResponse)
-	go func() {
-		for response := range in {
-			if response.Type() == "nodeinfo" {
-				status, err := r.Store.GetNodeStatusInfo(response.NodeId())
-				if err == nil && !status.Online {
-					// Existing offline node came back online
-					log.Debugf("Node %s came back online", response.NodeId())
-					OnlineNodes.Inc()
-				}
-			}
 			out <- response
 		}
 	}()
@@ -147,11 +111,40 @@ func (t *TrafficCountPipe) Process(in chan data.ParsedResponse) chan data.Parsed
 
 // NodeMetricCollector updates per node metrics based on received statistics responses.
 type NodeMetricCollector struct {
-	Store data.Nodeinfostore
+	Store    data.Nodeinfostore
+	nexthops *linkGauges
+	airtime  *linkGauges
+}
+
+// NodeOverrides are applied to the nodes before their labels are built. Nil
+// if no overrides are configured.
+var NodeOverrides *data.Overrides
+
+// labelNode is the node the node labels of the metrics are built from.
+type labelNode struct {
+	data.NodeInfo
+	// Domain is the mesh domain of the node.
+	Domain string
+	// Hidden nodes get no per node metrics.
+	Hidden bool
 }
 
-func getLabels(nodeinfo data.NodeInfo, defaultLabels ...string) []string {
-	labels := make([]string, 0, 5)
+// newLabelNode takes the domain of the node from its status info, which knows
+// the domain of the receivers, or from the nodeinfo. The NodeOverrides of the
+// node are applied to both.
+func newLabelNode(store data.Nodeinfostore, nodeinfo data.NodeInfo) labelNode {
+	nodeinfo = NodeOverrides.ApplyNodeInfo(nodeinfo)
+	node := labelNode{NodeInfo: nodeinfo, Domain: nodeinfo.Domain(), Hidden: NodeOverrides.Hidden(nodeinfo.NodeId)}
+	if status, err := store.GetNodeStatusInfo(nodeinfo.NodeId); err == nil {
+		if status = NodeOverrides.ApplyStatus(status); status.Domain != "" {
+			node.Domain = status.Domain
+		}
+	}
+	return node
+}
+
+func getLabels(nodeinfo labelNode, defaultLabels ...string) []string {
+	labels := make([]string, 0, 6)
 	labels = append(labels, nodeinfo.NodeId)
 	prometheusCfg, err := config.Global.Get("prometheus")
 	if err != nil {
@@ -163,59 +156,248 @@ func getLabels(nodeinfo data.NodeInfo, defaultLabels ...string) []string {
 	if prometheusCfg.UBool("sitecodelabel", false) {
 		labels = append(labels, nodeinfo.System.SiteCode)
 	}
+	if prometheusCfg.UBool("domainlabel", false) {
+		labels = append(labels, nodeinfo.Domain)
+	}
 	return append(labels, defaultLabels...)
 }
 
+// updateExtendedMetrics sets the metrics for the fields of newer gluon
+// versions. Metrics for fields a node doesn't report are not touched.
+func (n *NodeMetricCollector) updateExtendedMetrics(nodeinfo labelNode, stats *data.StatisticsStruct) {
+	if n.nexthops == nil {
+		n.nexthops = newLinkGauges(NodesGatewayNexthop)
+		n.airtime = newLinkGauges(NodesAirtimeActive, NodesAirtimeBusy, NodesAirtimeRx,
+			NodesAirtimeTx, NodesChannelUtilization, NodesWirelessNoise)
+	}
+	NodesClientsByType.WithLabelValues(getLabels(nodeinfo, "wifi")...).Set(float64(stats.Clients.Wifi))
+	NodesClientsByType.WithLabelValues(getLabels(nodeinfo, "wifi24")...).Set(float64(stats.Clients.Wifi24))
+	NodesClientsByType.WithLabelValues(getLabels(nodeinfo, "wifi5")...).Set(float64(stats.Clients.Wifi5))
+	NodesClientsByType.WithLabelValues(getLabels(nodeinfo, "owe")...).Set(float64(stats.Clients.Owe))
+
+	n.nexthops.reset(stats.NodeId)
+	if stats.Gateway != "" {
+		n.nexthops.set(stats.NodeId, getLabels(nodeinfo, stats.Gateway, stats.GatewayNexthop), 1)
+	}
+
+	n.airtime.reset(stats.NodeId)
+	for _, wireless := range stats.Wireless {
+		n.airtime.set(stats.NodeId, getLabels(nodeinfo, strconv.Itoa(wireless.Frequency)),
+			float64(wireless.Active), float64(wireless.Busy), float64(wireless.Rx),
+			float64(wireless.Tx), wireless.ChannelUtilization(), float64(wireless.Noise))
+	}
+
+	if stats.Stat != nil && stats.Stat.CPU != nil {
+		for mode, value := range stats.Stat.CPU.Modes() {
+			NodesCPU.WithLabelValues(getLabels(nodeinfo, mode)...).Set(float64(value))
+		}
+	}
+}
+
 func (n *NodeMetricCollector) Process(in chan data.ParsedResponse) chan data.ParsedResponse {
 	out := make(chan data.ParsedResponse)
 	go func() {
 		for response := range in {
 			if response.Type() == "statistics" {
 				stats := response.ParsedData().(*data.StatisticsStruct)
-				nodeinfo, err := n.Store.GetNodeInfo(response.NodeId())
+				storedNodeinfo, err := n.Store.GetNodeInfo(response.NodeId())
 				if err != nil {
-					// Extended labels are not configured
-					if _, err := config.Global.Get("prometheus"); err != nil {
-						// FIXME This is bad code duplication, we should find a more elegant way
-						NodesClients.WithLabelValues(response.NodeId()).Set(float64(stats.Clients.Total))
-						NodesUptime.WithLabelValues(response.NodeId()).Set(stats.Uptime)
-						if stats.Traffic != nil {
-							if stats.Traffic.Rx != nil {
-								NodesTrafficRx.WithLabelValues(response.NodeId(), "traffic").Set(float64(stats.Traffic.Rx.Bytes))
-							}
-							if stats.Traffic.Tx != nil {
-								NodesTrafficTx.WithLabelValues(response.NodeId(), "traffic").Set(float64(stats.Traffic.Tx.Bytes))
-							}
-							if stats.Traffic.MgmtRx != nil {
-								NodesTrafficRx.WithLabelValues(response.NodeId(), "mgmt_traffic").Set(float64(stats.Traffic.MgmtRx.Bytes))
-							}
-							if stats.Traffic.MgmtTx != nil {
-								NodesTrafficTx.WithLabelValues(response.NodeId(), "mgmt_traffic").Set(float64(stats.Traffic.MgmtTx.Bytes))
-							}
-						}
-					} else {
+					if _, err := config.Global.Get("prometheus"); err == nil {
+						// Extended labels are configured, but we don't know them
 						log.WithFields(log.Fields{
 							"nodeid": response.NodeId(),
 						}).Errorf("Can't retrieve node infos to get the hostname")
+						out <- response
+						continue
 					}
-				} else {
-					NodesClients.WithLabelValues(getLabels(nodeinfo)...).Set(float64(stats.Clients.Total))
-					NodesUptime.WithLabelValues(getLabels(nodeinfo)...).Set(stats.Uptime)
-					if stats.Traffic != nil {
-						if stats.Traffic.Rx != nil {
-							NodesTrafficRx.WithLabelValues(getLabels(nodeinfo, "traffic")...).Set(float64(stats.Traffic.Rx.Bytes))
-						}
-						if stats.Traffic.Tx != nil {
-							NodesTrafficTx.WithLabelValues(getLabels(nodeinfo, "traffic")...).Set(float64(stats.Traffic.Tx.Bytes))
-						}
-						if stats.Traffic.MgmtRx != nil {
-							NodesTrafficRx.WithLabelValues(getLabels(nodeinfo, "mgmt_traffic")...).Set(float64(stats.Traffic.MgmtRx.Bytes))
-						}
-						if stats.Traffic.MgmtTx != nil {
-							NodesTrafficTx.WithLabelValues(getLabels(nodeinfo, "mgmt_traffic")...).Set(float64(stats.Traffic.MgmtTx.Bytes))
-						}
+					// Without extended labels only the node id is needed
+					storedNodeinfo = data.NodeInfo{NodeId: response.NodeId()}
+				}
+				nodeinfo := newLabelNode(n.Store, storedNodeinfo)
+				if nodeinfo.Hidden {
+					out <- response
+					continue
+				}
+				NodesClients.WithLabelValues(getLabels(nodeinfo)...).Set(float64(stats.Clients.Total))
+				NodesUptime.WithLabelValues(getLabels(nodeinfo)...).Set(stats.Uptime)
+				if stats.Traffic != nil {
+					if stats.Traffic.Rx != nil {
+						NodesTrafficRx.WithLabelValues(getLabels(nodeinfo, "traffic")...).Set(float64(stats.Traffic.Rx.Bytes))
+					}
+					if stats.Traffic.Tx != nil {
+						NodesTrafficTx.WithLabelValues(getLabels(nodeinfo, "traffic")...).Set(float64(stats.Traffic.Tx.Bytes))
+					}
+					if stats.Traffic.MgmtRx != nil {
+						NodesTrafficRx.WithLabelValues(getLabels(nodeinfo, "mgmt_traffic")...).Set(float64(stats.Traffic.MgmtRx.Bytes))
+					}
+					if stats.Traffic.MgmtTx != nil {
+						NodesTrafficTx.WithLabelValues(getLabels(nodeinfo, "mgmt_traffic")...).Set(float64(stats.Traffic.MgmtTx.Bytes))
 					}
 				}
+				n.updateExtendedMetrics(nodeinfo, stats)
+			}
+			out <- response
+		}
+	}()
+	return out
+}
+
+// linkGauges keeps track of the label values of all per link metrics exported
+// for a node, so links a node doesn't report any more can be removed.
+type linkGauges struct {
+	gauges []*stat.GaugeVec
+	links  map[string][][]string
+}
+
+func newLinkGauges(gauges ...*stat.GaugeVec) *linkGauges {
+	return &linkGauges{gauges: gauges, links: make(map[string][][]string)}
+}
+
+// reset removes all links of the node from the metrics.
+func (l *linkGauges) reset(nodeId string) {
+	for _, labels := range l.links[nodeId] {
+		for _, gauge := range l.gauges {
+			gauge.DeleteLabelValues(labels...)
+		}
+	}
+	delete(l.links, nodeId)
+}
+
+// set updates the values of a link. The values are given in the same order as
+// the gauges.
+func (l *linkGauges) set(nodeId string, labels []string, values ...float64) {
+	for i, gauge := range l.gauges {
+		gauge.WithLabelValues(labels...).Set(values[i])
+	}
+	l.links[nodeId] = append(l.links[nodeId], labels)
+}
+
+// metricVec is a gauge or counter vector.
+type metricVec interface {
+	Collect(ch chan<- stat.Metric)
+	Delete(labels stat.Labels) bool
+}
+
+// nodeMetrics are all metrics with the node labels.
+func nodeMetrics() []metricVec {
+	return []metricVec{NodesTrafficRx, NodesTrafficTx, NodesUptime, NodesClients,
+		NodesWifiSignal, NodesWifiNoise, NodesWifiInactive, NodesClientsByType,
+		NodesGatewayNexthop, NodesAirtimeActive, NodesAirtimeBusy, NodesAirtimeRx,
+		NodesAirtimeTx, NodesChannelUtilization, NodesWirelessNoise, NodesCPU,
+		NodesNeighbourQuality, NodesNeighbourCost}
+}
+
+// DeleteNodeMetrics deletes all series of the node, e.g. when it is hidden by
+// the NodeOverrides. The series are found by the node id label, since the other
+// node labels might have changed in the meantime.
+func DeleteNodeMetrics(nodeId string) {
+	if NodesTrafficRx == nil {
+		return
+	}
+	for _, vec := range nodeMetrics() {
+		metrics := make(chan stat.Metric)
+		go func() {
+			vec.Collect(metrics)
+			close(metrics)
+		}()
+		series := make([]stat.Labels, 0)
+		for metric := range metrics {
+			metricDto := &dto.Metric{}
+			if err := metric.Write(metricDto); err != nil {
+				continue
+			}
+			labels := make(stat.Labels, len(metricDto.Label))
+			for _, pair := range metricDto.Label {
+				labels[pair.GetName()] = pair.GetValue()
+			}
+			if labels["nodeid"] == nodeId {
+				series = append(series, labels)
+			}
+		}
+		// The vector is locked while it is collected
+		for _, labels := range series {
+			vec.Delete(labels)
+		}
+	}
+}
+
+// getNodeinfoForLabels returns the node to build the labels from. If we don't
+// know the node yet hostname and site code labels stay empty.
+func getNodeinfoForLabels(store data.Nodeinfostore, nodeId string) labelNode {
+	nodeinfo, err := store.GetNodeInfo(nodeId)
+	if err != nil {
+		nodeinfo = data.NodeInfo{NodeId: nodeId}
+	}
+	return newLabelNode(store, nodeinfo)
+}
+
+// WifiMetricsPipe updates per link metrics for signal, noise and inactive time
+// of all wifi neighbours reported by the nodes. Links a node doesn't report any
+// more are removed from the metrics.
+type WifiMetricsPipe struct {
+	Store data.Nodeinfostore
+	links *linkGauges
+}
+
+func (w *WifiMetricsPipe) updateLinks(neighbours *data.NeighbourStruct) {
+	if w.links == nil {
+		w.links = newLinkGauges(NodesWifiSignal, NodesWifiNoise, NodesWifiInactive)
+	}
+	nodeinfo := getNodeinfoForLabels(w.Store, neighbours.NodeId)
+	w.links.reset(neighbours.NodeId)
+	if nodeinfo.Hidden {
+		return
+	}
+	for ownMac, wifiNeighbours := range neighbours.Wifi {
+		for peerMac, link := range wifiNeighbours.Neighbours {
+			w.links.set(neighbours.NodeId, getLabels(nodeinfo, ownMac, peerMac),
+				float64(link.Signal), float64(link.Noise), float64(link.Inactive))
+		}
+	}
+}
+
+func (w *WifiMetricsPipe) Process(in chan data.ParsedResponse) chan data.ParsedResponse {
+	out := make(chan data.ParsedResponse)
+	go func() {
+		for response := range in {
+			if response.Type() == "neighbours" {
+				w.updateLinks(response.ParsedData().(*data.NeighbourStruct))
+			}
+			out <- response
+		}
+	}()
+	return out
+}
+
+// NeighbourMetricsPipe updates per link metrics for the mesh neighbours of all
+// routing protocols. Links a node doesn't report any more are removed from the
+// metrics.
+type NeighbourMetricsPipe struct {
+	Store data.Nodeinfostore
+	links *linkGauges
+}
+
+func (n *NeighbourMetricsPipe) updateLinks(neighbours *data.NeighbourStruct) {
+	if n.links == nil {
+		n.links = newLinkGauges(NodesNeighbourQuality, NodesNeighbourCost)
+	}
+	nodeinfo := getNodeinfoForLabels(n.Store, neighbours.NodeId)
+	n.links.reset(neighbours.NodeId)
+	if nodeinfo.Hidden {
+		return
+	}
+	for _, link := range neighbours.Links() {
+		n.links.set(neighbours.NodeId, getLabels(nodeinfo, link.Protocol, link.Interface, link.PeerAddress),
+			link.Quality, link.Cost)
+	}
+}
+
+func (n *NeighbourMetricsPipe) Process(in chan data.ParsedResponse) chan data.ParsedResponse {
+	out := make(chan data.ParsedResponse)
+	go func() {
+		for response := range in {
+			if response.Type() == "neighbours" {
+				n.updateLinks(response.ParsedData().(*data.NeighbourStruct))
 			}
 			out <- response
 		}
@@ -225,13 +407,14 @@ func (n *NodeMetricCollector) Process(in chan data.ParsedResponse) chan data.Par
 
 // GetPrometheusProcessPipes returns all ProcessPipes necessary to keep Prometheus
 // metrics up to date. In most cases the Prometheus pipes need to be added before
-// all other pipes to the ProcessPipeline.
+// all other pipes to the ProcessPipeline. The node counts are kept up to date
+// by ProcessStoredValues instead.
 func GetPrometheusProcessPipes(store data.Nodeinfostore) []pipeline.ProcessPipe {
 	return []pipeline.ProcessPipe{
-		&NodeCountPipe{Store: store},
-		&ReturnedNodeDetector{Store: store},
 		&ClientCountPipe{Store: store},
 		&TrafficCountPipe{Store: store},
 		&NodeMetricCollector{Store: store},
+		&WifiMetricsPipe{Store: store},
+		&NeighbourMetricsPipe{Store: store},
 	}
 }
@@ -34,10 +34,56 @@ func TestCreationOfPrometheusLabels(t *testing.T) {
 	assert.Nil(err)
 	assert.NotNil(prmcfg)
 
-	nodeLabels := getLabels(nodeinfo, "metric")
+	nodeLabels := getLabels(labelNode{NodeInfo: nodeinfo}, "metric")
 	assert.Equal(4, len(nodeLabels))
 	assert.Equal("1122", nodeLabels[0])
 	assert.Equal("Testnode", nodeLabels[1])
 	assert.Equal("fftest", nodeLabels[2])
 	assert.Equal("metric", nodeLabels[3])
 }
+
+func TestDomainLabel(t *testing.T) {
+	assert := assert.New(t)
+	var err error
+	config.Global, err = cfg.ParseYaml(`
+  prometheus:
+    domainlabel: true
+  `)
+	assert.Nil(err)
+	store := data.NewSimpleInMemoryStore()
+	nodeinfo := data.NodeInfo{NodeId: "1122", System: data.SystemStruct{SiteCode: "fftest"}}
+	assert.Equal([]string{"1122", "fftest", "metric"}, getLabels(newLabelNode(store, nodeinfo), "metric"))
+
+	// The domain of the receiver wins over the announced one
+	store.PutNodeStatusInfo("1122", data.NodeStatusInfo{NodeId: "1122", Domain: "dom1"})
+	assert.Equal([]string{"1122", "dom1"}, getLabels(newLabelNode(store, nodeinfo)))
+}
+
+func TestLabelsOfMetricsAreIndependent(t *testing.T) {
+	assert := assert.New(t)
+	saved := nodeLabels
+	defer func() { nodeLabels = saved }()
+	// Appending the configured labels leaves spare capacity
+	nodeLabels = append(make([]string, 0, 8), "nodeid", "hostname", "sitecode")
+
+	traffic := labelsWith("type")
+	cpu := labelsWith("mode")
+	assert.Equal([]string{"nodeid", "hostname", "sitecode", "type"}, traffic)
+	assert.Equal([]string{"nodeid", "hostname", "sitecode", "mode"}, cpu)
+}
+
+func TestDeletingMetricsOfNode(t *testing.T) {
+	assert := assert.New(t)
+	config.Global = &cfg.Config{}
+	Init()
+	nodeinfo := labelNode{NodeInfo: data.NodeInfo{NodeId: "hidden"}}
+	NodesClients.WithLabelValues(getLabels(nodeinfo)...).Set(3)
+	NodesCPU.WithLabelValues(getLabels(nodeinfo, "user")...).Set(10)
+	other := labelNode{NodeInfo: data.NodeInfo{NodeId: "other"}}
+	NodesCPU.WithLabelValues(getLabels(other, "user")...).Set(10)
+
+	DeleteNodeMetrics("hidden")
+	assert.False(NodesClients.DeleteLabelValues(getLabels(nodeinfo)...))
+	assert.False(NodesCPU.DeleteLabelValues(getLabels(nodeinfo, "user")...))
+	assert.True(NodesCPU.DeleteLabelValues(getLabels(other, "user")...), "Other nodes are kept")
+}
@@ -9,6 +9,8 @@ import (
 
 var (
 	nodeLabels = []string{"nodeid"}
+	wifiLabels = []string{"interface", "neighbour"}
+	linkLabels = []string{"protocol", "interface", "neighbour"}
 )
 
 /*
@@ -37,6 +39,48 @@ var (
 	NodesUptime *stat.CounterVec
 
 	NodesClients *stat.GaugeVec
+
+	NodesWifiSignal *stat.GaugeVec
+
+	NodesWifiNoise *stat.GaugeVec
+
+	NodesWifiInactive *stat.GaugeVec
+
+	NodesClientsByType *stat.GaugeVec
+
+	NodesGatewayNexthop *stat.GaugeVec
+
+	NodesAirtimeActive *stat.GaugeVec
+
+	NodesAirtimeBusy *stat.GaugeVec
+
+	NodesAirtimeRx *stat.GaugeVec
+
+	NodesAirtimeTx *stat.GaugeVec
+
+	NodesChannelUtilization *stat.GaugeVec
+
+	NodesWirelessNoise *stat.GaugeVec
+
+	NodesCPU *stat.GaugeVec
+
+	NodesNeighbourQuality *stat.GaugeVec
+
+	NodesNeighbourCost *stat.GaugeVec
+
+	IdentityConflicts stat.Counter
+
+	DuplicateResponses stat.Counter
+
+	DeniedResponses stat.Counter
+
+	GatewayNodes *stat.GaugeVec
+
+	GatewayClients *stat.GaugeVec
+
+	GatewaySwitches *stat.CounterVec
+
+	FlappingNodes stat.Gauge
 )
 
 func initPrometheusMetrics() {
@@ -78,12 +122,12 @@ func initPrometheusMetrics() {
 	NodesTrafficRx = stat.NewCounterVec(stat.CounterOpts{
 		Name: "meshnode_traffic_rx",
 		Help: "Transmitted traffic from nodes",
-	}, append(nodeLabels, "type"))
+	}, labelsWith("type"))
 
 	NodesTrafficTx = stat.NewCounterVec(stat.CounterOpts{
 		Name: "meshnode_traffic_tx",
 		Help: "Received traffic on nodes",
-	}, append(nodeLabels, "type"))
+	}, labelsWith("type"))
 
 	NodesUptime = stat.NewCounterVec(stat.CounterOpts{
 		Name: "meshnode_uptime",
@@ -94,6 +138,119 @@ func initPrometheusMetrics() {
 		Name: "meshnode_clients",
 		Help: "Clients on single meshnodes",
 	}, nodeLabels)
+
+	NodesWifiSignal = stat.NewGaugeVec(stat.GaugeOpts{
+		Name: "meshnode_wifi_signal",
+		Help: "Signal in dBm of wifi links as seen by meshnodes",
+	}, labelsWith(wifiLabels...))
+
+	NodesWifiNoise = stat.NewGaugeVec(stat.GaugeOpts{
+		Name: "meshnode_wifi_noise",
+		Help: "Noise in dBm of wifi links as seen by meshnodes",
+	}, labelsWith(wifiLabels...))
+
+	NodesWifiInactive = stat.NewGaugeVec(stat.GaugeOpts{
+		Name: "meshnode_wifi_inactive",
+		Help: "Milliseconds since the last packet received over wifi links of meshnodes",
+	}, labelsWith(wifiLabels...))
+
+	NodesClientsByType = stat.NewGaugeVec(stat.GaugeOpts{
+		Name: "meshnode_clients_type",
+		Help: "Clients on single meshnodes by type of connection",
+	}, labelsWith("type"))
+
+	NodesGatewayNexthop = stat.NewGaugeVec(stat.GaugeOpts{
+		Name: "meshnode_gateway_nexthop",
+		Help: "Selected gateway and the neighbour over which it is reached, always 1",
+	}, labelsWith("gateway", "nexthop"))
+
+	NodesAirtimeActive = stat.NewGaugeVec(stat.GaugeOpts{
+		Name: "meshnode_airtime_active",
+		Help: "Milliseconds the radio was active",
+	}, labelsWith("frequency"))
+
+	NodesAirtimeBusy = stat.NewGaugeVec(stat.GaugeOpts{
+		Name: "meshnode_airtime_busy",
+		Help: "Milliseconds the channel was busy",
+	}, labelsWith("frequency"))
+
+	NodesAirtimeRx = stat.NewGaugeVec(stat.GaugeOpts{
+		Name: "meshnode_airtime_rx",
+		Help: "Milliseconds the radio was receiving",
+	}, labelsWith("frequency"))
+
+	NodesAirtimeTx = stat.NewGaugeVec(stat.GaugeOpts{
+		Name: "meshnode_airtime_tx",
+		Help: "Milliseconds the radio was transmitting",
+	}, labelsWith("frequency"))
+
+	NodesChannelUtilization = stat.NewGaugeVec(stat.GaugeOpts{
+		Name: "meshnode_channel_utilization",
+		Help: "Share of the active time the channel was busy since the radio came up",
+	}, labelsWith("frequency"))
+
+	NodesWirelessNoise = stat.NewGaugeVec(stat.GaugeOpts{
+		Name: "meshnode_wireless_noise",
+		Help: "Noise in dBm on the channel of the radio",
+	}, labelsWith("frequency"))
+
+	NodesCPU = stat.NewGaugeVec(stat.GaugeOpts{
+		Name: "meshnode_cpu",
+		Help: "Cpu time of meshnodes in jiffies by mode",
+	}, labelsWith("mode"))
+
+	NodesNeighbourQuality = stat.NewGaugeVec(stat.GaugeOpts{
+		Name: "meshnode_neighbour_quality",
+		Help: "Quality between 0 and 1 of links to mesh neighbours of all routing protocols",
+	}, labelsWith(linkLabels...))
+
+	NodesNeighbourCost = stat.NewGaugeVec(stat.GaugeOpts{
+		Name: "meshnode_neighbour_cost",
+		Help: "Routing metric of links to mesh neighbours, tq for batman and cost for babel",
+	}, labelsWith(linkLabels...))
+
+	IdentityConflicts = stat.NewCounter(stat.CounterOpts{
+		Name: "identity_conflicts_total",
+		Help: "Responses whose node id didn't match the known identity of the sender",
+	})
+
+	DuplicateResponses = stat.NewCounter(stat.CounterOpts{
+		Name: "duplicate_responses_total",
+		Help: "Identical responses received more than once, i.e. by several receivers",
+	})
+
+	DeniedResponses = stat.NewCounter(stat.CounterOpts{
+		Name: "denied_responses_total",
+		Help: "Responses dropped or quarantined since their node is on the deny list",
+	})
+
+	GatewayNodes = stat.NewGaugeVec(stat.GaugeOpts{
+		Name: "gateway_nodes",
+		Help: "Online nodes using the gateway",
+	}, []string{"gateway"})
+
+	GatewayClients = stat.NewGaugeVec(stat.GaugeOpts{
+		Name: "gateway_clients",
+		Help: "Clients of the online nodes using the gateway",
+	}, []string{"gateway"})
+
+	GatewaySwitches = stat.NewCounterVec(stat.CounterOpts{
+		Name: "gateway_switches_total",
+		Help: "Nodes switching from one gateway to another",
+	}, []string{"from", "to"})
+
+	FlappingNodes = stat.NewGauge(stat.GaugeOpts{
+		Name: "gateway_flapping_nodes",
+		Help: "Nodes switching their gateway too often",
+	})
+}
+
+// labelsWith returns the node labels followed by the extra labels. The node
+// labels are copied, since the descriptors of the metrics keep the slice.
+func labelsWith(extra ...string) []string {
+	labels := make([]string, 0, len(nodeLabels)+len(extra))
+	labels = append(labels, nodeLabels...)
+	return append(labels, extra...)
 }
 
 func initNodeLabels() {
@@ -107,6 +264,9 @@ func initNodeLabels() {
 	if prometheusCfg.UBool("sitecodelabel", false) {
 		nodeLabels = append(nodeLabels, "sitecode")
 	}
+	if prometheusCfg.UBool("domainlabel", false) {
+		nodeLabels = append(nodeLabels, "domain")
+	}
 }
 
 // Register all accumulated metrics
@@ -128,6 +288,27 @@ func Init() {
 	stat.MustRegister(NodesTrafficTx)
 	stat.MustRegister(NodesUptime)
 	stat.MustRegister(NodesClients)
+	stat.MustRegister(NodesWifiSignal)
+	stat.MustRegister(NodesWifiNoise)
+	stat.MustRegister(NodesWifiInactive)
+	stat.MustRegister(NodesClientsByType)
+	stat.MustRegister(NodesGatewayNexthop)
+	stat.MustRegister(NodesAirtimeActive)
+	stat.MustRegister(NodesAirtimeBusy)
+	stat.MustRegister(NodesAirtimeRx)
+	stat.MustRegister(NodesAirtimeTx)
+	stat.MustRegister(NodesChannelUtilization)
+	stat.MustRegister(NodesWirelessNoise)
+	stat.MustRegister(NodesCPU)
+	stat.MustRegister(NodesNeighbourQuality)
+	stat.MustRegister(NodesNeighbourCost)
+	stat.MustRegister(IdentityConflicts)
+	stat.MustRegister(DuplicateResponses)
+	stat.MustRegister(DeniedResponses)
+	stat.MustRegister(GatewayNodes)
+	stat.MustRegister(GatewayClients)
+	stat.MustRegister(GatewaySwitches)
+	stat.MustRegister(FlappingNodes)
 }
 
 // initTotalClientsGauge iterates over all statistics
@@ -178,19 +359,8 @@ func initTrafficCounter(store data.Nodeinfostore) {
 	}
 }
 
-// decrementOnlineNodes is a callback which can registered with a Nodeinfostore
-// to be notified if a node is marked as offline.
-func decrementOnlineNodes(nodeId string) {
-	if nodeId != "" {
-		OnlineNodes.Dec()
-	} else {
-		log.Warn("decrementOnlineNodes callback called with empty nodeId")
-	}
-}
-
 // initOnlineNodesGauge counts all nodes with status Online and initializes the
-// OnlineNode Gauge with it. It also register a callback with the database to be notified
-// of nodes going offline.
+// OnlineNode Gauge with it.
 func initOnlineNodesGauge(store data.Nodeinfostore) {
 	OnlineNodes.Set(0.0)
 	for _, status := range store.GetNodeStatusInfos() {
@@ -198,15 +368,36 @@ func initOnlineNodesGauge(store data.Nodeinfostore) {
 			OnlineNodes.Inc()
 		}
 	}
-	store.NotifyNodeOffline(decrementOnlineNodes)
+}
+
+// countNodes keeps the TotalNodes and OnlineNodes Gauges up to date. Every node
+// is counted exactly once when it is new, goes online, offline or expires.
+// Identity conflicts are counted as well.
+func countNodes(event data.Event) {
+	switch event.Type {
+	case data.NodeNew:
+		TotalNodes.Inc()
+		OnlineNodes.Inc()
+	case data.NodeOnline:
+		OnlineNodes.Inc()
+	case data.NodeOffline:
+		OnlineNodes.Dec()
+	case data.NodeExpired:
+		TotalNodes.Dec()
+	case data.IdentityConflict:
+		IdentityConflicts.Inc()
+	}
 }
 
 // ProcessStoredValues needs to be called at startup as soon as the data store is
 // ready. This methods takes care if initializing all Metrics with values based on
-// the last saved values.
+// the last saved values. The node counts are updated from the events of the store
+// afterwards.
 func ProcessStoredValues(store data.Nodeinfostore) {
 	TotalNodes.Set(float64(len(store.GetNodeStatusInfos())))
 	initTotalClientsGauge(store)
 	initTrafficCounter(store)
 	initOnlineNodesGauge(store)
+	store.Events().Subscribe(countNodes, data.NodeNew, data.NodeOnline, data.NodeOffline, data.NodeExpired,
+		data.IdentityConflict)
 }
@@ -0,0 +1,36 @@
+package prometheus
+
+import (
+	log "github.com/Sirupsen/logrus"
+	"github.com/ffdo/node-informant/gluon-collector/data"
+	"github.com/ffdo/node-informant/gluon-collector/pipeline"
+	cfg "github.com/olebedev/config"
+)
+
+func init() {
+	// The node counts are derived from the events of the store now
+	for _, name := range []string{"nodecount", "returnednodes"} {
+		stage := name
+		pipeline.RegisterProcessPipe(stage, func(store data.Nodeinfostore, options *cfg.Config) (pipeline.ProcessPipe, error) {
+			log.WithFields(log.Fields{
+				"stage": stage,
+			}).Warn("The pipeline stage is deprecated and does nothing, it can be removed")
+			return &deprecatedPipe{}, nil
+		})
+	}
+	pipeline.RegisterProcessPipe("clientcount", func(store data.Nodeinfostore, options *cfg.Config) (pipeline.ProcessPipe, error) {
+		return &ClientCountPipe{Store: store}, nil
+	})
+	pipeline.RegisterProcessPipe("trafficcount", func(store data.Nodeinfostore, options *cfg.Config) (pipeline.ProcessPipe, error) {
+		return &TrafficCountPipe{Store: store}, nil
+	})
+	pipeline.RegisterProcessPipe("nodemetrics", func(store data.Nodeinfostore, options *cfg.Config) (pipeline.ProcessPipe, error) {
+		return &NodeMetricCollector{Store: store}, nil
+	})
+	pipeline.RegisterProcessPipe("wifimetrics", func(store data.Nodeinfostore, options *cfg.Config) (pipeline.ProcessPipe, error) {
+		return &WifiMetricsPipe{Store: store}, nil
+	})
+	pipeline.RegisterProcessPipe("neighbourmetrics", func(store data.Nodeinfostore, options *cfg.Config) (pipeline.ProcessPipe, error) {
+		return &NeighbourMetricsPipe{Store: store}, nil
+	})
+}
@@ -98,3 +98,24 @@ func TestPrometheusClientCounterWithFullLabels(t *testing.T) {
 	// so for now this test simply makes sure that the application doesn't
 	// crash if extended node labels are activated.
 }
+
+func TestNodeCountsFollowEvents(t *testing.T) {
+	assert := assert.New(t)
+	prometheus.Init()
+	store := data.NewSimpleInMemoryStore()
+	store.PutNodeStatusInfo("a", data.NodeStatusInfo{NodeId: "a", Online: true})
+	store.PutNodeStatusInfo("b", data.NodeStatusInfo{NodeId: "b", Online: false})
+	prometheus.ProcessStoredValues(store)
+	assert.Equal(2.0, collectGaugeValue(prometheus.TotalNodes))
+	assert.Equal(1.0, collectGaugeValue(prometheus.OnlineNodes))
+
+	events := store.Events()
+	events.Publish(data.Event{Type: data.NodeNew, NodeId: "c"})
+	events.Publish(data.Event{Type: data.NodeOnline, NodeId: "b"})
+	events.Publish(data.Event{Type: data.NodeOffline, NodeId: "a"})
+	events.Publish(data.Event{Type: data.NodeExpired, NodeId: "a"})
+	events.Publish(data.Event{Type: data.NodeinfoChanged, NodeId: "c"})
+	events.Close()
+	assert.Equal(2.0, collectGaugeValue(prometheus.TotalNodes))
+	assert.Equal(2.0, collectGaugeValue(prometheus.OnlineNodes))
+}
@@ -0,0 +1,117 @@
+package quarantine
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/ffdo/node-informant/gluon-collector/data"
+	"github.com/ffdo/node-informant/gluon-collector/httpserver"
+	"github.com/gorilla/mux"
+)
+
+// Entry is a single response which has been held back instead of being stored
+// in the data store.
+type Entry struct {
+	NodeId string      `json:"node_id"`
+	Type   string      `json:"type"`
+	Source string      `json:"source,omitempty"`
+	Reason string      `json:"reason"`
+	Time   time.Time   `json:"time"`
+	Data   interface{} `json:"data"`
+}
+
+// Area keeps quarantined responses in memory, so they can be inspected later.
+// Only the latest entries per node id are kept.
+type Area struct {
+	lock       sync.RWMutex
+	maxPerNode int
+	entries    map[string][]Entry
+}
+
+// NewArea creates a new quarantine Area keeping at most maxPerNode entries for
+// every node id.
+func NewArea(maxPerNode int) *Area {
+	if maxPerNode < 1 {
+		maxPerNode = 1
+	}
+	return &Area{
+		maxPerNode: maxPerNode,
+		entries:    make(map[string][]Entry),
+	}
+}
+
+// Put moves the response into the quarantine area, annotated with the reason
+// why it was quarantined.
+func (a *Area) Put(reason string, response data.ParsedResponse) {
+	entry := Entry{
+		NodeId: response.NodeId(),
+		Type:   response.Type(),
+		Reason: reason,
+		Time:   time.Now(),
+		Data:   response.ParsedData(),
+	}
+	if source := response.Meta().ClientAddr; source != nil {
+		entry.Source = source.String()
+	}
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	entries := append(a.entries[entry.NodeId], entry)
+	if len(entries) > a.maxPerNode {
+		entries = entries[len(entries)-a.maxPerNode:]
+	}
+	a.entries[entry.NodeId] = entries
+}
+
+// Get returns all quarantined entries for the given node id.
+func (a *Area) Get(nodeId string) ([]Entry, error) {
+	a.lock.RLock()
+	defer a.lock.RUnlock()
+	entries, exists := a.entries[nodeId]
+	if !exists {
+		return nil, fmt.Errorf("No quarantined data for node id %s", nodeId)
+	}
+	return append([]Entry{}, entries...), nil
+}
+
+// All returns all quarantined entries.
+func (a *Area) All() []Entry {
+	a.lock.RLock()
+	defer a.lock.RUnlock()
+	list := make([]Entry, 0, len(a.entries))
+	for _, entries := range a.entries {
+		list = append(list, entries...)
+	}
+	return list
+}
+
+// Remove drops all quarantined entries for the given node id.
+func (a *Area) Remove(nodeId string) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	delete(a.entries, nodeId)
+}
+
+func (a *Area) GetAllRest(w http.ResponseWriter, r *http.Request) {
+	httpserver.RespondOK(w, a.All())
+}
+
+func (a *Area) GetNodeRest(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	entries, err := a.Get(vars["nodeid"])
+	if err == nil {
+		httpserver.RespondOK(w, entries)
+	} else {
+		httpserver.RespondMissing(w, err)
+	}
+}
+
+// Routes serves the quarantined responses below /quarantine. Since they contain
+// full nodeinfos and source addresses, authentication is required.
+func (a *Area) Routes() []httpserver.Route {
+	return []httpserver.Route{
+		httpserver.Route{"Quarantine", "GET", "/quarantine", httpserver.RequireAuth(a.GetAllRest)},
+		httpserver.Route{"NodeQuarantine", "GET", "/quarantine/{nodeid}", httpserver.RequireAuth(a.GetNodeRest)},
+	}
+}
@@ -56,6 +56,20 @@ func (m *MultiReceiver) Close() error {
 	return nil
 }
 
+// DomainReceiver tags all responses of the wrapped receiver with the mesh
+// domain the receiver listens to.
+type DomainReceiver struct {
+	announced.AnnouncedPacketReceiver
+	Domain string
+}
+
+func (d *DomainReceiver) Receive(rFunc func(announced.Response)) {
+	d.AnnouncedPacketReceiver.Receive(func(response announced.Response) {
+		response.Domain = d.Domain
+		rFunc(response)
+	})
+}
+
 func buildReceiver() announced.AnnouncedPacketReceiver {
 	receiverConfigList, err := conf.Global.List("receiver")
 	if err != nil {
@@ -83,7 +97,11 @@ func receiverFactory(receiverConfig *cfg.Config) announced.AnnouncedPacketReceiv
 
 	switch receiverType {
 	case "announced":
-		return buildAnnouncedReceiver(receiverConfig)
+		receiver := buildAnnouncedReceiver(receiverConfig)
+		if domain := receiverConfig.UString("domain", ""); domain != "" {
+			return &DomainReceiver{AnnouncedPacketReceiver: receiver, Domain: domain}
+		}
+		return receiver
 	default:
 		log.Fatalf("Unknown receiver type %s", receiverType)
 		return nil
@@ -100,7 +118,8 @@ func buildAnnouncedReceiver(announcedConfig *cfg.Config) announced.AnnouncedPack
 	if err != nil {
 		log.Fatalf("Can't determine port for announced receiver")
 	}
-	requester, err := announced.NewRequester(iface, port)
+	name := announcedConfig.UString("name", iface)
+	requester, err := announced.NewNamedRequester(name, iface, port)
 	if err != nil {
 		log.Fatalf("Error creating requester: %v", err)
 	}
@@ -48,3 +48,16 @@ func TestMultiReceiver(t *testing.T) {
 	assert.Equal(totalPacketCount, i, "Received less packets than we fed through 2 receiver")
 	assert.True(packetFound, "Didn't found the additional payload")
 }
+
+func TestDomainReceiverTagsResponses(t *testing.T) {
+	assert := assert.New(t)
+	receiver := &DomainReceiver{
+		AnnouncedPacketReceiver: &test.TestDataReceiver{additionalData},
+		Domain:                  "dom1",
+	}
+	domains := make([]string, 0, 1)
+	receiver.Receive(func(packet announced.Response) {
+		domains = append(domains, packet.Domain)
+	})
+	assert.Equal([]string{"dom1"}, domains)
+}
@@ -0,0 +1,303 @@
+package status
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+
+	conf "github.com/ffdo/node-informant/gluon-collector/config"
+	"github.com/ffdo/node-informant/gluon-collector/data"
+	"github.com/ffdo/node-informant/gluon-collector/scheduler"
+)
+
+const (
+	// observedGaps is the number of gaps between reports which are kept per
+	// node to learn its reporting interval.
+	observedGaps int = 8
+	// minObservedGaps is the number of gaps needed before an interval is learned.
+	minObservedGaps int = 3
+)
+
+// observation holds what the Engine has seen of the reports of a single node.
+type observation struct {
+	last time.Time
+	gaps []time.Duration
+}
+
+// add records a report received at the given time and returns whether it was
+// a new one.
+func (o *observation) add(received time.Time) bool {
+	if !received.After(o.last) {
+		return false
+	}
+	if !o.last.IsZero() {
+		o.gaps = append(o.gaps, received.Sub(o.last))
+		if len(o.gaps) > observedGaps {
+			o.gaps = o.gaps[len(o.gaps)-observedGaps:]
+		}
+	}
+	o.last = received
+	return true
+}
+
+// interval returns the median of the observed gaps, so single missed reports
+// or outages don't change the learned interval.
+func (o *observation) interval() (time.Duration, bool) {
+	if len(o.gaps) < minObservedGaps {
+		return 0, false
+	}
+	gaps := append([]time.Duration{}, o.gaps...)
+	sort.Sort(durations(gaps))
+	return gaps[len(gaps)/2], true
+}
+
+type durations []time.Duration
+
+func (d durations) Len() int           { return len(d) }
+func (d durations) Swap(i, j int)      { d[i], d[j] = d[j], d[i] }
+func (d durations) Less(i, j int) bool { return d[i] < d[j] }
+
+// Engine evaluates the state of all nodes in a Nodeinfostore independent of
+// the type of the store and publishes the changes on its EventBus. Every node
+// is expected to report in its own interval, which is either configured or
+// learned from the received statistics. A node which missed Factor of its
+// intervals is offline, a node which is reachable but misses some of the
+// ExpectedResponses is degraded. Nodes which are offline for longer than
+// GoneAfter are gone and are deleted after ExpireAfter.
+type Engine struct {
+	Store data.Nodeinfostore
+	// DefaultInterval is used for nodes without configured or learned interval.
+	DefaultInterval time.Duration
+	// Factor is the number of intervals a node may miss before it is offline.
+	Factor int
+	// Intervals are the configured intervals by node id, which are never
+	// overwritten by learned ones.
+	Intervals map[string]time.Duration
+	// LearnIntervals enables learning the intervals from the received statistics.
+	LearnIntervals bool
+	// ExpectedResponses are the response types a node needs to deliver in time
+	// to not be degraded.
+	ExpectedResponses []string
+	GoneAfter         time.Duration
+	ExpireAfter       time.Duration
+
+	lock         sync.Mutex
+	observations map[string]*observation
+	evaluateJob  *scheduler.ScheduledJob
+}
+
+// NewEngine creates an Engine for the store configured from the status section
+// of the global configuration. The Engine doesn't evaluate anything before it is
+// started.
+func NewEngine(store data.Nodeinfostore) *Engine {
+	engine := &Engine{
+		Store:             store,
+		DefaultInterval:   time.Duration(conf.UInt("announced.interval.statistics", 300)) * time.Second,
+		Factor:            conf.UInt("announced.interval.expire", 3),
+		Intervals:         make(map[string]time.Duration),
+		LearnIntervals:    conf.UBool("status.learnIntervals", true),
+		ExpectedResponses: []string{"statistics", "neighbours"},
+		GoneAfter:         time.Duration(conf.UInt("status.goneAfterDays", 7)*24) * time.Hour,
+		ExpireAfter:       time.Duration(conf.UInt("store.expireNodesAfterDays", 365)*24) * time.Hour,
+		observations:      make(map[string]*observation),
+	}
+	if conf.Global == nil {
+		return engine
+	}
+	if expected, err := conf.Global.List("status.expectedResponses"); err == nil {
+		engine.ExpectedResponses = make([]string, 0, len(expected))
+		for _, responseType := range expected {
+			if name, ok := responseType.(string); ok {
+				engine.ExpectedResponses = append(engine.ExpectedResponses, name)
+			}
+		}
+	}
+	intervals, _ := conf.Global.Map("status.intervals")
+	for nodeId := range intervals {
+		seconds := conf.Global.UInt("status.intervals."+nodeId, 0)
+		if seconds <= 0 {
+			log.WithFields(log.Fields{
+				"nodeid":   nodeId,
+				"interval": intervals[nodeId],
+			}).Warn("Ignoring invalid configured interval")
+			continue
+		}
+		engine.Intervals[nodeId] = time.Duration(seconds) * time.Second
+	}
+	return engine
+}
+
+// Start evaluates the state of all nodes once a minute.
+func (e *Engine) Start() {
+	e.evaluateJob = scheduler.NewJob(time.Minute*1, func() {
+		e.Evaluate(time.Now())
+	}, false)
+}
+
+// Close stops the evaluation.
+func (e *Engine) Close() error {
+	if e.evaluateJob != nil {
+		e.evaluateJob.Stop()
+	}
+	return nil
+}
+
+// Interval returns the interval the node is expected to report in.
+func (e *Engine) Interval(status data.NodeStatusInfo) time.Duration {
+	if interval, ok := e.Intervals[status.NodeId]; ok {
+		return interval
+	}
+	if e.LearnIntervals && status.ExpectedInterval > 0 {
+		return time.Duration(status.ExpectedInterval) * time.Second
+	}
+	return e.DefaultInterval
+}
+
+// observe feeds the last report of the node into the learned interval and
+// returns the interval in seconds to store, or zero if there is none yet.
+func (e *Engine) observe(status data.NodeStatusInfo, lastseen time.Time) int {
+	if interval, ok := e.Intervals[status.NodeId]; ok {
+		return int(interval / time.Second)
+	}
+	if !e.LearnIntervals {
+		return status.ExpectedInterval
+	}
+	// The statistics are requested in a fixed interval, while other responses
+	// follow them shortly, so only the statistics tell the interval reliably.
+	received := lastseen
+	if meta, err := e.Store.GetResponseMeta(status.NodeId, "statistics"); err == nil && !meta.Received.IsZero() {
+		received = meta.Received
+	}
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	obs, exists := e.observations[status.NodeId]
+	if !exists {
+		obs = &observation{}
+		e.observations[status.NodeId] = obs
+	}
+	if obs.add(received) {
+		if interval, ok := obs.interval(); ok {
+			return int(interval / time.Second)
+		}
+	}
+	return status.ExpectedInterval
+}
+
+// degraded checks whether any of the expected responses is missing or older
+// than the given threshold.
+func (e *Engine) degraded(nodeId string, threshold time.Duration, now time.Time) bool {
+	for _, responseType := range e.ExpectedResponses {
+		meta, err := e.Store.GetResponseMeta(nodeId, responseType)
+		if err != nil {
+			return true
+		}
+		if !meta.Received.IsZero() && now.Sub(meta.Received) > threshold {
+			return true
+		}
+	}
+	return false
+}
+
+// State determines the state of the node at the given time.
+func (e *Engine) State(status data.NodeStatusInfo, now time.Time) string {
+	lastseen, err := status.LastseenTime()
+	if err != nil {
+		// Nodes with broken status infos are treated as long gone
+		log.WithFields(log.Fields{
+			"error":      err,
+			"timeString": status.Lastseen,
+			"nodeId":     status.NodeId,
+		}).Error("Can't parse lastseen time")
+	}
+	threshold := e.Interval(status) * time.Duration(e.Factor)
+	silent := now.Sub(lastseen)
+	switch {
+	case silent > e.GoneAfter && silent > threshold:
+		return data.StateGone
+	case silent > threshold:
+		return data.StateOffline
+	case e.degraded(status.NodeId, threshold, now):
+		return data.StateDegraded
+	}
+	return data.StateOnline
+}
+
+// Evaluate determines the state of all nodes at the given time, stores the
+// changes and publishes them on the EventBus of the store. Nodes which have
+// not been seen for longer than ExpireAfter are deleted.
+func (e *Engine) Evaluate(now time.Time) {
+	events := e.Store.Events()
+	for _, status := range e.Store.GetNodeStatusInfos() {
+		if status.NodeId == "" {
+			continue
+		}
+		lastseen, _ := status.LastseenTime()
+		from := status.CurrentState()
+		if now.Sub(lastseen) > e.ExpireAfter {
+			e.expire(status, now)
+			continue
+		}
+		evaluated := status
+		evaluated.ExpectedInterval = e.observe(status, lastseen)
+		to := e.State(evaluated, now)
+		if to == from && evaluated.ExpectedInterval == status.ExpectedInterval && status.State != "" {
+			continue
+		}
+		updated, ok := e.update(status, func(current *data.NodeStatusInfo) {
+			current.State = to
+			current.Online = to == data.StateOnline || to == data.StateDegraded
+			current.ExpectedInterval = evaluated.ExpectedInterval
+		})
+		if !ok || to == from {
+			continue
+		}
+		if status.Online && !updated.Online {
+			log.WithFields(log.Fields{
+				"nodeid":   status.NodeId,
+				"lastseen": status.Lastseen,
+				"interval": e.Interval(updated).String(),
+			}).Info("Node is considered offline after being unreachable for too long")
+			e.putEvent(now, status.NodeId, data.EventOffline)
+			events.Publish(data.Event{Type: data.NodeOffline, NodeId: status.NodeId, Time: now})
+		}
+		events.Publish(data.Event{Type: data.NodeStateChanged, NodeId: status.NodeId, Time: now, From: from, To: to})
+	}
+}
+
+// expire deletes the node from the store and forgets its observed intervals.
+func (e *Engine) expire(status data.NodeStatusInfo, now time.Time) {
+	log.WithFields(log.Fields{
+		"nodeid":   status.NodeId,
+		"lastseen": status.Lastseen,
+	}).Info("Deleting node as it is considered gone forever after")
+	e.lock.Lock()
+	delete(e.observations, status.NodeId)
+	e.lock.Unlock()
+	data.ExpireNode(e.Store, status, now)
+}
+
+// update applies the changes to the status info of the node in a single store
+// update, unless a response of the node has been received since it was read.
+// This keeps the Engine from overwriting the updates of the pipeline.
+func (e *Engine) update(read data.NodeStatusInfo, apply func(*data.NodeStatusInfo)) (data.NodeStatusInfo, bool) {
+	var updated data.NodeStatusInfo
+	applied := false
+	e.Store.UpdateNodeStatusInfo(read.NodeId, func(current *data.NodeStatusInfo) bool {
+		if current.Lastseen == read.Lastseen {
+			apply(current)
+			applied = true
+		}
+		updated = *current
+		return applied
+	})
+	return updated, applied
+}
+
+// putEvent records the transition if the store keeps an event log.
+func (e *Engine) putEvent(now time.Time, nodeId, eventType string) {
+	if eventLog, ok := e.Store.(data.EventLog); ok {
+		eventLog.PutEvent(data.NodeEvent{Time: now, NodeId: nodeId, Type: eventType})
+	}
+}
@@ -0,0 +1,176 @@
+package status
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/ffdo/node-informant/gluon-collector/data"
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestEngine(store data.Nodeinfostore) *Engine {
+	engine := NewEngine(store)
+	engine.DefaultInterval = 5 * time.Minute
+	engine.Factor = 3
+	engine.GoneAfter = 7 * 24 * time.Hour
+	engine.ExpireAfter = 30 * 24 * time.Hour
+	return engine
+}
+
+func putReport(store data.Nodeinfostore, nodeId string, received time.Time, online bool) {
+	store.PutNodeStatusInfo(nodeId, data.NodeStatusInfo{
+		NodeId:   nodeId,
+		Online:   online,
+		Lastseen: received.Format(data.TimeFormat),
+	})
+	for _, responseType := range data.RawResponseTypes {
+		store.PutResponseMeta(nodeId, responseType, data.ResponseMeta{Received: received})
+	}
+}
+
+// receiveEvents subscribes to the events of the store and returns a function
+// waiting for the given number of them.
+func receiveEvents(store data.Nodeinfostore, types ...data.EventType) func(int) []data.Event {
+	received := make(chan data.Event, 100)
+	store.Events().Subscribe(func(event data.Event) {
+		received <- event
+	}, types...)
+	return func(count int) []data.Event {
+		events := make([]data.Event, 0, count)
+		for len(events) < count {
+			select {
+			case event := <-received:
+				events = append(events, event)
+			case <-time.After(time.Second):
+				return events
+			}
+		}
+		return events
+	}
+}
+
+func TestEvaluatingStates(t *testing.T) {
+	assert := assert.New(t)
+	store := data.NewSimpleInMemoryStore()
+	engine := newTestEngine(store)
+	now := time.Now()
+
+	putReport(store, "online", now.Add(-time.Minute), true)
+	putReport(store, "offline", now.Add(-time.Hour), true)
+	putReport(store, "gone", now.Add(-10*24*time.Hour), false)
+	putReport(store, "degraded", now.Add(-time.Minute), true)
+	store.PutResponseMeta("degraded", "neighbours", data.ResponseMeta{Received: now.Add(-time.Hour)})
+
+	receive := receiveEvents(store, data.NodeStateChanged)
+	engine.Evaluate(now)
+	for nodeId, state := range map[string]string{"online": data.StateOnline,
+		"offline": data.StateOffline, "gone": data.StateGone, "degraded": data.StateDegraded} {
+		status, err := store.GetNodeStatusInfo(nodeId)
+		assert.Nil(err)
+		assert.Equal(state, status.State, nodeId)
+		assert.Equal(state == data.StateOnline || state == data.StateDegraded, status.Online, nodeId)
+	}
+	changes := make(map[string]string)
+	for _, event := range receive(3) {
+		changes[event.NodeId] = event.From + "->" + event.To
+	}
+	assert.Equal(map[string]string{"offline": "online->offline", "gone": "offline->gone",
+		"degraded": "online->degraded"}, changes)
+}
+
+func TestPublishingOfflineAndExpiredNodes(t *testing.T) {
+	assert := assert.New(t)
+	dbPath := "./engine.db"
+	defer os.RemoveAll(dbPath)
+	store, err := data.NewBoltStore(dbPath)
+	assert.Nil(err)
+	defer store.Close()
+	engine := newTestEngine(store)
+	now := time.Now()
+
+	putReport(store, "a", now.Add(-time.Hour), true)
+	putReport(store, "b", now.Add(-60*24*time.Hour), false)
+	putReport(store, "c", now.Add(-60*24*time.Hour), true)
+	receive := receiveEvents(store, data.NodeOffline, data.NodeExpired)
+
+	engine.Evaluate(now)
+	published := make(map[string][]data.EventType)
+	for _, event := range receive(4) {
+		published[event.NodeId] = append(published[event.NodeId], event.Type)
+	}
+	assert.Equal([]data.EventType{data.NodeOffline}, published["a"])
+	assert.Equal([]data.EventType{data.NodeExpired}, published["b"])
+	// Nodes still online go offline before they expire
+	assert.Equal([]data.EventType{data.NodeOffline, data.NodeExpired}, published["c"])
+	_, err = store.GetNodeStatusInfo("b")
+	assert.NotNil(err)
+	events, err := store.GetEvents("", now.Add(-time.Minute))
+	assert.Nil(err)
+	assert.Equal(3, len(events))
+
+	// Offline nodes are reported only once
+	engine.Evaluate(now.Add(time.Minute))
+	assert.Equal(0, len(receive(1)))
+}
+
+func TestLearningIntervals(t *testing.T) {
+	assert := assert.New(t)
+	store := data.NewSimpleInMemoryStore()
+	engine := newTestEngine(store)
+	start := time.Now().Add(-24 * time.Hour)
+
+	// The node reports every 30 minutes, one report got lost
+	for _, minutes := range []int{0, 30, 60, 120, 150, 180} {
+		received := start.Add(time.Duration(minutes) * time.Minute)
+		putReport(store, "a", received, true)
+		engine.Evaluate(received.Add(time.Minute))
+	}
+	status, err := store.GetNodeStatusInfo("a")
+	assert.Nil(err)
+	assert.Equal(1800, status.ExpectedInterval)
+	assert.Equal(30*time.Minute, engine.Interval(status))
+
+	// One hour without a report is no reason to mark it offline
+	engine.Evaluate(start.Add(240 * time.Minute))
+	status, _ = store.GetNodeStatusInfo("a")
+	assert.Equal(data.StateOnline, status.State)
+	engine.Evaluate(start.Add(300 * time.Minute))
+	status, _ = store.GetNodeStatusInfo("a")
+	assert.Equal(data.StateOffline, status.State)
+}
+
+func TestConfiguredIntervals(t *testing.T) {
+	assert := assert.New(t)
+	store := data.NewSimpleInMemoryStore()
+	engine := newTestEngine(store)
+	engine.Intervals["a"] = time.Hour
+	now := time.Now()
+
+	putReport(store, "a", now.Add(-2*time.Hour), true)
+	putReport(store, "b", now.Add(-2*time.Hour), true)
+	engine.Evaluate(now)
+	status, _ := store.GetNodeStatusInfo("a")
+	assert.Equal(data.StateOnline, status.State)
+	assert.Equal(3600, status.ExpectedInterval)
+	status, _ = store.GetNodeStatusInfo("b")
+	assert.Equal(data.StateOffline, status.State)
+}
+
+func TestKeepingNewerReports(t *testing.T) {
+	assert := assert.New(t)
+	store := data.NewSimpleInMemoryStore()
+	engine := newTestEngine(store)
+	now := time.Now()
+
+	putReport(store, "a", now.Add(-time.Hour), true)
+	read, _ := store.GetNodeStatusInfo("a")
+	putReport(store, "a", now, true)
+	_, ok := engine.update(read, func(status *data.NodeStatusInfo) {
+		status.Online = false
+	})
+	assert.False(ok)
+	status, _ := store.GetNodeStatusInfo("a")
+	assert.True(status.Online)
+	assert.Equal(now.Format(data.TimeFormat), status.Lastseen)
+}
@@ -81,7 +81,7 @@ func ExecuteCompletePipe(t *testing.T, store data.Nodeinfostore) {
 	testReceiver := &TestDataReceiver{TestData: TestData}
 
 	i := 0
-	closeables, err := assemble.BuildPipelines(store, testReceiver, func(response data.ParsedResponse) {
+	closeables, _, err := assemble.BuildPipelines(store, testReceiver, func(response data.ParsedResponse) {
 		i = i + 1
 	})
 	assert.Nil(err)